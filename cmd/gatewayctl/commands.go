@@ -0,0 +1,405 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/store"
+)
+
+// errLocalQueue is returned by the commands working on the queue when it is
+// held by the instances themselves.
+var errLocalQueue = errors.New("the queue is held by each instance in local queue mode and cannot be reached from here")
+
+// queueReachable returns errLocalQueue in local queue mode.
+func (ctl *controller) queueReachable() error {
+	if ctl.config.QueueMode == constants.QueueModeLocal {
+		return errLocalQueue
+	}
+	return nil
+}
+
+func queueDepth(ctl *controller, args []string) error {
+	flags := ctl.flags("queue depth")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	if err := ctl.queueReachable(); err != nil {
+		return err
+	}
+
+	depths, err := ctl.store.QueueDepths()
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(constants.Priorities)+1)
+	var total int64
+	for _, priority := range constants.Priorities {
+		rows = append(rows, []string{string(priority), fmt.Sprint(depths[priority])})
+		total += depths[priority]
+	}
+	rows = append(rows, []string{"total", fmt.Sprint(total)})
+	return ctl.out.write(depths, []string{"LANE", "DEPTH"}, rows)
+}
+
+func queueList(ctl *controller, args []string) error {
+	flags := ctl.flags("queue list")
+	lane := flags.String("lane", string(constants.PriorityNormal), "lane to list, high, normal or low")
+	limit := flags.Int("limit", 20, "maximum number of payments to list")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	if !slices.Contains(constants.Priorities, constants.PaymentPriority(*lane)) {
+		return fmt.Errorf("unknown lane %q", *lane)
+	}
+	if err := ctl.queueReachable(); err != nil {
+		return err
+	}
+
+	payments, err := ctl.store.PeekQueue(constants.PaymentPriority(*lane), max(*limit, 1))
+	if err != nil {
+		return err
+	}
+	return ctl.out.writePayments(payments)
+}
+
+func deadLettersList(ctl *controller, args []string) error {
+	flags := ctl.flags("dead-letters list")
+	limit := flags.Int("limit", 20, "maximum number of payments to list")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	payments, err := ctl.store.PeekDeadLetters(max(*limit, 1))
+	if err != nil {
+		return err
+	}
+	return ctl.out.writePayments(payments)
+}
+
+func healthShow(ctl *controller, args []string) error {
+	flags := ctl.flags("health show")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	names := make([]constants.PaymentMode, len(ctl.config.Processors))
+	for i, processor := range ctl.config.Processors {
+		names[i] = processor.Name
+	}
+	healths, err := ctl.store.GetProcessorsHealth(names)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	rows := make([][]string, 0, len(names))
+	for _, name := range names {
+		health, ok := healths[name]
+		if !ok {
+			rows = append(rows, []string{string(name), "-", "-", "-", "-"})
+			continue
+		}
+
+		forced := "no"
+		if health.IsForced(now) {
+			forced = "until released"
+			if !health.ForcedUntil.IsZero() {
+				forced = "until " + formatTime(health.ForcedUntil)
+			}
+		}
+		rows = append(rows, []string{
+			string(name),
+			fmt.Sprint(health.Failing),
+			fmt.Sprintf("%dms", health.MinResponseTime),
+			formatTime(health.LastChecked),
+			forced,
+		})
+	}
+	return ctl.out.write(healths, []string{"PROCESSOR", "FAILING", "MIN RESPONSE", "LAST CHECKED", "FORCED"}, rows)
+}
+
+func healthForce(ctl *controller, args []string) error {
+	flags := ctl.flags("health force")
+	failing := flags.Bool("failing", false, "mark the processor as failing")
+	minResponseTime := flags.Int("min-response-time", 0, "minimum response time to report, in milliseconds")
+	duration := flags.Duration("for", 0, "how long to force the health for, until released when 0")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	name := constants.PaymentMode(flags.Arg(0))
+	if ctl.config.Processor(name) == nil {
+		return fmt.Errorf("unknown processor %q", name)
+	}
+
+	now := time.Now().UTC()
+	health := models.ProcessorHealth{
+		Failing:         *failing,
+		MinResponseTime: *minResponseTime,
+		LastChecked:     now,
+		Forced:          true,
+	}
+	if *duration > 0 {
+		health.ForcedUntil = now.Add(*duration)
+	}
+
+	if err := ctl.store.SetProcessorHealth(name, health); err != nil {
+		return err
+	}
+	return ctl.out.write(health, []string{"PROCESSOR", "FAILING", "MIN RESPONSE", "FORCED UNTIL"}, [][]string{{
+		string(name), fmt.Sprint(health.Failing), fmt.Sprintf("%dms", health.MinResponseTime), formatTime(health.ForcedUntil),
+	}})
+}
+
+func healthRelease(ctl *controller, args []string) error {
+	flags := ctl.flags("health release")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	name := constants.PaymentMode(flags.Arg(0))
+	healths, err := ctl.store.GetProcessorsHealth([]constants.PaymentMode{name})
+	if err != nil {
+		return err
+	}
+	health, ok := healths[name]
+	if !ok {
+		return fmt.Errorf("processor %q has no health record", name)
+	}
+
+	// The health is left as it was forced until the monitor checks the
+	// processor again.
+	health.Forced = false
+	health.ForcedUntil = time.Time{}
+	if err := ctl.store.SetProcessorHealth(name, *health); err != nil {
+		return err
+	}
+	return ctl.out.write(health, []string{"PROCESSOR", "RELEASED"}, [][]string{{string(name), "yes"}})
+}
+
+// summaryRow is the summary of one processor in one currency.
+type summaryRow struct {
+	Processor string `json:"processor"`
+	Currency  string `json:"currency"`
+	models.SummaryTotals
+}
+
+func summaryDump(ctl *controller, args []string) error {
+	flags := ctl.flags("summary dump")
+	merchantID := flags.String("merchant", "", "merchant to summarize, all of them when empty")
+	currency := flags.String("currency", "", "currency to summarize, every configured one when empty")
+	fromStr := flags.String("from", "", "start of the summarized period, an RFC 3339 timestamp")
+	toStr := flags.String("to", "", "end of the summarized period, an RFC 3339 timestamp")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	from, err := parseTime("from", *fromStr)
+	if err != nil {
+		return err
+	}
+	to, err := parseTime("to", *toStr)
+	if err != nil {
+		return err
+	}
+
+	currencies := ctl.config.CurrencyCodes()
+	if *currency != "" {
+		code := strings.ToUpper(*currency)
+		if _, ok := ctl.config.Currencies[code]; !ok {
+			return fmt.Errorf("unknown currency %q", *currency)
+		}
+		currencies = []string{code}
+	}
+
+	processors := ctl.config.ProcessorNames(*merchantID)
+	summaries := []summaryRow{}
+	var rows [][]string
+	for _, code := range currencies {
+		summary, err := ctl.store.GetSummary(*merchantID, processors, store.CurrencyScope(code, ctl.config.DefaultCurrency), from, to)
+		if err != nil {
+			return err
+		}
+
+		for _, processor := range processors {
+			totals := (*summary)[string(processor)].SummaryTotals
+			summaries = append(summaries, summaryRow{Processor: string(processor), Currency: code, SummaryTotals: totals})
+			rows = append(rows, []string{
+				string(processor), code, fmt.Sprint(totals.TotalRequest), formatAmount(totals.TotalAmount),
+				formatAmount(totals.RefundedAmount), formatAmount(totals.NetAmount),
+			})
+		}
+	}
+	return ctl.out.write(summaries, []string{"PROCESSOR", "CURRENCY", "REQUESTS", "AMOUNT", "REFUNDED", "NET"}, rows)
+}
+
+// parseTime parses the RFC 3339 timestamp of a flag, returning nil when it is
+// empty.
+func parseTime(name, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("-%s: expected an RFC 3339 timestamp", name)
+	}
+	return &t, nil
+}
+
+func summaryRecompute(ctl *controller, args []string) error {
+	flags := ctl.flags("summary recompute")
+	merchantID := flags.String("merchant", "", "merchant whose summary to recompute, the global summary when empty")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	var recomputed int64
+	for _, processor := range ctl.config.ProcessorNames(*merchantID) {
+		for _, code := range ctl.config.CurrencyCodes() {
+			scope := store.CurrencyScope(code, ctl.config.DefaultCurrency)
+			if err := ctl.store.RecomputeSummary(*merchantID, processor, scope); err != nil {
+				return fmt.Errorf("%s %s: %w", processor, code, err)
+			}
+			recomputed++
+		}
+	}
+	return ctl.out.writeCount("recomputed", recomputed)
+}
+
+var purgeTargets = []string{"queue", "schedule", "dead-letters", "health", "summaries"}
+
+// purge removes state of the given target. Payments purged from the queue or
+// the schedule are marked failed, so they may be submitted again.
+func purge(ctl *controller, args []string) error {
+	flags := ctl.flags("purge")
+	confirmed := flags.Bool("yes", false, "confirm the purge, which cannot be undone")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	target := flags.Arg(0)
+	if !slices.Contains(purgeTargets, target) {
+		return fmt.Errorf("unknown purge target %q, expected one of %v", target, purgeTargets)
+	}
+	if !*confirmed {
+		fmt.Fprintf(os.Stderr, "purging %s cannot be undone, run again with -yes to confirm\n", target)
+		return errUsage
+	}
+
+	var purged int64
+	switch target {
+	case "queue":
+		if err := ctl.queueReachable(); err != nil {
+			return err
+		}
+		payments, err := ctl.store.PurgeQueue()
+		if err != nil {
+			return err
+		}
+		purged = int64(len(payments))
+		ctl.failPurged(payments, constants.PaymentQueued, constants.PaymentProcessing)
+	case "schedule":
+		payments, err := ctl.store.PurgeScheduledPayments()
+		if err != nil {
+			return err
+		}
+		purged = int64(len(payments))
+		ctl.failPurged(payments, constants.PaymentScheduled)
+	case "dead-letters":
+		var err error
+		if purged, err = ctl.store.PurgeDeadLetters(); err != nil {
+			return err
+		}
+	case "health":
+		var err error
+		if purged, err = ctl.store.PurgeProcessorsHealth(); err != nil {
+			return err
+		}
+	case "summaries":
+		var err error
+		if purged, err = ctl.store.PurgeSummaries(); err != nil {
+			return err
+		}
+	}
+	return ctl.out.writeCount("purged", purged)
+}
+
+// failPurged moves the status of purged payments to failed, from any of the
+// given statuses.
+func (ctl *controller) failPurged(payments []*models.QueuedPayment, from ...constants.PaymentStatus) {
+	for _, payment := range payments {
+		if _, _, err := ctl.store.TransitionPayment(payment.Key(), constants.PaymentFailed, from...); err != nil && !errors.Is(err, store.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "failed to mark purged payment [%s] failed: %s\n", payment.CorrelationID, err)
+		}
+	}
+}
+
+// replay queues payments read from a file, or from the standard input when it
+// is "-". They are queued as new payments: retries, timing and schedule are
+// reset. Payments still active are left out as duplicates.
+func replay(ctl *controller, args []string) error {
+	flags := ctl.flags("replay")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	if err := ctl.queueReachable(); err != nil {
+		return err
+	}
+
+	input := os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	payments, err := readPayments(input)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, payment := range payments {
+		payment.RetryCount = 0
+		payment.CreatedAt = now
+		payment.ScheduledAt = nil
+		payment.Deadline = time.Time{}
+		if ctl.config.PaymentDeadline > 0 {
+			payment.Deadline = now.Add(ctl.config.PaymentDeadline)
+		}
+		payment.Currency = cmp.Or(payment.Currency, ctl.config.DefaultCurrency)
+	}
+
+	// Replayed payments are refused past the hard limit but never shed.
+	admissions, _, err := ctl.store.EnqueuePayments(payments, ctl.config.MaxQueueSize, ctl.config.MaxQueueSize, 0)
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int64{"accepted": 0, "duplicate": 0, "full": 0}
+	for _, admission := range admissions {
+		switch admission {
+		case store.QueueAccepted:
+			counts["accepted"]++
+		case store.QueueDuplicate:
+			counts["duplicate"]++
+		default:
+			counts["full"]++
+		}
+	}
+	return ctl.out.write(counts, []string{"ACCEPTED", "DUPLICATE", "QUEUE FULL"}, [][]string{{
+		fmt.Sprint(counts["accepted"]), fmt.Sprint(counts["duplicate"]), fmt.Sprint(counts["full"]),
+	}})
+}
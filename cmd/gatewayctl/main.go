@@ -0,0 +1,161 @@
+// Command gatewayctl inspects and repairs the state the gateway keeps in its
+// store. It reads the same environment as the gateway, so it works against
+// whichever backend STORAGE_BACKEND selects.
+//
+// Usage:
+//
+//	gatewayctl queue depth
+//	gatewayctl queue list [-lane normal] [-limit 20]
+//	gatewayctl dead-letters list [-limit 20]
+//	gatewayctl health show
+//	gatewayctl health force [-failing] [-min-response-time ms] [-for duration] <processor>
+//	gatewayctl health release <processor>
+//	gatewayctl summary dump [-merchant id] [-currency code] [-from time] [-to time]
+//	gatewayctl summary recompute [-merchant id]
+//	gatewayctl purge -yes <queue|schedule|dead-letters|health|summaries>
+//	gatewayctl replay <file|->
+//
+// Every subcommand takes -o table or -o json. With QUEUE_MODE=local the queue
+// is held by each instance in its write-ahead log, out of reach of the queue
+// subcommands, purge queue and replay.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/store"
+)
+
+// errUsage is returned by commands invoked with invalid arguments, after the
+// usage has been printed.
+var errUsage = errors.New("invalid usage")
+
+// command runs a subcommand with its remaining arguments.
+type command func(ctl *controller, args []string) error
+
+var commands = map[string]map[string]command{
+	"queue": {
+		"depth": queueDepth,
+		"list":  queueList,
+	},
+	"dead-letters": {
+		"list": deadLettersList,
+	},
+	"health": {
+		"show":    healthShow,
+		"force":   healthForce,
+		"release": healthRelease,
+	},
+	"summary": {
+		"dump":      summaryDump,
+		"recompute": summaryRecompute,
+	},
+}
+
+// controller holds what every subcommand works with.
+type controller struct {
+	config *config.Config
+	store  store.Store
+	out    *output
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+
+	cmd, args, ok := lookup(args)
+	if !ok {
+		usage()
+		return 2
+	}
+
+	config, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %s\n", err)
+		return 1
+	}
+	store, err := store.New(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open store: %s\n", err)
+		return 1
+	}
+
+	ctl := &controller{config: config, store: store, out: &output{w: os.Stdout}}
+	if err := cmd(ctl, args); err != nil {
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// lookup resolves the subcommand named by the first arguments, returning the
+// arguments left to it.
+func lookup(args []string) (command, []string, bool) {
+	switch args[0] {
+	case "purge":
+		return purge, args[1:], true
+	case "replay":
+		return replay, args[1:], true
+	}
+
+	group, ok := commands[args[0]]
+	if !ok || len(args) < 2 {
+		return nil, nil, false
+	}
+	cmd, ok := group[args[1]]
+	return cmd, args[2:], ok
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: gatewayctl <command> [flags] [args]
+
+commands:
+  queue depth                 number of queued payments per lane
+  queue list                  queued payments of a lane, next to be dequeued first
+  dead-letters list           dead-lettered payments, latest first
+  health show                 last known health of every processor
+  health force <processor>    force the health of a processor
+  health release <processor>  hand the health of a processor back to the monitor
+  summary dump                summaries of every processor and currency
+  summary recompute           rebuild summary totals from their records
+  purge <target>              remove the queue, schedule, dead-letters, health or summaries
+  replay <file|->             queue payments read from a JSON array or JSON lines
+
+Every command takes -o table or -o json. Run a command with -h for its flags.
+`)
+}
+
+// flags returns the flag set of a subcommand, with the output flag every
+// subcommand takes.
+func (ctl *controller) flags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Var(&ctl.out.format, "o", "output format, table or json")
+	return flags
+}
+
+// parse parses the flags of a subcommand, which takes want positional
+// arguments.
+func parse(flags *flag.FlagSet, args []string, want int) error {
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != want {
+		fmt.Fprintf(os.Stderr, "%s takes %d argument(s), got %d\n", flags.Name(), want, flags.NArg())
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
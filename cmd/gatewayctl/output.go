@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/models"
+)
+
+// format is an output format, settable as a flag.
+type format string
+
+const (
+	formatTable format = "table"
+	formatJSON  format = "json"
+)
+
+func (f *format) String() string {
+	if *f == "" {
+		return string(formatTable)
+	}
+	return string(*f)
+}
+
+func (f *format) Set(value string) error {
+	switch format(value) {
+	case formatTable, formatJSON:
+		*f = format(value)
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q", value)
+	}
+}
+
+// output writes results as aligned tables or as indented JSON.
+type output struct {
+	w      io.Writer
+	format format
+}
+
+// write writes value as JSON, or as a table of the given header and rows.
+func (o *output) write(value any, header []string, rows [][]string) error {
+	if o.format == formatJSON {
+		encoder := json.NewEncoder(o.w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// writePayments writes queued payments, one row each.
+func (o *output) writePayments(payments []*models.QueuedPayment) error {
+	if payments == nil {
+		payments = []*models.QueuedPayment{}
+	}
+
+	rows := make([][]string, len(payments))
+	for i, payment := range payments {
+		rows[i] = []string{
+			payment.CorrelationID,
+			payment.MerchantID,
+			formatAmount(payment.Amount),
+			payment.Currency,
+			string(payment.Priority),
+			fmt.Sprint(payment.RetryCount),
+			formatTime(payment.CreatedAt),
+			formatTime(payment.Deadline),
+		}
+	}
+	return o.write(payments, []string{"CORRELATION ID", "MERCHANT", "AMOUNT", "CURRENCY", "PRIORITY", "RETRIES", "CREATED", "DEADLINE"}, rows)
+}
+
+// writeCount writes the number of records affected by a command.
+func (o *output) writeCount(name string, count int64) error {
+	return o.write(map[string]int64{name: count}, []string{strings.ToUpper(name)}, [][]string{{fmt.Sprint(count)}})
+}
+
+func formatAmount(amount float64) string {
+	return fmt.Sprintf("%.2f", amount)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
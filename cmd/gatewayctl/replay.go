@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mochaeng/payment-gateway/internal/models"
+)
+
+// readPayments reads the payments to replay, given as a JSON array or as JSON
+// values one after the other, as written by "dead-letters list -o json" or
+// one per line. Field names match case-insensitively, so payment requests
+// are accepted as well.
+func readPayments(r io.Reader) ([]*models.QueuedPayment, error) {
+	reader := bufio.NewReader(r)
+	first, err := peekNonSpace(reader)
+	if err == io.EOF {
+		return nil, errors.New("no payments to replay")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var payments []*models.QueuedPayment
+	decoder := json.NewDecoder(reader)
+	if first == '[' {
+		if err := decoder.Decode(&payments); err != nil {
+			return nil, fmt.Errorf("failed to decode payments: %w", err)
+		}
+	} else {
+		for {
+			var payment models.QueuedPayment
+			err := decoder.Decode(&payment)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode payment %d: %w", len(payments)+1, err)
+			}
+			payments = append(payments, &payment)
+		}
+	}
+
+	for i, payment := range payments {
+		if payment == nil || payment.CorrelationID == "" || payment.Amount <= 0 {
+			return nil, fmt.Errorf("payment %d needs a correlation ID and a positive amount", i+1)
+		}
+	}
+	return payments, nil
+}
+
+// peekNonSpace returns the first byte of r that is not JSON whitespace,
+// leaving it unread.
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, r.UnreadByte()
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadPayments_AcceptsArraysAndJSONLines(t *testing.T) {
+	inputs := map[string]string{
+		"array": `
+			[{"CorrelationID": "a", "Amount": 10.5, "MerchantID": "m1"},
+			 {"correlationId": "b", "amount": 20}]`,
+		"lines": `{"CorrelationID": "a", "Amount": 10.5, "MerchantID": "m1"}
+			{"correlationId": "b", "amount": 20}
+		`,
+	}
+
+	for name, input := range inputs {
+		payments, err := readPayments(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(payments) != 2 {
+			t.Fatalf("%s: got %d payments, want 2", name, len(payments))
+		}
+		if payments[0].CorrelationID != "a" || payments[0].MerchantID != "m1" || payments[1].Amount != 20 {
+			t.Fatalf("%s: got %+v %+v", name, payments[0], payments[1])
+		}
+	}
+}
+
+func TestReadPayments_RejectsInvalidPayments(t *testing.T) {
+	inputs := map[string]string{
+		"empty":          "  \n",
+		"missing id":     `[{"Amount": 10}]`,
+		"zero amount":    `{"CorrelationID": "a"}`,
+		"malformed line": "{\"CorrelationID\": \"a\", \"Amount\": 1}\n{",
+	}
+
+	for name, input := range inputs {
+		if _, err := readPayments(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/valyala/fasthttp"
+)
+
+// initialToken is the admin token the payment processors start with.
+const initialToken = "123"
+
+// processor is a payment processor of the rinha environment, whose admin
+// endpoints drive the scenario.
+type processor struct {
+	name string
+	url  string
+}
+
+// processorSummary is the summary a payment processor keeps of the payments
+// it received.
+type processorSummary struct {
+	TotalAmount       float64 `json:"totalAmount"`
+	TotalRequests     int64   `json:"totalRequests"`
+	FeePerTransaction float64 `json:"feePerTransaction"`
+	TotalFee          float64 `json:"totalFee"`
+}
+
+// client talks to the gateway and to the admin endpoints of the payment
+// processors, with every request bounded by the same timeout.
+type client struct {
+	http       *fasthttp.Client
+	gatewayURL string
+	apiKey     string
+	token      string
+	timeout    time.Duration
+}
+
+func newClient(options *options) *client {
+	return &client{
+		http: &fasthttp.Client{
+			Dial: func(addr string) (net.Conn, error) {
+				return fasthttp.DialTimeout(addr, options.timeout)
+			},
+			MaxConnsPerHost: options.maxVUs + 16,
+		},
+		gatewayURL: options.gatewayURL,
+		apiKey:     options.apiKey,
+		token:      options.token,
+		timeout:    options.timeout,
+	}
+}
+
+// do sends a request with a JSON body, unless body is nil, and returns the
+// status code, decoding the response into out unless it is nil.
+func (c *client) do(method, uri string, headers map[string]string, body, out any) (int, error) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	req.Header.SetContentType("application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		req.SetBody(data)
+	}
+
+	if err := c.http.DoTimeout(req, resp, c.timeout); err != nil {
+		return 0, err
+	}
+
+	status := resp.StatusCode()
+	if out != nil && status == fasthttp.StatusOK {
+		if err := json.Unmarshal(resp.Body(), out); err != nil {
+			return status, fmt.Errorf("failed to decode response of %s: %w", uri, err)
+		}
+	}
+	return status, nil
+}
+
+// expect sends a request and fails unless it is answered with the given
+// status.
+func (c *client) expect(want int, method, uri string, headers map[string]string, body any) error {
+	status, err := c.do(method, uri, headers, body, nil)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, uri, err)
+	}
+	if status != want {
+		return fmt.Errorf("%s %s: got HTTP %d, want %d", method, uri, status, want)
+	}
+	return nil
+}
+
+func (c *client) adminHeaders() map[string]string {
+	return map[string]string{"X-Rinha-Token": c.token}
+}
+
+// setToken replaces the initial admin token of a processor with the token of
+// the run.
+func (c *client) setToken(processor processor) error {
+	return c.expect(fasthttp.StatusNoContent, fasthttp.MethodPut, processor.url+"/admin/configurations/token",
+		map[string]string{"X-Rinha-Token": initialToken}, map[string]string{"token": c.token})
+}
+
+func (c *client) setDelay(processor processor, delay int) error {
+	return c.expect(fasthttp.StatusOK, fasthttp.MethodPut, processor.url+"/admin/configurations/delay",
+		c.adminHeaders(), map[string]int{"delay": delay})
+}
+
+func (c *client) setFailure(processor processor, failure bool) error {
+	return c.expect(fasthttp.StatusOK, fasthttp.MethodPut, processor.url+"/admin/configurations/failure",
+		c.adminHeaders(), map[string]bool{"failure": failure})
+}
+
+func (c *client) purgeProcessor(processor processor) error {
+	return c.expect(fasthttp.StatusOK, fasthttp.MethodPost, processor.url+"/admin/purge-payments", c.adminHeaders(), nil)
+}
+
+func (c *client) processorSummary(processor processor, from, to time.Time) (*processorSummary, error) {
+	var summary processorSummary
+	uri := processor.url + "/admin/payments-summary?" + summaryQuery(from, to)
+	status, err := c.do(fasthttp.MethodGet, uri, c.adminHeaders(), nil, &summary)
+	if err != nil {
+		return nil, err
+	}
+	if status != fasthttp.StatusOK {
+		return nil, fmt.Errorf("GET %s: got HTTP %d", uri, status)
+	}
+	return &summary, nil
+}
+
+func (c *client) gatewayHeaders() map[string]string {
+	if c.apiKey == "" {
+		return nil
+	}
+	return map[string]string{"X-API-Key": c.apiKey}
+}
+
+// purgeGateway asks the gateway to drop its payments, which only gateways
+// exposing POST /purge-payments do.
+func (c *client) purgeGateway() error {
+	return c.expect(fasthttp.StatusOK, fasthttp.MethodPost, c.gatewayURL+"/purge-payments", c.gatewayHeaders(), nil)
+}
+
+func (c *client) gatewaySummary(from, to time.Time) (models.PaymentSummaryResponse, error) {
+	var summary models.PaymentSummaryResponse
+	uri := c.gatewayURL + "/payments-summary?" + summaryQuery(from, to)
+	status, err := c.do(fasthttp.MethodGet, uri, c.gatewayHeaders(), nil, &summary)
+	if err != nil {
+		return nil, err
+	}
+	if status != fasthttp.StatusOK {
+		return nil, fmt.Errorf("GET %s: got HTTP %d", uri, status)
+	}
+	return summary, nil
+}
+
+// pay submits a payment to the gateway, returning the status code and how
+// long the gateway took to answer.
+func (c *client) pay(correlationID string, amount float64) (int, time.Duration, error) {
+	payment := map[string]any{"correlationId": correlationID, "amount": amount}
+
+	start := time.Now()
+	status, err := c.do(fasthttp.MethodPost, c.gatewayURL+"/payments", c.gatewayHeaders(), payment, nil)
+	return status, time.Since(start), err
+}
+
+func summaryQuery(from, to time.Time) string {
+	query := url.Values{}
+	query.Set("from", from.UTC().Format(time.RFC3339Nano))
+	query.Set("to", to.UTC().Format(time.RFC3339Nano))
+	return query.Encode()
+}
@@ -0,0 +1,106 @@
+// Command loadgen reproduces the scenarios of rinha-test/rinha.js without k6
+// or network access: virtual users ramping up to -max-vus, each submitting a
+// payment every second; stages flipping the delay and failure of the payment
+// processors; a consistency check comparing the gateway and processor
+// summaries every ten seconds; and a final summary comparison, scored like
+// the k6 script.
+//
+// The report is printed and, with -out, written as JSON. The gateway keeps
+// its payments between runs unless it exposes POST /purge-payments; running
+// "gatewayctl purge -yes summaries" beforehand gives a clean slate.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+type options struct {
+	gatewayURL  string
+	defaultURL  string
+	fallbackURL string
+	apiKey      string
+	token       string
+	maxVUs      int
+	duration    time.Duration
+	amount      float64
+	timeout     time.Duration
+	participant string
+	out         string
+}
+
+func parseOptions() *options {
+	options := &options{}
+	flag.StringVar(&options.gatewayURL, "gateway", "http://localhost:9999", "base URL of the gateway")
+	flag.StringVar(&options.defaultURL, "default", "http://localhost:8001", "base URL of the default payment processor")
+	flag.StringVar(&options.fallbackURL, "fallback", "http://localhost:8002", "base URL of the fallback payment processor")
+	flag.StringVar(&options.apiKey, "api-key", "", "API key sent to the gateway, when it requires one")
+	flag.StringVar(&options.token, "token", getEnv("TOKEN", initialToken), "admin token the payment processors are given")
+	flag.IntVar(&options.maxVUs, "max-vus", getEnvInt("MAX_REQUESTS", 500), "number of virtual users reached at the end of the ramp")
+	flag.DurationVar(&options.duration, "duration", 60*time.Second, "duration of the run")
+	flag.Float64Var(&options.amount, "amount", 19.9, "amount of every payment")
+	flag.DurationVar(&options.timeout, "timeout", 1500*time.Millisecond, "timeout of every request")
+	flag.StringVar(&options.participant, "participant", getEnv("PARTICIPANT", "anonymous"), "name shown in the report")
+	flag.StringVar(&options.out, "out", "", "file to write the JSON report to")
+	flag.Parse()
+
+	options.maxVUs = max(options.maxVUs, 1)
+	return options
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func main() {
+	options := parseOptions()
+	runner := newRunner(options)
+
+	if err := runner.setup(); err != nil {
+		fmt.Fprintf(os.Stderr, "setup failed: %s\n", err)
+		os.Exit(1)
+	}
+
+	elapsed, runErr := runner.run()
+	if runErr != nil {
+		fmt.Fprintf(os.Stderr, "run aborted: %s\n", runErr)
+	}
+
+	report, err := runner.teardown(elapsed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "teardown failed: %s\n", err)
+		os.Exit(1)
+	}
+	report.write(os.Stdout)
+
+	if options.out != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encode the report: %s\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(options.out, append(data, '\n'), 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write the report: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if runErr != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// p99BonusTarget is the p99 latency, in milliseconds, below which every
+	// millisecond earns p99BonusRate of the liquid amount.
+	p99BonusTarget = 11
+	p99BonusRate   = 0.02
+	// inconsistencyFine is the share of the liquid amount lost when the
+	// gateway disagrees with the processors at any check.
+	inconsistencyFine = 0.35
+)
+
+// latencyRecorder collects the latencies of the payment requests.
+type latencyRecorder struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+func (l *latencyRecorder) add(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.latencies = append(l.latencies, latency)
+}
+
+// percentile returns the q-th percentile of the recorded latencies,
+// interpolated between the closest ranks as k6 does.
+func (l *latencyRecorder) percentile(q float64) time.Duration {
+	l.mu.Lock()
+	sorted := slices.Clone(l.latencies)
+	l.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return 0
+	}
+	slices.Sort(sorted)
+
+	rank := q / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+	weight := rank - float64(lower)
+	return sorted[lower] + time.Duration(weight*float64(sorted[lower+1]-sorted[lower]))
+}
+
+// processorReport is what the gateway reports of one processor, with the fee
+// the processor charged for it.
+type processorReport struct {
+	Requests int64   `json:"requests"`
+	Amount   float64 `json:"amount"`
+	Fee      float64 `json:"fee"`
+}
+
+// report is the outcome of a run, scored like rinha-test/rinha.js.
+type report struct {
+	Participant string  `json:"participant"`
+	MaxVUs      int     `json:"maxVUs"`
+	Duration    float64 `json:"durationSeconds"`
+
+	Succeeded  int64   `json:"succeeded"`
+	Failed     int64   `json:"failed"`
+	Throughput float64 `json:"throughput"`
+	P99        float64 `json:"p99Ms"`
+	P99Bonus   float64 `json:"p99Bonus"`
+
+	Inconsistencies int64   `json:"inconsistencies"`
+	Fine            float64 `json:"fine"`
+
+	Default  processorReport `json:"default"`
+	Fallback processorReport `json:"fallback"`
+
+	GrossAmount  float64 `json:"grossAmount"`
+	TotalFee     float64 `json:"totalFee"`
+	LiquidAmount float64 `json:"liquidAmount"`
+	// Lag is the number of accepted payments missing from the gateway
+	// summary. A negative lag means the gateway reported payments it was
+	// never sent, which disqualifies it.
+	Lag          int64 `json:"lag"`
+	Disqualified bool  `json:"disqualified"`
+}
+
+// score derives the bonus, fine, amounts and lag from the measured fields.
+func (r *report) score() {
+	if r.Duration > 0 {
+		r.Throughput = round(float64(r.Succeeded)/r.Duration, 2)
+	}
+	r.P99 = round(r.P99, 2)
+	r.P99Bonus = max(round((p99BonusTarget-r.P99)*p99BonusRate, 2), 0)
+
+	r.Fine = 0
+	if r.Inconsistencies > 0 {
+		r.Fine = inconsistencyFine
+	}
+
+	r.GrossAmount = round(r.Default.Amount+r.Fallback.Amount, 2)
+	r.TotalFee = round(r.Default.Fee+r.Fallback.Fee, 2)
+	partial := r.GrossAmount - r.TotalFee
+	r.LiquidAmount = round(partial+partial*r.P99Bonus-partial*r.Fine, 2)
+
+	r.Lag = r.Succeeded - (r.Default.Requests + r.Fallback.Requests)
+	r.Disqualified = r.Lag < 0
+}
+
+func round(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
+
+// teardown fetches the final summaries of the gateway and the processors and
+// scores the run.
+func (r *runner) teardown(elapsed time.Duration) (*report, error) {
+	to := time.Now()
+	from := to.Add(-(r.options.duration + summarySlack))
+
+	gateway, err := r.client.gatewaySummary(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the gateway summary: %w", err)
+	}
+
+	report := &report{
+		Participant:     r.options.participant,
+		MaxVUs:          r.options.maxVUs,
+		Duration:        elapsed.Seconds(),
+		Succeeded:       r.succeeded.Load(),
+		Failed:          r.failed.Load(),
+		P99:             float64(r.latencies.percentile(99)) / float64(time.Millisecond),
+		Inconsistencies: r.inconsistencies.Load(),
+	}
+
+	for _, processor := range []struct {
+		processor
+		report *processorReport
+	}{
+		{r.defaultProcessor, &report.Default},
+		{r.fallbackProcessor, &report.Fallback},
+	} {
+		summary, err := r.client.processorSummary(processor.processor, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the %s summary: %w", processor.name, err)
+		}
+
+		totals := gateway[processor.name].SummaryTotals
+		*processor.report = processorReport{
+			Requests: totals.TotalRequest,
+			Amount:   totals.TotalAmount,
+			Fee:      summary.FeePerTransaction * totals.TotalAmount,
+		}
+	}
+
+	report.score()
+	return report, nil
+}
+
+// write prints the report for a person to read.
+func (r *report) write(w io.Writer) {
+	fmt.Fprintf(w, "\nParticipant:      %s\n", r.Participant)
+	fmt.Fprintf(w, "Virtual users:    up to %d over %.0fs\n", r.MaxVUs, r.Duration)
+	fmt.Fprintf(w, "Payments:         %d succeeded, %d failed\n", r.Succeeded, r.Failed)
+	fmt.Fprintf(w, "Throughput:       %.2f payments/s\n", r.Throughput)
+	fmt.Fprintf(w, "p99:              %.2fms (bonus %.0f%%)\n", r.P99, r.P99Bonus*100)
+	fmt.Fprintf(w, "Inconsistencies:  %d (fine %.0f%%)\n", r.Inconsistencies, r.Fine*100)
+	fmt.Fprintf(w, "Default:          %d payments, %.2f gross, %.2f fee\n", r.Default.Requests, r.Default.Amount, r.Default.Fee)
+	fmt.Fprintf(w, "Fallback:         %d payments, %.2f gross, %.2f fee\n", r.Fallback.Requests, r.Fallback.Amount, r.Fallback.Fee)
+	fmt.Fprintf(w, "Lag:              %d\n", r.Lag)
+	if r.Disqualified {
+		fmt.Fprintln(w, "                  negative lag: the gateway reported payments it was never sent")
+	}
+	fmt.Fprintf(w, "Gross amount:     %.2f\n", r.GrossAmount)
+	fmt.Fprintf(w, "Total fee:        %.2f\n", r.TotalFee)
+	fmt.Fprintf(w, "Liquid amount:    %.2f\n", r.LiquidAmount)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyRecorder_InterpolatesPercentiles(t *testing.T) {
+	var recorder latencyRecorder
+	if got := recorder.percentile(99); got != 0 {
+		t.Fatalf("got %s with no latencies, want 0", got)
+	}
+
+	for i := 100; i >= 1; i-- {
+		recorder.add(time.Duration(i) * time.Millisecond)
+	}
+
+	if got, want := recorder.percentile(99), 99010*time.Microsecond; got != want {
+		t.Fatalf("got p99 %s, want %s", got, want)
+	}
+	if got := recorder.percentile(100); got != 100*time.Millisecond {
+		t.Fatalf("got p100 %s, want the slowest latency", got)
+	}
+}
+
+func TestReport_ScoresLikeTheRinhaScript(t *testing.T) {
+	report := &report{
+		Duration:        60,
+		Succeeded:       1200,
+		P99:             6.004,
+		Inconsistencies: 0,
+		Default:         processorReport{Requests: 1000, Amount: 19900, Fee: 995},
+		Fallback:        processorReport{Requests: 200, Amount: 3980, Fee: 597},
+	}
+	report.score()
+
+	if report.Throughput != 20 || report.P99 != 6 || report.P99Bonus != 0.1 {
+		t.Fatalf("got throughput %v, p99 %v and bonus %v, want 20, 6 and 0.1", report.Throughput, report.P99, report.P99Bonus)
+	}
+	if report.GrossAmount != 23880 || report.TotalFee != 1592 || report.LiquidAmount != 24516.8 {
+		t.Fatalf("got gross %v, fee %v and liquid %v", report.GrossAmount, report.TotalFee, report.LiquidAmount)
+	}
+	if report.Lag != 0 || report.Disqualified {
+		t.Fatalf("got lag %d, want none", report.Lag)
+	}
+
+	report.Inconsistencies = 3
+	report.P99 = 20
+	report.Succeeded = 1100
+	report.score()
+
+	if report.P99Bonus != 0 || report.Fine != 0.35 {
+		t.Fatalf("got bonus %v and fine %v, want 0 and 0.35", report.P99Bonus, report.Fine)
+	}
+	if report.LiquidAmount != 14487.2 {
+		t.Fatalf("got liquid %v, want 14487.2", report.LiquidAmount)
+	}
+	if report.Lag != -100 || !report.Disqualified {
+		t.Fatalf("got lag %d, want -100 and a disqualification", report.Lag)
+	}
+}
+
+func TestVUsAt_RampsLinearly(t *testing.T) {
+	duration := 60 * time.Second
+	cases := map[time.Duration]int{0: 1, 30 * time.Second: 250, 60 * time.Second: 500, 90 * time.Second: 500}
+
+	for elapsed, want := range cases {
+		if got := vusAt(elapsed, duration, 500); got != want {
+			t.Errorf("vusAt(%s) = %d, want %d", elapsed, got, want)
+		}
+	}
+}
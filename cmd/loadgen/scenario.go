@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/utils"
+)
+
+const (
+	// vuPause is the pause of a virtual user between two payments.
+	vuPause = time.Second
+	// rampTick is how often the number of virtual users is raised.
+	rampTick = 100 * time.Millisecond
+
+	// consistencyInterval separates two consistency checks, which compare
+	// the window from consistencyFrom to consistencyTo ago, leaving the
+	// latest payments time to settle.
+	consistencyInterval = 10 * time.Second
+	consistencyFrom     = 15 * time.Second
+	consistencyTo       = 1500 * time.Millisecond
+
+	// summarySlack widens the window of the final summary comparison past
+	// the start of the run.
+	summarySlack = 10 * time.Second
+)
+
+// stage sets the delay, in milliseconds, and the failure of both processors
+// at a given time of the run.
+type stage struct {
+	at              time.Duration
+	defaultDelay    int
+	defaultFailure  bool
+	fallbackDelay   int
+	fallbackFailure bool
+}
+
+// stages are those of rinha-test/rinha.js: the default processor slows down,
+// then fails, then both fail, before recovering while the fallback turns
+// very slow.
+var stages = []stage{
+	{at: 1 * time.Second},
+	{at: 10 * time.Second, defaultDelay: 100},
+	{at: 20 * time.Second, defaultDelay: 100, defaultFailure: true},
+	{at: 30 * time.Second, defaultDelay: 2000, defaultFailure: true, fallbackDelay: 1000, fallbackFailure: true},
+	{at: 40 * time.Second, defaultDelay: 20, fallbackDelay: 20},
+	{at: 50 * time.Second, fallbackDelay: 5000},
+}
+
+// errFinished ends the scenarios once the run lasted its whole duration.
+var errFinished = errors.New("run finished")
+
+// runner runs the scenarios and collects what the report is made of.
+type runner struct {
+	options           *options
+	client            *client
+	defaultProcessor  processor
+	fallbackProcessor processor
+
+	latencies       latencyRecorder
+	succeeded       atomic.Int64
+	failed          atomic.Int64
+	inconsistencies atomic.Int64
+}
+
+func newRunner(options *options) *runner {
+	return &runner{
+		options:           options,
+		client:            newClient(options),
+		defaultProcessor:  processor{name: "default", url: options.defaultURL},
+		fallbackProcessor: processor{name: "fallback", url: options.fallbackURL},
+	}
+}
+
+// setup hands the processors the token of the run and empties them and,
+// when it allows it, the gateway.
+func (r *runner) setup() error {
+	for _, processor := range []processor{r.defaultProcessor, r.fallbackProcessor} {
+		if err := r.client.setToken(processor); err != nil {
+			return fmt.Errorf("failed to set the token of %s: %w", processor.name, err)
+		}
+		if err := r.client.purgeProcessor(processor); err != nil {
+			return fmt.Errorf("failed to purge %s: %w", processor.name, err)
+		}
+	}
+
+	if err := r.client.purgeGateway(); err != nil {
+		fmt.Printf("The gateway was not purged, which it need not support: %s\n", err)
+	}
+	return nil
+}
+
+// run runs the payments, consistency and stage scenarios side by side for the
+// duration of the run, returning how long it took. A stage failing to apply
+// aborts the run.
+func (r *runner) run() (time.Duration, error) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	timer := time.AfterFunc(r.options.duration, func() { cancel(errFinished) })
+	defer timer.Stop()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		r.ramp(ctx, &wg, start)
+	}()
+	go func() {
+		defer wg.Done()
+		r.checkConsistency(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		if err := r.applyStages(ctx, start); err != nil {
+			cancel(err)
+		}
+	}()
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != errFinished {
+		return time.Since(start), err
+	}
+	return time.Since(start), nil
+}
+
+// vusAt returns the number of virtual users at a time of the run, ramping
+// linearly from one to maxVUs over its duration.
+func vusAt(elapsed, duration time.Duration, maxVUs int) int {
+	if elapsed >= duration {
+		return maxVUs
+	}
+	return 1 + int(float64(maxVUs-1)*float64(elapsed)/float64(duration))
+}
+
+// ramp starts virtual users as the run goes, each adding to wg.
+func (r *runner) ramp(ctx context.Context, wg *sync.WaitGroup, start time.Time) {
+	ticker := time.NewTicker(rampTick)
+	defer ticker.Stop()
+
+	vus := 0
+	for {
+		for target := vusAt(time.Since(start), r.options.duration, r.options.maxVUs); vus < target; vus++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.vu(ctx)
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// vu submits a payment every vuPause until the run ends. Only payments the
+// gateway accepted count as succeeded, and only answered requests add to the
+// latencies.
+func (r *runner) vu(ctx context.Context) {
+	for ctx.Err() == nil {
+		status, elapsed, err := r.client.pay(utils.NewUUID(), r.options.amount)
+		switch {
+		case err != nil:
+			r.failed.Add(1)
+		case status == 200 || status == 201 || status == 202 || status == 204:
+			r.succeeded.Add(1)
+			r.latencies.add(elapsed)
+		default:
+			r.failed.Add(1)
+			if status < 400 {
+				r.latencies.add(elapsed)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(vuPause):
+		}
+	}
+}
+
+// checkConsistency compares, every consistencyInterval, the payments the
+// gateway reports with those the processors received. A check whose
+// summaries cannot be fetched is skipped rather than counted.
+func (r *runner) checkConsistency(ctx context.Context) {
+	for {
+		now := time.Now()
+		from, to := now.Add(-consistencyFrom), now.Add(-consistencyTo)
+
+		inconsistencies, err := r.compareSummaries(from, to)
+		if err != nil {
+			fmt.Printf("Consistency check skipped: %s\n", err)
+		} else if inconsistencies > 0 {
+			r.inconsistencies.Add(inconsistencies)
+			fmt.Printf("%d inconsistencies found\n", inconsistencies)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(consistencyInterval):
+		}
+	}
+}
+
+// compareSummaries returns by how many payments the gateway and the
+// processors disagree between from and to.
+func (r *runner) compareSummaries(from, to time.Time) (int64, error) {
+	gateway, err := r.client.gatewaySummary(from, to)
+	if err != nil {
+		return 0, err
+	}
+	defaultSummary, err := r.client.processorSummary(r.defaultProcessor, from, to)
+	if err != nil {
+		return 0, err
+	}
+	fallbackSummary, err := r.client.processorSummary(r.fallbackProcessor, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	diff := gateway[r.defaultProcessor.name].TotalRequest - defaultSummary.TotalRequests +
+		gateway[r.fallbackProcessor.name].TotalRequest - fallbackSummary.TotalRequests
+	return max(diff, -diff), nil
+}
+
+// applyStages applies each stage at its time, skipping those past the end of
+// the run.
+func (r *runner) applyStages(ctx context.Context, start time.Time) error {
+	for _, stage := range stages {
+		if stage.at >= r.options.duration {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(start.Add(stage.at))):
+		}
+
+		fmt.Printf("Stage at %s: default %dms failing=%t, fallback %dms failing=%t\n",
+			stage.at, stage.defaultDelay, stage.defaultFailure, stage.fallbackDelay, stage.fallbackFailure)
+		if err := r.applyStage(stage); err != nil {
+			return fmt.Errorf("failed to apply the stage at %s: %w", stage.at, err)
+		}
+	}
+	return nil
+}
+
+func (r *runner) applyStage(stage stage) error {
+	if err := r.client.setDelay(r.defaultProcessor, stage.defaultDelay); err != nil {
+		return err
+	}
+	if err := r.client.setDelay(r.fallbackProcessor, stage.fallbackDelay); err != nil {
+		return err
+	}
+	if err := r.client.setFailure(r.defaultProcessor, stage.defaultFailure); err != nil {
+		return err
+	}
+	return r.client.setFailure(r.fallbackProcessor, stage.fallbackFailure)
+}
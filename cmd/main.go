@@ -8,7 +8,10 @@ import (
 )
 
 func main() {
-	config := config.Load()
+	config, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %s", err)
+	}
 
 	app, err := app.NewApp(config)
 	if err != nil {
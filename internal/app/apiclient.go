@@ -0,0 +1,113 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/services"
+	"github.com/valyala/fasthttp"
+)
+
+func (app *Application) apiClientsHandler(ctx *fasthttp.RequestCtx) {
+	switch {
+	case ctx.IsGet():
+		clients, err := app.services.Auth.Clients()
+		if err != nil {
+			writeError(ctx, 500, constants.ProblemInternal, "Failed to list API clients")
+			fmt.Println(err)
+			return
+		}
+
+		for _, client := range clients {
+			redactClient(client)
+		}
+		writeJSON(ctx, 200, clients)
+	case ctx.IsPost():
+		var req models.APIClientRequest
+		if !app.readJSON(ctx, &req, false) {
+			return
+		}
+
+		var params invalidParams
+		app.checkCallbackURL(&params, req.CallbackURL)
+		if req.MerchantID != "" && !merchantIDPattern.MatchString(req.MerchantID) {
+			params.add("merchantId", merchantIDReason)
+		}
+		if problem := params.problem(); problem != nil {
+			writeProblem(ctx, problem)
+			return
+		}
+
+		response, err := app.services.Auth.CreateClient(&req)
+		if err != nil {
+			app.writeAPIClientError(ctx, err)
+			return
+		}
+
+		redactClient(response.Client)
+		writeJSON(ctx, 201, response)
+	default:
+		writeMethodNotAllowed(ctx)
+	}
+}
+
+// apiClientHandler serves /admin/api-clients/{id}, and the rotate and revoke
+// actions below it.
+func (app *Application) apiClientHandler(ctx *fasthttp.RequestCtx, id, action string) {
+	switch {
+	case action == "" && ctx.IsGet():
+		client, err := app.services.Auth.Client(id)
+		if err != nil {
+			app.writeAPIClientError(ctx, err)
+			return
+		}
+
+		redactClient(client)
+		writeJSON(ctx, 200, client)
+	case action == "rotate" && ctx.IsPost():
+		response, err := app.services.Auth.RotateKey(id)
+		if err != nil {
+			app.writeAPIClientError(ctx, err)
+			return
+		}
+
+		redactClient(response.Client)
+		writeJSON(ctx, 200, response)
+	case action == "revoke" && ctx.IsPost():
+		client, err := app.services.Auth.RevokeClient(id)
+		if err != nil {
+			app.writeAPIClientError(ctx, err)
+			return
+		}
+
+		redactClient(client)
+		writeJSON(ctx, 200, client)
+	case action == "" || action == "rotate" || action == "revoke":
+		writeMethodNotAllowed(ctx)
+	default:
+		writeNotFound(ctx)
+	}
+}
+
+// redactClient clears the secrets of a client before it is written out. The
+// API key and webhook secret are only shown in the response issuing them.
+func redactClient(client *models.APIClient) {
+	client.KeyHash = ""
+	client.WebhookSecret = ""
+}
+
+func (app *Application) writeAPIClientError(ctx *fasthttp.RequestCtx, err error) {
+	switch {
+	case errors.Is(err, services.ErrClientNotFound):
+		writeError(ctx, 404, constants.ProblemAPIClientNotFound, "API client not found")
+	case errors.Is(err, services.ErrClientRevoked):
+		writeError(ctx, 409, constants.ProblemAPIClientRevoked, "API client is revoked")
+	case errors.Is(err, services.ErrMissingName), errors.Is(err, services.ErrInvalidScope):
+		writeError(ctx, 400, constants.ProblemValidationFailed, err.Error())
+	default:
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to manage API client")
+		fmt.Println(err)
+	}
+}
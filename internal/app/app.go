@@ -1,10 +1,14 @@
 package app
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
 	"github.com/mochaeng/payment-gateway/internal/services"
 	"github.com/mochaeng/payment-gateway/internal/store"
 	"github.com/valyala/fasthttp"
@@ -16,12 +20,15 @@ type Application struct {
 }
 
 func NewApp(config *config.Config) (*Application, error) {
-	store, err := store.NewRedisStore(config.RedisURL)
+	store, err := store.New(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create store: %w", err)
 	}
 
-	services := services.NewServices(config, store)
+	services, err := services.NewServices(config, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create services: %w", err)
+	}
 
 	return &Application{
 		config:   config,
@@ -30,34 +37,149 @@ func NewApp(config *config.Config) (*Application, error) {
 }
 
 func (app *Application) Mount() *fasthttp.Server {
+	paymentsHandler := app.rateLimitSource(app.authenticate(constants.ScopePaymentsWrite, app.rateLimit(app.paymentsHandler)))
+	scheduledPaymentsHandler := app.authenticate(constants.ScopePaymentsRead, app.scheduledPaymentsHandler)
+	batchPaymentsHandler := app.rateLimitSource(app.authenticate(constants.ScopePaymentsWrite, app.rateLimitBatch(app.idempotent(app.batchPaymentsHandler))))
+	summaryHandler := app.authenticate(constants.ScopeSummaryRead, app.paymentsSummaryHandler)
+	eventsHandler := app.authenticate(constants.ScopeEventsRead, app.eventsHandler)
+	webhookDeliveriesHandler := app.admin(app.webhookDeliveriesHandler)
+	apiClientsHandler := app.admin(app.apiClientsHandler)
+
 	return &fasthttp.Server{
+		MaxRequestBodySize: max(app.config.MaxBodySize, app.config.MaxBatchBodySize),
+		ErrorHandler: func(ctx *fasthttp.RequestCtx, err error) {
+			if errors.Is(err, fasthttp.ErrBodyTooLarge) {
+				writeError(ctx, 413, constants.ProblemBodyTooLarge, "Request body is too large")
+				return
+			}
+			writeError(ctx, 400, constants.ProblemMalformedRequest, "Malformed HTTP request")
+		},
 		Handler: func(ctx *fasthttp.RequestCtx) {
 			ctx.Response.Header.Set("Content-Type", "application/json")
 
 			switch string(ctx.Path()) {
 			case "/payments":
 				if ctx.IsPost() {
-					app.paymentsHandler(ctx)
+					paymentsHandler(ctx)
+				} else {
+					writeMethodNotAllowed(ctx)
+				}
+			case "/payments/batch":
+				if ctx.IsPost() {
+					batchPaymentsHandler(ctx)
+				} else {
+					writeMethodNotAllowed(ctx)
+				}
+			case "/payments/scheduled":
+				if ctx.IsGet() {
+					scheduledPaymentsHandler(ctx)
 				} else {
-					ctx.SetStatusCode(405)
-					ctx.SetBodyString(`{"error":"Method not allowed"}`)
+					writeMethodNotAllowed(ctx)
 				}
 			case "/payments-summary":
 				if ctx.IsGet() {
-					app.paymentsSummaryHandler(ctx)
+					summaryHandler(ctx)
+				} else {
+					writeMethodNotAllowed(ctx)
+				}
+			case "/events":
+				if ctx.IsGet() {
+					eventsHandler(ctx)
+				} else {
+					writeMethodNotAllowed(ctx)
+				}
+			case "/metrics":
+				if ctx.IsGet() {
+					ctx.Response.Header.Set("Content-Type", "text/plain; version=0.0.4")
+					ctx.SetStatusCode(200)
+					ctx.SetBody(app.services.Metrics.Render())
+				} else {
+					writeMethodNotAllowed(ctx)
+				}
+			case "/webhooks/deliveries":
+				if ctx.IsGet() {
+					webhookDeliveriesHandler(ctx)
 				} else {
-					ctx.SetStatusCode(405)
-					ctx.SetBodyString(`{"error":"Method not allowed"}`)
+					writeMethodNotAllowed(ctx)
 				}
+			case "/admin/api-clients":
+				apiClientsHandler(ctx)
 			default:
-				ctx.SetStatusCode(404)
-				ctx.SetBodyString(`{"error":"Not found"}`)
+				app.routeResource(ctx, string(ctx.Path()))
 			}
 		},
 	}
 }
 
+// routeResource dispatches the paths carrying an identifier, such as
+// /payments/{id}/cancel and /webhooks/deliveries/{id}/redeliver.
+func (app *Application) routeResource(ctx *fasthttp.RequestCtx, path string) {
+	if rest, ok := strings.CutPrefix(path, "/payments/"); ok && rest != "" {
+		correlationID, action, _ := strings.Cut(rest, "/")
+		switch {
+		case action == "" && ctx.IsGet():
+			app.authenticate(constants.ScopePaymentsRead, func(ctx *fasthttp.RequestCtx) {
+				app.paymentStatusHandler(ctx, correlationID)
+			})(ctx)
+		case action == "cancel" && ctx.IsPost():
+			app.authenticate(constants.ScopePaymentsWrite, func(ctx *fasthttp.RequestCtx) {
+				app.paymentCancelHandler(ctx, correlationID)
+			})(ctx)
+		case action == "refund" && ctx.IsPost():
+			app.authenticate(constants.ScopePaymentsWrite, app.idempotent(func(ctx *fasthttp.RequestCtx) {
+				app.paymentRefundHandler(ctx, correlationID)
+			}))(ctx)
+		case action == "" || action == "cancel" || action == "refund":
+			writeMethodNotAllowed(ctx)
+		default:
+			writeNotFound(ctx)
+		}
+		return
+	}
+
+	if rest, ok := strings.CutPrefix(path, "/webhooks/deliveries/"); ok && rest != "" {
+		app.admin(func(ctx *fasthttp.RequestCtx) {
+			if id, ok := strings.CutSuffix(rest, "/redeliver"); ok {
+				if ctx.IsPost() {
+					app.webhookRedeliverHandler(ctx, id)
+				} else {
+					writeMethodNotAllowed(ctx)
+				}
+				return
+			}
+
+			if ctx.IsGet() {
+				app.webhookDeliveryHandler(ctx, rest)
+			} else {
+				writeMethodNotAllowed(ctx)
+			}
+		})(ctx)
+		return
+	}
+
+	if rest, ok := strings.CutPrefix(path, "/admin/api-clients/"); ok && rest != "" {
+		id, action, _ := strings.Cut(rest, "/")
+		app.admin(func(ctx *fasthttp.RequestCtx) {
+			app.apiClientHandler(ctx, id, action)
+		})(ctx)
+		return
+	}
+
+	writeNotFound(ctx)
+}
+
 func (app *Application) Run(server *fasthttp.Server) error {
 	log.Printf("Starting server on port %s", app.config.Port)
 	return server.ListenAndServe(":" + app.config.Port)
 }
+
+func writeJSON(ctx *fasthttp.RequestCtx, statusCode int, v any) {
+	response, err := json.Marshal(v)
+	if err != nil {
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to serialize response")
+		return
+	}
+
+	ctx.SetStatusCode(statusCode)
+	ctx.SetBody(response)
+}
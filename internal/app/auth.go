@@ -0,0 +1,81 @@
+package app
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/services"
+	"github.com/valyala/fasthttp"
+)
+
+const apiClientKey = "apiClient"
+
+// authenticate requires a valid API key holding scope, given either as a
+// bearer token or in the X-API-Key header, and attaches the client to the
+// request. It lets everything through when authentication is disabled.
+func (app *Application) authenticate(scope string, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !app.config.AuthEnabled {
+			next(ctx)
+			return
+		}
+
+		apiKey := requestAPIKey(ctx)
+		if apiKey == "" {
+			ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="payments"`)
+			writeError(ctx, 401, constants.ProblemMissingAPIKey, "Missing API key")
+			return
+		}
+
+		client, err := app.services.Auth.Authenticate(apiKey, scope)
+		switch {
+		case errors.Is(err, services.ErrInvalidAPIKey):
+			ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="payments", error="invalid_token"`)
+			writeError(ctx, 401, constants.ProblemInvalidAPIKey, "Invalid API key")
+			return
+		case errors.Is(err, services.ErrMissingScope):
+			writeError(ctx, 403, constants.ProblemMissingScope, fmt.Sprintf("API key lacks the '%s' scope", scope))
+			return
+		case err != nil:
+			writeError(ctx, 500, constants.ProblemInternal, "Failed to authenticate")
+			fmt.Println(err)
+			return
+		}
+
+		ctx.SetUserValue(apiClientKey, client)
+		next(ctx)
+	}
+}
+
+// admin requires the ADMIN_TOKEN as bearer token. Admin endpoints are
+// unavailable when no token is configured.
+func (app *Application) admin(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		token, ok := strings.CutPrefix(string(ctx.Request.Header.Peek("Authorization")), "Bearer ")
+		if app.config.AdminToken == "" || !ok ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(app.config.AdminToken)) != 1 {
+			ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(ctx, 401, constants.ProblemAdminTokenRequired, "Admin token required")
+			return
+		}
+
+		next(ctx)
+	}
+}
+
+// authenticatedClient returns the client attached by authenticate, or nil.
+func authenticatedClient(ctx *fasthttp.RequestCtx) *models.APIClient {
+	client, _ := ctx.UserValue(apiClientKey).(*models.APIClient)
+	return client
+}
+
+func requestAPIKey(ctx *fasthttp.RequestCtx) string {
+	if token, ok := strings.CutPrefix(string(ctx.Request.Header.Peek("Authorization")), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return string(ctx.Request.Header.Peek("X-API-Key"))
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/services"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	batchItemAccepted = "accepted"
+	batchItemRejected = "rejected"
+)
+
+var ndjsonMediaTypes = []string{"application/x-ndjson", "application/ndjson", "application/jsonl"}
+
+// batchItemsKey holds the items of a batch once rateLimitBatch decoded them.
+const batchItemsKey = "batchItems"
+
+// batchPaymentsHandler queues a batch of payments given as a JSON array or as
+// NDJSON, one payment per line. Items are validated and admitted separately,
+// and the response reports the outcome of each of them.
+func (app *Application) batchPaymentsHandler(ctx *fasthttp.RequestCtx) {
+	if limit := app.config.MaxBatchBodySize; limit > 0 && len(ctx.PostBody()) > limit {
+		writeError(ctx, 413, constants.ProblemBodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", limit))
+		return
+	}
+	if !hasMediaType(ctx, append(ndjsonMediaTypes, "application/json")...) {
+		writeError(ctx, 415, constants.ProblemUnsupportedMediaType, "Expected an application/json or application/x-ndjson body")
+		return
+	}
+
+	items, ok := ctx.UserValue(batchItemsKey).([]json.RawMessage)
+	if !ok {
+		var err error
+		if items, err = decodeBatch(ctx); err != nil {
+			writeError(ctx, 400, constants.ProblemInvalidJSON, "Invalid batch, expected a JSON array or NDJSON")
+			return
+		}
+	}
+	if len(items) == 0 {
+		writeError(ctx, 400, constants.ProblemEmptyBatch, "Empty batch")
+		return
+	}
+	if len(items) > app.config.MaxBatchSize {
+		writeError(ctx, 413, constants.ProblemBatchTooLarge, fmt.Sprintf("Batch too large, at most %d payments are accepted", app.config.MaxBatchSize))
+		return
+	}
+
+	response := models.BatchPaymentResponse{Items: make([]models.BatchItemResult, len(items))}
+	payments := make([]*models.QueuedPayment, 0, len(items))
+	indexes := make([]int, 0, len(items))
+	seen := make(map[string]bool, len(items))
+
+	for i, item := range items {
+		result := &response.Items[i]
+		result.Index = i
+		result.Status = batchItemRejected
+
+		var req models.PaymentRequest
+		if problem := decodeStrict(item, &req); problem != nil {
+			rejectItem(result, problem)
+			continue
+		}
+		result.CorrelationID = req.CorrelationID
+
+		payment, problem := app.newPayment(ctx, &req)
+		if problem != nil {
+			rejectItem(result, problem)
+			continue
+		}
+		if seen[payment.Key()] {
+			result.Code = constants.ProblemDuplicateInBatch
+			result.Error = "Duplicate payment in batch"
+			continue
+		}
+		seen[payment.Key()] = true
+
+		payments = append(payments, payment)
+		indexes = append(indexes, i)
+	}
+
+	errs, err := app.services.Payment.SendBatch(payments)
+	if err != nil {
+		writeError(ctx, 500, constants.ProblemInternal, "Payment batch failed")
+		fmt.Println(err)
+		return
+	}
+
+	var retryAfter time.Duration
+	for j, err := range errs {
+		result := &response.Items[indexes[j]]
+		if err == nil {
+			result.Status = batchItemAccepted
+			continue
+		}
+
+		_, result.Code, result.Error = sendError(err)
+		var backpressure *services.BackpressureError
+		if errors.As(err, &backpressure) {
+			retryAfter = max(retryAfter, backpressure.RetryAfter)
+		}
+	}
+
+	for _, item := range response.Items {
+		if item.Status == batchItemAccepted {
+			response.Accepted++
+		} else {
+			response.Rejected++
+		}
+	}
+
+	if retryAfter > 0 {
+		ctx.Response.Header.Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+	}
+	writeJSON(ctx, 200, response)
+}
+
+// rejectItem records the problem rejecting a batch item.
+func rejectItem(result *models.BatchItemResult, problem *models.Problem) {
+	result.Code = problem.Code
+	result.Error = problem.Detail
+	result.InvalidParams = problem.InvalidParams
+}
+
+// decodeBatch splits a batch body into its items, without decoding them, so
+// one malformed item does not reject the whole batch.
+func decodeBatch(ctx *fasthttp.RequestCtx) ([]json.RawMessage, error) {
+	body := ctx.PostBody()
+
+	if hasMediaType(ctx, ndjsonMediaTypes...) {
+		var items []json.RawMessage
+		for _, line := range bytes.Split(body, []byte("\n")) {
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				items = append(items, json.RawMessage(line))
+			}
+		}
+		return items, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
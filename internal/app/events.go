@@ -0,0 +1,99 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/services"
+	"github.com/mochaeng/payment-gateway/internal/store"
+	"github.com/valyala/fasthttp"
+)
+
+// eventsRetry is the reconnection delay suggested to event stream clients.
+const eventsRetry = time.Second
+
+// eventsHandler streams payment events as Server-Sent Events. The "type",
+// "merchant", "correlationId" and "processor" query parameters filter them,
+// "type" taking a comma-separated list. A client resuming with the
+// Last-Event-ID header first receives the events it missed.
+func (app *Application) eventsHandler(ctx *fasthttp.RequestCtx) {
+	if !app.config.Events.Enabled {
+		writeError(ctx, 404, constants.ProblemEventsDisabled, "The event stream is disabled")
+		return
+	}
+
+	args := ctx.QueryArgs()
+	merchantID, err := requestMerchant(ctx, string(args.Peek("merchant")))
+	if err != nil {
+		writeMerchantError(ctx, err)
+		return
+	}
+
+	filter := services.EventFilter{
+		MerchantID:    merchantID,
+		CorrelationID: string(args.Peek("correlationId")),
+		Processor:     string(args.Peek("processor")),
+	}
+	if types := string(args.Peek("type")); types != "" {
+		for _, name := range strings.Split(types, ",") {
+			eventType := constants.PaymentEventType(strings.TrimSpace(name))
+			if !slices.Contains(constants.PaymentEventTypes, eventType) {
+				writeProblem(ctx, invalidParam("type", fmt.Sprintf("unknown event type '%s'", eventType)))
+				return
+			}
+			filter.Types = append(filter.Types, eventType)
+		}
+	}
+
+	lastEventID := strings.TrimSpace(string(ctx.Request.Header.Peek("Last-Event-ID")))
+	events, cancel, err := app.services.Events.Subscribe(filter, lastEventID)
+	if errors.Is(err, store.ErrInvalidEventID) {
+		writeError(ctx, 400, constants.ProblemInvalidEventID, "Last-Event-ID is not an event id")
+		return
+	}
+	if err != nil {
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to subscribe to payment events")
+		fmt.Println(err)
+		return
+	}
+
+	ctx.Response.Header.Set("Content-Type", "text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.Response.Header.Set("X-Accel-Buffering", "no")
+	ctx.SetStatusCode(200)
+
+	heartbeat := max(app.config.Events.Heartbeat, time.Second)
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+		for {
+			if err := w.Flush(); err != nil {
+				return
+			}
+
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			case <-ticker.C:
+				w.WriteString(": keep-alive\n\n")
+			}
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/valyala/fasthttp"
+)
+
+// idempotent applies requests sent with an Idempotency-Key once. Retries with
+// the same key and body get the original response, replayed with an
+// Idempotent-Replayed header. Server errors are not recorded so the request
+// can be retried.
+func (app *Application) idempotent(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		idempotencyKey := string(ctx.Request.Header.Peek("Idempotency-Key"))
+		if idempotencyKey == "" {
+			next(ctx)
+			return
+		}
+
+		key := idempotencyScope(ctx, idempotencyKey)
+		sum := sha256.Sum256(ctx.PostBody())
+		requestHash := hex.EncodeToString(sum[:])
+
+		previous, err := app.services.Idempotency.Claim(key, requestHash)
+		switch {
+		case err != nil:
+			writeError(ctx, 500, constants.ProblemInternal, "Failed to check Idempotency-Key")
+			fmt.Println(err)
+			return
+		case previous != nil && previous.RequestHash != requestHash:
+			writeError(ctx, 422, constants.ProblemIdempotencyMismatch, "Idempotency-Key was already used for a different request")
+			return
+		case previous != nil && previous.StatusCode == 0:
+			writeError(ctx, 409, constants.ProblemIdempotencyInFlight, "A request with this Idempotency-Key is still being processed")
+			return
+		case previous != nil:
+			ctx.Response.Header.Set("Idempotent-Replayed", "true")
+			if previous.StatusCode >= 400 {
+				ctx.Response.Header.SetContentType(problemContentType)
+			}
+			ctx.SetStatusCode(previous.StatusCode)
+			ctx.SetBody(previous.Body)
+			return
+		}
+
+		next(ctx)
+
+		statusCode := ctx.Response.StatusCode()
+		if statusCode >= 500 {
+			err = app.services.Idempotency.Release(key)
+		} else {
+			err = app.services.Idempotency.Save(key, requestHash, statusCode, ctx.Response.Body())
+		}
+		if err != nil {
+			fmt.Printf("Failed to record response for Idempotency-Key [%s]: %s\n", idempotencyKey, err)
+		}
+	}
+}
+
+// idempotencyScope scopes an Idempotency-Key to the caller and the endpoint, so
+// clients picking the same key do not see each other's responses.
+func idempotencyScope(ctx *fasthttp.RequestCtx, key string) string {
+	scope := ""
+	if client := authenticatedClient(ctx); client != nil {
+		scope = client.ID
+	}
+	return scope + ":" + string(ctx.Path()) + ":" + key
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/valyala/fasthttp"
+)
+
+var merchantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+const merchantIDReason = "expected up to 64 letters, digits, '-' or '_'"
+
+var (
+	errInvalidMerchant  = errors.New("invalid merchant id")
+	errMerchantMismatch = errors.New("api client is bound to another merchant")
+)
+
+// requestMerchant returns the merchant a request acts for. API clients bound
+// to a merchant act for it by default and cannot act for any other one.
+func requestMerchant(ctx *fasthttp.RequestCtx, requested string) (string, error) {
+	if requested != "" && !merchantIDPattern.MatchString(requested) {
+		return "", errInvalidMerchant
+	}
+
+	client := authenticatedClient(ctx)
+	if client == nil || client.MerchantID == "" {
+		return requested, nil
+	}
+	if requested != "" && requested != client.MerchantID {
+		return "", errMerchantMismatch
+	}
+	return client.MerchantID, nil
+}
+
+// merchantProblem returns the problem reported for an error of requestMerchant,
+// naming param as the invalid parameter.
+func merchantProblem(err error, param string) *models.Problem {
+	if errors.Is(err, errMerchantMismatch) {
+		return &models.Problem{
+			Status: 403,
+			Code:   constants.ProblemMerchantForbidden,
+			Detail: "API key is not allowed to act for this merchant",
+		}
+	}
+	return invalidParam(param, merchantIDReason)
+}
+
+// writeMerchantError writes the problem of a "merchant" query parameter.
+func writeMerchantError(ctx *fasthttp.RequestCtx, err error) {
+	writeProblem(ctx, merchantProblem(err, "merchant"))
+}
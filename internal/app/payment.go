@@ -1,9 +1,18 @@
 package app
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/mochaeng/payment-gateway/internal/constants"
 	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/services"
 	"github.com/valyala/fasthttp"
 )
 
@@ -11,26 +20,358 @@ func (app *Application) paymentsHandler(ctx *fasthttp.RequestCtx) {
 	// fmt.Println("receiving payment request")
 
 	var req models.PaymentRequest
-	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
-		ctx.SetStatusCode(400)
-		ctx.SetBodyString(`{"error":"Invalid JSON"}`)
+	if !app.readJSON(ctx, &req, false) {
 		return
 	}
 
-	if req.CorrelationID == "" || req.Amount <= 0 {
-		ctx.SetStatusCode(400)
-		ctx.SetBodyString(`{"error":"Invalid request"}`)
+	payment, problem := app.newPayment(ctx, &req)
+	if problem != nil {
+		writeProblem(ctx, problem)
 		return
 	}
 
-	err := app.services.Payment.Send(req.CorrelationID, req.Amount)
+	wait := app.requestedWait(ctx)
+
+	var results <-chan *models.PaymentResult
+	if wait > 0 {
+		var cancel func()
+		results, cancel = app.services.Payment.AwaitResult(payment.Key())
+		defer cancel()
+	}
+
+	err := app.services.Payment.Send(payment)
+	var backpressure *services.BackpressureError
+	if errors.As(err, &backpressure) {
+		ctx.Response.Header.Set("Retry-After", strconv.Itoa(ceilSeconds(backpressure.RetryAfter)))
+	}
+
 	if err != nil {
-		ctx.SetStatusCode(500)
-		ctx.SetBodyString(`{"error":"Payment failed"}`)
+		status, code, detail := sendError(err)
+		writeError(ctx, status, code, detail)
 		// fmt.Println("failed to process:", err)
+	} else if wait > 0 {
+		app.waitForResult(ctx, payment, results, wait)
 	} else {
 		ctx.SetStatusCode(200)
 		ctx.SetBodyString(`{"message":"Payment processed"}`)
 	}
 
 }
+
+// newPayment validates a payment request and builds the payment to queue. When
+// the request is invalid it returns a nil payment and the problem to report.
+func (app *Application) newPayment(ctx *fasthttp.RequestCtx, req *models.PaymentRequest) (*models.QueuedPayment, *models.Problem) {
+	var params invalidParams
+	checkCorrelationID(&params, req.CorrelationID)
+	app.checkAmount(&params, "amount", req.Amount, false)
+
+	currency := req.Currency
+	if currency == "" {
+		currency = app.config.DefaultCurrency
+	}
+	if minorUnits, ok := app.config.Currencies[currency]; !ok {
+		params.add("currency", fmt.Sprintf("expected one of %s", strings.Join(app.config.CurrencyCodes(), ", ")))
+	} else if !fitsMinorUnits(req.Amount, minorUnits) {
+		params.add("amount", fmt.Sprintf("%s allows %d decimal places", currency, minorUnits))
+	}
+
+	app.checkCallbackURL(&params, req.CallbackURL)
+
+	priority := constants.PaymentPriority(req.Priority)
+	if priority == "" {
+		priority = constants.PriorityNormal
+	} else if !slices.Contains(constants.Priorities, priority) {
+		params.add("priority", "expected one of high, normal or low")
+	}
+
+	if req.MerchantID != "" && !merchantIDPattern.MatchString(req.MerchantID) {
+		params.add("merchantId", merchantIDReason)
+	}
+
+	if problem := params.problem(); problem != nil {
+		return nil, problem
+	}
+
+	merchantID, err := requestMerchant(ctx, req.MerchantID)
+	if err != nil {
+		return nil, merchantProblem(err, "merchantId")
+	}
+
+	payment := &models.QueuedPayment{
+		CorrelationID: req.CorrelationID,
+		Amount:        req.Amount,
+		Currency:      currency,
+		CreatedAt:     time.Now().UTC(),
+		CallbackURL:   req.CallbackURL,
+		MerchantID:    merchantID,
+		Priority:      priority,
+		ScheduledAt:   req.ScheduledAt,
+	}
+
+	if client := authenticatedClient(ctx); client != nil {
+		payment.ClientID = client.ID
+		if payment.CallbackURL == "" {
+			payment.CallbackURL = client.CallbackURL
+		}
+	}
+
+	return payment, nil
+}
+
+// sendError maps an error returned when queueing a payment to the status, code
+// and detail reported to the caller.
+func sendError(err error) (int, constants.ProblemCode, string) {
+	switch {
+	case errors.Is(err, services.ErrQueueFull):
+		return 503, constants.ProblemQueueFull, "Payment queue is full"
+	case errors.Is(err, services.ErrQueueSaturated):
+		return 429, constants.ProblemQueueSaturated, "Payment queue is saturated"
+	case errors.Is(err, services.ErrDuplicatePayment):
+		return 409, constants.ProblemDuplicatePayment, "Payment already submitted"
+	case errors.Is(err, services.ErrNoAllowedProcessor):
+		return 422, constants.ProblemNoAllowedProcessor, "Merchant has no allowed processor"
+	case errors.Is(err, services.ErrUnsupportedCurrency):
+		return 422, constants.ProblemUnsupportedCurrency, "No allowed processor supports the currency"
+	default:
+		return 500, constants.ProblemInternal, "Payment failed"
+	}
+}
+
+// fitsMinorUnits reports whether amount has no more decimal places than the
+// minor units of its currency, allowing for float rounding.
+func fitsMinorUnits(amount float64, minorUnits int) bool {
+	scaled := amount * math.Pow10(minorUnits)
+	return math.Abs(scaled-math.Round(scaled)) < 1e-6
+}
+
+// requestedWait returns how long the caller asked to wait for the payment
+// outcome, through either a "Prefer: wait=N" header or a "wait" query
+// parameter, capped at MaxSyncWait. Zero means asynchronous processing.
+func (app *Application) requestedWait(ctx *fasthttp.RequestCtx) time.Duration {
+	value := string(ctx.QueryArgs().Peek("wait"))
+
+	for _, preference := range strings.Split(string(ctx.Request.Header.Peek("Prefer")), ",") {
+		name, param, found := strings.Cut(strings.TrimSpace(preference), "=")
+		if found && strings.EqualFold(name, "wait") {
+			value = strings.TrimSpace(param)
+		}
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+
+	return min(time.Duration(seconds)*time.Second, app.config.MaxSyncWait)
+}
+
+func (app *Application) waitForResult(ctx *fasthttp.RequestCtx, payment *models.QueuedPayment, results <-chan *models.PaymentResult, wait time.Duration) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	ctx.Response.Header.Set("Preference-Applied", fmt.Sprintf("wait=%d", int(wait.Seconds())))
+
+	select {
+	case result := <-results:
+		if result.Status == constants.PaymentSucceeded {
+			writeJSON(ctx, 200, result)
+		} else {
+			writeJSON(ctx, 502, result)
+		}
+	case <-timer.C:
+		statusURL := "/payments/" + url.PathEscape(payment.CorrelationID)
+		if payment.MerchantID != "" {
+			statusURL += "?merchant=" + url.QueryEscape(payment.MerchantID)
+		}
+		ctx.Response.Header.Set("Location", statusURL)
+		writeJSON(ctx, 202, models.PaymentAcceptedResponse{
+			Message:   "Payment accepted",
+			Status:    constants.PaymentQueued,
+			StatusURL: statusURL,
+		})
+	}
+}
+
+func (app *Application) paymentStatusHandler(ctx *fasthttp.RequestCtx, correlationID string) {
+	merchantID, ok := paymentMerchant(ctx, correlationID)
+	if !ok {
+		return
+	}
+
+	result, err := app.services.Payment.Status(models.PaymentKey(merchantID, correlationID))
+	if errors.Is(err, services.ErrPaymentNotFound) || err == nil && !ownsPayment(ctx, result) {
+		writeError(ctx, 404, constants.ProblemPaymentNotFound, "Payment not found")
+		return
+	}
+	if err != nil {
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to get payment status")
+		fmt.Println(err)
+		return
+	}
+
+	writeJSON(ctx, 200, result)
+}
+
+// ownsPayment reports whether the request may act on the payment. Payments
+// are only visible to the API client that submitted them, other clients of
+// the same merchant getting a 404 as for an unknown payment. Payments
+// submitted without an API key belong to the whole merchant.
+func ownsPayment(ctx *fasthttp.RequestCtx, result *models.PaymentResult) bool {
+	client := authenticatedClient(ctx)
+	return client == nil || result.ClientID == "" || result.ClientID == client.ID
+}
+
+// checkPaymentOwner writes a 404 and returns false when the payment belongs to
+// another API client, as ownsPayment. Unknown payments are left to the action,
+// which reports them itself.
+func (app *Application) checkPaymentOwner(ctx *fasthttp.RequestCtx, key string) bool {
+	if authenticatedClient(ctx) == nil {
+		return true
+	}
+
+	result, err := app.services.Payment.Status(key)
+	if errors.Is(err, services.ErrPaymentNotFound) {
+		return true
+	}
+	if err != nil {
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to get payment status")
+		fmt.Println(err)
+		return false
+	}
+	if !ownsPayment(ctx, result) {
+		writeError(ctx, 404, constants.ProblemPaymentNotFound, "Payment not found")
+		return false
+	}
+	return true
+}
+
+func (app *Application) paymentCancelHandler(ctx *fasthttp.RequestCtx, correlationID string) {
+	merchantID, ok := paymentMerchant(ctx, correlationID)
+	if !ok {
+		return
+	}
+
+	key := models.PaymentKey(merchantID, correlationID)
+	if !app.checkPaymentOwner(ctx, key) {
+		return
+	}
+
+	result, err := app.services.Payment.Cancel(key)
+	switch {
+	case errors.Is(err, services.ErrPaymentNotFound):
+		writeError(ctx, 404, constants.ProblemPaymentNotFound, "Payment not found")
+	case errors.Is(err, services.ErrNotCancellable):
+		writeError(ctx, 409, constants.ProblemNotCancellable, fmt.Sprintf("Payment is %s and can no longer be cancelled", result.Status))
+	case err != nil:
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to cancel payment")
+		fmt.Println(err)
+	default:
+		writeJSON(ctx, 200, result)
+	}
+}
+
+// paymentRefundHandler refunds the amount given in the body, or the whole
+// remaining amount when there is no body or no amount.
+func (app *Application) paymentRefundHandler(ctx *fasthttp.RequestCtx, correlationID string) {
+	merchantID, ok := paymentMerchant(ctx, correlationID)
+	if !ok {
+		return
+	}
+
+	var req models.RefundRequest
+	if !app.readJSON(ctx, &req, true) {
+		return
+	}
+
+	var params invalidParams
+	app.checkAmount(&params, "amount", req.Amount, true)
+	if problem := params.problem(); problem != nil {
+		writeProblem(ctx, problem)
+		return
+	}
+
+	key := models.PaymentKey(merchantID, correlationID)
+	if !app.checkPaymentOwner(ctx, key) {
+		return
+	}
+
+	refund, err := app.services.Payment.Refund(key, req.Amount)
+	switch {
+	case errors.Is(err, services.ErrPaymentNotFound):
+		writeError(ctx, 404, constants.ProblemPaymentNotFound, "Payment not found")
+	case errors.Is(err, services.ErrNotRefundable):
+		writeError(ctx, 409, constants.ProblemNotRefundable, "Only succeeded payments can be refunded")
+	case errors.Is(err, services.ErrRefundTooLarge):
+		writeError(ctx, 422, constants.ProblemRefundTooLarge, "Refund exceeds the amount left to refund")
+	case err != nil:
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to refund payment")
+		fmt.Println(err)
+	default:
+		writeJSON(ctx, 201, refund)
+	}
+}
+
+// paymentMerchant validates the correlation ID of a payment path and returns
+// the merchant the request acts for, writing the problem when either is
+// invalid.
+func paymentMerchant(ctx *fasthttp.RequestCtx, correlationID string) (string, bool) {
+	var params invalidParams
+	checkCorrelationID(&params, correlationID)
+	if problem := params.problem(); problem != nil {
+		writeProblem(ctx, problem)
+		return "", false
+	}
+
+	merchantID, err := requestMerchant(ctx, string(ctx.QueryArgs().Peek("merchant")))
+	if err != nil {
+		writeMerchantError(ctx, err)
+		return "", false
+	}
+	return merchantID, true
+}
+
+// scheduledPaymentsHandler lists the pending scheduled payments, the soonest
+// due first, up to the "limit" query parameter. Authenticated clients only see
+// the payments they own, as ownsPayment.
+func (app *Application) scheduledPaymentsHandler(ctx *fasthttp.RequestCtx) {
+	merchantID, err := requestMerchant(ctx, string(ctx.QueryArgs().Peek("merchant")))
+	if err != nil {
+		writeMerchantError(ctx, err)
+		return
+	}
+
+	limit := 100
+	if value := ctx.QueryArgs().Peek("limit"); len(value) > 0 {
+		limit, err = strconv.Atoi(string(value))
+		if err != nil || limit <= 0 || limit > 1000 {
+			writeProblem(ctx, invalidParam("limit", "expected a number between 1 and 1000"))
+			return
+		}
+	}
+
+	var clientID string
+	if client := authenticatedClient(ctx); client != nil {
+		clientID = client.ID
+	}
+
+	payments, err := app.services.Payment.Scheduled(merchantID, clientID, limit)
+	if err != nil {
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to list scheduled payments")
+		fmt.Println(err)
+		return
+	}
+
+	scheduled := make([]models.PaymentRequest, 0, len(payments))
+	for _, payment := range payments {
+		scheduled = append(scheduled, models.PaymentRequest{
+			CorrelationID: payment.CorrelationID,
+			Amount:        payment.Amount,
+			CallbackURL:   payment.CallbackURL,
+			MerchantID:    payment.MerchantID,
+			Priority:      string(payment.Priority),
+			ScheduledAt:   payment.ScheduledAt,
+			Currency:      payment.Currency,
+		})
+	}
+	writeJSON(ctx, 200, scheduled)
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"strings"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/valyala/fasthttp"
+)
+
+const problemContentType = "application/problem+json"
+
+// writeError responds with a problem document of the given code.
+func writeError(ctx *fasthttp.RequestCtx, statusCode int, code constants.ProblemCode, detail string) {
+	writeProblem(ctx, &models.Problem{Status: statusCode, Code: code, Detail: detail})
+}
+
+// writeProblem fills in the type, title and instance of a problem and responds
+// with it. The type is a relative URI reference named after the code, and the
+// title the reason phrase of the status.
+func writeProblem(ctx *fasthttp.RequestCtx, problem *models.Problem) {
+	problem.Type = "/problems/" + strings.ReplaceAll(string(problem.Code), "_", "-")
+	problem.Title = fasthttp.StatusMessage(problem.Status)
+	problem.Instance = string(ctx.Path())
+
+	writeJSON(ctx, problem.Status, problem)
+	ctx.Response.Header.SetContentType(problemContentType)
+}
+
+func writeMethodNotAllowed(ctx *fasthttp.RequestCtx) {
+	writeError(ctx, 405, constants.ProblemMethodNotAllowed, "Method not allowed")
+}
+
+func writeNotFound(ctx *fasthttp.RequestCtx) {
+	writeError(ctx, 404, constants.ProblemNotFound, "Not found")
+}
+
+// invalidParams collects the parameters of a request failing validation.
+type invalidParams []models.InvalidParam
+
+func (p *invalidParams) add(name, reason string) {
+	*p = append(*p, models.InvalidParam{Name: name, Reason: reason})
+}
+
+// problem returns the validation problem listing the invalid parameters, or
+// nil when there are none.
+func (p invalidParams) problem() *models.Problem {
+	if len(p) == 0 {
+		return nil
+	}
+
+	detail := "Invalid '" + p[0].Name + "', " + p[0].Reason
+	if len(p) > 1 {
+		detail = "Request has invalid parameters, see 'invalidParams'"
+	}
+	return &models.Problem{
+		Status:        400,
+		Code:          constants.ProblemValidationFailed,
+		Detail:        detail,
+		InvalidParams: p,
+	}
+}
+
+// invalidParam returns the validation problem of a single parameter.
+func invalidParam(name, reason string) *models.Problem {
+	var params invalidParams
+	params.add(name, reason)
+	return params.problem()
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/store"
+	"github.com/valyala/fasthttp"
+)
+
+// rateLimit rejects requests once the caller or the whole cluster ran out of
+// tokens, advertising the current quota through RateLimit-* headers. Requests
+// go through when Redis is unavailable.
+func (app *Application) rateLimit(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return app.limit(func(ctx *fasthttp.RequestCtx) (*store.RateLimitResult, error) {
+		return app.services.RateLimit.Allow(app.clientIdentity(ctx))
+	}, next)
+}
+
+// rateLimitBatch is rateLimit for batches, taking one token per payment so a
+// batch costs as much as submitting its payments one by one. The decoded items
+// are kept for the handler.
+func (app *Application) rateLimitBatch(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return app.limit(func(ctx *fasthttp.RequestCtx) (*store.RateLimitResult, error) {
+		count := 1
+		if items, err := decodeBatch(ctx); err == nil {
+			ctx.SetUserValue(batchItemsKey, items)
+			count = min(max(len(items), 1), app.config.MaxBatchSize)
+		}
+		return app.services.RateLimit.AllowN(app.clientIdentity(ctx), count)
+	}, next)
+}
+
+// rateLimitSource limits requests by source IP before they are authenticated,
+// so requests with missing or invalid API keys are limited too. Without
+// authentication rateLimit already limits by source IP, and this does nothing.
+func (app *Application) rateLimitSource(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if !app.config.AuthEnabled {
+		return next
+	}
+	return app.limit(func(ctx *fasthttp.RequestCtx) (*store.RateLimitResult, error) {
+		return app.services.RateLimit.AllowSource(app.clientIP(ctx))
+	}, next)
+}
+
+func (app *Application) limit(allow func(ctx *fasthttp.RequestCtx) (*store.RateLimitResult, error), next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		result, err := allow(ctx)
+		if err != nil {
+			fmt.Println(err)
+			next(ctx)
+			return
+		}
+
+		if result == nil {
+			next(ctx)
+			return
+		}
+
+		ctx.Response.Header.Set("RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+		ctx.Response.Header.Set("RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+		ctx.Response.Header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			ctx.Response.Header.Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+			writeError(ctx, 429, constants.ProblemRateLimited, "Rate limit exceeded")
+			return
+		}
+
+		next(ctx)
+	}
+}
+
+// clientIdentity returns the key callers are rate limited by: their API client
+// ID when authenticated, otherwise their source IP.
+func (app *Application) clientIdentity(ctx *fasthttp.RequestCtx) string {
+	if client := authenticatedClient(ctx); client != nil {
+		return client.ID
+	}
+	return app.clientIP(ctx)
+}
+
+// clientIP returns the source IP of the request. Behind a trusted proxy it is
+// read from X-Real-IP, or else from the last X-Forwarded-For entry, the one
+// appended by the proxy: earlier entries are sent by the client and can be
+// anything.
+func (app *Application) clientIP(ctx *fasthttp.RequestCtx) string {
+	if app.config.RateLimit.TrustProxy {
+		if realIP := strings.TrimSpace(string(ctx.Request.Header.Peek("X-Real-IP"))); realIP != "" {
+			return realIP
+		}
+		if forwarded := string(ctx.Request.Header.Peek("X-Forwarded-For")); forwarded != "" {
+			last := forwarded[strings.LastIndexByte(forwarded, ',')+1:]
+			if last = strings.TrimSpace(last); last != "" {
+				return last
+			}
+		}
+	}
+	return ctx.RemoteIP().String()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -5,18 +5,24 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/mochaeng/payment-gateway/internal/constants"
 	"github.com/valyala/fasthttp"
 )
 
 func (app *Application) paymentsSummaryHandler(ctx *fasthttp.RequestCtx) {
 	var from, to *time.Time
 
+	merchantID, err := requestMerchant(ctx, string(ctx.QueryArgs().Peek("merchant")))
+	if err != nil {
+		writeMerchantError(ctx, err)
+		return
+	}
+
 	fromStr := string(ctx.QueryArgs().Peek("from"))
 	if fromStr != "" {
 		fromTime, err := time.Parse(time.RFC3339, fromStr)
 		if err != nil {
-			ctx.SetStatusCode(400)
-			ctx.SetBodyString(`{"error":"Invalid 'from' timestamp format. Use ISO 8601 format"}`)
+			writeProblem(ctx, invalidParam("from", "expected an ISO 8601 timestamp"))
 			return
 		}
 		from = &fromTime
@@ -26,33 +32,29 @@ func (app *Application) paymentsSummaryHandler(ctx *fasthttp.RequestCtx) {
 	if toStr != "" {
 		toTime, err := time.Parse(time.RFC3339, toStr)
 		if err != nil {
-			ctx.SetStatusCode(400)
-			ctx.SetBodyString(`{"error":"Invalid 'to' timestamp format. Use ISO 8601 format"}`)
+			writeProblem(ctx, invalidParam("to", "expected an ISO 8601 timestamp"))
 			return
 		}
 		to = &toTime
 	}
 
 	if from != nil && to != nil && from.After(*to) {
-		ctx.SetStatusCode(400)
-		ctx.SetBodyString(`{"error":"'from' timestamp cannot be after 'to' timestamp"}`)
+		writeProblem(ctx, invalidParam("from", "cannot be after 'to'"))
 		return
 	}
 
 	fmt.Printf("Getting payment-summary call [%q] - [%q]\n", from, to)
 
-	summary, err := app.services.Summary.GetSummary(from, to)
+	summary, err := app.services.Summary.GetSummary(merchantID, from, to)
 	if err != nil {
-		ctx.SetStatusCode(500)
-		ctx.SetBodyString(`{"error":"Failed to get payment summary"}`)
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to get payment summary")
 		fmt.Println(err)
 		return
 	}
 
 	response, err := json.Marshal(summary)
 	if err != nil {
-		ctx.SetStatusCode(500)
-		ctx.SetBodyString(`{"error":"Failed to serialize response"}`)
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to serialize response")
 		fmt.Println(err)
 		return
 	}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/services"
+	"github.com/mochaeng/payment-gateway/internal/utils"
+	"github.com/valyala/fasthttp"
+)
+
+// readJSON decodes a JSON request body into v, writing the problem and
+// returning false when the body is too large, not sent as application/json or
+// does not decode strictly. An empty body is left undecoded when optional.
+func (app *Application) readJSON(ctx *fasthttp.RequestCtx, v any, optional bool) bool {
+	body := ctx.PostBody()
+	if len(body) == 0 && optional {
+		return true
+	}
+
+	if limit := app.config.MaxBodySize; limit > 0 && len(body) > limit {
+		writeError(ctx, 413, constants.ProblemBodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", limit))
+		return false
+	}
+	if !hasMediaType(ctx, "application/json") {
+		writeError(ctx, 415, constants.ProblemUnsupportedMediaType, "Expected an application/json body")
+		return false
+	}
+	if problem := decodeStrict(body, v); problem != nil {
+		writeProblem(ctx, problem)
+		return false
+	}
+	return true
+}
+
+// strictDecoder is implemented by the models with a hand-written strict
+// decoder, which decodeStrict prefers over encoding/json.
+type strictDecoder interface {
+	DecodeJSON(data []byte) error
+}
+
+// decodeStrict decodes a single JSON value into v, rejecting unknown fields
+// and trailing data, and returns the problem describing why it failed.
+func decodeStrict(data []byte, v any) *models.Problem {
+	var err error
+	if decoder, ok := v.(strictDecoder); ok {
+		err = decoder.DecodeJSON(data)
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+
+		err = decoder.Decode(v)
+		if err == nil && decoder.More() {
+			err = errors.New("unexpected data after the JSON value")
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	var unknownErr *models.UnknownFieldError
+	if errors.As(err, &unknownErr) {
+		return unknownField(unknownErr.Field)
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return unknownField(strings.Trim(field, `"`))
+	}
+
+	var fieldErr *models.FieldTypeError
+	if errors.As(err, &fieldErr) {
+		return invalidParam(fieldErr.Field, "expected a JSON "+fieldErr.Expected)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return invalidParam(typeErr.Field, fmt.Sprintf("expected a JSON %s", typeErr.Type.Kind()))
+	}
+
+	return &models.Problem{Status: 400, Code: constants.ProblemInvalidJSON, Detail: "Invalid JSON"}
+}
+
+func unknownField(name string) *models.Problem {
+	return &models.Problem{
+		Status:        400,
+		Code:          constants.ProblemUnknownField,
+		Detail:        fmt.Sprintf("Unknown field '%s'", name),
+		InvalidParams: []models.InvalidParam{{Name: name, Reason: "is not a known field"}},
+	}
+}
+
+// hasMediaType reports whether the request Content-Type is one of mediaTypes,
+// ignoring its parameters.
+func hasMediaType(ctx *fasthttp.RequestCtx, mediaTypes ...string) bool {
+	mediaType, _, _ := strings.Cut(string(ctx.Request.Header.ContentType()), ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	for _, candidate := range mediaTypes {
+		if strings.EqualFold(mediaType, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAmount validates an amount against MaxAmount. Zero amounts are only
+// accepted when allowZero is set.
+func (app *Application) checkAmount(params *invalidParams, name string, amount float64, allowZero bool) {
+	switch {
+	case math.IsNaN(amount) || math.IsInf(amount, 0):
+		params.add(name, "expected a finite number")
+	case amount < 0 || (amount == 0 && !allowZero):
+		params.add(name, "expected a positive amount")
+	case app.config.MaxAmount > 0 && amount > app.config.MaxAmount:
+		params.add(name, fmt.Sprintf("expected at most %g", app.config.MaxAmount))
+	}
+}
+
+// checkCorrelationID validates a correlation ID, which processors store as a
+// UUID primary key.
+func checkCorrelationID(params *invalidParams, correlationID string) {
+	if !utils.IsUUID(correlationID) {
+		params.add("correlationId", "expected a UUID")
+	}
+}
+
+// checkCallbackURL validates an optional callback URL, which is refused
+// outright while webhooks are disabled rather than silently never called.
+func (app *Application) checkCallbackURL(params *invalidParams, rawURL string) {
+	switch {
+	case rawURL == "":
+	case !app.config.Webhook.Enabled:
+		params.add("callbackUrl", "webhooks are not enabled")
+	case !isCallbackURL(rawURL):
+		params.add("callbackUrl", "expected an absolute http(s) URL")
+	default:
+		u, _ := url.Parse(rawURL)
+		if err := services.CheckCallbackHost(u.Hostname(), app.config.Webhook.AllowedHosts); err != nil {
+			params.add("callbackUrl", "expected a URL outside the gateway network")
+		}
+	}
+}
+
+func isCallbackURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/services"
+	"github.com/valyala/fasthttp"
+)
+
+func (app *Application) webhookDeliveriesHandler(ctx *fasthttp.RequestCtx) {
+	correlationID := string(ctx.QueryArgs().Peek("correlationId"))
+	if correlationID == "" {
+		writeProblem(ctx, invalidParam("correlationId", "is required"))
+		return
+	}
+
+	deliveries, err := app.services.Webhook.Deliveries(correlationID)
+	if err != nil {
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to list webhook deliveries")
+		fmt.Println(err)
+		return
+	}
+
+	writeJSON(ctx, 200, deliveries)
+}
+
+func (app *Application) webhookDeliveryHandler(ctx *fasthttp.RequestCtx, id string) {
+	log, err := app.services.Webhook.DeliveryLog(id)
+	if errors.Is(err, services.ErrDeliveryNotFound) {
+		writeError(ctx, 404, constants.ProblemDeliveryNotFound, "Webhook delivery not found")
+		return
+	}
+	if err != nil {
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to get webhook delivery")
+		fmt.Println(err)
+		return
+	}
+
+	writeJSON(ctx, 200, log)
+}
+
+func (app *Application) webhookRedeliverHandler(ctx *fasthttp.RequestCtx, id string) {
+	delivery, err := app.services.Webhook.Redeliver(id)
+	if errors.Is(err, services.ErrDeliveryNotFound) {
+		writeError(ctx, 404, constants.ProblemDeliveryNotFound, "Webhook delivery not found")
+		return
+	}
+	if err != nil {
+		writeError(ctx, 500, constants.ProblemInternal, "Failed to redeliver webhook")
+		fmt.Println(err)
+		return
+	}
+
+	writeJSON(ctx, 202, delivery)
+}
@@ -1,7 +1,15 @@
 package config
 
 import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mochaeng/payment-gateway/internal/constants"
@@ -9,45 +17,586 @@ import (
 
 type Config struct {
 	Port                string
-	RedisURL            string
+	Redis               RedisConfig
+	StorageBackend      constants.StorageBackend
+	DatabaseURL         string
+	AuthEnabled         bool
+	AdminToken          string
 	HealthCheckInterval time.Duration
+	SchedulerInterval   time.Duration
 	RequestTimeout      time.Duration
+	ConnectTimeout      time.Duration
+	HealthCheckTimeout  time.Duration
+	PaymentDeadline     time.Duration
+	QueueMode           constants.QueueMode
+	QueueLogPath        string
 	MaxQueueSize        int
+	QueueSoftLimit      int
+	QueueMemoryLimit    float64
+	MaxRetryAfter       time.Duration
+	MaxSyncWait         time.Duration
+	MaxBatchSize        int
+	MaxBodySize         int
+	MaxBatchBodySize    int
+	MaxAmount           float64
+	IdempotencyTTL      time.Duration
 	ProcessorThreshold  int
-	Urls                map[constants.PaymentMode]*ProcessorsConfig
+	Workers             int
+	DequeueBatchSize    int
+	LaneWeights         map[constants.PaymentPriority]int
+	Limiter             LimiterConfig
+	Processors          []*ProcessorConfig
+	MerchantProcessors  map[string][]constants.PaymentMode
+	Currencies          map[string]int
+	DefaultCurrency     string
+	StatusClasses       map[int]constants.ErrorClass
+	Webhook             WebhookConfig
+	Events              EventsConfig
+	RateLimit           RateLimitConfig
 }
 
-type ProcessorsConfig struct {
-	BaseURL    string
-	PaymentURL string
-	HealthURL  string
+type LimiterConfig struct {
+	Initial   int
+	Min       int
+	Max       int
+	Tolerance float64
+	Backoff   float64
+	RTTWindow time.Duration
 }
 
-func Load() *Config {
+type RateLimitRule struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitConfig configures ingress rate limiting. A zero rate disables the
+// corresponding bucket. Overrides replace the client rule for a given client
+// identity or source IP, and exempt clients, IPs or CIDRs are never limited.
+type RateLimitConfig struct {
+	Enabled    bool
+	TrustProxy bool
+	Global     RateLimitRule
+	Client     RateLimitRule
+	Overrides  map[string]RateLimitRule
+	Exempt     []string
+}
+
+// RedisConfig describes the Redis deployment. URL is a redis://, rediss:// or
+// unix:// URL, a host:port address, a comma-separated list of them for the
+// sentinels or cluster seeds, or the path of a Unix socket. The other
+// settings override those of the URL when set; DB is only applied when not 0.
+type RedisConfig struct {
+	URL                   string
+	Mode                  constants.RedisMode
+	MasterName            string
+	Username              string
+	Password              string
+	SentinelUsername      string
+	SentinelPassword      string
+	DB                    int
+	PoolSize              int
+	MinIdleConns          int
+	TLS                   bool
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
+}
+
+// WebhookConfig describes webhook deliveries. Secret signs the deliveries of
+// payments whose API client has no secret of its own, and is required when
+// webhooks are enabled. Concurrency bounds the deliveries each instance
+// attempts at once. Callbacks to internal addresses are refused, except to
+// the hosts in AllowedHosts.
+type WebhookConfig struct {
+	Enabled      bool
+	Secret       string
+	Timeout      time.Duration
+	Concurrency  int
+	MaxAttempts  int
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+	AllowedHosts []string
+}
+
+// EventsConfig describes the payment event stream. MaxLen bounds the events
+// kept for consumers resuming with Last-Event-ID, each taking a few hundred
+// bytes of the store's memory; Heartbeat is the interval of the keep-alive
+// comments sent to idle GET /events streams.
+type EventsConfig struct {
+	Enabled   bool
+	MaxLen    int64
+	Heartbeat time.Duration
+}
+
+// ProcessorConfig describes a payment processor. Zero timeouts fall back to
+// the global ones in Config.
+type ProcessorConfig struct {
+	Name           constants.PaymentMode
+	Priority       int
+	BaseURL        string
+	PaymentURL     string
+	HealthURL      string
+	ConnectTimeout time.Duration
+	RequestTimeout time.Duration
+	HealthTimeout  time.Duration
+	StatusClasses  map[int]constants.ErrorClass
+	Currencies     []string
+}
+
+// Supports reports whether the processor accepts payments in currency. Only
+// processors declaring their currencies are told the currency of a payment, so
+// a processor declaring none only accepts defaultCurrency.
+func (p *ProcessorConfig) Supports(currency, defaultCurrency string) bool {
+	if len(p.Currencies) == 0 {
+		return currency == defaultCurrency
+	}
+	return slices.Contains(p.Currencies, currency)
+}
+
+// Load reads the configuration from the environment, failing on settings the
+// gateway cannot start with.
+func Load() (*Config, error) {
 	config := &Config{
 		Port:                getEnv("PORT", "8080"),
-		RedisURL:            getEnv("REDIS_URL", "redis://localhost:6379"),
+		StorageBackend:      constants.StorageBackend(strings.ToLower(getEnv("STORAGE_BACKEND", "redis"))),
+		DatabaseURL:         getEnv("DATABASE_URL", ""),
+		AuthEnabled:         getEnv("AUTH_ENABLED", "false") == "true",
+		AdminToken:          getEnv("ADMIN_TOKEN", ""),
 		HealthCheckInterval: parseDuration(getEnv("HEALTH_CHECK_INTERVAL", "5s")),
+		SchedulerInterval:   parseDuration(getEnv("SCHEDULER_INTERVAL", "1s")),
 		RequestTimeout:      parseDuration(getEnv("REQUEST_TIMEOUT", "2s")),
-		MaxQueueSize:        1000,
+		ConnectTimeout:      parseDuration(getEnv("CONNECT_TIMEOUT", "500ms")),
+		HealthCheckTimeout:  parseDuration(getEnv("HEALTH_CHECK_TIMEOUT", "1s")),
+		PaymentDeadline:     parseDuration(getEnv("PAYMENT_DEADLINE", "60s")),
+		QueueMode:           constants.QueueMode(strings.ToLower(getEnv("QUEUE_MODE", "redis"))),
+		QueueLogPath:        getEnv("QUEUE_LOG_PATH", "data/queue.log"),
+		MaxQueueSize:        parseInt(getEnv("MAX_QUEUE_SIZE", "1000"), 1000),
+		QueueMemoryLimit:    parseFloat(getEnv("QUEUE_MEMORY_LIMIT", "0.9"), 0.9),
+		MaxRetryAfter:       parseDuration(getEnv("MAX_RETRY_AFTER", "30s")),
+		MaxSyncWait:         parseDuration(getEnv("MAX_SYNC_WAIT", "10s")),
+		MaxBatchSize:        parseInt(getEnv("MAX_BATCH_SIZE", "1000"), 1000),
+		MaxBodySize:         parseInt(getEnv("MAX_BODY_SIZE", "16384"), 16384),
+		MaxBatchBodySize:    parseInt(getEnv("MAX_BATCH_BODY_SIZE", "4194304"), 4194304),
+		MaxAmount:           parseFloat(getEnv("MAX_AMOUNT", "1000000000"), 1e9),
+		IdempotencyTTL:      parseDuration(getEnv("IDEMPOTENCY_TTL", "24h")),
 		ProcessorThreshold:  300,
-		Urls:                make(map[constants.PaymentMode]*ProcessorsConfig, 2),
+		Workers:             parseInt(getEnv("WORKERS", "1"), 1),
+		DequeueBatchSize:    parseInt(getEnv("DEQUEUE_BATCH_SIZE", "16"), 16),
+		LaneWeights:         parseLaneWeights(getEnv("QUEUE_LANE_WEIGHTS", "high=6,normal=3,low=1")),
+		MerchantProcessors:  parseMerchantProcessors(getEnv("MERCHANT_PROCESSORS", "")),
+	}
+
+	currencies, currenciesErr := parseCurrencies(getEnv("CURRENCIES", "BRL:2"))
+	config.Currencies = currencies
+	config.DefaultCurrency = strings.ToUpper(getEnv("DEFAULT_CURRENCY", "BRL"))
+	currencyErr := checkCurrency("DEFAULT_CURRENCY", config.DefaultCurrency)
+	if _, ok := config.Currencies[config.DefaultCurrency]; !ok && currencyErr == nil {
+		config.Currencies[config.DefaultCurrency] = 2
+	}
+
+	config.Limiter = LimiterConfig{
+		Initial:   parseInt(getEnv("LIMITER_INITIAL", "10"), 10),
+		Min:       parseInt(getEnv("LIMITER_MIN", "1"), 1),
+		Max:       parseInt(getEnv("LIMITER_MAX", "100"), 100),
+		Tolerance: parseFloat(getEnv("LIMITER_TOLERANCE", "2"), 2),
+		Backoff:   parseFloat(getEnv("LIMITER_BACKOFF", "0.9"), 0.9),
+		RTTWindow: parseDuration(getEnv("LIMITER_RTT_WINDOW", "30s")),
+	}
+
+	config.RateLimit = RateLimitConfig{
+		Enabled:    getEnv("RATE_LIMIT_ENABLED", "false") == "true",
+		TrustProxy: getEnv("RATE_LIMIT_TRUST_PROXY", "false") == "true",
+		Global: RateLimitRule{
+			Rate:  parseFloat(getEnv("RATE_LIMIT_GLOBAL_RPS", "0"), 0),
+			Burst: parseInt(getEnv("RATE_LIMIT_GLOBAL_BURST", "0"), 0),
+		},
+		Client: RateLimitRule{
+			Rate:  parseFloat(getEnv("RATE_LIMIT_CLIENT_RPS", "0"), 0),
+			Burst: parseInt(getEnv("RATE_LIMIT_CLIENT_BURST", "0"), 0),
+		},
+		Overrides: parseRateLimitRules(getEnv("RATE_LIMIT_OVERRIDES", "")),
+		Exempt:    splitList(getEnv("RATE_LIMIT_EXEMPT", "")),
+	}
+
+	config.Redis = RedisConfig{
+		URL:                   getEnv("REDIS_URL", "redis://localhost:6379"),
+		Mode:                  constants.RedisMode(strings.ToLower(getEnv("REDIS_MODE", "standalone"))),
+		MasterName:            getEnv("REDIS_SENTINEL_MASTER", ""),
+		Username:              getEnv("REDIS_USERNAME", ""),
+		Password:              getEnv("REDIS_PASSWORD", ""),
+		SentinelUsername:      getEnv("REDIS_SENTINEL_USERNAME", ""),
+		SentinelPassword:      getEnv("REDIS_SENTINEL_PASSWORD", ""),
+		DB:                    parseInt(getEnv("REDIS_DB", "0"), 0),
+		PoolSize:              parseInt(getEnv("REDIS_POOL_SIZE", "0"), 0),
+		MinIdleConns:          parseInt(getEnv("REDIS_MIN_IDLE_CONNS", "0"), 0),
+		TLS:                   getEnv("REDIS_TLS", "false") == "true",
+		TLSCAFile:             getEnv("REDIS_TLS_CA_FILE", ""),
+		TLSCertFile:           getEnv("REDIS_TLS_CERT_FILE", ""),
+		TLSKeyFile:            getEnv("REDIS_TLS_KEY_FILE", ""),
+		TLSServerName:         getEnv("REDIS_TLS_SERVER_NAME", ""),
+		TLSInsecureSkipVerify: getEnv("REDIS_TLS_INSECURE_SKIP_VERIFY", "false") == "true",
+	}
+
+	config.Webhook = WebhookConfig{
+		Enabled:      getEnv("WEBHOOK_ENABLED", "false") == "true",
+		Secret:       getEnv("WEBHOOK_SECRET", ""),
+		Timeout:      parseDuration(getEnv("WEBHOOK_TIMEOUT", "5s")),
+		Concurrency:  parseInt(getEnv("WEBHOOK_CONCURRENCY", "16"), 16),
+		MaxAttempts:  parseInt(getEnv("WEBHOOK_MAX_ATTEMPTS", "8"), 8),
+		Backoff:      parseDuration(getEnv("WEBHOOK_BACKOFF", "1s")),
+		MaxBackoff:   parseDuration(getEnv("WEBHOOK_MAX_BACKOFF", "10m")),
+		AllowedHosts: splitList(strings.ToLower(getEnv("WEBHOOK_ALLOWED_HOSTS", ""))),
+	}
+
+	config.Events = EventsConfig{
+		Enabled:   getEnv("EVENTS_ENABLED", "false") == "true",
+		MaxLen:    int64(parseInt(getEnv("EVENTS_MAX_LEN", "10000"), 10000)),
+		Heartbeat: parseDuration(getEnv("EVENTS_HEARTBEAT", "15s")),
+	}
+
+	config.QueueSoftLimit = parseInt(getEnv("QUEUE_SOFT_LIMIT", ""), config.MaxQueueSize*8/10)
+
+	statusClasses, statusClassesErr := parseStatusClasses("PROCESSOR_STATUS_CLASSES", getEnv("PROCESSOR_STATUS_CLASSES", ""))
+	config.StatusClasses = statusClasses
+
+	processors, processorsErr := loadProcessors()
+	config.Processors = processors
+	config.SortProcessors()
+
+	if err := errors.Join(currenciesErr, currencyErr, statusClassesErr, processorsErr, config.validate()); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// validate reports every setting the gateway cannot start with.
+func (c *Config) validate() error {
+	var errs []error
+	if c.Webhook.Enabled && c.Webhook.Secret == "" {
+		errs = append(errs, errors.New("WEBHOOK_SECRET is required when WEBHOOK_ENABLED is true"))
+	}
+
+	merchants := make([]string, 0, len(c.MerchantProcessors))
+	for merchant := range c.MerchantProcessors {
+		merchants = append(merchants, merchant)
+	}
+	sort.Strings(merchants)
+	for _, merchant := range merchants {
+		for _, name := range c.MerchantProcessors[merchant] {
+			if c.Processor(name) == nil {
+				errs = append(errs, fmt.Errorf("MERCHANT_PROCESSORS: unknown processor %q for merchant %q", name, merchant))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Processor returns the configuration of the processor with the given name,
+// or nil when no such processor is configured.
+func (c *Config) Processor(name constants.PaymentMode) *ProcessorConfig {
+	for _, processor := range c.Processors {
+		if processor.Name == name {
+			return processor
+		}
+	}
+	return nil
+}
+
+// ProcessorsFor returns the processors a merchant may use for payments in
+// currency, ordered by priority. Merchants without an allow-list may use every
+// processor, and an empty currency matches every processor.
+func (c *Config) ProcessorsFor(merchantID, currency string) []*ProcessorConfig {
+	allowed, restricted := c.MerchantProcessors[merchantID]
+	if !restricted && currency == "" {
+		return c.Processors
+	}
+
+	processors := make([]*ProcessorConfig, 0, len(c.Processors))
+	for _, processor := range c.Processors {
+		if restricted && !slices.Contains(allowed, processor.Name) {
+			continue
+		}
+		if currency != "" && !processor.Supports(currency, c.DefaultCurrency) {
+			continue
+		}
+		processors = append(processors, processor)
+	}
+	return processors
+}
+
+// CurrencyCodes returns the configured currency codes in alphabetical order.
+func (c *Config) CurrencyCodes() []string {
+	codes := make([]string, 0, len(c.Currencies))
+	for code := range c.Currencies {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func (c *Config) ConnectTimeoutOf(processor *ProcessorConfig) time.Duration {
+	return cmp.Or(processor.ConnectTimeout, c.ConnectTimeout)
+}
+
+func (c *Config) RequestTimeoutOf(processor *ProcessorConfig) time.Duration {
+	return cmp.Or(processor.RequestTimeout, c.RequestTimeout)
+}
+
+func (c *Config) HealthTimeoutOf(processor *ProcessorConfig) time.Duration {
+	return cmp.Or(processor.HealthTimeout, c.HealthCheckTimeout)
+}
+
+// ProcessorNames returns the names of the processors a merchant may use,
+// ordered by priority.
+func (c *Config) ProcessorNames(merchantID string) []constants.PaymentMode {
+	processors := c.ProcessorsFor(merchantID, "")
+	names := make([]constants.PaymentMode, 0, len(processors))
+	for _, processor := range processors {
+		names = append(names, processor.Name)
+	}
+	return names
+}
+
+// SortProcessors orders the processors by priority, lowest value first. Ties
+// keep their declaration order.
+func (c *Config) SortProcessors() {
+	sort.SliceStable(c.Processors, func(i, j int) bool {
+		return c.Processors[i].Priority < c.Processors[j].Priority
+	})
+}
+
+// NewProcessorConfig builds a processor configuration with the standard
+// payment and health endpoints under baseURL.
+func NewProcessorConfig(name constants.PaymentMode, priority int, baseURL string) *ProcessorConfig {
+	return &ProcessorConfig{
+		Name:       name,
+		Priority:   priority,
+		BaseURL:    baseURL,
+		PaymentURL: baseURL + "/payments",
+		HealthURL:  baseURL + "/payments/service-health",
+	}
+}
+
+// loadProcessors reads the processor list from PROCESSORS, a comma separated
+// list of names. Each processor is then configured through
+// PROCESSOR_<NAME>_URL, PROCESSOR_<NAME>_PRIORITY and optionally
+// PROCESSOR_<NAME>_PAYMENT_URL and PROCESSOR_<NAME>_HEALTH_URL. Without
+// PROCESSORS the default and fallback processors are configured from
+// DEFAULT_PROCESSOR_URL and FALLBACK_PROCESSOR_URL. It fails on missing or
+// invalid URLs, on unknown status classes and on names listed twice, which
+// would share their settings.
+func loadProcessors() ([]*ProcessorConfig, error) {
+	names := splitList(getEnv("PROCESSORS", ""))
+	if len(names) == 0 {
+		defaultBase := getEnv("DEFAULT_PROCESSOR_URL", "http://localhost:8001")
+		fallbackBase := getEnv("FALLBACK_PROCESSOR_URL", "http://localhost:8002")
+		err := errors.Join(checkURL("DEFAULT_PROCESSOR_URL", defaultBase), checkURL("FALLBACK_PROCESSOR_URL", fallbackBase))
+		return []*ProcessorConfig{
+			NewProcessorConfig(constants.DefaultProcessorKey, 0, defaultBase),
+			NewProcessorConfig(constants.FallbackProcessorKey, 1, fallbackBase),
+		}, err
+	}
+
+	var errs []error
+	seen := make(map[string]bool, len(names))
+	processors := make([]*ProcessorConfig, 0, len(names))
+	for i, name := range names {
+		prefix := "PROCESSOR_" + envName(name) + "_"
+		if seen[prefix] {
+			errs = append(errs, fmt.Errorf("PROCESSORS lists %q more than once", name))
+			continue
+		}
+		seen[prefix] = true
+
+		baseURL := getEnv(prefix+"URL", "")
+		errs = append(errs, checkURL(prefix+"URL", baseURL))
+		priority := parseInt(getEnv(prefix+"PRIORITY", ""), i)
+
+		processor := NewProcessorConfig(constants.PaymentMode(name), priority, baseURL)
+		if value := getEnv(prefix+"PAYMENT_URL", ""); value != "" {
+			processor.PaymentURL = value
+			errs = append(errs, checkURL(prefix+"PAYMENT_URL", value))
+		}
+		if value := getEnv(prefix+"HEALTH_URL", ""); value != "" {
+			processor.HealthURL = value
+			errs = append(errs, checkURL(prefix+"HEALTH_URL", value))
+		}
+		processor.ConnectTimeout = parseOptionalDuration(getEnv(prefix+"CONNECT_TIMEOUT", ""))
+		processor.RequestTimeout = parseOptionalDuration(getEnv(prefix+"REQUEST_TIMEOUT", ""))
+		processor.HealthTimeout = parseOptionalDuration(getEnv(prefix+"HEALTH_TIMEOUT", ""))
+		statusClasses, err := parseStatusClasses(prefix+"STATUS_CLASSES", getEnv(prefix+"STATUS_CLASSES", ""))
+		processor.StatusClasses = statusClasses
+		errs = append(errs, err)
+		currencies, err := parseCurrencyList(prefix+"CURRENCIES", getEnv(prefix+"CURRENCIES", ""))
+		processor.Currencies = currencies
+		errs = append(errs, err)
+
+		processors = append(processors, processor)
+	}
+
+	return processors, errors.Join(errs...)
+}
+
+// checkURL fails unless value, the setting of variable, is an absolute HTTP
+// URL.
+func checkURL(variable, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s is required", variable)
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("%s must be an http or https URL, got %q", variable, value)
 	}
+	return nil
+}
+
+func envName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
 
-	defaultBase := getEnv("DEFAULT_PROCESSOR_URL", "http://localhost:8001")
-	fallbackbase := getEnv("FALLBACK_PROCESSOR_URL", "http://localhost:8002")
-	config.Urls[constants.DefaultProcessorKey] = &ProcessorsConfig{
-		BaseURL:    defaultBase,
-		PaymentURL: defaultBase + "/payments",
-		HealthURL:  defaultBase + "/payments/service-health",
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
-	config.Urls[constants.FallbackProcessorKey] = &ProcessorsConfig{
-		BaseURL:    fallbackbase,
-		PaymentURL: fallbackbase + "/payments",
-		HealthURL:  fallbackbase + "/payments/service-health",
+	return items
+}
+
+// parseStatusClasses reads a "code=class,..." list such as
+// "422=already_processed,400=permanent", the setting of variable. It fails on
+// malformed items and on classes that are not constants.ErrorClasses.
+func parseStatusClasses(variable, s string) (map[int]constants.ErrorClass, error) {
+	var errs []error
+	classes := make(map[int]constants.ErrorClass)
+	for _, item := range splitList(s) {
+		code, name, found := strings.Cut(item, "=")
+		statusCode, err := strconv.Atoi(strings.TrimSpace(code))
+		if !found || err != nil || statusCode < 100 || statusCode > 599 {
+			errs = append(errs, fmt.Errorf("%s: %q is not a code=class pair", variable, item))
+			continue
+		}
+
+		class := constants.ErrorClass(strings.TrimSpace(name))
+		if !slices.Contains(constants.ErrorClasses, class) {
+			errs = append(errs, fmt.Errorf("%s: unknown error class %q for status %d", variable, class, statusCode))
+			continue
+		}
+		classes[statusCode] = class
 	}
+	return classes, errors.Join(errs...)
+}
+
+// parseRateLimitRules reads a "client=rate:burst,..." list such as
+// "10.0.0.5=100:200,billing=50:500".
+func parseRateLimitRules(s string) map[string]RateLimitRule {
+	rules := make(map[string]RateLimitRule)
+	for _, item := range splitList(s) {
+		client, rule, found := strings.Cut(item, "=")
+		if !found {
+			continue
+		}
 
-	return config
+		rate, burst, _ := strings.Cut(rule, ":")
+		rules[strings.TrimSpace(client)] = RateLimitRule{
+			Rate:  parseFloat(strings.TrimSpace(rate), 0),
+			Burst: parseInt(strings.TrimSpace(burst), 0),
+		}
+	}
+	return rules
+}
+
+// parseLaneWeights reads a "lane=weight,..." list such as
+// "high=6,normal=3,low=1". Lanes left out get a weight of 1.
+func parseLaneWeights(s string) map[constants.PaymentPriority]int {
+	weights := make(map[constants.PaymentPriority]int, len(constants.Priorities))
+	for _, priority := range constants.Priorities {
+		weights[priority] = 1
+	}
+
+	for _, item := range splitList(s) {
+		lane, weight, found := strings.Cut(item, "=")
+		if !found {
+			continue
+		}
+		weights[constants.PaymentPriority(strings.TrimSpace(lane))] = max(parseInt(strings.TrimSpace(weight), 1), 1)
+	}
+	return weights
+}
+
+// parseCurrencies reads a "code:minor_units,..." list such as
+// "BRL:2,USD:2,JPY:0". Currencies without minor units get 2.
+func parseCurrencies(s string) (map[string]int, error) {
+	var errs []error
+	currencies := make(map[string]int)
+	for _, item := range splitList(s) {
+		code, units, found := strings.Cut(item, ":")
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if err := checkCurrency("CURRENCIES", code); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		minorUnits := 2
+		if found {
+			var err error
+			minorUnits, err = strconv.Atoi(strings.TrimSpace(units))
+			if err != nil || minorUnits < 0 || minorUnits > 4 {
+				errs = append(errs, fmt.Errorf("CURRENCIES: %q has invalid minor units, expected 0 to 4", item))
+				continue
+			}
+		}
+		currencies[code] = minorUnits
+	}
+	return currencies, errors.Join(errs...)
+}
+
+// parseCurrencyList reads a list of currency codes, the setting of variable.
+func parseCurrencyList(variable, s string) ([]string, error) {
+	var errs []error
+	var codes []string
+	for _, code := range splitList(s) {
+		code = strings.ToUpper(code)
+		if err := checkCurrency(variable, code); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		codes = append(codes, code)
+	}
+	return codes, errors.Join(errs...)
+}
+
+// checkCurrency fails unless code, given in variable, has the shape of an ISO
+// 4217 code: three letters, uppercased by the caller.
+func checkCurrency(variable, code string) error {
+	if len(code) != 3 || strings.IndexFunc(code, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+		return fmt.Errorf("%s: %q is not an ISO 4217 currency code", variable, code)
+	}
+	return nil
+}
+
+// parseMerchantProcessors reads a "merchant=processor|processor,..." list such
+// as "acme=default|fallback,globex=fallback".
+func parseMerchantProcessors(s string) map[string][]constants.PaymentMode {
+	merchants := make(map[string][]constants.PaymentMode)
+	for _, item := range splitList(s) {
+		merchant, list, found := strings.Cut(item, "=")
+		if !found {
+			continue
+		}
+
+		var processors []constants.PaymentMode
+		for _, name := range strings.Split(list, "|") {
+			if name = strings.TrimSpace(name); name != "" {
+				processors = append(processors, constants.PaymentMode(name))
+			}
+		}
+		merchants[strings.TrimSpace(merchant)] = processors
+	}
+	return merchants
 }
 
 func getEnv(key, defaultValue string) string {
@@ -57,6 +606,30 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func parseInt(s string, defaultValue int) int {
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func parseFloat(s string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func parseOptionalDuration(s string) time.Duration {
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return 0
+	}
+	return duration
+}
+
 func parseDuration(s string) time.Duration {
 	duration, err := time.ParseDuration(s)
 	if err != nil {
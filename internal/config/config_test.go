@@ -0,0 +1,158 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+)
+
+func TestLoadProcessors_RejectsInvalidSettings(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "valid",
+			env:  map[string]string{"PROCESSORS": "acme", "PROCESSOR_ACME_URL": "http://acme:8080"},
+		},
+		{
+			name: "missing url",
+			env:  map[string]string{"PROCESSORS": "acme"},
+			want: "PROCESSOR_ACME_URL is required",
+		},
+		{
+			name: "relative url",
+			env:  map[string]string{"PROCESSORS": "acme", "PROCESSOR_ACME_URL": "acme:8080"},
+			want: "PROCESSOR_ACME_URL must be an http or https URL",
+		},
+		{
+			name: "invalid payment url",
+			env: map[string]string{
+				"PROCESSORS": "acme", "PROCESSOR_ACME_URL": "http://acme", "PROCESSOR_ACME_PAYMENT_URL": "ftp://acme/pay",
+			},
+			want: "PROCESSOR_ACME_PAYMENT_URL must be an http or https URL",
+		},
+		{
+			name: "duplicate name",
+			env:  map[string]string{"PROCESSORS": "acme-pay,ACME_PAY", "PROCESSOR_ACME_PAY_URL": "http://acme"},
+			want: `PROCESSORS lists "ACME_PAY" more than once`,
+		},
+		{
+			name: "unknown status class",
+			env: map[string]string{
+				"PROCESSORS": "acme", "PROCESSOR_ACME_URL": "http://acme", "PROCESSOR_ACME_STATUS_CLASSES": "402=fatal",
+			},
+			want: `PROCESSOR_ACME_STATUS_CLASSES: unknown error class "fatal"`,
+		},
+		{
+			name: "invalid currency",
+			env: map[string]string{
+				"PROCESSORS": "acme", "PROCESSOR_ACME_URL": "http://acme", "PROCESSOR_ACME_CURRENCIES": "BRL,DOLLAR",
+			},
+			want: `PROCESSOR_ACME_CURRENCIES: "DOLLAR" is not an ISO 4217 currency code`,
+		},
+		{
+			name: "invalid default url",
+			env:  map[string]string{"DEFAULT_PROCESSOR_URL": "localhost:8001"},
+			want: "DEFAULT_PROCESSOR_URL must be an http or https URL",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv("PROCESSORS", "")
+			for key, value := range c.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := loadProcessors()
+			if c.want == "" && err != nil {
+				t.Fatalf("got %v, want no error", err)
+			}
+			if c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)) {
+				t.Fatalf("got %v, want an error containing %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestParseStatusClasses_RejectsUnknownClasses(t *testing.T) {
+	cases := []struct {
+		value string
+		want  string
+	}{
+		{value: "422=already_processed, 400=permanent,503=retryable"},
+		{value: "409=retry", want: `unknown error class "retry" for status 409`},
+		{value: "409", want: `"409" is not a code=class pair`},
+		{value: "abc=permanent", want: `"abc=permanent" is not a code=class pair`},
+		{value: "42=permanent", want: `"42=permanent" is not a code=class pair`},
+	}
+
+	for _, c := range cases {
+		classes, err := parseStatusClasses("PROCESSOR_STATUS_CLASSES", c.value)
+		if c.want == "" {
+			if err != nil {
+				t.Errorf("parseStatusClasses(%q) = %v, want no error", c.value, err)
+			}
+			if classes[422] != constants.ClassAlreadyProcessed || classes[400] != constants.ClassPermanent || classes[503] != constants.ClassRetryable {
+				t.Errorf("parseStatusClasses(%q) = %v", c.value, classes)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("parseStatusClasses(%q) = %v, want an error containing %q", c.value, err, c.want)
+		}
+	}
+}
+
+func TestValidate_RejectsUnknownMerchantProcessors(t *testing.T) {
+	config := &Config{
+		Processors: []*ProcessorConfig{
+			NewProcessorConfig(constants.DefaultProcessorKey, 0, "http://default"),
+			NewProcessorConfig(constants.FallbackProcessorKey, 1, "http://fallback"),
+		},
+		MerchantProcessors: parseMerchantProcessors("acme=default|fallback,globex=fallbak"),
+	}
+
+	err := config.validate()
+	want := `MERCHANT_PROCESSORS: unknown processor "fallbak" for merchant "globex"`
+	if err == nil || err.Error() != want {
+		t.Fatalf("got %v, want %q", err, want)
+	}
+
+	delete(config.MerchantProcessors, "globex")
+	if err := config.validate(); err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+}
+
+func TestParseCurrencies_RejectsInvalidCodes(t *testing.T) {
+	cases := []struct {
+		value string
+		want  string
+	}{
+		{value: "BRL:2, usd:2,JPY:0,CLF:4,EUR"},
+		{value: "REAL:2", want: `CURRENCIES: "REAL" is not an ISO 4217 currency code`},
+		{value: "U$D:2", want: `CURRENCIES: "U$D" is not an ISO 4217 currency code`},
+		{value: "BRL:x", want: `CURRENCIES: "BRL:x" has invalid minor units`},
+		{value: "BRL:5", want: `CURRENCIES: "BRL:5" has invalid minor units`},
+	}
+
+	for _, c := range cases {
+		currencies, err := parseCurrencies(c.value)
+		if c.want == "" {
+			if err != nil {
+				t.Errorf("parseCurrencies(%q) = %v, want no error", c.value, err)
+			}
+			if currencies["USD"] != 2 || currencies["JPY"] != 0 || currencies["CLF"] != 4 || currencies["EUR"] != 2 {
+				t.Errorf("parseCurrencies(%q) = %v", c.value, currencies)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("parseCurrencies(%q) = %v, want an error containing %q", c.value, err, c.want)
+		}
+	}
+}
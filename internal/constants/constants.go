@@ -6,3 +6,145 @@ const (
 	DefaultProcessorKey  PaymentMode = "default"
 	FallbackProcessorKey PaymentMode = "fallback"
 )
+
+type PaymentStatus string
+
+const (
+	PaymentScheduled    PaymentStatus = "scheduled"
+	PaymentQueued       PaymentStatus = "queued"
+	PaymentProcessing   PaymentStatus = "processing"
+	PaymentSucceeded    PaymentStatus = "succeeded"
+	PaymentRefunded     PaymentStatus = "refunded"
+	PaymentCancelled    PaymentStatus = "cancelled"
+	PaymentFailed       PaymentStatus = "failed"
+	PaymentDeadLettered PaymentStatus = "dead_lettered"
+	PaymentTimedOut     PaymentStatus = "timed_out"
+)
+
+// PaymentPriority selects the queue lane of a payment.
+type PaymentPriority string
+
+const (
+	PriorityHigh   PaymentPriority = "high"
+	PriorityNormal PaymentPriority = "normal"
+	PriorityLow    PaymentPriority = "low"
+)
+
+// Priorities lists the queue lanes from the most to the least urgent.
+var Priorities = []PaymentPriority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// QueueMode selects where admitted payments wait for a worker: in the queue
+// of the store, shared by every instance, or in the memory of a single
+// instance. The redis mode predates other stores and uses whichever is set.
+type QueueMode string
+
+const (
+	QueueModeRedis QueueMode = "redis"
+	QueueModeLocal QueueMode = "local"
+)
+
+// RedisMode selects the Redis deployment the store connects to.
+type RedisMode string
+
+const (
+	RedisStandalone RedisMode = "standalone"
+	RedisSentinel   RedisMode = "sentinel"
+	RedisCluster    RedisMode = "cluster"
+)
+
+// StorageBackend selects the store holding the gateway state.
+type StorageBackend string
+
+const (
+	StorageRedis    StorageBackend = "redis"
+	StoragePostgres StorageBackend = "postgres"
+)
+
+type WebhookStatus string
+
+const (
+	WebhookPending   WebhookStatus = "pending"
+	WebhookDelivered WebhookStatus = "delivered"
+	WebhookFailed    WebhookStatus = "failed"
+)
+
+// PaymentEventType is the type of a payment lifecycle event.
+type PaymentEventType string
+
+const (
+	EventPaymentAccepted       PaymentEventType = "payment.accepted"
+	EventPaymentDispatched     PaymentEventType = "payment.dispatched"
+	EventPaymentSucceeded      PaymentEventType = "payment.succeeded"
+	EventPaymentRetryScheduled PaymentEventType = "payment.retry_scheduled"
+	EventPaymentGivenUp        PaymentEventType = "payment.given_up"
+)
+
+// PaymentEventTypes lists the event types in lifecycle order.
+var PaymentEventTypes = []PaymentEventType{
+	EventPaymentAccepted, EventPaymentDispatched, EventPaymentSucceeded,
+	EventPaymentRetryScheduled, EventPaymentGivenUp,
+}
+
+// ErrorClass tells how a failed processor call must be handled.
+type ErrorClass string
+
+const (
+	// ClassRetryable failures may succeed later, on this or another processor.
+	ClassRetryable ErrorClass = "retryable"
+	// ClassPermanent failures will fail again; the payment is dead-lettered.
+	ClassPermanent ErrorClass = "permanent"
+	// ClassAlreadyProcessed means the processor already holds the payment, so
+	// it is recorded as succeeded instead of being sent again.
+	ClassAlreadyProcessed ErrorClass = "already_processed"
+)
+
+// ErrorClasses lists the classes processor status codes may be mapped to.
+var ErrorClasses = []ErrorClass{ClassRetryable, ClassPermanent, ClassAlreadyProcessed}
+
+const (
+	ScopePaymentsRead  = "payments:read"
+	ScopePaymentsWrite = "payments:write"
+	ScopeSummaryRead   = "summary:read"
+	ScopeEventsRead    = "events:read"
+)
+
+// ProblemCode identifies the kind of an error response. Codes are part of the
+// API and must not change once released.
+type ProblemCode string
+
+const (
+	ProblemMalformedRequest     ProblemCode = "malformed_request"
+	ProblemInvalidJSON          ProblemCode = "invalid_json"
+	ProblemUnknownField         ProblemCode = "unknown_field"
+	ProblemValidationFailed     ProblemCode = "validation_failed"
+	ProblemUnsupportedMediaType ProblemCode = "unsupported_media_type"
+	ProblemBodyTooLarge         ProblemCode = "body_too_large"
+	ProblemBatchTooLarge        ProblemCode = "batch_too_large"
+	ProblemEmptyBatch           ProblemCode = "empty_batch"
+	ProblemDuplicateInBatch     ProblemCode = "duplicate_in_batch"
+	ProblemMethodNotAllowed     ProblemCode = "method_not_allowed"
+	ProblemNotFound             ProblemCode = "not_found"
+	ProblemMissingAPIKey        ProblemCode = "missing_api_key"
+	ProblemInvalidAPIKey        ProblemCode = "invalid_api_key"
+	ProblemMissingScope         ProblemCode = "missing_scope"
+	ProblemAdminTokenRequired   ProblemCode = "admin_token_required"
+	ProblemMerchantForbidden    ProblemCode = "merchant_forbidden"
+	ProblemRateLimited          ProblemCode = "rate_limited"
+	ProblemQueueFull            ProblemCode = "queue_full"
+	ProblemQueueSaturated       ProblemCode = "queue_saturated"
+	ProblemDuplicatePayment     ProblemCode = "duplicate_payment"
+	ProblemNoAllowedProcessor   ProblemCode = "no_allowed_processor"
+	ProblemUnsupportedCurrency  ProblemCode = "unsupported_currency"
+	ProblemPaymentNotFound      ProblemCode = "payment_not_found"
+	ProblemNotCancellable       ProblemCode = "not_cancellable"
+	ProblemNotRefundable        ProblemCode = "not_refundable"
+	ProblemRefundTooLarge       ProblemCode = "refund_too_large"
+	ProblemIdempotencyMismatch  ProblemCode = "idempotency_key_mismatch"
+	ProblemIdempotencyInFlight  ProblemCode = "idempotency_key_in_flight"
+	ProblemAPIClientNotFound    ProblemCode = "api_client_not_found"
+	ProblemAPIClientRevoked     ProblemCode = "api_client_revoked"
+	ProblemDeliveryNotFound     ProblemCode = "webhook_delivery_not_found"
+	ProblemInvalidEventID       ProblemCode = "invalid_event_id"
+	ProblemEventsDisabled       ProblemCode = "events_disabled"
+	ProblemInternal             ProblemCode = "internal_error"
+)
@@ -0,0 +1,767 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+)
+
+// The payment hot path encodes and decodes the structs below for every
+// request, so they get hand-written codecs instead of going through
+// encoding/json reflection. Encoders produce the same documents as
+// encoding/json and allocate nothing when given a buffer with enough room.
+// Decoders only allocate the strings they return.
+
+// ErrInvalidJSON is returned by the decoders for malformed documents.
+var ErrInvalidJSON = errors.New("invalid JSON")
+
+// UnknownFieldError is returned by strict decoders for a field the target
+// struct does not have.
+type UnknownFieldError struct {
+	Field string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return "unknown field " + strconv.Quote(e.Field)
+}
+
+// FieldTypeError is returned by the decoders for a field holding a value of
+// the wrong JSON type.
+type FieldTypeError struct {
+	Field    string
+	Expected string
+}
+
+func (e *FieldTypeError) Error() string {
+	return "field " + strconv.Quote(e.Field) + " expects a JSON " + e.Expected
+}
+
+// AppendJSON appends the JSON encoding of the request to b.
+func (r *PaymentRequest) AppendJSON(b []byte) []byte {
+	b = append(b, `{"correlationId":`...)
+	b = appendJSONString(b, r.CorrelationID)
+	b = append(b, `,"amount":`...)
+	b = appendJSONFloat(b, r.Amount)
+	if r.CallbackURL != "" {
+		b = append(b, `,"callbackUrl":`...)
+		b = appendJSONString(b, r.CallbackURL)
+	}
+	if r.MerchantID != "" {
+		b = append(b, `,"merchantId":`...)
+		b = appendJSONString(b, r.MerchantID)
+	}
+	if r.Priority != "" {
+		b = append(b, `,"priority":`...)
+		b = appendJSONString(b, r.Priority)
+	}
+	if r.ScheduledAt != nil {
+		b = append(b, `,"scheduledAt":`...)
+		b = appendJSONTime(b, *r.ScheduledAt)
+	}
+	if r.Currency != "" {
+		b = append(b, `,"currency":`...)
+		b = appendJSONString(b, r.Currency)
+	}
+	return append(b, '}')
+}
+
+var paymentRequestFields = []string{
+	"correlationId", "amount", "callbackUrl", "merchantId", "priority", "scheduledAt", "currency",
+}
+
+// DecodeJSON decodes a request body, rejecting unknown fields and trailing
+// data like a json.Decoder with DisallowUnknownFields.
+func (r *PaymentRequest) DecodeJSON(data []byte) error {
+	d := jsonDecoder{data: unsafe.String(unsafe.SliceData(data), len(data)), clone: true}
+	return d.document(paymentRequestFields, func(key string) error {
+		var err error
+		switch key {
+		case "correlationId":
+			r.CorrelationID, err = d.stringValue(key)
+		case "amount":
+			r.Amount, err = d.floatValue(key)
+		case "callbackUrl":
+			r.CallbackURL, err = d.stringValue(key)
+		case "merchantId":
+			r.MerchantID, err = d.stringValue(key)
+		case "priority":
+			r.Priority, err = d.stringValue(key)
+		case "scheduledAt":
+			r.ScheduledAt, err = d.timePointerValue(key)
+		case "currency":
+			r.Currency, err = d.stringValue(key)
+		default:
+			return &UnknownFieldError{Field: strings.Clone(key)}
+		}
+		return err
+	})
+}
+
+// AppendJSON appends the JSON encoding of the payment to b.
+func (p *QueuedPayment) AppendJSON(b []byte) []byte {
+	b = append(b, `{"CorrelationID":`...)
+	b = appendJSONString(b, p.CorrelationID)
+	b = append(b, `,"Amount":`...)
+	b = appendJSONFloat(b, p.Amount)
+	b = append(b, `,"CreatedAt":`...)
+	b = appendJSONTime(b, p.CreatedAt)
+	b = append(b, `,"RetryCount":`...)
+	b = strconv.AppendInt(b, int64(p.RetryCount), 10)
+	b = append(b, `,"Deadline":`...)
+	b = appendJSONTime(b, p.Deadline)
+	if p.CallbackURL != "" {
+		b = append(b, `,"CallbackURL":`...)
+		b = appendJSONString(b, p.CallbackURL)
+	}
+	if p.PinnedProcessor != "" {
+		b = append(b, `,"PinnedProcessor":`...)
+		b = appendJSONString(b, string(p.PinnedProcessor))
+	}
+	if p.PinnedAt != nil {
+		b = append(b, `,"PinnedAt":`...)
+		b = appendJSONTime(b, *p.PinnedAt)
+	}
+	if p.ClientID != "" {
+		b = append(b, `,"ClientID":`...)
+		b = appendJSONString(b, p.ClientID)
+	}
+	if p.MerchantID != "" {
+		b = append(b, `,"MerchantID":`...)
+		b = appendJSONString(b, p.MerchantID)
+	}
+	if p.Priority != "" {
+		b = append(b, `,"Priority":`...)
+		b = appendJSONString(b, string(p.Priority))
+	}
+	if p.ScheduledAt != nil {
+		b = append(b, `,"ScheduledAt":`...)
+		b = appendJSONTime(b, *p.ScheduledAt)
+	}
+	if p.Currency != "" {
+		b = append(b, `,"Currency":`...)
+		b = appendJSONString(b, p.Currency)
+	}
+	return append(b, '}')
+}
+
+// DecodeJSON decodes a payment, skipping unknown fields so payments queued by
+// other versions of the gateway can still be read.
+func (p *QueuedPayment) DecodeJSON(data []byte) error {
+	return p.decode(jsonDecoder{data: unsafe.String(unsafe.SliceData(data), len(data)), clone: true})
+}
+
+// DecodeJSONString is DecodeJSON for payments read as strings, such as Redis
+// replies. The decoded strings share the memory of data.
+func (p *QueuedPayment) DecodeJSONString(data string) error {
+	return p.decode(jsonDecoder{data: data})
+}
+
+var queuedPaymentFields = []string{
+	"CorrelationID", "Amount", "CreatedAt", "RetryCount", "Deadline", "CallbackURL", "PinnedProcessor",
+	"PinnedAt", "ClientID", "MerchantID", "Priority", "ScheduledAt", "Currency",
+}
+
+func (p *QueuedPayment) decode(d jsonDecoder) error {
+	return d.document(queuedPaymentFields, func(key string) error {
+		var err error
+		var value string
+		switch key {
+		case "CorrelationID":
+			p.CorrelationID, err = d.stringValue(key)
+		case "Amount":
+			p.Amount, err = d.floatValue(key)
+		case "CreatedAt":
+			p.CreatedAt, err = d.timeValue(key)
+		case "RetryCount":
+			p.RetryCount, err = d.intValue(key)
+		case "Deadline":
+			p.Deadline, err = d.timeValue(key)
+		case "CallbackURL":
+			p.CallbackURL, err = d.stringValue(key)
+		case "PinnedProcessor":
+			value, err = d.stringValue(key)
+			p.PinnedProcessor = constants.PaymentMode(value)
+		case "PinnedAt":
+			p.PinnedAt, err = d.timePointerValue(key)
+		case "ClientID":
+			p.ClientID, err = d.stringValue(key)
+		case "MerchantID":
+			p.MerchantID, err = d.stringValue(key)
+		case "Priority":
+			value, err = d.stringValue(key)
+			p.Priority = constants.PaymentPriority(value)
+		case "ScheduledAt":
+			p.ScheduledAt, err = d.timePointerValue(key)
+		case "Currency":
+			p.Currency, err = d.stringValue(key)
+		default:
+			err = d.skipValue()
+		}
+		return err
+	})
+}
+
+// AppendJSON appends the JSON encoding of the request to b.
+func (r *PaymentProcessorRequest) AppendJSON(b []byte) []byte {
+	b = append(b, `{"correlationId":`...)
+	b = appendJSONString(b, r.CorrelationID)
+	b = append(b, `,"amount":`...)
+	b = appendJSONFloat(b, r.Amount)
+	if r.Currency != "" {
+		b = append(b, `,"currency":`...)
+		b = appendJSONString(b, r.Currency)
+	}
+	b = append(b, `,"requestedAt":`...)
+	b = appendJSONTime(b, r.RequestedAt)
+	return append(b, '}')
+}
+
+var processorRequestFields = []string{"correlationId", "amount", "currency", "requestedAt"}
+
+// DecodeJSON decodes a processor request, skipping unknown fields.
+func (r *PaymentProcessorRequest) DecodeJSON(data []byte) error {
+	d := jsonDecoder{data: unsafe.String(unsafe.SliceData(data), len(data)), clone: true}
+	return d.document(processorRequestFields, func(key string) error {
+		var err error
+		switch key {
+		case "correlationId":
+			r.CorrelationID, err = d.stringValue(key)
+		case "amount":
+			r.Amount, err = d.floatValue(key)
+		case "currency":
+			r.Currency, err = d.stringValue(key)
+		case "requestedAt":
+			r.RequestedAt, err = d.timeValue(key)
+		default:
+			err = d.skipValue()
+		}
+		return err
+	})
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as a JSON string, escaped like encoding/json
+// does, including its HTML escaping and replacement of invalid UTF-8.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+		case r == '\u2028' || r == '\u2029':
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+		default:
+			i += size
+			continue
+		}
+		i += size
+		start = i
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
+
+// appendJSONFloat appends f formatted like encoding/json does. encoding/json
+// refuses NaN and infinities, which are encoded as null here.
+func appendJSONFloat(b []byte, f float64) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return append(b, "null"...)
+	}
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+func appendJSONTime(b []byte, t time.Time) []byte {
+	b = append(b, '"')
+	b = t.AppendFormat(b, time.RFC3339Nano)
+	return append(b, '"')
+}
+
+// jsonDecoder reads the top level object of a document. When clone is set,
+// data aliases a caller's buffer and returned strings are copied out of it.
+type jsonDecoder struct {
+	data  string
+	pos   int
+	clone bool
+}
+
+// document decodes an object holding the whole document, calling field to
+// decode the value of each key. Like encoding/json, keys matching none of
+// fields exactly are matched case-insensitively, field being called with the
+// name they match.
+func (d *jsonDecoder) document(fields []string, field func(key string) error) error {
+	if !d.consume('{') {
+		return ErrInvalidJSON
+	}
+	if d.consume('}') {
+		return d.end()
+	}
+
+	for {
+		d.skipSpace()
+		key, err := d.rawString()
+		if err != nil {
+			return err
+		}
+		if !d.consume(':') {
+			return ErrInvalidJSON
+		}
+		d.skipSpace()
+		if !slices.Contains(fields, key) {
+			key = foldField(fields, key)
+		}
+		if err := field(key); err != nil {
+			return err
+		}
+
+		if d.consume(',') {
+			continue
+		}
+		if d.consume('}') {
+			return d.end()
+		}
+		return ErrInvalidJSON
+	}
+}
+
+// foldField returns the first of fields equal to key under Unicode case
+// folding, or key itself when there is none.
+func foldField(fields []string, key string) string {
+	for _, name := range fields {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+	}
+	return key
+}
+
+func (d *jsonDecoder) end() error {
+	d.skipSpace()
+	if d.pos != len(d.data) {
+		return ErrInvalidJSON
+	}
+	return nil
+}
+
+func (d *jsonDecoder) skipSpace() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+// consume skips spaces and the byte c, reporting whether it was there.
+func (d *jsonDecoder) consume(c byte) bool {
+	d.skipSpace()
+	if d.pos < len(d.data) && d.data[d.pos] == c {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *jsonDecoder) peek() byte {
+	if d.pos < len(d.data) {
+		return d.data[d.pos]
+	}
+	return 0
+}
+
+// null consumes a null literal, reporting whether there was one.
+func (d *jsonDecoder) null() bool {
+	if strings.HasPrefix(d.data[d.pos:], "null") {
+		d.pos += len("null")
+		return true
+	}
+	return false
+}
+
+// typeError validates and skips a value of the wrong type for key.
+func (d *jsonDecoder) typeError(key, expected string) error {
+	if err := d.skipValue(); err != nil {
+		return err
+	}
+	return &FieldTypeError{Field: strings.Clone(key), Expected: expected}
+}
+
+// rawString reads a string without copying it out of the data when it holds
+// no escape sequences.
+func (d *jsonDecoder) rawString() (string, error) {
+	if d.peek() != '"' {
+		return "", ErrInvalidJSON
+	}
+	d.pos++
+
+	start := d.pos
+	for d.pos < len(d.data) {
+		switch c := d.data[d.pos]; {
+		case c == '"':
+			s := d.data[start:d.pos]
+			d.pos++
+			return s, nil
+		case c == '\\':
+			return d.unescape(start)
+		case c < 0x20:
+			return "", ErrInvalidJSON
+		default:
+			d.pos++
+		}
+	}
+	return "", ErrInvalidJSON
+}
+
+// unescape reads the rest of a string starting at start that holds escape
+// sequences, d.pos being at the first of them.
+func (d *jsonDecoder) unescape(start int) (string, error) {
+	b := make([]byte, 0, d.pos-start+16)
+	b = append(b, d.data[start:d.pos]...)
+
+	for d.pos < len(d.data) {
+		c := d.data[d.pos]
+		switch {
+		case c == '"':
+			d.pos++
+			return string(b), nil
+		case c < 0x20:
+			return "", ErrInvalidJSON
+		case c != '\\':
+			b = append(b, c)
+			d.pos++
+			continue
+		}
+
+		if d.pos+1 >= len(d.data) {
+			return "", ErrInvalidJSON
+		}
+		escaped := d.data[d.pos+1]
+		d.pos += 2
+		switch escaped {
+		case '"', '\\', '/':
+			b = append(b, escaped)
+		case 'b':
+			b = append(b, '\b')
+		case 'f':
+			b = append(b, '\f')
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case 'u':
+			r, ok := d.hexRune()
+			if !ok {
+				return "", ErrInvalidJSON
+			}
+			if utf16.IsSurrogate(r) {
+				r = d.lowSurrogate(r)
+			}
+			b = utf8.AppendRune(b, r)
+		default:
+			return "", ErrInvalidJSON
+		}
+	}
+	return "", ErrInvalidJSON
+}
+
+func (d *jsonDecoder) hexRune() (rune, bool) {
+	if d.pos+4 > len(d.data) {
+		return 0, false
+	}
+	var r rune
+	for _, c := range []byte(d.data[d.pos : d.pos+4]) {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		r = r*16 + rune(c)
+	}
+	d.pos += 4
+	return r, true
+}
+
+// lowSurrogate combines a high surrogate with the escaped low surrogate
+// following it, like encoding/json does. Unpaired surrogates are replaced.
+func (d *jsonDecoder) lowSurrogate(high rune) rune {
+	if !strings.HasPrefix(d.data[d.pos:], `\u`) {
+		return utf8.RuneError
+	}
+
+	pos := d.pos
+	d.pos += 2
+	if low, ok := d.hexRune(); ok {
+		if r := utf16.DecodeRune(high, low); r != utf8.RuneError {
+			return r
+		}
+	}
+	d.pos = pos
+	return utf8.RuneError
+}
+
+// stringValue reads a string value, leaving null as the empty string.
+func (d *jsonDecoder) stringValue(key string) (string, error) {
+	if d.null() {
+		return "", nil
+	}
+	if d.peek() != '"' {
+		return "", d.typeError(key, "string")
+	}
+
+	start := d.pos
+	s, err := d.rawString()
+	if err != nil {
+		return "", err
+	}
+	// unescaped strings are already copies
+	if d.clone && d.pos-start == len(s)+2 {
+		s = strings.Clone(s)
+	}
+	return s, nil
+}
+
+// number reads a number, checking it follows the JSON grammar.
+func (d *jsonDecoder) number() (string, error) {
+	start := d.pos
+	d.pos += d.prefixLen("-")
+	switch {
+	case d.prefixLen("0") == 1:
+		d.pos++
+	case d.digits() == 0:
+		return "", ErrInvalidJSON
+	}
+	if d.prefixLen(".") == 1 {
+		d.pos++
+		if d.digits() == 0 {
+			return "", ErrInvalidJSON
+		}
+	}
+	if c := d.peek(); c == 'e' || c == 'E' {
+		d.pos++
+		if c := d.peek(); c == '+' || c == '-' {
+			d.pos++
+		}
+		if d.digits() == 0 {
+			return "", ErrInvalidJSON
+		}
+	}
+	return d.data[start:d.pos], nil
+}
+
+func (d *jsonDecoder) prefixLen(prefix string) int {
+	if strings.HasPrefix(d.data[d.pos:], prefix) {
+		return len(prefix)
+	}
+	return 0
+}
+
+func (d *jsonDecoder) digits() int {
+	start := d.pos
+	for d.pos < len(d.data) && '0' <= d.data[d.pos] && d.data[d.pos] <= '9' {
+		d.pos++
+	}
+	return d.pos - start
+}
+
+func (d *jsonDecoder) isNumberStart() bool {
+	c := d.peek()
+	return c == '-' || ('0' <= c && c <= '9')
+}
+
+// floatValue reads a number value, leaving null as zero.
+func (d *jsonDecoder) floatValue(key string) (float64, error) {
+	if d.null() {
+		return 0, nil
+	}
+	if !d.isNumberStart() {
+		return 0, d.typeError(key, "number")
+	}
+
+	number, err := d.number()
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, &FieldTypeError{Field: strings.Clone(key), Expected: "number"}
+	}
+	return f, nil
+}
+
+// intValue reads an integer value, leaving null as zero.
+func (d *jsonDecoder) intValue(key string) (int, error) {
+	if d.null() {
+		return 0, nil
+	}
+	if !d.isNumberStart() {
+		return 0, d.typeError(key, "number")
+	}
+
+	number, err := d.number()
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(number)
+	if err != nil {
+		return 0, &FieldTypeError{Field: strings.Clone(key), Expected: "integer"}
+	}
+	return i, nil
+}
+
+// timeValue reads an RFC 3339 timestamp, leaving null as the zero time.
+func (d *jsonDecoder) timeValue(key string) (time.Time, error) {
+	if d.null() {
+		return time.Time{}, nil
+	}
+	if d.peek() != '"' {
+		return time.Time{}, d.typeError(key, "string")
+	}
+
+	s, err := d.rawString()
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, &FieldTypeError{Field: strings.Clone(key), Expected: "RFC 3339 timestamp"}
+	}
+	return t, nil
+}
+
+func (d *jsonDecoder) timePointerValue(key string) (*time.Time, error) {
+	if d.null() {
+		return nil, nil
+	}
+	t, err := d.timeValue(key)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// skipValue validates and skips any value.
+func (d *jsonDecoder) skipValue() error {
+	d.skipSpace()
+	switch c := d.peek(); {
+	case c == '"':
+		_, err := d.rawString()
+		return err
+	case c == '{':
+		d.pos++
+		if d.consume('}') {
+			return nil
+		}
+		for {
+			d.skipSpace()
+			if _, err := d.rawString(); err != nil {
+				return err
+			}
+			if !d.consume(':') {
+				return ErrInvalidJSON
+			}
+			if err := d.skipValue(); err != nil {
+				return err
+			}
+			if d.consume(',') {
+				continue
+			}
+			if d.consume('}') {
+				return nil
+			}
+			return ErrInvalidJSON
+		}
+	case c == '[':
+		d.pos++
+		if d.consume(']') {
+			return nil
+		}
+		for {
+			if err := d.skipValue(); err != nil {
+				return err
+			}
+			if d.consume(',') {
+				continue
+			}
+			if d.consume(']') {
+				return nil
+			}
+			return ErrInvalidJSON
+		}
+	case d.isNumberStart():
+		_, err := d.number()
+		return err
+	case d.null():
+		return nil
+	case strings.HasPrefix(d.data[d.pos:], "true"):
+		d.pos += len("true")
+		return nil
+	case strings.HasPrefix(d.data[d.pos:], "false"):
+		d.pos += len("false")
+		return nil
+	default:
+		return ErrInvalidJSON
+	}
+}
@@ -0,0 +1,293 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+)
+
+var (
+	codecTime = time.Date(2025, 7, 14, 12, 30, 45, 123456789, time.UTC)
+
+	codecRequest = PaymentRequest{
+		CorrelationID: "4a7901b8-7d26-4d2d-8c5e-6d0a2ba5a9f1",
+		Amount:        19.9,
+		CallbackURL:   "https://example.com/hooks?a=1&b=<2>",
+		MerchantID:    "acme",
+		Priority:      "high",
+		ScheduledAt:   &codecTime,
+		Currency:      "BRL",
+	}
+
+	codecPayment = QueuedPayment{
+		CorrelationID:   "4a7901b8-7d26-4d2d-8c5e-6d0a2ba5a9f1",
+		Amount:          19.9,
+		CreatedAt:       codecTime,
+		RetryCount:      3,
+		Deadline:        codecTime.Add(time.Minute),
+		CallbackURL:     "https://example.com/hooks",
+		PinnedProcessor: constants.FallbackProcessorKey,
+		PinnedAt:        &codecTime,
+		ClientID:        "client",
+		MerchantID:      "acme",
+		Priority:        constants.PriorityLow,
+		ScheduledAt:     &codecTime,
+		Currency:        "USD",
+	}
+
+	codecProcessorRequest = PaymentProcessorRequest{
+		CorrelationID: "4a7901b8-7d26-4d2d-8c5e-6d0a2ba5a9f1",
+		Amount:        19.9,
+		Currency:      "USD",
+		RequestedAt:   codecTime,
+	}
+)
+
+func TestAppendJSON_MatchesEncodingJSON(t *testing.T) {
+	tricky := "quote\" backslash\\ tab\t nul\x00 bell\x07 line  invalid\xff é"
+
+	cases := map[string]struct {
+		value  any
+		encode func() []byte
+	}{
+		"PaymentRequest": {&codecRequest, func() []byte { return codecRequest.AppendJSON(nil) }},
+		"PaymentRequest/minimal": {&PaymentRequest{CorrelationID: tricky, Amount: 1e-7}, func() []byte {
+			return (&PaymentRequest{CorrelationID: tricky, Amount: 1e-7}).AppendJSON(nil)
+		}},
+		"QueuedPayment": {&codecPayment, func() []byte { return codecPayment.AppendJSON(nil) }},
+		"QueuedPayment/minimal": {&QueuedPayment{Amount: 1e21}, func() []byte {
+			return (&QueuedPayment{Amount: 1e21}).AppendJSON(nil)
+		}},
+		"PaymentProcessorRequest": {&codecProcessorRequest, func() []byte { return codecProcessorRequest.AppendJSON(nil) }},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			want, err := json.Marshal(tc.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tc.encode(); string(got) != string(want) {
+				t.Errorf("AppendJSON() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeJSON_RoundTrips(t *testing.T) {
+	var request PaymentRequest
+	if err := request.DecodeJSON(codecRequest.AppendJSON(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if request.CorrelationID != codecRequest.CorrelationID || request.Amount != codecRequest.Amount ||
+		request.CallbackURL != codecRequest.CallbackURL || !request.ScheduledAt.Equal(codecTime) {
+		t.Errorf("decoded request %+v, want %+v", request, codecRequest)
+	}
+
+	var payment QueuedPayment
+	if err := payment.DecodeJSONString(string(codecPayment.AppendJSON(nil))); err != nil {
+		t.Fatal(err)
+	}
+	if payment.PinnedProcessor != codecPayment.PinnedProcessor || payment.PinnedAt == nil ||
+		!payment.PinnedAt.Equal(codecTime) || payment.RetryCount != 3 ||
+		!payment.Deadline.Equal(codecPayment.Deadline) || payment.Currency != "USD" {
+		t.Errorf("decoded payment %+v, want %+v", payment, codecPayment)
+	}
+
+	var processorRequest PaymentProcessorRequest
+	if err := processorRequest.DecodeJSON(codecProcessorRequest.AppendJSON(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if processorRequest != codecProcessorRequest {
+		t.Errorf("decoded processor request %+v, want %+v", processorRequest, codecProcessorRequest)
+	}
+}
+
+func TestPaymentRequestDecodeJSON_MatchesEncodingJSON(t *testing.T) {
+	bodies := []string{
+		` { "correlationId" : "aé😀\n" , "amount" : -1.5e2 } `,
+		`{"correlationId":null,"amount":null,"scheduledAt":null}`,
+		`{"amount":0}`,
+		`{}`,
+		// keys match case-insensitively, as in encoding/json
+		`{"CorrelationID":"a","AMOUNT":1,"ScheduledAt":null}`,
+		`{"correlationid":"b","amount":2,"Amount":3}`,
+	}
+
+	for _, body := range bodies {
+		var got, want PaymentRequest
+		if err := json.Unmarshal([]byte(body), &want); err != nil {
+			t.Fatalf("json.Unmarshal(%s): %s", body, err)
+		}
+		if err := got.DecodeJSON([]byte(body)); err != nil {
+			t.Fatalf("DecodeJSON(%s): %s", body, err)
+		}
+		if got.CorrelationID != want.CorrelationID || got.Amount != want.Amount || got.ScheduledAt != want.ScheduledAt {
+			t.Errorf("DecodeJSON(%s) = %+v, want %+v", body, got, want)
+		}
+	}
+}
+
+func TestPaymentRequestDecodeJSON_Rejects(t *testing.T) {
+	cases := map[string]struct {
+		body  string
+		check func(error) bool
+	}{
+		"unknown field": {`{"correlationId":"a","amout":1}`, func(err error) bool {
+			var unknown *UnknownFieldError
+			return errors.As(err, &unknown) && unknown.Field == "amout"
+		}},
+		"wrong type": {`{"amount":"10"}`, func(err error) bool {
+			var typeErr *FieldTypeError
+			return errors.As(err, &typeErr) && typeErr.Field == "amount"
+		}},
+		"trailing data":    {`{"amount":1} {}`, isInvalidJSON},
+		"truncated":        {`{"amount":1`, isInvalidJSON},
+		"leading zero":     {`{"amount":01}`, isInvalidJSON},
+		"plus sign":        {`{"amount":+1}`, isInvalidJSON},
+		"raw control char": {"{\"correlationId\":\"a\nb\"}", isInvalidJSON},
+		"not an object":    {`[1]`, isInvalidJSON},
+		"trailing comma":   {`{"amount":1,}`, isInvalidJSON},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var request PaymentRequest
+			if err := request.DecodeJSON([]byte(tc.body)); !tc.check(err) {
+				t.Errorf("DecodeJSON(%s) = %v", tc.body, err)
+			}
+		})
+	}
+}
+
+func isInvalidJSON(err error) bool {
+	return errors.Is(err, ErrInvalidJSON)
+}
+
+func TestQueuedPaymentDecodeJSON_SkipsUnknownFields(t *testing.T) {
+	data := `{"CorrelationID":"a","Legacy":{"nested":[1,"two",true,null,{"x":-0.5e-3}]},"Amount":2}`
+
+	var payment QueuedPayment
+	if err := payment.DecodeJSONString(data); err != nil {
+		t.Fatal(err)
+	}
+	if payment.CorrelationID != "a" || payment.Amount != 2 {
+		t.Errorf("decoded payment %+v", payment)
+	}
+}
+
+func TestCodec_DoesNotAllocate(t *testing.T) {
+	buf := make([]byte, 0, 1024)
+	encoded := codecPayment.AppendJSON(nil)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = codecRequest.AppendJSON(buf[:0])
+		buf = codecPayment.AppendJSON(buf[:0])
+		buf = codecProcessorRequest.AppendJSON(buf[:0])
+	})
+	if allocs != 0 {
+		t.Errorf("encoders allocate %v times, want 0", allocs)
+	}
+
+	data := string(encoded)
+	var payment QueuedPayment
+	allocs = testing.AllocsPerRun(100, func() {
+		payment = QueuedPayment{}
+		if err := payment.DecodeJSONString(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// the ScheduledAt and PinnedAt pointers
+	if allocs > 2 {
+		t.Errorf("DecodeJSONString allocates %v times, want at most 2", allocs)
+	}
+}
+
+// The benchmarks below compare the codecs with encoding/json for the three
+// steps every payment goes through: decoding the API request, queueing it in
+// Redis and sending it to a processor.
+
+var requestBody = []byte(`{"correlationId":"4a7901b8-7d26-4d2d-8c5e-6d0a2ba5a9f1","amount":19.9}`)
+
+func BenchmarkPaymentRequest_Decode(b *testing.B) {
+	b.Run("codec", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			var request PaymentRequest
+			if err := request.DecodeJSON(requestBody); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("encoding_json", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			var request PaymentRequest
+			if err := json.Unmarshal(requestBody, &request); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkQueuedPayment_Encode(b *testing.B) {
+	b.Run("codec", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, 512)
+		for range b.N {
+			buf = codecPayment.AppendJSON(buf[:0])
+		}
+	})
+	b.Run("encoding_json", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := json.Marshal(&codecPayment); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkQueuedPayment_Decode(b *testing.B) {
+	data := string(codecPayment.AppendJSON(nil))
+
+	b.Run("codec", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			var payment QueuedPayment
+			if err := payment.DecodeJSONString(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("encoding_json", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			var payment QueuedPayment
+			if err := json.Unmarshal([]byte(data), &payment); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkPaymentProcessorRequest_Encode(b *testing.B) {
+	b.Run("codec", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, 256)
+		for range b.N {
+			buf = codecProcessorRequest.AppendJSON(buf[:0])
+		}
+	})
+	b.Run("encoding_json", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := json.Marshal(&codecProcessorRequest); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
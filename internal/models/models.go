@@ -1,15 +1,26 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+)
 
 type PaymentRequest struct {
-	CorrelationID string  `json:"correlationId"`
-	Amount        float64 `json:"amount"`
+	CorrelationID string     `json:"correlationId"`
+	Amount        float64    `json:"amount"`
+	CallbackURL   string     `json:"callbackUrl,omitempty"`
+	MerchantID    string     `json:"merchantId,omitempty"`
+	Priority      string     `json:"priority,omitempty"`
+	ScheduledAt   *time.Time `json:"scheduledAt,omitempty"`
+	Currency      string     `json:"currency,omitempty"`
 }
 
 type PaymentProcessorRequest struct {
 	CorrelationID string    `json:"correlationId"`
 	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency,omitempty"`
 	RequestedAt   time.Time `json:"requestedAt"`
 }
 
@@ -17,27 +28,233 @@ type PaymentProcessorResponse struct {
 	Message string `json:"message"`
 }
 
-type ProcessorSummary struct {
-	TotalRequest int64   `json:"totalRequests"`
-	TotalAmount  float64 `json:"totalAmount"`
+// SummaryTotals reports the gross amount of the payments processed by a
+// processor, the amount refunded from them and the net of both.
+type SummaryTotals struct {
+	TotalRequest   int64   `json:"totalRequests"`
+	TotalAmount    float64 `json:"totalAmount"`
+	RefundedAmount float64 `json:"refundedAmount"`
+	NetAmount      float64 `json:"netAmount"`
 }
 
-type PaymentSummaryResponse struct {
-	Default  ProcessorSummary `json:"default"`
-	Fallback ProcessorSummary `json:"fallback"`
+// ProcessorSummary holds the totals of a processor in the default currency,
+// and the totals in each configured currency, keyed by currency code.
+type ProcessorSummary struct {
+	SummaryTotals
+	Currencies map[string]SummaryTotals `json:"currencies,omitempty"`
 }
 
+// PaymentSummaryResponse holds one summary per configured processor, keyed by
+// the processor name.
+type PaymentSummaryResponse map[string]ProcessorSummary
+
+// ProcessorHealth is the last known health of a processor. Health forced by
+// an operator is left alone by the health monitor until ForcedUntil, or until
+// released when ForcedUntil is zero.
 type ProcessorHealth struct {
 	Failing         bool
 	MinResponseTime int
 	LastChecked     time.Time
+	Forced          bool
+	ForcedUntil     time.Time
+}
+
+// IsForced reports whether the health is forced at the given time.
+func (h *ProcessorHealth) IsForced(now time.Time) bool {
+	return h.Forced && (h.ForcedUntil.IsZero() || now.Before(h.ForcedUntil))
 }
 
 type QueuedPayment struct {
-	CorrelationID string
-	Amount        float64
-	CreatedAt     time.Time
-	RetryCount    int
+	CorrelationID   string
+	Amount          float64
+	CreatedAt       time.Time
+	RetryCount      int
+	Deadline        time.Time
+	CallbackURL     string                    `json:",omitempty"`
+	PinnedProcessor constants.PaymentMode     `json:",omitempty"`
+	PinnedAt        *time.Time                `json:",omitempty"`
+	ClientID        string                    `json:",omitempty"`
+	MerchantID      string                    `json:",omitempty"`
+	Priority        constants.PaymentPriority `json:",omitempty"`
+	ScheduledAt     *time.Time                `json:",omitempty"`
+	Currency        string                    `json:",omitempty"`
+}
+
+// Key identifies the payment within its merchant, since merchants pick their
+// correlation IDs independently.
+func (p *QueuedPayment) Key() string {
+	return PaymentKey(p.MerchantID, p.CorrelationID)
+}
+
+// PaymentKey scopes a correlation ID to a merchant. Payments without a
+// merchant keep their bare correlation ID.
+func PaymentKey(merchantID, correlationID string) string {
+	if merchantID == "" {
+		return correlationID
+	}
+	return merchantID + ":" + correlationID
+}
+
+type PaymentResult struct {
+	CorrelationID  string                  `json:"correlationId"`
+	MerchantID     string                  `json:"merchantId,omitempty"`
+	ClientID       string                  `json:"clientId,omitempty"`
+	Status         constants.PaymentStatus `json:"status"`
+	Processor      string                  `json:"processor,omitempty"`
+	Amount         float64                 `json:"amount"`
+	Currency       string                  `json:"currency,omitempty"`
+	RefundedAmount float64                 `json:"refundedAmount,omitempty"`
+	Reason         string                  `json:"reason,omitempty"`
+	UpdatedAt      time.Time               `json:"updatedAt"`
+}
+
+func (r *PaymentResult) Key() string {
+	return PaymentKey(r.MerchantID, r.CorrelationID)
+}
+
+// Refund is a full or partial refund of a processed payment. Refunds are kept
+// apart from the payment so summaries can report them separately.
+type Refund struct {
+	ID            string    `json:"id"`
+	CorrelationID string    `json:"correlationId"`
+	MerchantID    string    `json:"merchantId,omitempty"`
+	Processor     string    `json:"processor"`
+	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+type RefundRequest struct {
+	Amount float64 `json:"amount,omitempty"`
+}
+
+// BatchItemResult reports the outcome of one item of a payment batch, by its
+// position in the batch.
+type BatchItemResult struct {
+	Index         int                   `json:"index"`
+	CorrelationID string                `json:"correlationId,omitempty"`
+	Status        string                `json:"status"`
+	Code          constants.ProblemCode `json:"code,omitempty"`
+	Error         string                `json:"error,omitempty"`
+	InvalidParams []InvalidParam        `json:"invalidParams,omitempty"`
+}
+
+type BatchPaymentResponse struct {
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Items    []BatchItemResult `json:"items"`
+}
+
+// Problem is an RFC 7807 problem details document, returned by every failed
+// request. Code is a stable identifier of the problem, and Type a URI
+// reference derived from it.
+type Problem struct {
+	Type          string                `json:"type"`
+	Title         string                `json:"title"`
+	Status        int                   `json:"status"`
+	Detail        string                `json:"detail,omitempty"`
+	Instance      string                `json:"instance,omitempty"`
+	Code          constants.ProblemCode `json:"code"`
+	InvalidParams []InvalidParam        `json:"invalidParams,omitempty"`
+}
+
+// InvalidParam names a request parameter that failed validation and why.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+type PaymentAcceptedResponse struct {
+	Message   string                  `json:"message"`
+	Status    constants.PaymentStatus `json:"status"`
+	StatusURL string                  `json:"statusUrl"`
+}
+
+type WebhookEvent struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Data      *PaymentResult `json:"data"`
+}
+
+// PaymentEvent is a transition of a payment through its lifecycle, as
+// published to the event stream. ID is assigned by the stream and orders the
+// events; Status is the status the payment entered. Attempt counts the retries
+// already made, and RetryAt is set on retry_scheduled events.
+type PaymentEvent struct {
+	ID            string                     `json:"id,omitempty"`
+	Type          constants.PaymentEventType `json:"type"`
+	CorrelationID string                     `json:"correlationId"`
+	MerchantID    string                     `json:"merchantId,omitempty"`
+	Status        constants.PaymentStatus    `json:"status"`
+	Amount        float64                    `json:"amount"`
+	Currency      string                     `json:"currency,omitempty"`
+	Priority      constants.PaymentPriority  `json:"priority,omitempty"`
+	Processor     string                     `json:"processor,omitempty"`
+	Attempt       int                        `json:"attempt"`
+	RetryAt       *time.Time                 `json:"retryAt,omitempty"`
+	Reason        string                     `json:"reason,omitempty"`
+	OccurredAt    time.Time                  `json:"occurredAt"`
+}
+
+type WebhookDelivery struct {
+	ID            string                  `json:"id"`
+	CorrelationID string                  `json:"correlationId"`
+	ClientID      string                  `json:"clientId,omitempty"`
+	URL           string                  `json:"url"`
+	Event         string                  `json:"event"`
+	Payload       json.RawMessage         `json:"payload"`
+	Status        constants.WebhookStatus `json:"status"`
+	Attempts      int                     `json:"attempts"`
+	LastError     string                  `json:"lastError,omitempty"`
+	CreatedAt     time.Time               `json:"createdAt"`
+	NextAttemptAt time.Time               `json:"nextAttemptAt"`
+	DeliveredAt   *time.Time              `json:"deliveredAt,omitempty"`
+}
+
+type WebhookAttempt struct {
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+	At         time.Time `json:"at"`
+}
+
+type WebhookDeliveryLog struct {
+	Delivery *WebhookDelivery `json:"delivery"`
+	Attempts []WebhookAttempt `json:"attempts"`
+}
+
+// APIClient is a caller of the payments API. KeyHash is the SHA-256 of its
+// current API key; the key itself is only shown once, when issued.
+// WebhookSecret signs the webhooks of its payments and is likewise only shown
+// when issued.
+type APIClient struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	Scopes        []string   `json:"scopes"`
+	CallbackURL   string     `json:"callbackUrl,omitempty"`
+	MerchantID    string     `json:"merchantId,omitempty"`
+	KeyHash       string     `json:"keyHash,omitempty"`
+	KeyPrefix     string     `json:"keyPrefix"`
+	WebhookSecret string     `json:"webhookSecret,omitempty"`
+	Revoked       bool       `json:"revoked"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	RotatedAt     *time.Time `json:"rotatedAt,omitempty"`
+	RevokedAt     *time.Time `json:"revokedAt,omitempty"`
+}
+
+type APIClientRequest struct {
+	Name        string   `json:"name"`
+	Scopes      []string `json:"scopes"`
+	CallbackURL string   `json:"callbackUrl,omitempty"`
+	MerchantID  string   `json:"merchantId,omitempty"`
+}
+
+type APIKeyResponse struct {
+	Client        *APIClient `json:"client"`
+	APIKey        string     `json:"apiKey"`
+	WebhookSecret string     `json:"webhookSecret,omitempty"`
 }
 
 type HealthResponse struct {
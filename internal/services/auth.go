@@ -0,0 +1,229 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/store"
+	"github.com/mochaeng/payment-gateway/internal/utils"
+)
+
+const (
+	apiKeyPrefix        = "pgw_"
+	webhookSecretPrefix = "whsec_"
+	apiKeyCacheTTL      = 10 * time.Second
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+	ErrClientNotFound = errors.New("api client not found")
+	ErrClientRevoked  = errors.New("api client is revoked")
+	ErrInvalidScope   = errors.New("invalid scope")
+	ErrMissingName    = errors.New("api client name is required")
+	ErrMissingScope   = errors.New("api key lacks the required scope")
+)
+
+var knownScopes = []string{
+	constants.ScopePaymentsRead,
+	constants.ScopePaymentsWrite,
+	constants.ScopeSummaryRead,
+	constants.ScopeEventsRead,
+}
+
+// AuthService manages API clients and authenticates their keys. Keys are only
+// stored as SHA-256 hashes. Authenticated clients are cached for a few seconds,
+// so a revoked key may keep working on an instance for up to apiKeyCacheTTL.
+type AuthService struct {
+	store store.Store
+
+	mu    sync.Mutex
+	cache map[string]cachedClient
+}
+
+type cachedClient struct {
+	client    *models.APIClient
+	expiresAt time.Time
+}
+
+func NewAuthService(store store.Store) *AuthService {
+	return &AuthService{
+		store: store,
+		cache: make(map[string]cachedClient),
+	}
+}
+
+func (a *AuthService) CreateClient(req *models.APIClientRequest) (*models.APIKeyResponse, error) {
+	if req.Name == "" {
+		return nil, ErrMissingName
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(knownScopes, scope) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+
+	client := &models.APIClient{
+		ID:          utils.NewID(),
+		Name:        req.Name,
+		Scopes:      req.Scopes,
+		CallbackURL: req.CallbackURL,
+		MerchantID:  req.MerchantID,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	return a.issueKey(client)
+}
+
+func (a *AuthService) RotateKey(id string) (*models.APIKeyResponse, error) {
+	client, err := a.Client(id)
+	if err != nil {
+		return nil, err
+	}
+	if client.Revoked {
+		return nil, ErrClientRevoked
+	}
+
+	now := time.Now().UTC()
+	client.RotatedAt = &now
+
+	return a.issueKey(client)
+}
+
+func (a *AuthService) RevokeClient(id string) (*models.APIClient, error) {
+	client, err := a.Client(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	oldKeyHash := client.KeyHash
+	client.Revoked = true
+	client.RevokedAt = &now
+	client.KeyHash = ""
+
+	if err := a.store.SaveAPIClient(client); err != nil {
+		return nil, fmt.Errorf("failed to save api client: %w", err)
+	}
+	if err := a.store.ReplaceAPIKey(client.ID, "", oldKeyHash); err != nil {
+		return nil, fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	a.forget(oldKeyHash)
+
+	return client, nil
+}
+
+func (a *AuthService) Client(id string) (*models.APIClient, error) {
+	client, err := a.store.GetAPIClient(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api client: %w", err)
+	}
+	return client, nil
+}
+
+func (a *AuthService) Clients() ([]*models.APIClient, error) {
+	return a.store.ListAPIClients()
+}
+
+// Authenticate returns the client owning the key, checking it holds scope.
+func (a *AuthService) Authenticate(apiKey, scope string) (*models.APIClient, error) {
+	keyHash := hashAPIKey(apiKey)
+
+	client, ok := a.cached(keyHash)
+	if !ok {
+		var err error
+		client, err = a.store.GetAPIClientByKeyHash(keyHash)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to authenticate api key: %w", err)
+		}
+		a.remember(keyHash, client)
+	}
+
+	if client.Revoked || client.KeyHash != keyHash {
+		return nil, ErrInvalidAPIKey
+	}
+	if !hasScope(client.Scopes, scope) {
+		return nil, ErrMissingScope
+	}
+
+	return client, nil
+}
+
+// hasScope reports whether scopes grant scope. Writing payments implies
+// reading them, so clients created before the read scope existed keep
+// reading the status of the payments they submit.
+func hasScope(scopes []string, scope string) bool {
+	return slices.Contains(scopes, scope) ||
+		scope == constants.ScopePaymentsRead && slices.Contains(scopes, constants.ScopePaymentsWrite)
+}
+
+// issueKey gives the client a new API key, along with a webhook secret when it
+// has none yet, as clients created before webhook secrets existed.
+func (a *AuthService) issueKey(client *models.APIClient) (*models.APIKeyResponse, error) {
+	apiKey := apiKeyPrefix + utils.NewID() + utils.NewID()
+	oldKeyHash := client.KeyHash
+
+	var webhookSecret string
+	if client.WebhookSecret == "" {
+		webhookSecret = webhookSecretPrefix + utils.NewID() + utils.NewID()
+		client.WebhookSecret = webhookSecret
+	}
+
+	client.KeyHash = hashAPIKey(apiKey)
+	client.KeyPrefix = apiKey[:len(apiKeyPrefix)+8]
+
+	if err := a.store.SaveAPIClient(client); err != nil {
+		return nil, fmt.Errorf("failed to save api client: %w", err)
+	}
+	if err := a.store.ReplaceAPIKey(client.ID, client.KeyHash, oldKeyHash); err != nil {
+		return nil, fmt.Errorf("failed to save api key: %w", err)
+	}
+	a.forget(oldKeyHash)
+
+	return &models.APIKeyResponse{
+		Client:        client,
+		APIKey:        apiKey,
+		WebhookSecret: webhookSecret,
+	}, nil
+}
+
+func (a *AuthService) cached(keyHash string) (*models.APIClient, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry, ok := a.cache[keyHash]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(a.cache, keyHash)
+		return nil, false
+	}
+	return entry.client, true
+}
+
+func (a *AuthService) remember(keyHash string, client *models.APIClient) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cache[keyHash] = cachedClient{client: client, expiresAt: time.Now().Add(apiKeyCacheTTL)}
+}
+
+func (a *AuthService) forget(keyHash string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.cache, keyHash)
+}
+
+func hashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
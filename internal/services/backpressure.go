@@ -0,0 +1,202 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/store"
+)
+
+const drainRateWindow = 10 * time.Second
+
+// BackpressureError is returned when a payment is refused to protect the
+// queue. It wraps ErrQueueSaturated or ErrQueueFull.
+type BackpressureError struct {
+	Err        error
+	Depth      int64
+	RetryAfter time.Duration
+}
+
+func (e *BackpressureError) Error() string {
+	return fmt.Sprintf("%s (depth %d, retry after %s)", e.Err, e.Depth, e.RetryAfter)
+}
+
+func (e *BackpressureError) Unwrap() error {
+	return e.Err
+}
+
+// QueueGuard admits payments into the shared queue, or into the local queue
+// when set. It refreshes the cluster drain rate and the store memory usage in
+// the background, when the store measures them, so admission only costs the
+// enqueue itself.
+type QueueGuard struct {
+	store  store.Store
+	config *config.Config
+	local  *LocalQueue
+
+	drainMeter  store.DrainMeter
+	memoryMeter store.MemoryMeter
+
+	drainRate   atomic.Uint64
+	memoryUsage atomic.Uint64
+	laneDepths  map[constants.PaymentPriority]*atomic.Int64
+}
+
+func (g *QueueGuard) Start() {
+	g.laneDepths = make(map[constants.PaymentPriority]*atomic.Int64, len(constants.Priorities))
+	for _, priority := range constants.Priorities {
+		g.laneDepths[priority] = &atomic.Int64{}
+	}
+	g.drainMeter, _ = g.store.(store.DrainMeter)
+	g.memoryMeter, _ = g.store.(store.MemoryMeter)
+
+	go g.monitorLoop()
+}
+
+// LaneDepth returns the depth of a queue lane as of the last refresh.
+func (g *QueueGuard) LaneDepth(priority constants.PaymentPriority) int64 {
+	return g.laneDepths[priority].Load()
+}
+
+func (g *QueueGuard) monitorLoop() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if g.drainMeter != nil {
+			if rate, err := g.drainMeter.DrainRate(drainRateWindow); err == nil {
+				g.drainRate.Store(math.Float64bits(rate))
+			} else {
+				fmt.Printf("failed to get queue drain rate: %s\n", err)
+			}
+		}
+
+		if g.memoryMeter != nil {
+			if usage, err := g.memoryMeter.MemoryUsage(); err == nil {
+				g.memoryUsage.Store(math.Float64bits(usage))
+			} else {
+				fmt.Printf("failed to get store memory usage: %s\n", err)
+			}
+		}
+
+		if depths, err := g.store.QueueDepths(); err == nil {
+			for priority, depth := range depths {
+				g.laneDepths[priority].Store(depth)
+			}
+		} else {
+			fmt.Printf("failed to get queue depths: %s\n", err)
+		}
+	}
+}
+
+func (g *QueueGuard) Admit(payment *models.QueuedPayment) error {
+	if g.config.QueueMemoryLimit > 0 &&
+		math.Float64frombits(g.memoryUsage.Load()) >= g.config.QueueMemoryLimit {
+		return g.outOfMemory()
+	}
+
+	enqueue := g.store.EnqueuePaymentWithLimit
+	if g.local != nil {
+		enqueue = g.local.Enqueue
+	}
+
+	admission, depth, err := enqueue(payment, g.config.MaxQueueSize, g.config.QueueSoftLimit, rand.Float64())
+	if errors.Is(err, store.ErrOutOfMemory) {
+		return g.outOfMemory()
+	}
+	if err != nil {
+		return err
+	}
+
+	switch admission {
+	case store.QueueDuplicate:
+		return ErrDuplicatePayment
+	case store.QueueShed:
+		return &BackpressureError{
+			Err:        ErrQueueSaturated,
+			Depth:      depth,
+			RetryAfter: g.retryAfter(depth - int64(g.config.QueueSoftLimit)),
+		}
+	case store.QueueFull:
+		return &BackpressureError{
+			Err:        ErrQueueFull,
+			Depth:      depth,
+			RetryAfter: g.retryAfter(depth - int64(g.config.QueueSoftLimit)),
+		}
+	default:
+		return nil
+	}
+}
+
+// AdmitBatch admits a batch of payments, returning one error per payment.
+// Payments are shed and refused past the limits as Admit does, the whole batch
+// sharing one roll.
+func (g *QueueGuard) AdmitBatch(payments []*models.QueuedPayment) ([]error, error) {
+	errs := make([]error, len(payments))
+
+	outOfMemory := func() ([]error, error) {
+		full := g.outOfMemory()
+		for i := range errs {
+			errs[i] = full
+		}
+		return errs, nil
+	}
+	if g.config.QueueMemoryLimit > 0 &&
+		math.Float64frombits(g.memoryUsage.Load()) >= g.config.QueueMemoryLimit {
+		return outOfMemory()
+	}
+
+	enqueue := g.store.EnqueuePayments
+	if g.local != nil {
+		enqueue = g.local.EnqueueBatch
+	}
+
+	admissions, depth, err := enqueue(payments, g.config.MaxQueueSize, g.config.QueueSoftLimit, rand.Float64())
+	if errors.Is(err, store.ErrOutOfMemory) {
+		return outOfMemory()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	retryAfter := g.retryAfter(depth - int64(g.config.QueueSoftLimit))
+	for i, admission := range admissions {
+		switch admission {
+		case store.QueueDuplicate:
+			errs[i] = ErrDuplicatePayment
+		case store.QueueShed:
+			errs[i] = &BackpressureError{Err: ErrQueueSaturated, Depth: depth, RetryAfter: retryAfter}
+		case store.QueueFull:
+			errs[i] = &BackpressureError{Err: ErrQueueFull, Depth: depth, RetryAfter: retryAfter}
+		}
+	}
+	return errs, nil
+}
+
+// outOfMemory returns the error of payments refused because the store is
+// running out of memory, which takes the longest to recover from.
+func (g *QueueGuard) outOfMemory() error {
+	return &BackpressureError{
+		Err:        ErrQueueFull,
+		RetryAfter: g.config.MaxRetryAfter,
+	}
+}
+
+// retryAfter estimates how long the cluster needs to drain excess payments at
+// the observed rate, clamped between one second and MaxRetryAfter.
+func (g *QueueGuard) retryAfter(excess int64) time.Duration {
+	rate := math.Float64frombits(g.drainRate.Load())
+	if rate <= 0 {
+		return g.config.MaxRetryAfter
+	}
+
+	wait := time.Duration(math.Ceil(float64(excess+1)/rate)) * time.Second
+	return min(max(wait, time.Second), g.config.MaxRetryAfter)
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/store"
+)
+
+// scriptedQueue is a store answering every enqueue with the same admission,
+// depth and error, recording the limits it was given.
+type scriptedQueue struct {
+	store.Store
+
+	admission store.QueueAdmission
+	depth     int64
+	err       error
+
+	hardLimit, softLimit int
+}
+
+func (s *scriptedQueue) EnqueuePaymentWithLimit(payment *models.QueuedPayment, hardLimit, softLimit int, roll float64) (store.QueueAdmission, int64, error) {
+	s.hardLimit, s.softLimit = hardLimit, softLimit
+	return s.admission, s.depth, s.err
+}
+
+func (s *scriptedQueue) EnqueuePayments(payments []*models.QueuedPayment, hardLimit, softLimit int, roll float64) ([]store.QueueAdmission, int64, error) {
+	s.hardLimit, s.softLimit = hardLimit, softLimit
+	admissions := make([]store.QueueAdmission, len(payments))
+	for i := range admissions {
+		admissions[i] = s.admission
+	}
+	return admissions, s.depth, s.err
+}
+
+func (s *scriptedQueue) MarkPaymentsQueued(payments []*models.QueuedPayment) ([]store.QueueAdmission, error) {
+	return make([]store.QueueAdmission, len(payments)), nil
+}
+
+func newTestGuard(queue *scriptedQueue, drainRate float64) *QueueGuard {
+	guard := &QueueGuard{
+		store: queue,
+		config: &config.Config{
+			MaxQueueSize:     100,
+			QueueSoftLimit:   80,
+			QueueMemoryLimit: 0.9,
+			MaxRetryAfter:    30 * time.Second,
+		},
+	}
+	guard.drainRate.Store(math.Float64bits(drainRate))
+	return guard
+}
+
+// TestLocalQueue_ThresholdsAndShedding checks the admission rule the local
+// queue shares with enqueueScript: payments are shed with a probability
+// growing from 0 at the soft limit to 1 at the hard limit, past which the
+// queue is full.
+func TestLocalQueue_ThresholdsAndShedding(t *testing.T) {
+	cases := []struct {
+		depth int64
+		roll  float64
+		want  store.QueueAdmission
+	}{
+		{depth: 0, roll: 0, want: store.QueueAccepted},
+		{depth: 79, roll: 0, want: store.QueueAccepted},
+		{depth: 80, roll: 0, want: store.QueueAccepted},
+		{depth: 90, roll: 0.49, want: store.QueueShed},
+		{depth: 90, roll: 0.5, want: store.QueueAccepted},
+		{depth: 99, roll: 0.9, want: store.QueueShed},
+		{depth: 99, roll: 0.96, want: store.QueueAccepted},
+		{depth: 100, roll: 0.99, want: store.QueueFull},
+		{depth: 150, roll: 0.99, want: store.QueueFull},
+	}
+
+	local, err := NewLocalQueue(&config.Config{QueueLogPath: filepath.Join(t.TempDir(), "queue.log")}, &scriptedQueue{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.log.Close()
+
+	for _, c := range cases {
+		local.depth.Store(c.depth)
+		admission, depth, err := local.Enqueue(&models.QueuedPayment{CorrelationID: "shed"}, 100, 80, c.roll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if admission != c.want {
+			t.Errorf("depth %d, roll %v: got admission %d, want %d", c.depth, c.roll, admission, c.want)
+			continue
+		}
+
+		if admission == store.QueueAccepted {
+			if len(local.take(1)) != 1 {
+				t.Errorf("depth %d, roll %v: accepted payment was not queued", c.depth, c.roll)
+			}
+			continue
+		}
+		if depth != c.depth || local.Depth() != c.depth {
+			t.Errorf("depth %d, roll %v: got depth %d and %d after a refusal", c.depth, c.roll, depth, local.Depth())
+		}
+	}
+}
+
+// TestLocalQueue_PromoteBypassesLimits checks promoted and requeued payments
+// are queued past the hard limit without waiting for room, and served in
+// order.
+func TestLocalQueue_PromoteBypassesLimits(t *testing.T) {
+	local, err := NewLocalQueue(&config.Config{QueueLogPath: filepath.Join(t.TempDir(), "queue.log")}, &scriptedQueue{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.log.Close()
+
+	local.depth.Store(100)
+	err = local.Promote([]*models.QueuedPayment{{CorrelationID: "a"}, {CorrelationID: "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Requeue(&models.QueuedPayment{CorrelationID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if local.Depth() != 103 {
+		t.Fatalf("got depth %d, want 103", local.Depth())
+	}
+
+	var got []string
+	for _, payment := range local.Dequeue(time.Second, 10) {
+		got = append(got, payment.CorrelationID)
+	}
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Fatalf("got payments %v, want a, b and c", got)
+	}
+	if local.Depth() != 100 {
+		t.Fatalf("got depth %d after dequeuing, want 100", local.Depth())
+	}
+}
+
+func TestQueueGuard_AdmitMapsAdmissions(t *testing.T) {
+	cases := []struct {
+		admission  store.QueueAdmission
+		depth      int64
+		memory     float64
+		err        error
+		want       error
+		retryAfter time.Duration
+	}{
+		{admission: store.QueueAccepted, depth: 10},
+		{admission: store.QueueDuplicate, depth: 10, want: ErrDuplicatePayment},
+		{admission: store.QueueShed, depth: 90, want: ErrQueueSaturated, retryAfter: 2 * time.Second},
+		{admission: store.QueueFull, depth: 100, want: ErrQueueFull, retryAfter: 3 * time.Second},
+		{admission: store.QueueAccepted, depth: 10, memory: 0.95, want: ErrQueueFull, retryAfter: 30 * time.Second},
+		{err: fmt.Errorf("failed to enqueue payment: %w", store.ErrOutOfMemory), want: ErrQueueFull, retryAfter: 30 * time.Second},
+	}
+
+	for _, c := range cases {
+		queue := &scriptedQueue{admission: c.admission, depth: c.depth, err: c.err}
+		guard := newTestGuard(queue, 10)
+		guard.memoryUsage.Store(math.Float64bits(c.memory))
+
+		err := guard.Admit(&models.QueuedPayment{CorrelationID: "admit"})
+		if !errors.Is(err, c.want) {
+			t.Errorf("%v at depth %d: got %v, want %v", c.admission, c.depth, err, c.want)
+			continue
+		}
+
+		var backpressure *BackpressureError
+		if errors.As(err, &backpressure) && backpressure.RetryAfter != c.retryAfter {
+			t.Errorf("%v at depth %d: got Retry-After %s, want %s", c.admission, c.depth, backpressure.RetryAfter, c.retryAfter)
+		}
+		if c.memory == 0 && (queue.hardLimit != 100 || queue.softLimit != 80) {
+			t.Errorf("got limits %d and %d, want 100 and 80", queue.hardLimit, queue.softLimit)
+		}
+	}
+}
+
+func TestQueueGuard_AdmitBatchShedsAndRefuses(t *testing.T) {
+	cases := []struct {
+		admission  store.QueueAdmission
+		depth      int64
+		want       error
+		retryAfter time.Duration
+	}{
+		{admission: store.QueueShed, depth: 90, want: ErrQueueSaturated, retryAfter: 2 * time.Second},
+		{admission: store.QueueFull, depth: 100, want: ErrQueueFull, retryAfter: 3 * time.Second},
+	}
+
+	for _, c := range cases {
+		queue := &scriptedQueue{admission: c.admission, depth: c.depth}
+		guard := newTestGuard(queue, 10)
+
+		errs, err := guard.AdmitBatch([]*models.QueuedPayment{{CorrelationID: "a"}, {CorrelationID: "b"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, err := range errs {
+			var backpressure *BackpressureError
+			if !errors.As(err, &backpressure) || !errors.Is(err, c.want) {
+				t.Fatalf("payment %d: got %v, want %v", i, err, c.want)
+			}
+			if backpressure.RetryAfter != c.retryAfter {
+				t.Fatalf("payment %d: got Retry-After %s, want %s", i, backpressure.RetryAfter, c.retryAfter)
+			}
+		}
+		if queue.hardLimit != 100 || queue.softLimit != 80 {
+			t.Fatalf("got limits %d and %d, want 100 and 80", queue.hardLimit, queue.softLimit)
+		}
+	}
+}
+
+func TestQueueGuard_RetryAfter(t *testing.T) {
+	cases := []struct {
+		rate   float64
+		excess int64
+		want   time.Duration
+	}{
+		// without a measured drain rate clients wait the longest
+		{rate: 0, excess: 10, want: 30 * time.Second},
+		{rate: 10, excess: 0, want: time.Second},
+		{rate: 10, excess: -5, want: time.Second},
+		{rate: 10, excess: 49, want: 5 * time.Second},
+		{rate: 2.5, excess: 9, want: 4 * time.Second},
+		{rate: 2, excess: 100, want: 30 * time.Second},
+	}
+
+	for _, c := range cases {
+		guard := newTestGuard(&scriptedQueue{}, c.rate)
+		if got := guard.retryAfter(c.excess); got != c.want {
+			t.Errorf("retryAfter(%d) at %v/s = %s, want %s", c.excess, c.rate, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ErrForbiddenCallback is returned for callback URLs pointing inside the
+// network of the gateway, which webhooks must not be used to reach.
+var ErrForbiddenCallback = errors.New("callback address is not allowed")
+
+// forbiddenPrefixes are the ranges, beyond the loopback, private, link-local
+// and multicast ones net.IP reports, that are not reachable on the internet:
+// "this network", carrier-grade NAT, IPv4 documentation and benchmarking
+// ranges, and the IPv6 documentation range.
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// isPublicIP reports whether ip is an address callbacks may reach.
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckCallbackHost refuses callback hosts known to be internal without
+// resolving them: localhost and IP literals outside the public ranges. Names
+// resolving to internal addresses are refused when dialed instead. Hosts in
+// allowed are always accepted.
+func CheckCallbackHost(host string, allowed []string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if slices.Contains(allowed, host) {
+		return nil
+	}
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrForbiddenCallback, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenCallback, host)
+	}
+	return nil
+}
+
+// callbackDialer dials callback hosts, refusing those resolving to an address
+// callbacks may not reach. It connects to the address it checked, so a host
+// cannot resolve to another one in between.
+type callbackDialer struct {
+	timeout time.Duration
+	allowed []string
+}
+
+func (d *callbackDialer) dial(addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckCallbackHost(host, d.allowed); err != nil {
+		return nil, err
+	}
+	if slices.Contains(d.allowed, strings.ToLower(host)) {
+		return fasthttp.DialTimeout(addr, d.timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return nil, fmt.Errorf("%w: %s resolves to %s", ErrForbiddenCallback, host, ip)
+		}
+	}
+
+	return fasthttp.DialTimeout(net.JoinHostPort(ips[0].String(), port), d.timeout)
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckCallbackHost(t *testing.T) {
+	cases := []struct {
+		host    string
+		allowed []string
+		ok      bool
+	}{
+		{host: "example.com", ok: true},
+		{host: "93.184.216.34", ok: true},
+		{host: "2606:2800:220:1:248:1893:25c8:1946", ok: true},
+		{host: "localhost"},
+		{host: "api.localhost."},
+		{host: "127.0.0.1"},
+		{host: "10.1.2.3"},
+		{host: "172.16.0.1"},
+		{host: "192.168.1.1"},
+		{host: "169.254.169.254"},
+		{host: "100.64.0.1"},
+		{host: "0.0.0.0"},
+		{host: "::1"},
+		{host: "fe80::1"},
+		{host: "fd00::1"},
+		{host: "::ffff:127.0.0.1"},
+		{host: "127.0.0.1", allowed: []string{"127.0.0.1"}, ok: true},
+		{host: "LocalHost", allowed: []string{"localhost"}, ok: true},
+	}
+
+	for _, c := range cases {
+		err := CheckCallbackHost(c.host, c.allowed)
+		if c.ok && err != nil {
+			t.Errorf("CheckCallbackHost(%q) = %v, want no error", c.host, err)
+		}
+		if !c.ok && !errors.Is(err, ErrForbiddenCallback) {
+			t.Errorf("CheckCallbackHost(%q) = %v, want ErrForbiddenCallback", c.host, err)
+		}
+	}
+}
+
+func TestCallbackDialer_RefusesInternalAddresses(t *testing.T) {
+	dialer := &callbackDialer{timeout: time.Second}
+
+	for _, addr := range []string{"127.0.0.1:80", "localhost:8080", "[::1]:443"} {
+		if _, err := dialer.dial(addr); !errors.Is(err, ErrForbiddenCallback) {
+			t.Errorf("dial(%q) = %v, want ErrForbiddenCallback", addr, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"net"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/valyala/fasthttp"
+)
+
+// processorClients holds one HTTP client per processor so each one gets its
+// own connect and request timeouts.
+type processorClients map[constants.PaymentMode]*fasthttp.Client
+
+func newProcessorClients(cfg *config.Config) processorClients {
+	clients := make(processorClients, len(cfg.Processors))
+
+	for _, processor := range cfg.Processors {
+		connectTimeout := cfg.ConnectTimeoutOf(processor)
+		requestTimeout := cfg.RequestTimeoutOf(processor)
+
+		clients[processor.Name] = &fasthttp.Client{
+			Dial: func(addr string) (net.Conn, error) {
+				return fasthttp.DialTimeout(addr, connectTimeout)
+			},
+			ReadTimeout:  requestTimeout,
+			WriteTimeout: requestTimeout,
+		}
+	}
+
+	return clients
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+)
+
+// ProcessorError is a processor answer with an error status code.
+type ProcessorError struct {
+	Processor  constants.PaymentMode
+	StatusCode int
+	Class      constants.ErrorClass
+}
+
+func (e *ProcessorError) Error() string {
+	return fmt.Sprintf("processor %s answered with status code [%d] (%s)", e.Processor, e.StatusCode, e.Class)
+}
+
+// Classify returns the class of an error returned while processing a
+// payment. Anything that is not a classified processor answer, such as a
+// transport failure, is retryable.
+func Classify(err error) constants.ErrorClass {
+	var processorErr *ProcessorError
+	if errors.As(err, &processorErr) {
+		return processorErr.Class
+	}
+	return constants.ClassRetryable
+}
+
+// classifyStatus maps a processor status code to an error class. The
+// processor's own mapping wins over the global one, which wins over the
+// defaults: 408, 425, 429 and 5xx are retryable, 409 and 422 (duplicate
+// correlationId) mean already processed, and other 4xx are permanent.
+func classifyStatus(cfg *config.Config, processor *config.ProcessorConfig, statusCode int) constants.ErrorClass {
+	for _, classes := range []map[int]constants.ErrorClass{processor.StatusClasses, cfg.StatusClasses} {
+		if class, ok := classes[statusCode]; ok {
+			return class
+		}
+	}
+
+	switch {
+	case statusCode == 408 || statusCode == 425 || statusCode == 429 || statusCode >= 500:
+		return constants.ClassRetryable
+	case statusCode == 409 || statusCode == 422:
+		return constants.ClassAlreadyProcessed
+	default:
+		return constants.ClassPermanent
+	}
+}
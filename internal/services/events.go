@@ -0,0 +1,280 @@
+package services
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/store"
+)
+
+const (
+	// eventReadCount caps the events read from the stream at once.
+	eventReadCount = 256
+	// eventTailBlock bounds a wait of the tail for new events.
+	eventTailBlock = 5 * time.Second
+	// eventSubscriberBuffer is the number of events a subscriber may lag
+	// behind the tail before it is dropped.
+	eventSubscriberBuffer = 1024
+	// eventPublishBuffer is the number of events waiting to be appended to
+	// the stream past which new events are dropped.
+	eventPublishBuffer = 4096
+)
+
+// EventStream publishes payment lifecycle events to the stream of the store
+// and fans the stream out to the local subscribers of GET /events. A single
+// tail per instance reads the stream, so subscribers do not each hold a store
+// connection. Events are appended in the background, so publishing never
+// waits for the store.
+type EventStream struct {
+	config *config.Config
+	store  store.Store
+
+	pending chan *models.PaymentEvent
+	dropped atomic.Int64
+
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+}
+
+type eventSubscriber struct {
+	events chan *models.PaymentEvent
+}
+
+// EventFilter selects events. Empty fields match every event.
+type EventFilter struct {
+	Types         []constants.PaymentEventType
+	MerchantID    string
+	CorrelationID string
+	Processor     string
+}
+
+func (f EventFilter) Match(event *models.PaymentEvent) bool {
+	return (len(f.Types) == 0 || slices.Contains(f.Types, event.Type)) &&
+		(f.MerchantID == "" || f.MerchantID == event.MerchantID) &&
+		(f.CorrelationID == "" || f.CorrelationID == event.CorrelationID) &&
+		(f.Processor == "" || f.Processor == event.Processor)
+}
+
+// Start appends published events in the background and follows the stream
+// from its current end. Should the store be unreachable, the tail starts from
+// wherever the end is once it is back.
+func (e *EventStream) Start() {
+	if !e.config.Events.Enabled {
+		return
+	}
+	go e.send()
+
+	last, err := e.store.LastEventID()
+	if err != nil {
+		fmt.Printf("Failed to get last payment event: %s\n", err)
+	}
+	go e.tail(last)
+}
+
+// Publish hands the events to the background sender. Events are a side
+// channel that must not hold payments up, so they are dropped when the sender
+// falls behind and failures are only logged.
+func (e *EventStream) Publish(events ...*models.PaymentEvent) {
+	if !e.config.Events.Enabled {
+		return
+	}
+
+	for _, event := range events {
+		select {
+		case e.pending <- event:
+		default:
+			e.dropped.Add(1)
+		}
+	}
+}
+
+// send appends the published events to the stream, taking every event
+// waiting at once so a busy stream costs one round trip per batch.
+func (e *EventStream) send() {
+	batch := make([]*models.PaymentEvent, 0, eventReadCount)
+	for event := range e.pending {
+		batch = append(batch[:0], event)
+	fill:
+		for len(batch) < eventReadCount {
+			select {
+			case event := <-e.pending:
+				batch = append(batch, event)
+			default:
+				break fill
+			}
+		}
+
+		if dropped := e.dropped.Swap(0); dropped > 0 {
+			fmt.Printf("Dropped %d payment events, the publish buffer was full\n", dropped)
+		}
+		if err := e.store.AppendEvents(batch, e.config.Events.MaxLen); err != nil {
+			fmt.Printf("Failed to publish %d payment events: %s\n", len(batch), err)
+		}
+	}
+}
+
+// paymentEvent returns the event of a payment entering the given status.
+func paymentEvent(eventType constants.PaymentEventType, payment *models.QueuedPayment, status constants.PaymentStatus) *models.PaymentEvent {
+	return &models.PaymentEvent{
+		Type:          eventType,
+		CorrelationID: payment.CorrelationID,
+		MerchantID:    payment.MerchantID,
+		Status:        status,
+		Amount:        payment.Amount,
+		Currency:      payment.Currency,
+		Priority:      payment.Priority,
+		Attempt:       payment.RetryCount,
+		OccurredAt:    time.Now().UTC(),
+	}
+}
+
+// tail follows the stream after the event last, handing every event to the
+// subscribers.
+func (e *EventStream) tail(last string) {
+	for {
+		if last == "" {
+			id, err := e.store.LastEventID()
+			if err != nil {
+				fmt.Printf("Failed to get last payment event: %s\n", err)
+				time.Sleep(time.Second)
+				continue
+			}
+			last = id
+		}
+
+		events, err := e.store.ReadEvents(last, eventReadCount, eventTailBlock)
+		if err != nil {
+			fmt.Printf("Failed to read payment events: %s\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, event := range events {
+			e.broadcast(event)
+			last = event.ID
+		}
+	}
+}
+
+// broadcast hands an event to every subscriber. A subscriber too far behind
+// is dropped, its stream ending so the client resumes from its last event.
+func (e *EventStream) broadcast(event *models.PaymentEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for subscriber := range e.subscribers {
+		select {
+		case subscriber.events <- event:
+		default:
+			delete(e.subscribers, subscriber)
+			close(subscriber.events)
+		}
+	}
+}
+
+// Subscribe streams the events matching filter, starting after the event with
+// the given ID, or with the next event published when after is empty. The
+// channel is closed when the subscriber falls behind; the returned cancel
+// function must be called once the caller stops reading.
+func (e *EventStream) Subscribe(filter EventFilter, after string) (<-chan *models.PaymentEvent, func(), error) {
+	// Subscribing before replaying ensures no event falls between the two.
+	subscriber := &eventSubscriber{events: make(chan *models.PaymentEvent, eventSubscriberBuffer)}
+	e.mu.Lock()
+	e.subscribers[subscriber] = struct{}{}
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			if _, ok := e.subscribers[subscriber]; ok {
+				delete(e.subscribers, subscriber)
+				close(subscriber.events)
+			}
+		})
+	}
+
+	var replay []*models.PaymentEvent
+	if after != "" {
+		var err error
+		if replay, err = e.store.ReadEvents(after, eventReadCount, 0); err != nil {
+			cancel()
+			return nil, nil, err
+		}
+	}
+
+	events := make(chan *models.PaymentEvent)
+	go func() {
+		defer close(events)
+
+		send := func(event *models.PaymentEvent) bool {
+			if !filter.Match(event) {
+				return true
+			}
+			select {
+			case events <- event:
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		last := after
+		for len(replay) > 0 {
+			for _, event := range replay {
+				if !send(event) {
+					return
+				}
+				last = event.ID
+			}
+			if len(replay) < eventReadCount {
+				break
+			}
+
+			var err error
+			if replay, err = e.store.ReadEvents(last, eventReadCount, 0); err != nil {
+				fmt.Printf("Failed to replay payment events: %s\n", err)
+				return
+			}
+		}
+
+		for event := range subscriber.events {
+			if last != "" && compareEventIDs(event.ID, last) <= 0 {
+				continue
+			}
+			if !send(event) {
+				return
+			}
+		}
+	}()
+
+	return events, cancel, nil
+}
+
+// compareEventIDs orders two event IDs, which are integers or Redis stream
+// IDs, milliseconds and a sequence number joined by a dash.
+func compareEventIDs(a, b string) int {
+	aMs, aSeq := splitEventID(a)
+	bMs, bSeq := splitEventID(b)
+	return cmp.Or(cmp.Compare(aMs, bMs), cmp.Compare(aSeq, bSeq))
+}
+
+func splitEventID(id string) (uint64, uint64) {
+	first, second, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseUint(first, 10, 64)
+	seq, _ := strconv.ParseUint(second, 10, 64)
+	return ms, seq
+}
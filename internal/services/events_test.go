@@ -0,0 +1,160 @@
+package services
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/store"
+)
+
+// memoryEvents is a store holding only an event stream, numbered from 1.
+type memoryEvents struct {
+	store.Store
+
+	mu     sync.Mutex
+	events []*models.PaymentEvent
+}
+
+func (m *memoryEvents) AppendEvents(events []*models.PaymentEvent, maxLen int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, event := range events {
+		stored := *event
+		stored.ID = strconv.Itoa(len(m.events) + 1)
+		m.events = append(m.events, &stored)
+	}
+	return nil
+}
+
+func (m *memoryEvents) ReadEvents(after string, count int, block time.Duration) ([]*models.PaymentEvent, error) {
+	id, err := strconv.Atoi(after)
+	if err != nil {
+		return nil, store.ErrInvalidEventID
+	}
+
+	deadline := time.Now().Add(block)
+	for {
+		m.mu.Lock()
+		events := m.events[min(id, len(m.events)):]
+		m.mu.Unlock()
+
+		if len(events) > 0 || !time.Now().Before(deadline) {
+			return events[:min(count, len(events))], nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (m *memoryEvents) LastEventID() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return strconv.Itoa(len(m.events)), nil
+}
+
+func newTestEventStream() *EventStream {
+	return &EventStream{
+		config:      &config.Config{Events: config.EventsConfig{Enabled: true, MaxLen: 100}},
+		store:       &memoryEvents{},
+		pending:     make(chan *models.PaymentEvent, eventPublishBuffer),
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+}
+
+func merchantEvent(eventType constants.PaymentEventType, merchantID string) *models.PaymentEvent {
+	return &models.PaymentEvent{Type: eventType, CorrelationID: merchantID + "-payment", MerchantID: merchantID}
+}
+
+func receiveEvent(t *testing.T, events <-chan *models.PaymentEvent) *models.PaymentEvent {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an event")
+		return nil
+	}
+}
+
+func TestEventStream_ResumesAfterLastEventID(t *testing.T) {
+	stream := newTestEventStream()
+	stream.Publish(
+		merchantEvent(constants.EventPaymentAccepted, "a"),
+		merchantEvent(constants.EventPaymentAccepted, "b"),
+		merchantEvent(constants.EventPaymentDispatched, "a"),
+	)
+	stream.Start()
+
+	events, cancel, err := stream.Subscribe(EventFilter{MerchantID: "a"}, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if event := receiveEvent(t, events); event.ID != "3" || event.Type != constants.EventPaymentDispatched {
+		t.Fatalf("got event %s %s, want the missed event 3", event.ID, event.Type)
+	}
+
+	stream.Publish(
+		merchantEvent(constants.EventPaymentSucceeded, "b"),
+		merchantEvent(constants.EventPaymentSucceeded, "a"),
+	)
+	if event := receiveEvent(t, events); event.ID != "5" {
+		t.Fatalf("got event %s, want the live event 5", event.ID)
+	}
+
+	select {
+	case event := <-events:
+		t.Fatalf("got unexpected event %s", event.ID)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestEventStream_PublishDropsEventsWhenBehind(t *testing.T) {
+	stream := newTestEventStream()
+	stream.pending = make(chan *models.PaymentEvent, 1)
+
+	stream.Publish(
+		merchantEvent(constants.EventPaymentAccepted, "a"),
+		merchantEvent(constants.EventPaymentAccepted, "b"),
+		merchantEvent(constants.EventPaymentAccepted, "c"),
+	)
+	if len(stream.pending) != 1 || stream.dropped.Load() != 2 {
+		t.Fatalf("got %d pending and %d dropped events, want 1 and 2", len(stream.pending), stream.dropped.Load())
+	}
+}
+
+func TestEventStream_RejectsInvalidLastEventID(t *testing.T) {
+	stream := newTestEventStream()
+
+	if _, _, err := stream.Subscribe(EventFilter{}, "not-an-id"); err != store.ErrInvalidEventID {
+		t.Fatalf("got %v, want ErrInvalidEventID", err)
+	}
+	if len(stream.subscribers) != 0 {
+		t.Fatal("expected the failed subscriber to be removed")
+	}
+}
+
+func TestCompareEventIDs(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"9", "10", -1},
+		{"1700000000000-1", "1700000000000-0", 1},
+		{"1700000000000-0", "1700000000000", 0},
+		{"1699999999999-5", "1700000000000-0", -1},
+	}
+
+	for _, c := range cases {
+		if got := compareEventIDs(c.a, c.b); got != c.want {
+			t.Errorf("compareEventIDs(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
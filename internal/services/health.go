@@ -13,10 +13,10 @@ import (
 )
 
 type HealthMonitorService struct {
-	store       *store.RedisStore
+	store       store.Store
 	config      *config.Config
 	lastChecked time.Time
-	httpClient  *fasthttp.Client
+	httpClients processorClients
 }
 
 func (m *HealthMonitorService) Start() {
@@ -31,16 +31,45 @@ func (m *HealthMonitorService) monitorLoop() {
 		if time.Since(m.lastChecked) > m.config.HealthCheckInterval {
 			fmt.Println("Cheking all health systems")
 
-			m.checkProcessor(constants.DefaultProcessorKey)
-			m.checkProcessor(constants.FallbackProcessorKey)
+			forced := m.forcedProcessors()
+			for _, processor := range m.config.Processors {
+				if !forced[processor.Name] {
+					m.checkProcessor(processor)
+				}
+			}
 
 			m.lastChecked = time.Now()
 		}
 	}
 }
 
-func (m *HealthMonitorService) checkProcessor(processor constants.PaymentMode) error {
-	url := m.config.Urls[processor].HealthURL
+// forcedProcessors returns the processors whose health is forced by an
+// operator, which are not checked.
+func (m *HealthMonitorService) forcedProcessors() map[constants.PaymentMode]bool {
+	names := make([]constants.PaymentMode, len(m.config.Processors))
+	for i, processor := range m.config.Processors {
+		names[i] = processor.Name
+	}
+
+	healths, err := m.store.GetProcessorsHealth(names)
+	if err != nil {
+		fmt.Printf("failed to get processors health: %s\n", err)
+		return nil
+	}
+
+	now := time.Now()
+	forced := make(map[constants.PaymentMode]bool)
+	for name, health := range healths {
+		if health.IsForced(now) {
+			forced[name] = true
+		}
+	}
+	return forced
+}
+
+func (m *HealthMonitorService) checkProcessor(processorConfig *config.ProcessorConfig) error {
+	processor := processorConfig.Name
+	url := processorConfig.HealthURL
 
 	// fmt.Println("Healthy url: ", url)
 
@@ -52,10 +81,7 @@ func (m *HealthMonitorService) checkProcessor(processor constants.PaymentMode) e
 	req.SetRequestURI(url)
 	req.Header.SetMethod("GET")
 
-	// ctx, cancel := context.WithTimeout(context.Background(), monitor.config.RequestTimeout)
-	// defer cancel()
-
-	err := m.httpClient.Do(req, resp)
+	err := m.httpClients[processor].DoTimeout(req, resp, m.config.HealthTimeoutOf(processorConfig))
 	if err != nil {
 		m.store.SetProcessorHealth(processor, models.ProcessorHealth{
 			Failing:     true,
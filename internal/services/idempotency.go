@@ -0,0 +1,39 @@
+package services
+
+import (
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/store"
+)
+
+// IdempotencyService records responses by Idempotency-Key so a retried
+// request gets the original response instead of being applied twice.
+type IdempotencyService struct {
+	store store.Store
+	ttl   time.Duration
+}
+
+func NewIdempotencyService(store store.Store, ttl time.Duration) *IdempotencyService {
+	return &IdempotencyService{
+		store: store,
+		ttl:   ttl,
+	}
+}
+
+// Claim reserves key for a request identified by requestHash. It returns nil
+// when the key is new, and otherwise the response recorded for it.
+func (s *IdempotencyService) Claim(key, requestHash string) (*store.IdempotentResponse, error) {
+	return s.store.ClaimIdempotencyKey(key, requestHash, s.ttl)
+}
+
+func (s *IdempotencyService) Save(key, requestHash string, statusCode int, body []byte) error {
+	return s.store.SaveIdempotentResponse(key, &store.IdempotentResponse{
+		RequestHash: requestHash,
+		StatusCode:  statusCode,
+		Body:        body,
+	}, s.ttl)
+}
+
+func (s *IdempotencyService) Release(key string) error {
+	return s.store.ReleaseIdempotencyKey(key)
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ConcurrencyLimiter bounds the number of in-flight requests to a processor.
+// The limit grows additively while latency stays within Tolerance times the
+// minimum observed latency and shrinks multiplicatively when latency exceeds
+// it or a request is dropped, keeping the processor's own queue short.
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	released chan struct{}
+
+	minLimit  float64
+	maxLimit  float64
+	tolerance float64
+	backoff   float64
+
+	minRTT       time.Duration
+	windowMinRTT time.Duration
+	windowStart  time.Time
+	rttWindow    time.Duration
+}
+
+type LimiterOptions struct {
+	Initial   int
+	Min       int
+	Max       int
+	Tolerance float64
+	Backoff   float64
+	RTTWindow time.Duration
+}
+
+// NewConcurrencyLimiter builds a limiter, replacing unset or invalid options
+// with a tolerance of 2, a backoff of 0.9 and a 30s latency window.
+func NewConcurrencyLimiter(opts LimiterOptions) *ConcurrencyLimiter {
+	if opts.Tolerance <= 1 {
+		opts.Tolerance = 2
+	}
+	if opts.Backoff <= 0 || opts.Backoff >= 1 {
+		opts.Backoff = 0.9
+	}
+	if opts.RTTWindow <= 0 {
+		opts.RTTWindow = 30 * time.Second
+	}
+
+	minLimit := max(opts.Min, 1)
+	maxLimit := max(opts.Max, opts.Initial, minLimit)
+
+	return &ConcurrencyLimiter{
+		limit:       float64(min(max(opts.Initial, minLimit), maxLimit)),
+		released:    make(chan struct{}),
+		minLimit:    float64(minLimit),
+		maxLimit:    float64(maxLimit),
+		tolerance:   opts.Tolerance,
+		backoff:     opts.Backoff,
+		rttWindow:   opts.RTTWindow,
+		windowStart: time.Now(),
+	}
+}
+
+// TryAcquire takes a slot without waiting, reporting whether one was free.
+func (l *ConcurrencyLimiter) TryAcquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inflight >= int(l.limit) {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// Acquire waits for a slot until the deadline, reporting whether one was
+// taken. A zero deadline waits forever.
+func (l *ConcurrencyLimiter) Acquire(deadline time.Time) bool {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		l.mu.Lock()
+		if l.inflight < int(l.limit) {
+			l.inflight++
+			l.mu.Unlock()
+			return true
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// Release frees a slot and adjusts the limit from the observed latency.
+// dropped marks requests that timed out or were rejected for overload.
+func (l *ConcurrencyLimiter) Release(latency time.Duration, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight--
+	close(l.released)
+	l.released = make(chan struct{})
+
+	if !dropped {
+		l.observeRTT(latency)
+	}
+
+	switch {
+	case dropped || latency > time.Duration(float64(l.minRTT)*l.tolerance):
+		l.limit = math.Max(l.minLimit, l.limit*l.backoff)
+	case float64(l.inflight+1) >= l.limit/2:
+		l.limit = math.Min(l.maxLimit, l.limit+1/l.limit)
+	}
+}
+
+// observeRTT tracks the minimum latency over a sliding window so a processor
+// that became permanently slower gets a new baseline instead of being starved.
+func (l *ConcurrencyLimiter) observeRTT(latency time.Duration) {
+	if l.windowMinRTT == 0 || latency < l.windowMinRTT {
+		l.windowMinRTT = latency
+	}
+	if l.minRTT == 0 || latency < l.minRTT {
+		l.minRTT = latency
+	}
+
+	if time.Since(l.windowStart) >= l.rttWindow {
+		l.minRTT = l.windowMinRTT
+		l.windowMinRTT = 0
+		l.windowStart = time.Now()
+	}
+}
+
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+func (l *ConcurrencyLimiter) Inflight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
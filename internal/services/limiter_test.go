@@ -0,0 +1,51 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter_ShrinksOnDropsAndGrowsBack(t *testing.T) {
+	limiter := NewConcurrencyLimiter(LimiterOptions{Initial: 4, Min: 1, Max: 8})
+
+	for range 4 {
+		if !limiter.TryAcquire() {
+			t.Fatal("expected a free slot below the initial limit")
+		}
+	}
+	if limiter.TryAcquire() {
+		t.Fatal("expected the limiter to be saturated")
+	}
+
+	for range 4 {
+		limiter.Release(0, true)
+	}
+	if got := limiter.Limit(); got >= 4 {
+		t.Fatalf("expected the limit to shrink after drops, got %d", got)
+	}
+
+	for range 200 {
+		if limiter.TryAcquire() {
+			limiter.Release(time.Millisecond, false)
+		}
+	}
+	if got := limiter.Limit(); got < 2 {
+		t.Fatalf("expected the limit to grow back with fast responses, got %d", got)
+	}
+}
+
+func TestConcurrencyLimiter_AcquireHonoursDeadline(t *testing.T) {
+	limiter := NewConcurrencyLimiter(LimiterOptions{Initial: 1, Min: 1, Max: 1})
+
+	if !limiter.TryAcquire() {
+		t.Fatal("expected a free slot")
+	}
+	if limiter.Acquire(time.Now().Add(20 * time.Millisecond)) {
+		t.Fatal("expected Acquire to give up at the deadline")
+	}
+
+	go limiter.Release(time.Millisecond, false)
+	if !limiter.Acquire(time.Now().Add(time.Second)) {
+		t.Fatal("expected Acquire to get the released slot")
+	}
+}
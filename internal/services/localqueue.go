@@ -0,0 +1,258 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/store"
+)
+
+// LocalQueue is the queue of the local queue mode. Admitted payments wait in
+// memory and are written to a write-ahead log before being acknowledged, so
+// payments accepted but not yet processed are replayed after a crash. Status
+// records and summaries still live in the store.
+//
+// The queue is not shared, so it only suits single-instance deployments, and
+// it serves payments in arrival order whatever their lane. It is unbounded:
+// admission enforces the limits, while promotions, retries and replays never
+// wait for room.
+type LocalQueue struct {
+	store store.Store
+	log   *store.PaymentLog
+
+	mu       sync.Mutex
+	payments []*models.QueuedPayment
+	// ready wakes a Dequeue waiting for payments.
+	ready chan struct{}
+
+	// depth counts the queued payments, slots being reserved before a
+	// payment is admitted so the limits hold under concurrent admissions.
+	depth atomic.Int64
+}
+
+// NewLocalQueue opens the write-ahead log and replays the payments it holds
+// in the background.
+func NewLocalQueue(config *config.Config, paymentStore store.Store) (*LocalQueue, error) {
+	log, pending, err := store.OpenPaymentLog(config.QueueLogPath)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &LocalQueue{
+		store: paymentStore,
+		log:   log,
+		ready: make(chan struct{}, 1),
+	}
+	if len(pending) > 0 {
+		fmt.Printf("Replaying %d payments from the queue log\n", len(pending))
+		go q.replay(pending)
+	}
+	return q, nil
+}
+
+// replay queues the payments left in the log by the previous run. Those that
+// were being processed when it stopped are marked queued again so workers
+// claim them; those that completed are skipped by the workers.
+func (q *LocalQueue) replay(payments []*models.QueuedPayment) {
+	for _, payment := range payments {
+		_, _, err := q.store.TransitionPayment(payment.Key(), constants.PaymentQueued, constants.PaymentProcessing)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			fmt.Printf("Failed to reset status of replayed payment [%s]: %s\n", payment.CorrelationID, err)
+		}
+		q.push(payment)
+	}
+}
+
+// Depth returns the number of queued payments.
+func (q *LocalQueue) Depth() int64 {
+	return q.depth.Load()
+}
+
+// Enqueue admits a payment like RedisStore.EnqueuePaymentWithLimit, shedding
+// it with a growing probability between the soft and the hard limit.
+func (q *LocalQueue) Enqueue(payment *models.QueuedPayment, hardLimit, softLimit int, roll float64) (store.QueueAdmission, int64, error) {
+	depth := q.depth.Add(1) - 1
+	hard, soft := int64(hardLimit), int64(softLimit)
+
+	if depth >= hard {
+		q.depth.Add(-1)
+		return store.QueueFull, depth, nil
+	}
+	if depth >= soft && hard > soft && roll < float64(depth-soft)/float64(hard-soft) {
+		q.depth.Add(-1)
+		return store.QueueShed, depth, nil
+	}
+
+	admissions, err := q.admit([]*models.QueuedPayment{payment})
+	if err != nil {
+		return store.QueueFull, depth, err
+	}
+	return admissions[0], depth + 1, nil
+}
+
+// EnqueueBatch admits a batch of payments like RedisStore.EnqueuePayments,
+// shedding or refusing them in turn as Enqueue does.
+func (q *LocalQueue) EnqueueBatch(payments []*models.QueuedPayment, hardLimit, softLimit int, roll float64) ([]store.QueueAdmission, int64, error) {
+	admissions := make([]store.QueueAdmission, len(payments))
+	reserved := make([]*models.QueuedPayment, 0, len(payments))
+	indexes := make([]int, 0, len(payments))
+	hard, soft := int64(hardLimit), int64(softLimit)
+
+	for i, payment := range payments {
+		depth := q.depth.Add(1) - 1
+		if depth >= hard {
+			q.depth.Add(-1)
+			admissions[i] = store.QueueFull
+			continue
+		}
+		if depth >= soft && hard > soft && roll < float64(depth-soft)/float64(hard-soft) {
+			q.depth.Add(-1)
+			admissions[i] = store.QueueShed
+			continue
+		}
+		reserved = append(reserved, payment)
+		indexes = append(indexes, i)
+	}
+	if len(reserved) == 0 {
+		return admissions, q.depth.Load(), nil
+	}
+
+	admitted, err := q.admit(reserved)
+	if err != nil {
+		return nil, 0, err
+	}
+	for j, admission := range admitted {
+		admissions[indexes[j]] = admission
+	}
+	return admissions, q.depth.Load(), nil
+}
+
+// admit records the queued status of payments whose slot is reserved, logs
+// and queues those that are not duplicates, and releases the other slots.
+func (q *LocalQueue) admit(payments []*models.QueuedPayment) ([]store.QueueAdmission, error) {
+	admissions, err := q.store.MarkPaymentsQueued(payments)
+	if err != nil {
+		q.depth.Add(-int64(len(payments)))
+		return nil, err
+	}
+
+	accepted := make([]*models.QueuedPayment, 0, len(payments))
+	for i, payment := range payments {
+		if admissions[i] == store.QueueAccepted {
+			accepted = append(accepted, payment)
+		}
+	}
+	q.depth.Add(-int64(len(payments) - len(accepted)))
+	if len(accepted) == 0 {
+		return admissions, nil
+	}
+
+	if err := q.log.Append(accepted...); err != nil {
+		q.depth.Add(-int64(len(accepted)))
+		for _, payment := range accepted {
+			_, _, err := q.store.TransitionPayment(payment.Key(), constants.PaymentFailed, constants.PaymentQueued)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				fmt.Printf("Failed to release status of payment [%s]: %s\n", payment.CorrelationID, err)
+			}
+		}
+		return nil, err
+	}
+
+	q.append(accepted...)
+	return admissions, nil
+}
+
+// Promote logs and queues scheduled payments that became due. Like in Redis,
+// promotion bypasses the queue limits.
+func (q *LocalQueue) Promote(payments []*models.QueuedPayment) error {
+	if len(payments) == 0 {
+		return nil
+	}
+	if err := q.log.Append(payments...); err != nil {
+		return err
+	}
+	for _, payment := range payments {
+		q.push(payment)
+	}
+	return nil
+}
+
+// Requeue queues a payment again for a retry, logging its new retry count.
+func (q *LocalQueue) Requeue(payment *models.QueuedPayment) error {
+	if err := q.log.Append(payment); err != nil {
+		return err
+	}
+	q.push(payment)
+	return nil
+}
+
+// Done removes a payment that will not be retried from the log.
+func (q *LocalQueue) Done(payment *models.QueuedPayment) {
+	if err := q.log.Complete(payment.Key()); err != nil {
+		fmt.Printf("Failed to log completion of payment [%s]: %s\n", payment.CorrelationID, err)
+	}
+}
+
+func (q *LocalQueue) push(payment *models.QueuedPayment) {
+	q.depth.Add(1)
+	q.append(payment)
+}
+
+// append queues payments whose slot is already counted in depth.
+func (q *LocalQueue) append(payments ...*models.QueuedPayment) {
+	q.mu.Lock()
+	q.payments = append(q.payments, payments...)
+	q.mu.Unlock()
+	q.wake()
+}
+
+// wake signals ready unless a signal is already pending.
+func (q *LocalQueue) wake() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Dequeue takes up to count payments, waiting up to timeout for the first one.
+// It returns no payments when none arrived in time.
+func (q *LocalQueue) Dequeue(timeout time.Duration, count int) []*models.QueuedPayment {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		if payments := q.take(count); len(payments) > 0 {
+			return payments
+		}
+		select {
+		case <-q.ready:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// take removes up to count payments from the head of the queue, passing the
+// wake-up on to another Dequeue when payments are left.
+func (q *LocalQueue) take(count int) []*models.QueuedPayment {
+	q.mu.Lock()
+	n := min(count, len(q.payments))
+	payments := slices.Clone(q.payments[:n])
+	clear(q.payments[:n])
+	q.payments = q.payments[n:]
+	left := len(q.payments)
+	q.mu.Unlock()
+
+	if left > 0 {
+		q.wake()
+	}
+	q.depth.Add(-int64(n))
+	return payments
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics is a small registry of counters and gauges exposed in the
+// Prometheus text format.
+type Metrics struct {
+	mu       sync.RWMutex
+	counters map[string]*atomic.Int64
+	gauges   map[string]func() float64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		counters: make(map[string]*atomic.Int64),
+		gauges:   make(map[string]func() float64),
+	}
+}
+
+// Inc increments a counter. Labels are given as name, value pairs.
+func (m *Metrics) Inc(name string, labels ...string) {
+	m.Add(name, 1, labels...)
+}
+
+func (m *Metrics) Add(name string, delta int64, labels ...string) {
+	series := seriesName(name, labels)
+
+	m.mu.RLock()
+	counter, ok := m.counters[series]
+	m.mu.RUnlock()
+
+	if !ok {
+		m.mu.Lock()
+		if counter, ok = m.counters[series]; !ok {
+			counter = &atomic.Int64{}
+			m.counters[series] = counter
+		}
+		m.mu.Unlock()
+	}
+
+	counter.Add(delta)
+}
+
+// Gauge registers a gauge whose value is read when the metrics are rendered.
+func (m *Metrics) Gauge(name string, value func() float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges[seriesName(name, labels)] = value
+}
+
+func (m *Metrics) Render() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	lines := make([]string, 0, len(m.counters)+len(m.gauges))
+	for series, counter := range m.counters {
+		lines = append(lines, fmt.Sprintf("%s %d", series, counter.Load()))
+	}
+	for series, value := range m.gauges {
+		lines = append(lines, fmt.Sprintf("%s %g", series, value()))
+	}
+	sort.Strings(lines)
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func seriesName(name string, labels []string) string {
+	if len(labels) < 2 {
+		return name
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
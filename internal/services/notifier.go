@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/store"
+)
+
+// CompletionNotifier fans payment results published by any instance out to
+// the local requests waiting on them.
+type CompletionNotifier struct {
+	store store.Store
+
+	mu      sync.Mutex
+	waiters map[string][]chan *models.PaymentResult
+}
+
+func (n *CompletionNotifier) Start() {
+	results := n.store.SubscribePaymentResults(context.Background())
+	go func() {
+		for result := range results {
+			n.notify(result)
+		}
+	}()
+}
+
+// Wait registers interest in the result of the payment with the given key. The
+// returned cancel function must be called once the caller stops waiting.
+func (n *CompletionNotifier) Wait(key string) (<-chan *models.PaymentResult, func()) {
+	ch := make(chan *models.PaymentResult, 1)
+
+	n.mu.Lock()
+	n.waiters[key] = append(n.waiters[key], ch)
+	n.mu.Unlock()
+
+	cancel := func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		waiters := n.waiters[key]
+		for i, waiter := range waiters {
+			if waiter == ch {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(n.waiters, key)
+		} else {
+			n.waiters[key] = waiters
+		}
+	}
+
+	return ch, cancel
+}
+
+func (n *CompletionNotifier) notify(result *models.PaymentResult) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, waiter := range n.waiters[result.Key()] {
+		select {
+		case waiter <- result:
+		default:
+		}
+	}
+}
@@ -1,98 +1,554 @@
 package services
 
 import (
-	"encoding/json"
+	"cmp"
 	"errors"
 	"fmt"
+	"math/rand/v2"
+	"sync"
 	"time"
 
 	"github.com/mochaeng/payment-gateway/internal/config"
 	"github.com/mochaeng/payment-gateway/internal/constants"
 	"github.com/mochaeng/payment-gateway/internal/models"
 	"github.com/mochaeng/payment-gateway/internal/store"
-	"github.com/redis/go-redis/v9"
+	"github.com/mochaeng/payment-gateway/internal/utils"
+	"github.com/valyala/bytebufferpool"
 	"github.com/valyala/fasthttp"
 )
 
 var (
-	ErrProcessorsDown = errors.New("all processors are down")
-	ErrQueueFull      = errors.New("queue is full")
+	ErrProcessorsDown      = errors.New("all processors are down")
+	ErrProcessorsBusy      = errors.New("all processors are at their concurrency limit")
+	ErrQueueFull           = errors.New("queue is full")
+	ErrQueueSaturated      = errors.New("queue is above its soft limit")
+	ErrPaymentNotFound     = errors.New("payment not found")
+	ErrDuplicatePayment    = errors.New("payment already submitted")
+	ErrNoAllowedProcessor  = errors.New("merchant has no allowed processor")
+	ErrUnsupportedCurrency = errors.New("no allowed processor supports the currency")
+	ErrNotCancellable      = errors.New("payment can no longer be cancelled")
+	ErrNotRefundable       = errors.New("only succeeded payments can be refunded")
+	ErrRefundTooLarge      = errors.New("refund exceeds the amount left to refund")
+
+	ErrProcessorTimeout = errors.New("processor timed out")
+	ErrDeadlineExceeded = errors.New("payment deadline exceeded")
 )
 
 type PaymentService struct {
-	store      *store.RedisStore
-	config     *config.Config
-	health     *HealthMonitorService
-	httpClient *fasthttp.Client
-	queue      chan *models.QueuedPayment
+	store       store.Store
+	config      *config.Config
+	health      *HealthMonitorService
+	guard       *QueueGuard
+	notifier    *CompletionNotifier
+	webhooks    *WebhookService
+	events      *EventStream
+	metrics     *Metrics
+	limiters    map[constants.PaymentMode]*ConcurrencyLimiter
+	httpClients processorClients
+	local       *LocalQueue
+}
+
+func (p *PaymentService) Send(payment *models.QueuedPayment) error {
+	if err := p.prepare(payment); err != nil {
+		return err
+	}
+
+	var err error
+	if payment.ScheduledAt != nil {
+		err = p.schedule(payment)
+	} else {
+		err = p.guard.Admit(payment)
+	}
+	if err == nil {
+		p.events.Publish(acceptedEvent(payment))
+	}
+	return err
+}
+
+// SendBatch queues several payments at once, returning one error per payment.
+// The returned error is only set when the batch as a whole failed.
+func (p *PaymentService) SendBatch(payments []*models.QueuedPayment) ([]error, error) {
+	errs := make([]error, len(payments))
+	admissible := make([]*models.QueuedPayment, 0, len(payments))
+	indexes := make([]int, 0, len(payments))
+
+	for i, payment := range payments {
+		if err := p.prepare(payment); err != nil {
+			errs[i] = err
+			continue
+		}
+		if payment.ScheduledAt != nil {
+			errs[i] = p.schedule(payment)
+			continue
+		}
+		admissible = append(admissible, payment)
+		indexes = append(indexes, i)
+	}
+
+	admitErrs, err := p.guard.AdmitBatch(admissible)
+	if err != nil {
+		return nil, err
+	}
+	for j, err := range admitErrs {
+		errs[indexes[j]] = err
+	}
+
+	var events []*models.PaymentEvent
+	for i, payment := range payments {
+		if errs[i] == nil {
+			events = append(events, acceptedEvent(payment))
+		}
+	}
+	p.events.Publish(events...)
+
+	return errs, nil
 }
 
-func (p *PaymentService) Send(correlationID string, amount float64) error {
-	return p.store.EnqueuePayment(&models.QueuedPayment{
-		CorrelationID: correlationID,
-		Amount:        amount,
-	})
+// acceptedEvent returns the event of a payment admitted into the queue or the
+// schedule.
+func acceptedEvent(payment *models.QueuedPayment) *models.PaymentEvent {
+	status := constants.PaymentQueued
+	if payment.ScheduledAt != nil {
+		status = constants.PaymentScheduled
+	}
+	return paymentEvent(constants.EventPaymentAccepted, payment, status)
+}
+
+// prepare fills the timing fields of a new payment and checks that it can be
+// routed to at least one processor. A payment scheduled in the past is queued
+// right away, and the deadline of a scheduled one runs from its due time.
+func (p *PaymentService) prepare(payment *models.QueuedPayment) error {
+	if payment.CreatedAt.IsZero() {
+		payment.CreatedAt = time.Now().UTC()
+	}
+	if payment.ScheduledAt != nil && !payment.ScheduledAt.After(payment.CreatedAt) {
+		payment.ScheduledAt = nil
+	}
+	if payment.Deadline.IsZero() && p.config.PaymentDeadline > 0 {
+		start := payment.CreatedAt
+		if payment.ScheduledAt != nil {
+			start = *payment.ScheduledAt
+		}
+		payment.Deadline = start.Add(p.config.PaymentDeadline)
+	}
+	if payment.Currency == "" {
+		payment.Currency = p.config.DefaultCurrency
+	}
+	if len(p.config.ProcessorsFor(payment.MerchantID, payment.Currency)) == 0 {
+		if len(p.config.ProcessorNames(payment.MerchantID)) == 0 {
+			return ErrNoAllowedProcessor
+		}
+		return ErrUnsupportedCurrency
+	}
+	return nil
+}
+
+// AwaitResult returns a channel receiving the final result of the payment with
+// the given key. It must be called before Send so a fast completion is not
+// missed.
+func (p *PaymentService) AwaitResult(key string) (<-chan *models.PaymentResult, func()) {
+	return p.notifier.Wait(key)
+}
+
+func (p *PaymentService) Status(key string) (*models.PaymentResult, error) {
+	result, err := p.store.GetPaymentStatus(key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrPaymentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment status: %w", err)
+	}
+	return result, nil
+}
+
+func (p *PaymentService) schedule(payment *models.QueuedPayment) error {
+	admission, err := p.store.SchedulePayment(payment)
+	if err != nil {
+		return err
+	}
+	if admission == store.QueueDuplicate {
+		return ErrDuplicatePayment
+	}
+	return nil
 }
 
+// Scheduled lists up to limit pending scheduled payments of a merchant, the
+// soonest due first. Without a merchant, every merchant's payments are listed.
+// With a clientID, payments other API clients submitted are left out.
+func (p *PaymentService) Scheduled(merchantID, clientID string, limit int) ([]*models.QueuedPayment, error) {
+	return p.store.ListScheduledPayments(merchantID, clientID, limit)
+}
+
+// Cancel cancels a payment that is scheduled, queued or waiting for a retry.
+// Scheduled payments are removed right away. Queued ones are dropped when a
+// worker dequeues them, which is also when their webhook is sent.
+func (p *PaymentService) Cancel(key string) (*models.PaymentResult, error) {
+	result, cancelled, err := p.store.TransitionPayment(key, constants.PaymentCancelled,
+		constants.PaymentScheduled, constants.PaymentQueued)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrPaymentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		return result, ErrNotCancellable
+	}
+
+	p.metrics.Inc("gateway_payments_total", "outcome", string(constants.PaymentCancelled))
+	if err := p.store.CompletePayment(result); err != nil {
+		fmt.Printf("Failed to publish cancellation of payment [%s]: %s\n", key, err)
+	}
+
+	scheduled, err := p.store.UnschedulePayment(key)
+	if err != nil {
+		fmt.Printf("Failed to unschedule payment [%s]: %s\n", key, err)
+	} else if scheduled != nil {
+		if err := p.webhooks.Notify(scheduled, result); err != nil {
+			fmt.Printf("Failed to schedule webhook of payment [%s]: %s\n", key, err)
+		}
+	}
+
+	return result, nil
+}
+
+// Refund refunds amount from a succeeded payment, or all that is left of it
+// when amount is zero. Processors have no refund endpoint, so refunds are only
+// recorded by the gateway, and only while the payment status is retained.
+func (p *PaymentService) Refund(key string, amount float64) (*models.Refund, error) {
+	outcome, refund, _, err := p.store.RefundPayment(key, utils.NewID(), amount, p.config.DefaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	switch outcome {
+	case store.RefundNotFound:
+		return nil, ErrPaymentNotFound
+	case store.RefundNotAllowed:
+		return nil, ErrNotRefundable
+	case store.RefundExceedsAmount:
+		return nil, ErrRefundTooLarge
+	}
+
+	p.metrics.Inc("gateway_refunds_total", "processor", refund.Processor)
+	return refund, nil
+}
+
+func (p *PaymentService) complete(payment *models.QueuedPayment, status constants.PaymentStatus, processor constants.PaymentMode, reason string) {
+	result := &models.PaymentResult{
+		CorrelationID: payment.CorrelationID,
+		MerchantID:    payment.MerchantID,
+		ClientID:      payment.ClientID,
+		Status:        status,
+		Processor:     string(processor),
+		Amount:        payment.Amount,
+		Currency:      payment.Currency,
+		Reason:        reason,
+		UpdatedAt:     time.Now().UTC(),
+	}
+
+	if err := p.store.CompletePayment(result); err != nil {
+		fmt.Printf("Failed to record result of payment [%s]: %s\n", payment.CorrelationID, err)
+	}
+
+	if err := p.webhooks.Notify(payment, result); err != nil {
+		fmt.Printf("Failed to schedule webhook of payment [%s]: %s\n", payment.CorrelationID, err)
+	}
+}
+
+// processQueue takes payments from the queue in batches of DequeueBatchSize
+// and handles each batch concurrently, with the processors health read once
+// for the whole batch.
 func (p *PaymentService) processQueue() {
+	batchSize := max(p.config.DequeueBatchSize, 1)
+
 	for {
-		payment, err := p.store.BlockingDequeuePayment(5 * time.Second)
-		if err != nil {
-			if err != redis.Nil {
-				fmt.Printf("Failed to dequeue payment: %s\n", err)
+		payments, err := p.dequeue(batchSize)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			fmt.Printf("Failed to dequeue payments: %s\n", err)
+		}
+		if len(payments) == 0 {
+			continue
+		}
+
+		if meter, ok := p.store.(store.DrainMeter); ok {
+			if err := meter.RecordDequeued(int64(len(payments))); err != nil {
+				fmt.Printf("Failed to record dequeued payments: %s\n", err)
 			}
+		}
+
+		health := p.processorsHealth()
+		if len(payments) == 1 {
+			p.process(payments[0], health)
 			continue
 		}
 
-		if err := p.tryProcess(payment); err != nil {
-			if payment.RetryCount < 3 {
-				payment.RetryCount++
+		var wg sync.WaitGroup
+		for _, payment := range payments {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.process(payment, health)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// dequeue takes the next batch of payments from the Redis queue, or from the
+// local queue when set.
+func (p *PaymentService) dequeue(batchSize int) ([]*models.QueuedPayment, error) {
+	if p.local != nil {
+		return p.local.Dequeue(5*time.Second, batchSize), nil
+	}
+	return p.store.BlockingDequeuePayments(5*time.Second, p.laneOrder(), batchSize)
+}
+
+// process handles a dequeued payment, dropping it from the local queue log
+// unless it was queued again for a retry.
+func (p *PaymentService) process(payment *models.QueuedPayment, health map[constants.PaymentMode]*models.ProcessorHealth) {
+	if retried := p.handle(payment, health); !retried && p.local != nil {
+		p.local.Done(payment)
+	}
+}
+
+// processorsHealth returns the last health of every processor. Processors
+// whose health is unknown are left out, and so treated as failing.
+func (p *PaymentService) processorsHealth() map[constants.PaymentMode]*models.ProcessorHealth {
+	names := make([]constants.PaymentMode, len(p.config.Processors))
+	for i, processor := range p.config.Processors {
+		names[i] = processor.Name
+	}
+
+	health, err := p.store.GetProcessorsHealth(names)
+	if err != nil {
+		fmt.Printf("failed to get processors health: %s\n", err)
+	}
+	return health
+}
+
+// laneOrder picks the lane served first at random, weighted by LaneWeights,
+// followed by the other lanes from the most urgent. While they hold payments,
+// lanes get dequeues in proportion to their weights, so low lanes are slowed
+// down but never starved.
+func (p *PaymentService) laneOrder() []constants.PaymentPriority {
+	total := 0
+	for _, priority := range constants.Priorities {
+		total += p.config.LaneWeights[priority]
+	}
+
+	first := constants.PriorityNormal
+	if total > 0 {
+		roll := rand.IntN(total)
+		for _, priority := range constants.Priorities {
+			if roll -= p.config.LaneWeights[priority]; roll < 0 {
+				first = priority
+				break
+			}
+		}
+	}
+
+	order := make([]constants.PaymentPriority, 0, len(constants.Priorities))
+	order = append(order, first)
+	for _, priority := range constants.Priorities {
+		if priority != first {
+			order = append(order, priority)
+		}
+	}
+	return order
+}
+
+// handle processes a dequeued payment, reporting whether it was queued again
+// for a retry.
+func (p *PaymentService) handle(payment *models.QueuedPayment, health map[constants.PaymentMode]*models.ProcessorHealth) bool {
+	if !p.claim(payment) {
+		return false
+	}
+
+	if p.pastDeadline(payment, 0) {
+		p.giveUpUnlessCharged(payment, constants.PaymentTimedOut, ErrDeadlineExceeded)
+		return false
+	}
 
-				backoffDuration := time.Duration(payment.RetryCount*payment.RetryCount) * time.Second
+	err := p.tryProcess(payment, health)
+	if err == nil {
+		return false
+	}
+
+	if Classify(err) == constants.ClassPermanent {
+		p.giveUp(payment, constants.PaymentFailed, err)
+		return false
+	}
+
+	if payment.RetryCount >= 3 {
+		p.giveUpUnlessCharged(payment, constants.PaymentDeadLettered, err)
+		return false
+	}
+
+	payment.RetryCount++
+	backoffDuration := time.Duration(payment.RetryCount*payment.RetryCount) * time.Second
+	backoffDuration = max(backoffDuration, p.lookupDelay(payment))
+
+	if p.pastDeadline(payment, backoffDuration) {
+		p.giveUpUnlessCharged(payment, constants.PaymentTimedOut, fmt.Errorf("%w: %w", ErrDeadlineExceeded, err))
+		return false
+	}
+
+	if _, _, err := p.store.TransitionPayment(payment.Key(), constants.PaymentQueued, constants.PaymentProcessing); err != nil && !errors.Is(err, store.ErrNotFound) {
+		fmt.Printf("Failed to requeue status of payment [%s]: %s\n", payment.CorrelationID, err)
+	}
+
+	event := paymentEvent(constants.EventPaymentRetryScheduled, payment, constants.PaymentQueued)
+	retryAt := event.OccurredAt.Add(backoffDuration)
+	event.RetryAt = &retryAt
+	event.Reason = err.Error()
+	p.events.Publish(event)
+
+	go func(payment *models.QueuedPayment) {
+		time.Sleep(backoffDuration)
+		if err := p.requeue(payment); err != nil {
+			fmt.Printf("Failed to enqueue retried payment [%s] with [%s]\n",
+				payment.CorrelationID, err)
+		}
+	}(payment)
+	return true
+}
+
+func (p *PaymentService) requeue(payment *models.QueuedPayment) error {
+	if p.local != nil {
+		return p.local.Requeue(payment)
+	}
+	return p.store.EnqueuePayment(payment)
+}
+
+// claim marks a dequeued payment as processing so it can no longer be
+// cancelled, reporting whether the worker should go on with it. Promoted
+// payments may still be marked scheduled, the scheduler updating their status
+// after pushing them. Cancelled
+// payments are dropped here and their webhook sent. Payments without a status
+// record are processed anyway.
+func (p *PaymentService) claim(payment *models.QueuedPayment) bool {
+	current, claimed, err := p.store.TransitionPayment(payment.Key(), constants.PaymentProcessing,
+		constants.PaymentQueued, constants.PaymentScheduled)
+	if err != nil || claimed {
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			fmt.Printf("Failed to claim payment [%s]: %s\n", payment.CorrelationID, err)
+		}
+		return true
+	}
+
+	if current.Status == constants.PaymentCancelled {
+		if err := p.webhooks.Notify(payment, current); err != nil {
+			fmt.Printf("Failed to schedule webhook of payment [%s]: %s\n", payment.CorrelationID, err)
+		}
+	} else {
+		fmt.Printf("payment [%s] is already %s, skipping\n", payment.CorrelationID, current.Status)
+	}
+	return false
+}
+
+// pastDeadline reports whether the payment deadline budget runs out within
+// the given delay.
+func (p *PaymentService) pastDeadline(payment *models.QueuedPayment, delay time.Duration) bool {
+	return !payment.Deadline.IsZero() && time.Now().Add(delay).After(payment.Deadline)
+}
 
-				go func(payment *models.QueuedPayment) {
-					time.Sleep(backoffDuration)
-					if err := p.store.EnqueuePayment(payment); err != nil {
-						fmt.Printf("Failed to enqueue retried payment [%s] with [%s]\n",
-							payment.CorrelationID, err)
-					}
-				}(payment)
-			} else {
-				fmt.Printf("Payment %s failed after %d retries, giving up\n",
-					payment.CorrelationID, payment.RetryCount)
+// giveUpUnlessCharged gives up on a payment unless it is pinned to a processor
+// that turns out to have charged it. The outcome of a pinned payment is
+// unknown, so it is looked up once more, after its lookupDelay, before being
+// reported as timed out or dead-lettered, and recorded as succeeded when the
+// processor has it.
+func (p *PaymentService) giveUpUnlessCharged(payment *models.QueuedPayment, status constants.PaymentStatus, err error) {
+	if payment.PinnedProcessor != "" {
+		if processor := p.config.Processor(payment.PinnedProcessor); processor != nil {
+			time.Sleep(p.lookupDelay(payment))
+			resolveErr := p.resolveAmbiguous(processor, payment, err)
+			if resolveErr == nil {
+				return
 			}
+			err = resolveErr
 		}
 	}
+	p.giveUp(payment, status, err)
 }
 
-func (p *PaymentService) tryProcess(payment *models.QueuedPayment) error {
-	defaultHealth, err := p.store.GetProcessorHealth(constants.DefaultProcessorKey)
-	if err != nil {
-		return fmt.Errorf("failed to get default processor health: %w", err)
+func (p *PaymentService) giveUp(payment *models.QueuedPayment, status constants.PaymentStatus, err error) {
+	fmt.Printf("Payment %s %s after %d retries, giving up: %s\n",
+		payment.CorrelationID, status, payment.RetryCount, err)
+
+	if err := p.store.DeadLetterPayment(payment); err != nil {
+		fmt.Printf("Failed to dead-letter payment [%s]: %s\n", payment.CorrelationID, err)
 	}
 
-	fallbackHealth, err := p.store.GetProcessorHealth(constants.FallbackProcessorKey)
-	if err != nil {
-		return fmt.Errorf("failed to get fallback processor health: %w", err)
-	}
-
-	switch {
-	case !defaultHealth.Failing:
-		return p.processPayment(constants.DefaultProcessorKey, payment)
-	// case !defaultHealth.Failing &&
-	// 	(defaultHealth.MinResponseTime <= fallbackHealth.MinResponseTime+p.config.ProcessorThreshold):
-	// 	return p.processPayment(constants.DefaultProcessorKey, payment)
-	case !fallbackHealth.Failing:
-		return p.processPayment(constants.FallbackProcessorKey, payment)
-	default:
+	event := paymentEvent(constants.EventPaymentGivenUp, payment, status)
+	event.Reason = err.Error()
+	p.events.Publish(event)
+
+	p.metrics.Inc("gateway_payments_total", "outcome", string(status))
+	p.complete(payment, status, "", err.Error())
+}
+
+func (p *PaymentService) tryProcess(payment *models.QueuedPayment, health map[constants.PaymentMode]*models.ProcessorHealth) error {
+	if payment.PinnedProcessor != "" {
+		processor := p.config.Processor(payment.PinnedProcessor)
+		if processor == nil {
+			payment.PinnedProcessor = ""
+		} else if err := p.resolveAmbiguous(processor, payment, ErrOutcomeUnknown); !errors.Is(err, ErrNoRecord) {
+			return err
+		}
+	}
+
+	var healthy []*config.ProcessorConfig
+	for _, processor := range p.config.ProcessorsFor(payment.MerchantID, payment.Currency) {
+		if status := health[processor.Name]; status != nil && !status.Failing {
+			healthy = append(healthy, processor)
+		}
+	}
+
+	if len(healthy) == 0 {
 		return ErrProcessorsDown
 	}
+
+	for _, processor := range healthy {
+		if p.limiters[processor.Name].TryAcquire() {
+			return p.limitedProcess(processor, payment)
+		}
+	}
+
+	// every healthy processor is saturated, wait for the preferred one until
+	// the payment deadline or, without one, for as long as a request to it may
+	// take, so the worker is not held forever
+	preferred := healthy[0]
+	wait := payment.Deadline
+	if wait.IsZero() {
+		wait = time.Now().Add(p.config.RequestTimeoutOf(preferred))
+	}
+	if !p.limiters[preferred.Name].Acquire(wait) {
+		if payment.Deadline.IsZero() {
+			return ErrProcessorsBusy
+		}
+		return ErrDeadlineExceeded
+	}
+	return p.limitedProcess(preferred, payment)
 }
 
-func (p *PaymentService) processPayment(processor constants.PaymentMode, payment *models.QueuedPayment) error {
-	processedKey := fmt.Sprintf("processed:%s", payment.CorrelationID)
+// limitedProcess sends a payment through a processor whose limiter slot was
+// already acquired, feeding the outcome back to the limiter.
+func (p *PaymentService) limitedProcess(processor *config.ProcessorConfig, payment *models.QueuedPayment) error {
+	start := time.Now()
+	err := p.processPayment(processor, payment)
+
+	var processorErr *ProcessorError
+	dropped := errors.Is(err, ErrProcessorTimeout) ||
+		(errors.As(err, &processorErr) && processorErr.Class == constants.ClassRetryable)
+	p.limiters[processor.Name].Release(time.Since(start), dropped)
 
-	isSet, err := p.store.SetProcessedPayment(processedKey, processor, 5*time.Second)
+	return err
+}
+
+func (p *PaymentService) processPayment(processorConfig *config.ProcessorConfig, payment *models.QueuedPayment) error {
+	processor := processorConfig.Name
+	isSet, err := p.store.SetProcessedPayment(payment.Key(), processor, 5*time.Second)
 	if err != nil {
 		return fmt.Errorf("failed to check payment processing status: %w", err)
 	}
@@ -101,7 +557,7 @@ func (p *PaymentService) processPayment(processor constants.PaymentMode, payment
 		fmt.Printf("payment [%s] already processed, skipping\n", payment.CorrelationID)
 	}
 
-	url := p.config.Urls[processor].PaymentURL
+	url := processorConfig.PaymentURL
 
 	req := fasthttp.AcquireRequest()
 	resp := fasthttp.AcquireResponse()
@@ -109,38 +565,102 @@ func (p *PaymentService) processPayment(processor constants.PaymentMode, payment
 	defer fasthttp.ReleaseResponse(resp)
 
 	paymentReq := models.PaymentProcessorRequest{
-		CorrelationID: payment.CorrelationID,
+		CorrelationID: processorCorrelationID(payment),
 		Amount:        payment.Amount,
 		RequestedAt:   time.Now().UTC(),
 	}
-
-	reqBody, err := json.Marshal(paymentReq)
-	if err != nil {
-		p.store.RemoveProcessedPayment(processedKey)
-		return fmt.Errorf("failed to marshal payment request: %w", err)
+	// Processors not declaring their currencies only get payments in the
+	// default currency and may not know the field.
+	if len(processorConfig.Currencies) > 0 {
+		paymentReq.Currency = payment.Currency
 	}
 
+	buf := bytebufferpool.Get()
+	buf.B = paymentReq.AppendJSON(buf.B[:0])
+	req.SetBody(buf.B)
+	bytebufferpool.Put(buf)
+
 	req.SetRequestURI(url)
 	req.Header.SetMethod("POST")
 	req.Header.SetContentType("application/json")
-	req.SetBody(reqBody)
 
-	if err := p.httpClient.Do(req, resp); err != nil {
-		p.store.RemoveProcessedPayment(processedKey)
-		return fmt.Errorf("failed to do request: %w", err)
+	deadline := time.Now().Add(p.config.RequestTimeoutOf(processorConfig))
+	if !payment.Deadline.IsZero() && payment.Deadline.Before(deadline) {
+		deadline = payment.Deadline
+	}
+
+	event := paymentEvent(constants.EventPaymentDispatched, payment, constants.PaymentProcessing)
+	event.Processor = string(processor)
+	p.events.Publish(event)
+
+	if err := p.httpClients[processor].DoDeadline(req, resp, deadline); err != nil {
+		if errors.Is(err, fasthttp.ErrTimeout) || errors.Is(err, fasthttp.ErrDialTimeout) {
+			p.metrics.Inc("gateway_processor_requests_total", "processor", string(processor), "outcome", "timeout")
+			err = fmt.Errorf("%w: %w", ErrProcessorTimeout, err)
+		} else {
+			p.metrics.Inc("gateway_processor_requests_total", "processor", string(processor), "outcome", "error")
+			err = fmt.Errorf("failed to do request: %w", err)
+		}
+
+		if isAmbiguous(err) {
+			return p.pin(processorConfig, payment, err)
+		}
+
+		p.store.RemoveProcessedPayment(payment.Key())
+		return err
 	}
 
 	if resp.StatusCode() >= 400 {
-		p.store.RemoveProcessedPayment(processedKey)
-		return fmt.Errorf("processor with status code [%d]", resp.StatusCode())
+		class := classifyStatus(p.config, processorConfig, resp.StatusCode())
+		p.metrics.Inc("gateway_processor_requests_total", "processor", string(processor), "outcome", string(class))
+
+		if class == constants.ClassAlreadyProcessed {
+			fmt.Printf("payment [%s] already processed by %s\n", payment.CorrelationID, processor)
+			return p.recordSuccess(processor, payment)
+		}
+
+		p.store.RemoveProcessedPayment(payment.Key())
+		return &ProcessorError{
+			Processor:  processor,
+			StatusCode: resp.StatusCode(),
+			Class:      class,
+		}
 	}
 
-	if err := p.store.UpdateSummary(processor, payment.Amount); err != nil {
+	p.metrics.Inc("gateway_processor_requests_total", "processor", string(processor), "outcome", "success")
+
+	return p.recordSuccess(processor, payment)
+}
+
+func (p *PaymentService) recordSuccess(processor constants.PaymentMode, payment *models.QueuedPayment) error {
+	if err := p.store.UpdateSummary(payment.MerchantID, processor, p.currencyScope(payment.Currency), payment.Amount); err != nil {
 		fmt.Printf("CRITICAL: failed to update summary for payment [%s] with value [%f]\n", payment.CorrelationID, payment.Amount)
 		return fmt.Errorf("failed to update summary: %w", err)
 	}
 
 	fmt.Printf("payment successed: %s with %f\n", processor, payment.Amount)
+	event := paymentEvent(constants.EventPaymentSucceeded, payment, constants.PaymentSucceeded)
+	event.Processor = string(processor)
+	p.events.Publish(event)
+
+	p.metrics.Inc("gateway_payments_total", "outcome", string(constants.PaymentSucceeded))
+	p.complete(payment, constants.PaymentSucceeded, processor, "")
 
 	return nil
 }
+
+// currencyScope returns the summary scope of a currency, treating payments
+// queued before currencies existed as in the default currency.
+func (p *PaymentService) currencyScope(currency string) string {
+	return store.CurrencyScope(cmp.Or(currency, p.config.DefaultCurrency), p.config.DefaultCurrency)
+}
+
+// processorCorrelationID returns the correlation ID sent to processors. Since
+// processors only know one namespace of correlation IDs, merchant payments are
+// sent under a UUID derived from their merchant scoped key.
+func processorCorrelationID(payment *models.QueuedPayment) string {
+	if payment.MerchantID == "" {
+		return payment.CorrelationID
+	}
+	return utils.NameUUID(payment.Key())
+}
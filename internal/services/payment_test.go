@@ -0,0 +1,211 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/store"
+)
+
+func TestLaneOrder_FollowsWeights(t *testing.T) {
+	payments := PaymentService{config: &config.Config{
+		LaneWeights: map[constants.PaymentPriority]int{
+			constants.PriorityHigh:   6,
+			constants.PriorityNormal: 3,
+			constants.PriorityLow:    1,
+		},
+	}}
+
+	firsts := make(map[constants.PaymentPriority]int)
+	for range 10000 {
+		order := payments.laneOrder()
+		if len(order) != len(constants.Priorities) {
+			t.Fatalf("expected every lane in the order, got %v", order)
+		}
+		firsts[order[0]]++
+	}
+
+	if firsts[constants.PriorityLow] < 500 || firsts[constants.PriorityLow] > 1500 {
+		t.Fatalf("expected the low lane first about 10%% of the time, got %d/10000", firsts[constants.PriorityLow])
+	}
+	if firsts[constants.PriorityHigh] < firsts[constants.PriorityNormal] {
+		t.Fatalf("expected the high lane first more often than the normal one, got %v", firsts)
+	}
+}
+
+// memoryResults is a store recording the results, summaries and dead letters
+// of payments.
+type memoryResults struct {
+	store.Store
+
+	mu          sync.Mutex
+	results     []*models.PaymentResult
+	summaries   int
+	deadLetters int
+}
+
+func (m *memoryResults) CompletePayment(result *models.PaymentResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results = append(m.results, result)
+	return nil
+}
+
+func (m *memoryResults) UpdateSummary(merchantID string, processor constants.PaymentMode, currency string, amount float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.summaries++
+	return nil
+}
+
+func (m *memoryResults) DeadLetterPayment(payment *models.QueuedPayment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deadLetters++
+	return nil
+}
+
+func (m *memoryResults) RemoveProcessedPayment(key string) (int64, error) {
+	return 0, nil
+}
+
+func TestGiveUpUnlessCharged_ResolvesPinnedPayments(t *testing.T) {
+	cases := []struct {
+		name       string
+		pinned     bool
+		lookup     int
+		wantStatus constants.PaymentStatus
+		wantLookup bool
+	}{
+		{name: "charged", pinned: true, lookup: http.StatusOK, wantStatus: constants.PaymentSucceeded, wantLookup: true},
+		{name: "missing", pinned: true, lookup: http.StatusNotFound, wantStatus: constants.PaymentTimedOut, wantLookup: true},
+		{name: "unknown", pinned: true, lookup: http.StatusInternalServerError, wantStatus: constants.PaymentTimedOut, wantLookup: true},
+		{name: "not pinned", wantStatus: constants.PaymentTimedOut},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var lookups atomic.Int32
+			processor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lookups.Add(1)
+				w.WriteHeader(c.lookup)
+			}))
+			defer processor.Close()
+
+			cfg := &config.Config{
+				ConnectTimeout: time.Second,
+				RequestTimeout: time.Second,
+				Processors:     []*config.ProcessorConfig{config.NewProcessorConfig(constants.DefaultProcessorKey, 0, processor.URL)},
+			}
+			results := &memoryResults{}
+			payments := PaymentService{
+				store:       results,
+				config:      cfg,
+				webhooks:    &WebhookService{config: cfg},
+				events:      &EventStream{config: cfg},
+				metrics:     NewMetrics(),
+				httpClients: newProcessorClients(cfg),
+			}
+
+			payment := &models.QueuedPayment{CorrelationID: "00000000-0000-4000-8000-000000000001", Amount: 10}
+			if c.pinned {
+				payment.PinnedProcessor = constants.DefaultProcessorKey
+			}
+			payments.giveUpUnlessCharged(payment, constants.PaymentTimedOut, ErrDeadlineExceeded)
+
+			if got := lookups.Load() > 0; got != c.wantLookup {
+				t.Fatalf("got lookup %t, want %t", got, c.wantLookup)
+			}
+			if len(results.results) != 1 || results.results[0].Status != c.wantStatus {
+				t.Fatalf("got results %+v, want one %s", results.results, c.wantStatus)
+			}
+			if charged := c.wantStatus == constants.PaymentSucceeded; (results.summaries == 1) != charged || (results.deadLetters == 0) != charged {
+				t.Fatalf("got %d summaries and %d dead letters for a %s payment", results.summaries, results.deadLetters, c.wantStatus)
+			}
+		})
+	}
+}
+
+func TestResolveAmbiguous_KeepsEarlyMissingPaymentsPinned(t *testing.T) {
+	processor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer processor.Close()
+
+	cfg := &config.Config{
+		ConnectTimeout: time.Second,
+		RequestTimeout: time.Second,
+		Processors:     []*config.ProcessorConfig{config.NewProcessorConfig(constants.DefaultProcessorKey, 0, processor.URL)},
+	}
+	payments := PaymentService{
+		store:       &memoryResults{},
+		config:      cfg,
+		metrics:     NewMetrics(),
+		httpClients: newProcessorClients(cfg),
+	}
+
+	cases := []struct {
+		name       string
+		pinnedAgo  time.Duration
+		want       error
+		wantPinned bool
+	}{
+		// the request may still be in flight at the processor
+		{name: "early", pinnedAgo: 0, want: ErrOutcomeUnknown, wantPinned: true},
+		{name: "after the request timeout", pinnedAgo: 2 * time.Second, want: ErrNoRecord},
+	}
+
+	for _, c := range cases {
+		pinnedAt := time.Now().Add(-c.pinnedAgo)
+		payment := &models.QueuedPayment{
+			CorrelationID:   "00000000-0000-4000-8000-000000000002",
+			Amount:          10,
+			PinnedProcessor: constants.DefaultProcessorKey,
+			PinnedAt:        &pinnedAt,
+		}
+
+		err := payments.resolveAmbiguous(cfg.Processors[0], payment, ErrProcessorTimeout)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+		if pinned := payment.PinnedProcessor != ""; pinned != c.wantPinned {
+			t.Errorf("%s: got pinned %t, want %t", c.name, pinned, c.wantPinned)
+		}
+	}
+}
+
+func TestTryProcess_BoundsWaitWithoutDeadline(t *testing.T) {
+	cfg := &config.Config{
+		RequestTimeout: 20 * time.Millisecond,
+		Processors:     []*config.ProcessorConfig{config.NewProcessorConfig(constants.DefaultProcessorKey, 0, "http://default")},
+	}
+	limiter := NewConcurrencyLimiter(LimiterOptions{Initial: 1, Max: 1})
+	limiter.TryAcquire()
+	payments := PaymentService{
+		config:   cfg,
+		limiters: map[constants.PaymentMode]*ConcurrencyLimiter{constants.DefaultProcessorKey: limiter},
+	}
+	health := map[constants.PaymentMode]*models.ProcessorHealth{constants.DefaultProcessorKey: {}}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- payments.tryProcess(&models.QueuedPayment{CorrelationID: "00000000-0000-4000-8000-000000000001"}, health)
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrProcessorsBusy) {
+			t.Fatalf("got %v, want ErrProcessorsBusy", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("tryProcess kept waiting for a slot without a deadline")
+	}
+}
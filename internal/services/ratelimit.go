@@ -0,0 +1,128 @@
+package services
+
+import (
+	"math"
+	"net"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/store"
+)
+
+const globalBucketKey = "global"
+
+// RateLimitService enforces token buckets shared by every gateway instance:
+// one for the whole cluster and one per client.
+type RateLimitService struct {
+	store      store.Store
+	config     *config.Config
+	exemptIDs  map[string]bool
+	exemptNets []*net.IPNet
+}
+
+func NewRateLimitService(config *config.Config, store store.Store) *RateLimitService {
+	service := &RateLimitService{
+		store:     store,
+		config:    config,
+		exemptIDs: make(map[string]bool),
+	}
+
+	for _, exempt := range config.RateLimit.Exempt {
+		if _, ipNet, err := net.ParseCIDR(exempt); err == nil {
+			service.exemptNets = append(service.exemptNets, ipNet)
+		} else {
+			service.exemptIDs[exempt] = true
+		}
+	}
+
+	return service
+}
+
+// Allow takes a token on behalf of a client, identified by its client ID or
+// source IP. A nil result means the client is not subject to rate limiting.
+func (s *RateLimitService) Allow(identity string) (*store.RateLimitResult, error) {
+	return s.AllowN(identity, 1)
+}
+
+// AllowN takes count tokens on behalf of a client, for requests carrying
+// several payments.
+func (s *RateLimitService) AllowN(identity string, count int) (*store.RateLimitResult, error) {
+	if !s.config.RateLimit.Enabled || s.isExempt(identity) {
+		return nil, nil
+	}
+
+	var buckets []store.TokenBucket
+	if rule := s.config.RateLimit.Global; rule.Rate > 0 {
+		buckets = append(buckets, newTokenBucket(globalBucketKey, rule))
+	}
+
+	if bucket, ok := s.clientBucket(identity); ok {
+		buckets = append(buckets, bucket)
+	}
+
+	if len(buckets) == 0 {
+		return nil, nil
+	}
+
+	return s.store.TakeTokens(count, buckets...)
+}
+
+// AllowSource takes a token from the bucket of a source IP only, for requests
+// not authenticated yet. It shares the bucket Allow uses for unauthenticated
+// clients.
+func (s *RateLimitService) AllowSource(ip string) (*store.RateLimitResult, error) {
+	if !s.config.RateLimit.Enabled || s.isExempt(ip) {
+		return nil, nil
+	}
+
+	bucket, ok := s.clientBucket(ip)
+	if !ok {
+		return nil, nil
+	}
+	return s.store.TakeTokens(1, bucket)
+}
+
+// clientBucket returns the bucket of a client, following its override, or
+// false when the client is not limited.
+func (s *RateLimitService) clientBucket(identity string) (store.TokenBucket, bool) {
+	rule, ok := s.config.RateLimit.Overrides[identity]
+	if !ok {
+		rule = s.config.RateLimit.Client
+	}
+	if rule.Rate <= 0 {
+		return store.TokenBucket{}, false
+	}
+	return newTokenBucket("client:"+identity, rule), true
+}
+
+func (s *RateLimitService) isExempt(identity string) bool {
+	if s.exemptIDs[identity] {
+		return true
+	}
+
+	ip := net.ParseIP(identity)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range s.exemptNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// newTokenBucket builds a bucket from a rule, allowing one second worth of
+// requests as burst when none is configured.
+func newTokenBucket(key string, rule config.RateLimitRule) store.TokenBucket {
+	burst := rule.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(rule.Rate))
+	}
+
+	return store.TokenBucket{
+		Key:   key,
+		Rate:  rule.Rate,
+		Burst: burst,
+	}
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	ErrOutcomeUnknown = errors.New("processor outcome unknown")
+	ErrNoRecord       = errors.New("processor has no record of payment")
+)
+
+type lookupResult int
+
+const (
+	lookupUnknown lookupResult = iota
+	lookupFound
+	lookupMissing
+)
+
+func (l lookupResult) String() string {
+	switch l {
+	case lookupFound:
+		return "found"
+	case lookupMissing:
+		return "missing"
+	default:
+		return "unknown"
+	}
+}
+
+// isAmbiguous reports whether a transport error may have happened after the
+// processor received the payment. Failures to connect are not ambiguous since
+// nothing was sent.
+func isAmbiguous(err error) bool {
+	if errors.Is(err, fasthttp.ErrDialTimeout) {
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return false
+	}
+
+	return errors.Is(err, fasthttp.ErrTimeout) ||
+		errors.Is(err, fasthttp.ErrConnectionClosed) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// lookupPayment asks the processor whether it has a record of the payment.
+func (p *PaymentService) lookupPayment(processorConfig *config.ProcessorConfig, correlationID string) lookupResult {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(processorConfig.PaymentURL + "/" + correlationID)
+	req.Header.SetMethod("GET")
+
+	err := p.httpClients[processorConfig.Name].DoTimeout(req, resp, p.config.RequestTimeoutOf(processorConfig))
+	if err != nil {
+		return lookupUnknown
+	}
+
+	switch resp.StatusCode() {
+	case fasthttp.StatusOK:
+		return lookupFound
+	case fasthttp.StatusNotFound:
+		return lookupMissing
+	default:
+		return lookupUnknown
+	}
+}
+
+// pin pins a payment whose request failed ambiguously to the processor, so
+// it is looked up there before being sent anywhere else. The lookup waits for
+// lookupDelay: the request may still be in flight at the processor.
+func (p *PaymentService) pin(processorConfig *config.ProcessorConfig, payment *models.QueuedPayment, cause error) error {
+	now := time.Now()
+	payment.PinnedProcessor = processorConfig.Name
+	payment.PinnedAt = &now
+	return fmt.Errorf("%w: %w", ErrOutcomeUnknown, cause)
+}
+
+// lookupDelay returns how long after it was pinned a payment may be looked up,
+// the request timeout of its processor, so a missing payment is not one whose
+// request is still being processed.
+func (p *PaymentService) lookupDelay(payment *models.QueuedPayment) time.Duration {
+	if payment.PinnedAt == nil {
+		return 0
+	}
+	processorConfig := p.config.Processor(payment.PinnedProcessor)
+	if processorConfig == nil {
+		return 0
+	}
+	return time.Until(payment.PinnedAt.Add(p.config.RequestTimeoutOf(processorConfig)))
+}
+
+// resolveAmbiguous settles a payment pinned to a processor after a request
+// that failed in a way that does not tell whether the processor charged it. A
+// charged payment is recorded as succeeded, and a missing one is released for
+// retry on any processor. A payment missing before lookupDelay, or whose
+// lookup failed, stays pinned so it is looked up again.
+func (p *PaymentService) resolveAmbiguous(processorConfig *config.ProcessorConfig, payment *models.QueuedPayment, cause error) error {
+	processor := processorConfig.Name
+
+	result := p.lookupPayment(processorConfig, processorCorrelationID(payment))
+	if result == lookupMissing && p.lookupDelay(payment) > 0 {
+		result = lookupUnknown
+	}
+	p.metrics.Inc("gateway_ambiguous_outcomes_total", "processor", string(processor), "resolution", result.String())
+
+	switch result {
+	case lookupFound:
+		payment.PinnedProcessor, payment.PinnedAt = "", nil
+		return p.recordSuccess(processor, payment)
+	case lookupMissing:
+		payment.PinnedProcessor, payment.PinnedAt = "", nil
+		p.store.RemoveProcessedPayment(payment.Key())
+		return fmt.Errorf("%w: %w", ErrNoRecord, cause)
+	default:
+		payment.PinnedProcessor = processor
+		return fmt.Errorf("%w: %w", ErrOutcomeUnknown, cause)
+	}
+}
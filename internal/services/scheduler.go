@@ -0,0 +1,60 @@
+package services
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/store"
+)
+
+const promoteBatchSize = 100
+
+// PaymentScheduler promotes scheduled payments into the queue once they are
+// due. Promotion is atomic in Redis, so every instance can run a scheduler.
+// In the local queue mode, promoted payments go to the local queue.
+type PaymentScheduler struct {
+	store   store.Store
+	config  *config.Config
+	metrics *Metrics
+	local   *LocalQueue
+}
+
+func (s *PaymentScheduler) Start() {
+	go s.promoteLoop()
+}
+
+func (s *PaymentScheduler) promoteLoop() {
+	ticker := time.NewTicker(cmp.Or(s.config.SchedulerInterval, time.Second))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			promoted, err := s.store.PromoteDuePayments(time.Now(), promoteBatchSize, s.local == nil)
+			if err != nil {
+				fmt.Printf("Failed to promote scheduled payments: %s\n", err)
+				break
+			}
+
+			for _, payment := range promoted {
+				_, _, err := s.store.TransitionPayment(payment.Key(), constants.PaymentQueued, constants.PaymentScheduled)
+				if err != nil && !errors.Is(err, store.ErrNotFound) {
+					fmt.Printf("Failed to update status of promoted payment [%s]: %s\n", payment.CorrelationID, err)
+				}
+			}
+			if s.local != nil {
+				if err := s.local.Promote(promoted); err != nil {
+					fmt.Printf("Failed to queue promoted payments: %s\n", err)
+				}
+			}
+			s.metrics.Add("gateway_scheduled_promoted_total", int64(len(promoted)))
+
+			if len(promoted) < promoteBatchSize {
+				break
+			}
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package services
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
 	"github.com/mochaeng/payment-gateway/internal/models"
 	"github.com/mochaeng/payment-gateway/internal/store"
 	"github.com/valyala/fasthttp"
@@ -11,40 +13,168 @@ import (
 
 type Service struct {
 	Payment interface {
-		Send(correlationID string, amount float64) error
+		Send(payment *models.QueuedPayment) error
+		SendBatch(payments []*models.QueuedPayment) ([]error, error)
+		AwaitResult(key string) (<-chan *models.PaymentResult, func())
+		Status(key string) (*models.PaymentResult, error)
+		Cancel(key string) (*models.PaymentResult, error)
+		Refund(key string, amount float64) (*models.Refund, error)
+		Scheduled(merchantID, clientID string, limit int) ([]*models.QueuedPayment, error)
 	}
 	Health interface {
 		Start()
 	}
 	Summary interface {
-		GetSummary(from, to *time.Time) (*models.PaymentSummaryResponse, error)
+		GetSummary(merchantID string, from, to *time.Time) (*models.PaymentSummaryResponse, error)
+	}
+	Webhook interface {
+		Deliveries(correlationID string) ([]*models.WebhookDelivery, error)
+		DeliveryLog(id string) (*models.WebhookDeliveryLog, error)
+		Redeliver(id string) (*models.WebhookDelivery, error)
+	}
+	Events interface {
+		Subscribe(filter EventFilter, after string) (<-chan *models.PaymentEvent, func(), error)
+	}
+	Metrics interface {
+		Render() []byte
+	}
+	RateLimit interface {
+		Allow(identity string) (*store.RateLimitResult, error)
+		AllowN(identity string, count int) (*store.RateLimitResult, error)
+		AllowSource(ip string) (*store.RateLimitResult, error)
+	}
+	Idempotency interface {
+		Claim(key, requestHash string) (*store.IdempotentResponse, error)
+		Save(key, requestHash string, statusCode int, body []byte) error
+		Release(key string) error
+	}
+	Auth interface {
+		Authenticate(apiKey, scope string) (*models.APIClient, error)
+		CreateClient(req *models.APIClientRequest) (*models.APIKeyResponse, error)
+		RotateKey(id string) (*models.APIKeyResponse, error)
+		RevokeClient(id string) (*models.APIClient, error)
+		Client(id string) (*models.APIClient, error)
+		Clients() ([]*models.APIClient, error)
 	}
 }
 
-func NewServices(config *config.Config, store *store.RedisStore) *Service {
+func NewServices(config *config.Config, store store.Store) (*Service, error) {
+	metrics := NewMetrics()
+	processorClients := newProcessorClients(config)
+
+	var local *LocalQueue
+	switch config.QueueMode {
+	case constants.QueueModeRedis, "":
+	case constants.QueueModeLocal:
+		var err error
+		if local, err = NewLocalQueue(config, store); err != nil {
+			return nil, fmt.Errorf("failed to open local queue: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown queue mode %q", config.QueueMode)
+	}
+
 	health := HealthMonitorService{
-		config:     config,
-		store:      store,
-		httpClient: &fasthttp.Client{},
+		config:      config,
+		store:       store,
+		httpClients: processorClients,
 	}
 	health.Start()
 
-	payment := PaymentService{
+	guard := QueueGuard{
+		config: config,
+		store:  store,
+		local:  local,
+	}
+	guard.Start()
+	if local != nil {
+		metrics.Gauge("gateway_queue_depth", func() float64 {
+			return float64(local.Depth())
+		}, "lane", "local")
+	} else {
+		for _, priority := range constants.Priorities {
+			metrics.Gauge("gateway_queue_depth", func() float64 {
+				return float64(guard.LaneDepth(priority))
+			}, "lane", string(priority))
+		}
+	}
+
+	notifier := CompletionNotifier{
+		store:   store,
+		waiters: make(map[string][]chan *models.PaymentResult),
+	}
+	notifier.Start()
+
+	dialer := &callbackDialer{timeout: config.Webhook.Timeout, allowed: config.Webhook.AllowedHosts}
+	webhooks := WebhookService{
 		config:     config,
 		store:      store,
-		health:     &health,
-		httpClient: &fasthttp.Client{},
-		queue:      make(chan *models.QueuedPayment, config.MaxQueueSize),
+		httpClient: &fasthttp.Client{Dial: dialer.dial},
+	}
+	if config.Webhook.Enabled {
+		webhooks.Start()
+	}
+
+	events := EventStream{
+		config:      config,
+		store:       store,
+		pending:     make(chan *models.PaymentEvent, eventPublishBuffer),
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+	events.Start()
+
+	limiters := make(map[constants.PaymentMode]*ConcurrencyLimiter, len(config.Processors))
+	for _, processor := range config.Processors {
+		limiter := NewConcurrencyLimiter(LimiterOptions(config.Limiter))
+		limiters[processor.Name] = limiter
+
+		metrics.Gauge("gateway_concurrency_limit", func() float64 {
+			return float64(limiter.Limit())
+		}, "processor", string(processor.Name))
+		metrics.Gauge("gateway_concurrency_inflight", func() float64 {
+			return float64(limiter.Inflight())
+		}, "processor", string(processor.Name))
 	}
-	go payment.processQueue()
+
+	payment := PaymentService{
+		config:      config,
+		store:       store,
+		health:      &health,
+		guard:       &guard,
+		notifier:    &notifier,
+		webhooks:    &webhooks,
+		events:      &events,
+		metrics:     metrics,
+		limiters:    limiters,
+		httpClients: processorClients,
+		local:       local,
+	}
+	for range max(config.Workers, 1) {
+		go payment.processQueue()
+	}
+
+	scheduler := PaymentScheduler{
+		config:  config,
+		store:   store,
+		metrics: metrics,
+		local:   local,
+	}
+	scheduler.Start()
 
 	summary := SummaryService{
-		store: store,
+		store:  store,
+		config: config,
 	}
 
 	return &Service{
-		Payment: &payment,
-		Health:  &health,
-		Summary: &summary,
-	}
+		Payment:     &payment,
+		Health:      &health,
+		Summary:     &summary,
+		Webhook:     &webhooks,
+		Events:      &events,
+		Metrics:     metrics,
+		RateLimit:   NewRateLimitService(config, store),
+		Auth:        NewAuthService(store),
+		Idempotency: NewIdempotencyService(store, config.IdempotencyTTL),
+	}, nil
 }
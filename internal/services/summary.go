@@ -3,14 +3,51 @@ package services
 import (
 	"time"
 
+	"github.com/mochaeng/payment-gateway/internal/config"
 	"github.com/mochaeng/payment-gateway/internal/models"
 	"github.com/mochaeng/payment-gateway/internal/store"
 )
 
 type SummaryService struct {
-	store *store.RedisStore
+	store  store.Store
+	config *config.Config
 }
 
-func (s *SummaryService) GetSummary(from, to *time.Time) (*models.PaymentSummaryResponse, error) {
-	return s.store.GetSummary(from, to)
+// GetSummary returns the summary of the processors a merchant may use,
+// restricted to its payments. An empty merchantID gives the global summary.
+// The top level totals are in the default currency, and each processor also
+// carries a breakdown by configured currency, since amounts in different
+// currencies cannot be added up.
+func (s *SummaryService) GetSummary(merchantID string, from, to *time.Time) (*models.PaymentSummaryResponse, error) {
+	processors := s.config.ProcessorNames(merchantID)
+
+	summary, err := s.store.GetSummary(merchantID, processors, "", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, processor := range *summary {
+		processor.Currencies = map[string]models.SummaryTotals{
+			s.config.DefaultCurrency: processor.SummaryTotals,
+		}
+		(*summary)[name] = processor
+	}
+
+	for _, currency := range s.config.CurrencyCodes() {
+		if currency == s.config.DefaultCurrency {
+			continue
+		}
+
+		scoped, err := s.store.GetSummary(merchantID, processors, currency, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for name, totals := range *scoped {
+			if processor, ok := (*summary)[name]; ok && totals.TotalRequest > 0 {
+				processor.Currencies[currency] = totals.SummaryTotals
+			}
+		}
+	}
+
+	return summary, nil
 }
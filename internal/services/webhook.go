@@ -0,0 +1,259 @@
+package services
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/store"
+	"github.com/mochaeng/payment-gateway/internal/utils"
+	"github.com/valyala/fasthttp"
+)
+
+var ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+// WebhookService delivers payment outcomes to the callback URL given by the
+// client, signed with the secret of its API client. Deliveries live in the
+// store and are retried with exponential backoff by whichever instance claims
+// them first.
+type WebhookService struct {
+	store      store.Store
+	config     *config.Config
+	httpClient *fasthttp.Client
+}
+
+func (w *WebhookService) Start() {
+	go w.deliveryLoop()
+}
+
+// Notify schedules the delivery of a payment result to its callback URL. It
+// does nothing for payments without one, or when webhooks are disabled.
+func (w *WebhookService) Notify(payment *models.QueuedPayment, result *models.PaymentResult) error {
+	if !w.config.Webhook.Enabled || payment.CallbackURL == "" {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	event := models.WebhookEvent{
+		ID:        utils.NewID(),
+		Type:      "payment." + string(result.Status),
+		CreatedAt: now,
+		Data:      result,
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %w", err)
+	}
+
+	return w.store.SaveWebhookDelivery(&models.WebhookDelivery{
+		ID:            event.ID,
+		CorrelationID: payment.CorrelationID,
+		ClientID:      payment.ClientID,
+		URL:           payment.CallbackURL,
+		Event:         event.Type,
+		Payload:       payload,
+		Status:        constants.WebhookPending,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	})
+}
+
+func (w *WebhookService) Deliveries(correlationID string) ([]*models.WebhookDelivery, error) {
+	return w.store.ListWebhookDeliveries(correlationID)
+}
+
+func (w *WebhookService) DeliveryLog(id string) (*models.WebhookDeliveryLog, error) {
+	delivery, err := w.store.GetWebhookDelivery(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+
+	attempts, err := w.store.GetWebhookAttempts(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.WebhookDeliveryLog{
+		Delivery: delivery,
+		Attempts: attempts,
+	}, nil
+}
+
+// Redeliver schedules a delivery again right away with a fresh retry budget,
+// whatever its current status.
+func (w *WebhookService) Redeliver(id string) (*models.WebhookDelivery, error) {
+	delivery, err := w.store.GetWebhookDelivery(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+
+	delivery.Status = constants.WebhookPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now().UTC()
+
+	if err := w.store.SaveWebhookDelivery(delivery); err != nil {
+		return nil, fmt.Errorf("failed to save webhook delivery: %w", err)
+	}
+
+	return delivery, nil
+}
+
+// deliveryLoop claims no more deliveries than it attempts at once, so the
+// lease only has to outlast a single attempt: a claimed delivery is never
+// left waiting behind the others until its lease expires and another
+// instance sends it again.
+func (w *WebhookService) deliveryLoop() {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	concurrency := max(w.config.Webhook.Concurrency, 1)
+	for range ticker.C {
+		ids, err := w.store.ClaimDueWebhooks(2*w.config.Webhook.Timeout, concurrency)
+		if err != nil {
+			fmt.Printf("Failed to claim webhook deliveries: %s\n", err)
+			continue
+		}
+
+		var wg sync.WaitGroup
+		for _, id := range ids {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := w.deliver(id); err != nil {
+					fmt.Printf("Failed to deliver webhook [%s]: %s\n", id, err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func (w *WebhookService) deliver(id string) error {
+	delivery, err := w.store.GetWebhookDelivery(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return w.store.DropWebhookDelivery(id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if delivery.Status != constants.WebhookPending {
+		return w.store.DropWebhookDelivery(id)
+	}
+
+	secret, err := w.secret(delivery)
+	if err != nil {
+		return err
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	timestamp := time.Now().Unix()
+
+	req.SetRequestURI(delivery.URL)
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType("application/json")
+	req.Header.Set("X-Webhook-Id", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Signature", SignWebhook(secret, timestamp, delivery.Payload))
+	req.SetBody(delivery.Payload)
+
+	start := time.Now()
+	err = w.httpClient.DoTimeout(req, resp, w.config.Webhook.Timeout)
+
+	delivery.Attempts++
+	attempt := models.WebhookAttempt{
+		Attempt:    delivery.Attempts,
+		DurationMs: time.Since(start).Milliseconds(),
+		At:         start.UTC(),
+	}
+
+	switch {
+	case err != nil:
+		attempt.Error = err.Error()
+	case resp.StatusCode() < 200 || resp.StatusCode() >= 300:
+		attempt.StatusCode = resp.StatusCode()
+		attempt.Error = fmt.Sprintf("receiver answered with status code [%d]", resp.StatusCode())
+	default:
+		attempt.StatusCode = resp.StatusCode()
+	}
+
+	if err := w.store.AppendWebhookAttempt(delivery.ID, attempt); err != nil {
+		fmt.Printf("Failed to log webhook attempt [%s]: %s\n", delivery.ID, err)
+	}
+
+	now := time.Now().UTC()
+	switch {
+	case attempt.Error == "":
+		delivery.Status = constants.WebhookDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= w.config.Webhook.MaxAttempts, errors.Is(err, ErrForbiddenCallback):
+		delivery.Status = constants.WebhookFailed
+		delivery.LastError = attempt.Error
+	default:
+		delivery.LastError = attempt.Error
+		delivery.NextAttemptAt = now.Add(w.backoff(delivery.Attempts))
+	}
+
+	return w.store.SaveWebhookDelivery(delivery)
+}
+
+// secret returns the secret signing a delivery: that of the API client of the
+// payment, or the gateway-wide one for payments without a client or made by
+// a client that has none.
+func (w *WebhookService) secret(delivery *models.WebhookDelivery) (string, error) {
+	if delivery.ClientID == "" {
+		return w.config.Webhook.Secret, nil
+	}
+
+	client, err := w.store.GetAPIClient(delivery.ClientID)
+	if errors.Is(err, store.ErrNotFound) {
+		return w.config.Webhook.Secret, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get api client: %w", err)
+	}
+	return cmp.Or(client.WebhookSecret, w.config.Webhook.Secret), nil
+}
+
+func (w *WebhookService) backoff(attempts int) time.Duration {
+	backoff := w.config.Webhook.Backoff << min(attempts-1, 30)
+	if backoff <= 0 || backoff > w.config.Webhook.MaxBackoff {
+		return w.config.Webhook.MaxBackoff
+	}
+	return backoff
+}
+
+// SignWebhook computes the X-Webhook-Signature header value. Receivers verify
+// it by computing HMAC-SHA256 over "<t>.<body>" with the shared secret.
+func SignWebhook(secret string, timestamp int64, payload []byte) string {
+	t := strconv.FormatInt(timestamp, 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
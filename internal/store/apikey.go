@@ -0,0 +1,83 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/mochaeng/payment-gateway/internal/models"
+)
+
+const (
+	apiClientPrefix = "apiclient:"
+	apiKeyPrefix    = "apikey:"
+
+	apiClientsKey = "apiclients"
+)
+
+func (r *RedisStore) SaveAPIClient(client *models.APIClient) error {
+	data, err := json.Marshal(client)
+	if err != nil {
+		return fmt.Errorf("failed to marshal api client: %w", err)
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.Set(r.ctx, r.key(apiClientsTag, apiClientPrefix+client.ID), data, 0)
+	pipe.SAdd(r.ctx, r.key(apiClientsTag, apiClientsKey), client.ID)
+	_, err = pipe.Exec(r.ctx)
+	return err
+}
+
+func (r *RedisStore) GetAPIClient(id string) (*models.APIClient, error) {
+	data, err := r.client.Get(r.ctx, r.key(apiClientsTag, apiClientPrefix+id)).Bytes()
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	var client models.APIClient
+	err = json.Unmarshal(data, &client)
+	return &client, err
+}
+
+func (r *RedisStore) ListAPIClients() ([]*models.APIClient, error) {
+	ids, err := r.client.SMembers(r.ctx, r.key(apiClientsTag, apiClientsKey)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api clients: %w", err)
+	}
+
+	clients := make([]*models.APIClient, 0, len(ids))
+	for _, id := range ids {
+		client, err := r.GetAPIClient(id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+// ReplaceAPIKey points keyHash at the client and drops oldKeyHash, if any, in
+// a single transaction so a rotated key stops working immediately.
+func (r *RedisStore) ReplaceAPIKey(clientID, keyHash, oldKeyHash string) error {
+	pipe := r.client.TxPipeline()
+	if oldKeyHash != "" {
+		pipe.Del(r.ctx, r.key(apiClientsTag, apiKeyPrefix+oldKeyHash))
+	}
+	if keyHash != "" {
+		pipe.Set(r.ctx, r.key(apiClientsTag, apiKeyPrefix+keyHash), clientID, 0)
+	}
+	_, err := pipe.Exec(r.ctx)
+	return err
+}
+
+func (r *RedisStore) GetAPIClientByKeyHash(keyHash string) (*models.APIClient, error) {
+	clientID, err := r.client.Get(r.ctx, r.key(apiClientsTag, apiKeyPrefix+keyHash)).Result()
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return r.GetAPIClient(clientID)
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const idempotencyPrefix = "idempotency:"
+
+// IdempotentResponse is the response recorded for an Idempotency-Key. A zero
+// StatusCode means the first request using the key is still in progress.
+type IdempotentResponse struct {
+	RequestHash string `json:"requestHash"`
+	StatusCode  int    `json:"statusCode"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// enqueueBatchScript admits a batch of payments as enqueueScript admits one,
+// in a single atomic step. The first ARGV[1] keys are the lanes the depth is
+// taken over, followed by the lane and the status key of each payment, whose
+// encoding and queued status follow the limits, roll and status TTL in ARGV.
+// The batch shares one roll, so once a payment is shed the rest of the batch
+// is too. The script returns the final depth followed by the admission of
+// each payment.
+var enqueueBatchScript = redis.NewScript(`
+	local lanes = tonumber(ARGV[1])
+	local hard = tonumber(ARGV[2])
+	local soft = tonumber(ARGV[3])
+	local roll = tonumber(ARGV[4])
+	local depth = 0
+	for i = 1, lanes do
+		depth = depth + redis.call('LLEN', KEYS[i])
+	end
+
+	local result = {0}
+	for i = 1, (#KEYS - lanes) / 2 do
+		local lane = KEYS[lanes + 2 * i - 1]
+		local statusKey = KEYS[lanes + 2 * i]
+		local admission = 0
+		local current = redis.call('GET', statusKey)
+		if current then
+			local status = cjson.decode(current).status
+			if status == 'scheduled' or status == 'queued' or status == 'processing' or status == 'succeeded' or status == 'refunded' then
+				admission = 3
+			end
+		end
+		if admission == 0 then
+			if depth >= hard then
+				admission = 2
+			elseif depth >= soft and hard > soft and roll < (depth - soft) / (hard - soft) then
+				admission = 1
+			else
+				redis.call('LPUSH', lane, ARGV[4 + 2 * i])
+				redis.call('SET', statusKey, ARGV[5 + 2 * i], 'EX', ARGV[5])
+				depth = depth + 1
+			end
+		end
+		result[i + 1] = admission
+	end
+	result[1] = depth
+	return result
+`)
+
+// EnqueuePayments enqueues a batch of payments in one round trip, applying the
+// limits of EnqueuePaymentWithLimit to each of them in turn. It returns the
+// admission of every payment and the queue depth once the batch is admitted.
+func (r *RedisStore) EnqueuePayments(payments []*models.QueuedPayment, hardLimit, softLimit int, roll float64) ([]QueueAdmission, int64, error) {
+	admissions := make([]QueueAdmission, len(payments))
+	if len(payments) == 0 {
+		return admissions, 0, nil
+	}
+
+	lanes := r.queueKeys(constants.Priorities)
+	keys := make([]string, 0, len(lanes)+2*len(payments))
+	keys = append(keys, lanes...)
+	args := make([]any, 0, 5+2*len(payments))
+	args = append(args, len(lanes), hardLimit, softLimit, roll, int(paymentStatusTTL.Seconds()))
+	for _, payment := range payments {
+		status, err := queuedStatus(payment)
+		if err != nil {
+			return nil, 0, err
+		}
+		keys = append(keys, r.queueKey(payment.Priority), r.statusKey(payment.Key()))
+		args = append(args, payment.AppendJSON(nil), status)
+	}
+
+	result, err := enqueueBatchScript.Run(r.ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to enqueue payments: %w", outOfMemory(err))
+	}
+	if len(result) != len(payments)+1 {
+		return nil, 0, fmt.Errorf("invalid enqueue result")
+	}
+
+	for i := range admissions {
+		admissions[i] = QueueAdmission(result[i+1])
+	}
+	return admissions, result[0], nil
+}
+
+// ClaimIdempotencyKey reserves an idempotency key for a request. When the key
+// was already used, the response recorded for it is returned instead.
+func (r *RedisStore) ClaimIdempotencyKey(key, requestHash string, ttl time.Duration) (*IdempotentResponse, error) {
+	pending, err := json.Marshal(IdempotentResponse{RequestHash: requestHash})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal idempotent response: %w", err)
+	}
+
+	claimed, err := r.client.SetNX(r.ctx, idempotencyPrefix+key, pending, ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if claimed {
+		return nil, nil
+	}
+
+	data, err := r.client.Get(r.ctx, idempotencyPrefix+key).Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotent response: %w", err)
+	}
+
+	var response IdempotentResponse
+	err = json.Unmarshal(data, &response)
+	return &response, err
+}
+
+func (r *RedisStore) SaveIdempotentResponse(key string, response *IdempotentResponse, ttl time.Duration) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotent response: %w", err)
+	}
+
+	return r.client.Set(r.ctx, idempotencyPrefix+key, data, ttl).Err()
+}
+
+// ReleaseIdempotencyKey forgets a claimed key, so a request that failed before
+// producing a response can be retried with it.
+func (r *RedisStore) ReleaseIdempotencyKey(key string) error {
+	return r.client.Del(r.ctx, idempotencyPrefix+key).Err()
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	eventStreamKey = "payment_events"
+	eventField     = "event"
+)
+
+// AppendEvents adds events to the stream in order, trimming it to about
+// maxLen events. Like the other writes of payment workers, they go through
+// the shared pipeline.
+func (r *RedisStore) AppendEvents(events []*models.PaymentEvent, maxLen int64) error {
+	data := make([][]byte, len(events))
+	for i, event := range events {
+		encoded, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payment event: %w", err)
+		}
+		data[i] = encoded
+	}
+
+	return r.writes.Do(func(pipe redis.Pipeliner) {
+		for _, event := range data {
+			pipe.XAdd(r.ctx, &redis.XAddArgs{
+				Stream: eventStreamKey,
+				MaxLen: maxLen,
+				Approx: true,
+				Values: []any{eventField, event},
+			})
+		}
+	})
+}
+
+// ReadEvents returns up to count events following the one with the given ID,
+// waiting up to block for one to be appended when there are none. Events
+// trimmed from the stream are skipped. It returns no events, and no error,
+// when the wait times out.
+func (r *RedisStore) ReadEvents(after string, count int, block time.Duration) ([]*models.PaymentEvent, error) {
+	if !validStreamID(after) {
+		return nil, ErrInvalidEventID
+	}
+
+	// A zero Block waits forever, a negative one not at all.
+	if block <= 0 {
+		block = -1
+	}
+
+	streams, err := r.client.XRead(r.ctx, &redis.XReadArgs{
+		Streams: []string{eventStreamKey, after},
+		Count:   int64(count),
+		Block:   block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payment events: %w", err)
+	}
+
+	var events []*models.PaymentEvent
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			data, _ := message.Values[eventField].(string)
+
+			var event models.PaymentEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				continue
+			}
+			event.ID = message.ID
+			events = append(events, &event)
+		}
+	}
+	return events, nil
+}
+
+// LastEventID returns the ID of the last event of the stream, or 0-0 when it
+// is empty.
+func (r *RedisStore) LastEventID() (string, error) {
+	messages, err := r.client.XRevRangeN(r.ctx, eventStreamKey, "+", "-", 1).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to get last payment event: %w", err)
+	}
+	if len(messages) == 0 {
+		return "0-0", nil
+	}
+	return messages[0].ID, nil
+}
+
+// validStreamID reports whether id is a stream ID, milliseconds optionally
+// followed by a sequence number.
+func validStreamID(id string) bool {
+	ms, seq, found := strings.Cut(id, "-")
+	if _, err := strconv.ParseUint(ms, 10, 64); err != nil {
+		return false
+	}
+	if found {
+		if _, err := strconv.ParseUint(seq, 10, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
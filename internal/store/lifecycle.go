@@ -0,0 +1,230 @@
+package store
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	refundsPrefix       = "payment:refunds:"
+	refundRecordsSuffix = ":refund_records"
+	refundedAmountKey   = "refunded_amount:"
+)
+
+type RefundOutcome int
+
+const (
+	RefundApplied RefundOutcome = iota
+	RefundNotFound
+	RefundNotAllowed
+	RefundExceedsAmount
+)
+
+// transitionScript moves the status record in KEYS[1] to the status ARGV[1]
+// when its current status is one of ARGV[3...], stamping ARGV[2] as update
+// time. It returns whether the record moved, with the resulting record, or
+// -1 when there is no record.
+var transitionScript = redis.NewScript(`
+	local current = redis.call('GET', KEYS[1])
+	if not current then
+		return {-1}
+	end
+	local payment = cjson.decode(current)
+	for i = 3, #ARGV do
+		if payment.status == ARGV[i] then
+			payment.status = ARGV[1]
+			payment.updatedAt = ARGV[2]
+			local updated = cjson.encode(payment)
+			redis.call('SET', KEYS[1], updated, 'KEEPTTL')
+			return {1, updated}
+		end
+	end
+	return {0, current}
+`)
+
+// refundScript refunds ARGV[1] from the succeeded payment whose status record
+// is KEYS[1], or whatever is left to refund when ARGV[1] is not positive. The
+// refund is appended to KEYS[2]. A fully refunded payment moves to the
+// refunded status. The summaries live in other slots and are updated by the
+// caller.
+var refundScript = redis.NewScript(`
+	local current = redis.call('GET', KEYS[1])
+	if not current then
+		return {1}
+	end
+	local payment = cjson.decode(current)
+	if payment.status ~= 'succeeded' then
+		return {2, current}
+	end
+
+	local refunded = tonumber(payment.refundedAmount) or 0
+	local remaining = payment.amount - refunded
+	local amount = tonumber(ARGV[1])
+	if amount <= 0 then
+		amount = remaining
+	end
+	if amount <= 0 or amount > remaining + 1e-9 then
+		return {3, current}
+	end
+
+	payment.refundedAmount = refunded + amount
+	if remaining - amount <= 1e-9 then
+		payment.status = 'refunded'
+	end
+	payment.updatedAt = ARGV[3]
+	local updated = cjson.encode(payment)
+	redis.call('SET', KEYS[1], updated, 'KEEPTTL')
+
+	local refund = cjson.encode({
+		id = ARGV[2],
+		correlationId = payment.correlationId,
+		merchantId = payment.merchantId,
+		processor = payment.processor,
+		amount = amount,
+		currency = payment.currency,
+		createdAt = ARGV[3],
+	})
+	redis.call('RPUSH', KEYS[2], refund)
+	return {0, updated, refund}
+`)
+
+// isActive reports whether a payment with the given status is still being
+// handled or went through, so the same payment cannot be submitted again.
+func isActive(status constants.PaymentStatus) bool {
+	switch status {
+	case constants.PaymentScheduled, constants.PaymentQueued, constants.PaymentProcessing,
+		constants.PaymentSucceeded, constants.PaymentRefunded:
+		return true
+	default:
+		return false
+	}
+}
+
+// refundKeys returns the refund records and refunded amount keys of a
+// processor, scoped like summaryKeys.
+func (r *RedisStore) refundKeys(merchantID string, processor constants.PaymentMode, currency string) (string, string) {
+	scope, suffix := "", ""
+	if merchantID != "" {
+		scope = merchantPrefix + merchantID + ":"
+	}
+	if currency != "" {
+		suffix = ":" + currency
+	}
+
+	tag := summaryTag(merchantID)
+	return r.key(tag, fmt.Sprintf("%s%s%s%s%s", scope, paymentPrefix, processor, suffix, refundRecordsSuffix)),
+		r.key(tag, fmt.Sprintf("%s%s%s%s%s", scope, summaryPrefix, refundedAmountKey, processor, suffix))
+}
+
+// TransitionPayment moves the status of a payment to `to` if it currently is
+// one of from. It returns the resulting status record and whether it moved, or
+// ErrNotFound when the payment has no status record.
+func (r *RedisStore) TransitionPayment(key string, to constants.PaymentStatus, from ...constants.PaymentStatus) (*models.PaymentResult, bool, error) {
+	args := []any{string(to), time.Now().UTC().Format(time.RFC3339Nano)}
+	for _, status := range from {
+		args = append(args, string(status))
+	}
+
+	result, err := transitionScript.Run(r.ctx, r.client, []string{r.statusKey(key)}, args...).Slice()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to transition payment: %w", err)
+	}
+
+	moved, _ := result[0].(int64)
+	if moved < 0 {
+		return nil, false, ErrNotFound
+	}
+
+	payment, err := decodeResult(result, 1)
+	return payment, moved == 1, err
+}
+
+// RefundPayment records a refund of amount, or of the whole remaining amount
+// when it is not positive, against a succeeded payment. Refunds are summarized
+// in the currency scope given by CurrencyScope.
+func (r *RedisStore) RefundPayment(key, refundID string, amount float64, defaultCurrency string) (RefundOutcome, *models.Refund, *models.PaymentResult, error) {
+	current, err := r.GetPaymentStatus(key)
+	if errors.Is(err, ErrNotFound) {
+		return RefundNotFound, nil, nil, nil
+	}
+	if err != nil {
+		return RefundNotFound, nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	keys := []string{r.statusKey(key), r.key(paymentsTag, refundsPrefix+key)}
+	result, err := refundScript.Run(r.ctx, r.client, keys, amount, refundID, now.Format(time.RFC3339Nano)).Slice()
+	if err != nil {
+		return RefundNotFound, nil, nil, fmt.Errorf("failed to refund payment: %w", err)
+	}
+
+	code, _ := result[0].(int64)
+	if code != int64(RefundApplied) {
+		payment, err := decodeResult(result, 1)
+		return RefundOutcome(code), nil, payment, err
+	}
+
+	payment, err := decodeResult(result, 1)
+	if err != nil {
+		return RefundApplied, nil, nil, err
+	}
+
+	data, _ := result[2].(string)
+	var refund models.Refund
+	if err := json.Unmarshal([]byte(data), &refund); err != nil {
+		return RefundApplied, nil, payment, fmt.Errorf("failed to decode refund: %w", err)
+	}
+
+	currency := CurrencyScope(cmp.Or(current.Currency, defaultCurrency), defaultCurrency)
+	if err := r.recordRefund(current.MerchantID, constants.PaymentMode(current.Processor), currency, refund.Amount, now); err != nil {
+		fmt.Printf("Failed to record refund [%s] in the summaries: %s\n", refund.ID, err)
+	}
+	return RefundApplied, &refund, payment, nil
+}
+
+// recordRefund adds a refund to the global summary and, when the payment
+// belongs to a merchant, to that merchant's summary, each in its own
+// transaction since they live in different slots in cluster mode.
+func (r *RedisStore) recordRefund(merchantID string, processor constants.PaymentMode, currency string, amount float64, at time.Time) error {
+	record, err := json.Marshal(map[string]any{"amount": amount, "timestamp": at.Unix()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal refund record: %w", err)
+	}
+	member := strconv.FormatInt(at.UnixNano(), 10) + ":" + string(record)
+
+	scopes := []string{""}
+	if merchantID != "" {
+		scopes = append(scopes, merchantID)
+	}
+
+	_, err = r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, scope := range scopes {
+			recordsKey, amountKey := r.refundKeys(scope, processor, currency)
+			pipe.ZAdd(r.ctx, recordsKey, redis.Z{Score: float64(at.Unix()), Member: member})
+			pipe.IncrByFloat(r.ctx, amountKey, amount)
+		}
+		return nil
+	})
+	return err
+}
+
+func decodeResult(result []any, index int) (*models.PaymentResult, error) {
+	if len(result) <= index {
+		return nil, nil
+	}
+
+	data, _ := result[index].(string)
+	var payment models.PaymentResult
+	if err := json.Unmarshal([]byte(data), &payment); err != nil {
+		return nil, fmt.Errorf("failed to decode payment status: %w", err)
+	}
+	return &payment, nil
+}
@@ -0,0 +1,157 @@
+-- Payments, one row per payment key, hold the latest status record. Unlike
+-- the Redis status records they never expire.
+CREATE TABLE payments (
+    key             text PRIMARY KEY,
+    correlation_id  text NOT NULL,
+    merchant_id     text NOT NULL DEFAULT '',
+    status          text NOT NULL,
+    processor       text NOT NULL DEFAULT '',
+    amount          numeric NOT NULL,
+    currency        text NOT NULL DEFAULT '',
+    refunded_amount numeric NOT NULL DEFAULT 0,
+    reason          text NOT NULL DEFAULT '',
+    created_at      timestamptz NOT NULL DEFAULT now(),
+    updated_at      timestamptz NOT NULL
+);
+
+CREATE INDEX payments_correlation_id ON payments (correlation_id);
+
+CREATE TABLE refunds (
+    id             text PRIMARY KEY,
+    payment_key    text NOT NULL REFERENCES payments (key),
+    correlation_id text NOT NULL,
+    merchant_id    text NOT NULL DEFAULT '',
+    processor      text NOT NULL,
+    amount         numeric NOT NULL,
+    currency       text NOT NULL DEFAULT '',
+    created_at     timestamptz NOT NULL
+);
+
+CREATE INDEX refunds_payment_key ON refunds (payment_key);
+
+-- The queue. Workers take rows with FOR UPDATE SKIP LOCKED, so instances
+-- never block on each other, and inserts wake them through NOTIFY.
+CREATE TABLE payment_queue (
+    id       bigserial PRIMARY KEY,
+    priority text NOT NULL,
+    payment  text NOT NULL
+);
+
+CREATE INDEX payment_queue_priority ON payment_queue (priority, id);
+
+CREATE FUNCTION notify_payment_queue() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('payment_queue', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER payment_queue_notify AFTER INSERT ON payment_queue
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_payment_queue();
+
+CREATE TABLE dead_letters (
+    id         bigserial PRIMARY KEY,
+    payment    text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE scheduled_payments (
+    key     text PRIMARY KEY,
+    due_at  timestamptz NOT NULL,
+    payment text NOT NULL
+);
+
+CREATE INDEX scheduled_payments_due_at ON scheduled_payments (due_at);
+
+-- Payments dequeued per second, for the drain rate.
+CREATE TABLE queue_drained (
+    second bigint PRIMARY KEY,
+    count  bigint NOT NULL
+);
+
+CREATE TABLE processed_payments (
+    key        text PRIMARY KEY,
+    processor  text NOT NULL,
+    expires_at timestamptz NOT NULL
+);
+
+-- Summaries: one record per successful payment or refund for time filtered
+-- summaries, and running totals per scope, a merchant or '' for all of them.
+-- currency is the scope given by CurrencyScope.
+CREATE TABLE summary_records (
+    id          bigserial PRIMARY KEY,
+    merchant_id text NOT NULL,
+    processor   text NOT NULL,
+    currency    text NOT NULL,
+    amount      numeric NOT NULL,
+    refund      boolean NOT NULL,
+    created_at  timestamptz NOT NULL
+);
+
+CREATE INDEX summary_records_processor ON summary_records (processor, currency, created_at);
+CREATE INDEX summary_records_merchant ON summary_records (merchant_id, processor, currency, created_at);
+
+CREATE TABLE summary_totals (
+    scope           text NOT NULL,
+    processor       text NOT NULL,
+    currency        text NOT NULL,
+    total_amount    numeric NOT NULL DEFAULT 0,
+    total_count     bigint NOT NULL DEFAULT 0,
+    refunded_amount numeric NOT NULL DEFAULT 0,
+    PRIMARY KEY (scope, processor, currency)
+);
+
+CREATE TABLE processor_health (
+    processor         text PRIMARY KEY,
+    failing           boolean NOT NULL,
+    min_response_time integer NOT NULL,
+    last_checked      timestamptz NOT NULL
+);
+
+CREATE TABLE idempotency_keys (
+    key          text PRIMARY KEY,
+    request_hash text NOT NULL,
+    status_code  integer NOT NULL DEFAULT 0,
+    body         bytea,
+    expires_at   timestamptz NOT NULL
+);
+
+CREATE TABLE rate_limits (
+    key        text PRIMARY KEY,
+    tokens     double precision NOT NULL,
+    updated_ms bigint NOT NULL,
+    expires_at timestamptz NOT NULL
+);
+
+CREATE TABLE api_clients (
+    id     text PRIMARY KEY,
+    client jsonb NOT NULL
+);
+
+CREATE TABLE api_keys (
+    key_hash  text PRIMARY KEY,
+    client_id text NOT NULL
+);
+
+-- Deliveries are due once claimable_at passes; it is null for deliveries
+-- that are not pending.
+CREATE TABLE webhook_deliveries (
+    id             text PRIMARY KEY,
+    correlation_id text NOT NULL,
+    delivery       jsonb NOT NULL,
+    claimable_at   timestamptz,
+    updated_at     timestamptz NOT NULL
+);
+
+CREATE INDEX webhook_deliveries_correlation_id ON webhook_deliveries (correlation_id);
+CREATE INDEX webhook_deliveries_claimable_at ON webhook_deliveries (claimable_at)
+    WHERE claimable_at IS NOT NULL;
+
+CREATE TABLE webhook_attempts (
+    id          bigserial PRIMARY KEY,
+    delivery_id text NOT NULL,
+    attempt     jsonb NOT NULL,
+    created_at  timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX webhook_attempts_delivery_id ON webhook_attempts (delivery_id, id);
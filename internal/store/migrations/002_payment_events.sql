@@ -0,0 +1,17 @@
+-- The payment event stream. Ids order the events; inserts wake the readers
+-- waiting for new events through NOTIFY.
+CREATE TABLE payment_events (
+    id         bigserial PRIMARY KEY,
+    event      jsonb NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE FUNCTION notify_payment_events() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('payment_events', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER payment_events_notify AFTER INSERT ON payment_events
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_payment_events();
@@ -0,0 +1,5 @@
+-- Health forced by an operator, kept by the health monitor until forced_until,
+-- or until released when it is null.
+ALTER TABLE processor_health
+    ADD COLUMN forced       boolean NOT NULL DEFAULT false,
+    ADD COLUMN forced_until timestamptz;
@@ -0,0 +1,4 @@
+-- The API client that submitted a payment, which alone may act on it. Payments
+-- submitted without an API key, or before the column existed, have none.
+ALTER TABLE payments
+    ADD COLUMN client_id text NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+-- Scheduled payments are listed per merchant, the soonest due first.
+ALTER TABLE scheduled_payments
+    ADD COLUMN merchant_id text NOT NULL DEFAULT '';
+
+UPDATE scheduled_payments SET merchant_id = payments.merchant_id
+    FROM payments WHERE payments.key = scheduled_payments.key;
+
+CREATE INDEX scheduled_payments_merchant_due_at ON scheduled_payments (merchant_id, due_at);
@@ -0,0 +1,33 @@
+-- The queue depth, kept by triggers so admissions read it instead of counting
+-- the queue. Admissions lock the row while they check the limits and insert,
+-- which makes the two atomic.
+LOCK TABLE payment_queue IN SHARE ROW EXCLUSIVE MODE;
+
+CREATE TABLE queue_depth (
+    id    boolean PRIMARY KEY DEFAULT true CHECK (id),
+    depth bigint NOT NULL
+);
+
+INSERT INTO queue_depth (depth) SELECT count(*) FROM payment_queue;
+
+CREATE FUNCTION count_payment_queue_inserts() RETURNS trigger AS $$
+BEGIN
+    UPDATE queue_depth SET depth = depth + (SELECT count(*) FROM inserted);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION count_payment_queue_deletes() RETURNS trigger AS $$
+BEGIN
+    UPDATE queue_depth SET depth = depth - (SELECT count(*) FROM deleted);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER payment_queue_count_inserts AFTER INSERT ON payment_queue
+    REFERENCING NEW TABLE AS inserted
+    FOR EACH STATEMENT EXECUTE FUNCTION count_payment_queue_inserts();
+
+CREATE TRIGGER payment_queue_count_deletes AFTER DELETE ON payment_queue
+    REFERENCING OLD TABLE AS deleted
+    FOR EACH STATEMENT EXECUTE FUNCTION count_payment_queue_deletes();
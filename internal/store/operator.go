@@ -0,0 +1,208 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// scanBatch is the number of keys asked for by each SCAN of deleteMatching.
+const scanBatch = 1000
+
+// recomputeAttempts bounds the retries of RecomputeSummary when payments keep
+// landing in the summary it recomputes.
+const recomputeAttempts = 5
+
+// PeekQueue returns up to limit payments of a lane without dequeuing them, the
+// next to be dequeued first.
+func (r *RedisStore) PeekQueue(priority constants.PaymentPriority, limit int) ([]*models.QueuedPayment, error) {
+	data, err := r.client.LRange(r.ctx, r.queueKey(priority), -int64(limit), -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to peek queue: %w", err)
+	}
+	slices.Reverse(data)
+	return decodePayments(data), nil
+}
+
+// PeekDeadLetters returns up to limit dead-lettered payments, the latest
+// first.
+func (r *RedisStore) PeekDeadLetters(limit int) ([]*models.QueuedPayment, error) {
+	data, err := r.client.LRange(r.ctx, r.key(paymentsTag, deadLetterKey), 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to peek dead letters: %w", err)
+	}
+	return decodePayments(data), nil
+}
+
+// RecomputeSummary rebuilds the totals of a summary from its records, scoped
+// like UpdateSummary. Should a payment or refund be recorded meanwhile, the
+// totals are computed again.
+func (r *RedisStore) RecomputeSummary(merchantID string, processor constants.PaymentMode, currency string) error {
+	recordsKey, totalAmountKey, totalCountKey := r.summaryKeys(merchantID, processor, currency)
+	refundRecordsKey, refundedAmountKey := r.refundKeys(merchantID, processor, currency)
+
+	recompute := func(tx *redis.Tx) error {
+		count, amount, err := sumRecords(r.ctx, tx, recordsKey, "-inf", "+inf")
+		if err != nil {
+			return err
+		}
+		_, refunded, err := sumRecords(r.ctx, tx, refundRecordsKey, "-inf", "+inf")
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(r.ctx, totalAmountKey, strconv.FormatFloat(amount, 'f', -1, 64), 0)
+			pipe.Set(r.ctx, totalCountKey, count, 0)
+			pipe.Set(r.ctx, refundedAmountKey, strconv.FormatFloat(refunded, 'f', -1, 64), 0)
+			return nil
+		})
+		return err
+	}
+
+	for range recomputeAttempts {
+		err := r.client.Watch(r.ctx, recompute, recordsKey, refundRecordsKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to recompute summary: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to recompute summary: %w", redis.TxFailedErr)
+}
+
+// PurgeQueue removes every queued payment, returning them.
+func (r *RedisStore) PurgeQueue() ([]*models.QueuedPayment, error) {
+	keys := r.queueKeys(constants.Priorities)
+
+	pipe := r.client.TxPipeline()
+	cmds := make([]*redis.StringSliceCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.LRange(r.ctx, key, 0, -1)
+	}
+	pipe.Del(r.ctx, keys...)
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return nil, fmt.Errorf("failed to purge queue: %w", err)
+	}
+
+	var data []string
+	for _, cmd := range cmds {
+		data = append(data, cmd.Val()...)
+	}
+	return decodePayments(data), nil
+}
+
+// PurgeScheduledPayments removes every pending scheduled payment, returning
+// them.
+func (r *RedisStore) PurgeScheduledPayments() ([]*models.QueuedPayment, error) {
+	setKey, dataKey := r.key(paymentsTag, scheduleKey), r.key(paymentsTag, scheduleDataKey)
+
+	pipe := r.client.TxPipeline()
+	values := pipe.HVals(r.ctx, dataKey)
+	pipe.Del(r.ctx, setKey, dataKey)
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return nil, fmt.Errorf("failed to purge scheduled payments: %w", err)
+	}
+	if _, err := r.deleteMatching(r.merchantScheduleKey("*")); err != nil {
+		return nil, fmt.Errorf("failed to purge merchant schedules: %w", err)
+	}
+	return decodePayments(values.Val()), nil
+}
+
+// PurgeDeadLetters removes every dead-lettered payment, returning how many
+// there were.
+func (r *RedisStore) PurgeDeadLetters() (int64, error) {
+	key := r.key(paymentsTag, deadLetterKey)
+
+	pipe := r.client.TxPipeline()
+	count := pipe.LLen(r.ctx, key)
+	pipe.Del(r.ctx, key)
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return 0, fmt.Errorf("failed to purge dead letters: %w", err)
+	}
+	return count.Val(), nil
+}
+
+// PurgeProcessorsHealth removes the health of every processor, returning how
+// many records were removed. The health monitor writes them again on its next
+// check.
+func (r *RedisStore) PurgeProcessorsHealth() (int64, error) {
+	deleted, err := r.deleteMatching(r.key(healthTag, healthPrefix+"*"))
+	if err != nil {
+		return deleted, fmt.Errorf("failed to purge processors health: %w", err)
+	}
+	return deleted, nil
+}
+
+// PurgeSummaries removes the records and totals of every summary, returning
+// how many keys were removed.
+func (r *RedisStore) PurgeSummaries() (int64, error) {
+	deleted, err := r.deleteMatching("*"+paymentPrefix+"*records", "*"+summaryPrefix+"*")
+	if err != nil {
+		return deleted, fmt.Errorf("failed to purge summaries: %w", err)
+	}
+	return deleted, nil
+}
+
+// deleteMatching deletes the keys matching any of the patterns, returning how
+// many it deleted. A cluster has every master scanned, as SCAN only covers the
+// node it is sent to.
+func (r *RedisStore) deleteMatching(patterns ...string) (int64, error) {
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return deleteMatching(r.ctx, r.client.(*redis.Client), patterns)
+	}
+
+	var deleted atomic.Int64
+	err := cluster.ForEachMaster(r.ctx, func(ctx context.Context, client *redis.Client) error {
+		count, err := deleteMatching(ctx, client, patterns)
+		deleted.Add(count)
+		return err
+	})
+	return deleted.Load(), err
+}
+
+// deleteMatching deletes the keys of one node matching any of the patterns.
+// Keys are deleted one by one, keys of different slots not being deletable
+// together on a cluster node.
+func deleteMatching(ctx context.Context, client *redis.Client, patterns []string) (int64, error) {
+	var deleted int64
+	for _, pattern := range patterns {
+		iter := client.Scan(ctx, 0, pattern, scanBatch).Iterator()
+		for {
+			var keys []string
+			for len(keys) < scanBatch && iter.Next(ctx) {
+				keys = append(keys, iter.Val())
+			}
+			if len(keys) == 0 {
+				break
+			}
+
+			pipe := client.Pipeline()
+			for _, key := range keys {
+				pipe.Del(ctx, key)
+			}
+			cmds, err := pipe.Exec(ctx)
+			for _, cmd := range cmds {
+				if del, ok := cmd.(*redis.IntCmd); ok {
+					deleted += del.Val()
+				}
+			}
+			if err != nil {
+				return deleted, err
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
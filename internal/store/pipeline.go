@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// writeBatchSize caps the requests sent in a single pipeline.
+	writeBatchSize = 128
+	// writeBatchFlushers is the number of pipelines that may be in flight at
+	// the same time.
+	writeBatchFlushers = 4
+)
+
+// writeBatcher groups the writes of concurrent callers into shared pipelines,
+// so that payments completing together cost one round trip instead of one
+// each. A flusher takes every request waiting when it becomes free, so
+// batches grow with the load and a lone request is sent right away.
+type writeBatcher struct {
+	client   redis.UniversalClient
+	ctx      context.Context
+	requests chan *writeRequest
+}
+
+type writeRequest struct {
+	queue func(pipe redis.Pipeliner)
+	done  chan error
+}
+
+func newWriteBatcher(ctx context.Context, client redis.UniversalClient) *writeBatcher {
+	b := &writeBatcher{
+		client:   client,
+		ctx:      ctx,
+		requests: make(chan *writeRequest, writeBatchSize*writeBatchFlushers),
+	}
+
+	for range writeBatchFlushers {
+		go b.run()
+	}
+	return b
+}
+
+// Do adds the commands queued by queue to the next pipeline and waits for it
+// to run, returning the first error of those commands. redis.Nil replies are
+// not errors here; callers read the replies from their own commands.
+func (b *writeBatcher) Do(queue func(pipe redis.Pipeliner)) error {
+	request := &writeRequest{queue: queue, done: make(chan error, 1)}
+	b.requests <- request
+	return <-request.done
+}
+
+func (b *writeBatcher) run() {
+	batch := make([]*writeRequest, 0, writeBatchSize)
+	for request := range b.requests {
+		batch = append(batch[:0], request)
+	fill:
+		for len(batch) < writeBatchSize {
+			select {
+			case request := <-b.requests:
+				batch = append(batch, request)
+			default:
+				break fill
+			}
+		}
+
+		b.flush(batch)
+	}
+}
+
+// flush runs a batch. Pipelined scripts are sent with EVALSHA only, so
+// requests failing with NOSCRIPT, after Redis restarted or failed over, are
+// retried once with the scripts loaded again.
+func (b *writeBatcher) flush(batch []*writeRequest) {
+	errs := b.exec(batch)
+
+	var retry []*writeRequest
+	for i, request := range batch {
+		if redis.HasErrorPrefix(errs[i], "NOSCRIPT") {
+			retry = append(retry, request)
+			continue
+		}
+		request.done <- errs[i]
+	}
+	if len(retry) == 0 {
+		return
+	}
+
+	if err := loadScripts(b.ctx, b.client); err != nil {
+		for _, request := range retry {
+			request.done <- err
+		}
+		return
+	}
+
+	errs = b.exec(retry)
+	for i, request := range retry {
+		request.done <- errs[i]
+	}
+}
+
+// exec runs the batch in one pipeline and returns the error of each request.
+func (b *writeBatcher) exec(batch []*writeRequest) []error {
+	pipe := b.client.Pipeline()
+	ends := make([]int, len(batch))
+	for i, request := range batch {
+		request.queue(pipe)
+		ends[i] = pipe.Len()
+	}
+
+	cmds, _ := pipe.Exec(b.ctx)
+
+	errs := make([]error, len(batch))
+	start := 0
+	for i, end := range ends {
+		for _, cmd := range cmds[start:end] {
+			if err := cmd.Err(); err != nil && err != redis.Nil {
+				errs[i] = err
+				break
+			}
+		}
+		start = end
+	}
+	return errs
+}
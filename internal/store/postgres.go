@@ -0,0 +1,242 @@
+package store
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mochaeng/payment-gateway/internal/models"
+)
+
+const (
+	queueChannel   = "payment_queue"
+	resultsChannel = "payment_results"
+	eventsChannel  = "payment_events"
+
+	// migrationLockID is the advisory lock held while migrating, so instances
+	// starting together apply each migration once.
+	migrationLockID = 0x70617967617465
+
+	// queuePollInterval bounds the wait of a dequeue between two looks at the
+	// queue, should a notification be missed while reconnecting.
+	queuePollInterval = time.Second
+
+	sweepInterval = time.Minute
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// PostgresStore is the Store backed by PostgreSQL. Payments, refunds and
+// summaries are durable tables, where Redis keeps them in memory with
+// expiring keys. The queue is a table that workers take rows from with
+// FOR UPDATE SKIP LOCKED; inserts, completed payments and payment events are
+// announced through LISTEN/NOTIFY. Expiring records are deleted by a
+// background sweep.
+type PostgresStore struct {
+	pool *pgxpool.Pool
+	ctx  context.Context
+
+	queueReady  *signal
+	eventsReady *signal
+
+	subscribersMu sync.Mutex
+	subscribers   map[*resultSubscriber]struct{}
+}
+
+type resultSubscriber struct {
+	results chan *models.PaymentResult
+	done    <-chan struct{}
+}
+
+// NewPostgresStore connects to the database at url, which may be empty to use
+// the PG* environment variables, and migrates its schema.
+func NewPostgresStore(url string) (*PostgresStore, error) {
+	ctx := context.Background()
+
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse database url: %w", err)
+	}
+
+	p := &PostgresStore{
+		pool:        pool,
+		ctx:         ctx,
+		queueReady:  newSignal(),
+		eventsReady: newSignal(),
+		subscribers: make(map[*resultSubscriber]struct{}),
+	}
+	if err := p.migrate(); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	go p.listen()
+	go p.sweep()
+	return p, nil
+}
+
+// migrate applies the migrations not yet recorded in schema_migrations, in
+// file name order, in a single transaction.
+func (p *PostgresStore) migrate() error {
+	tx, err := p.pool.Begin(p.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	defer tx.Rollback(p.ctx)
+
+	if _, err := tx.Exec(p.ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	_, err = tx.Exec(p.ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    text PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	rows, _ := tx.Query(p.ctx, "SELECT version FROM schema_migrations")
+	applied, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	entries, err := fs.ReadDir(migrations, "migrations")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		version := entry.Name()
+		if slices.Contains(applied, version) {
+			continue
+		}
+
+		script, err := migrations.ReadFile("migrations/" + version)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(p.ctx, string(script)); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", version, err)
+		}
+		if _, err := tx.Exec(p.ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+			return fmt.Errorf("failed to record migration %s: %w", version, err)
+		}
+	}
+
+	return tx.Commit(p.ctx)
+}
+
+// listen holds a connection listening to the queue, results and events
+// channels, reconnecting when it is lost.
+func (p *PostgresStore) listen() {
+	for {
+		err := p.listenOnce()
+		fmt.Printf("Lost postgres notifications, reconnecting: %s\n", err)
+
+		// Waiting readers look again in case an insert went unnoticed.
+		p.queueReady.broadcast()
+		p.eventsReady.broadcast()
+		time.Sleep(time.Second)
+	}
+}
+
+func (p *PostgresStore) listenOnce() error {
+	pooled, err := p.pool.Acquire(p.ctx)
+	if err != nil {
+		return err
+	}
+	// The connection keeps listening, so it does not go back to the pool.
+	conn := pooled.Hijack()
+	defer conn.Close(p.ctx)
+
+	if _, err := conn.Exec(p.ctx, "LISTEN "+queueChannel+"; LISTEN "+resultsChannel+"; LISTEN "+eventsChannel); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(p.ctx)
+		if err != nil {
+			return err
+		}
+		p.dispatch(notification)
+	}
+}
+
+func (p *PostgresStore) dispatch(notification *pgconn.Notification) {
+	switch notification.Channel {
+	case queueChannel:
+		p.queueReady.broadcast()
+	case eventsChannel:
+		p.eventsReady.broadcast()
+	case resultsChannel:
+		var result models.PaymentResult
+		if err := json.Unmarshal([]byte(notification.Payload), &result); err != nil {
+			return
+		}
+
+		p.subscribersMu.Lock()
+		defer p.subscribersMu.Unlock()
+		for subscriber := range p.subscribers {
+			result := result
+			select {
+			case subscriber.results <- &result:
+			case <-subscriber.done:
+			}
+		}
+	}
+}
+
+// sweep deletes expired records, which Redis would have evicted on its own.
+func (p *PostgresStore) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+
+		batch := &pgx.Batch{}
+		batch.Queue("DELETE FROM processed_payments WHERE expires_at <= $1", now)
+		batch.Queue("DELETE FROM idempotency_keys WHERE expires_at <= $1", now)
+		batch.Queue("DELETE FROM rate_limits WHERE expires_at <= $1", now)
+		batch.Queue("DELETE FROM queue_drained WHERE second < $1", now.Add(-time.Minute).Unix())
+		batch.Queue("DELETE FROM webhook_deliveries WHERE updated_at < $1", now.Add(-webhookRetention))
+		batch.Queue("DELETE FROM webhook_attempts WHERE created_at < $1", now.Add(-webhookRetention))
+
+		if err := p.pool.SendBatch(p.ctx, batch).Close(); err != nil {
+			fmt.Printf("Failed to sweep expired records: %s\n", err)
+		}
+	}
+}
+
+// signal wakes every goroutine waiting on it at once.
+type signal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newSignal() *signal {
+	return &signal{ch: make(chan struct{})}
+}
+
+// wait returns a channel closed on the next broadcast.
+func (s *signal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ch
+}
+
+func (s *signal) broadcast() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.ch)
+	s.ch = make(chan struct{})
+}
@@ -0,0 +1,329 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+)
+
+// ClaimIdempotencyKey reserves an idempotency key for a request. When the key
+// was already used, the response recorded for it is returned instead.
+func (p *PostgresStore) ClaimIdempotencyKey(key, requestHash string, ttl time.Duration) (*IdempotentResponse, error) {
+	now := time.Now()
+	tag, err := p.pool.Exec(p.ctx, `
+		INSERT INTO idempotency_keys (key, request_hash, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET
+			request_hash = EXCLUDED.request_hash, status_code = 0, body = NULL, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= $4`,
+		key, requestHash, now.Add(ttl), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	var response IdempotentResponse
+	err = p.pool.QueryRow(p.ctx, "SELECT request_hash, status_code, body FROM idempotency_keys WHERE key = $1", key).
+		Scan(&response.RequestHash, &response.StatusCode, &response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotent response: %w", err)
+	}
+	return &response, nil
+}
+
+func (p *PostgresStore) SaveIdempotentResponse(key string, response *IdempotentResponse, ttl time.Duration) error {
+	_, err := p.pool.Exec(p.ctx, `
+		INSERT INTO idempotency_keys (key, request_hash, status_code, body, expires_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (key) DO UPDATE SET
+			request_hash = EXCLUDED.request_hash,
+			status_code = EXCLUDED.status_code,
+			body = EXCLUDED.body,
+			expires_at = EXCLUDED.expires_at`,
+		key, response.RequestHash, response.StatusCode, response.Body, time.Now().Add(ttl))
+	if err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStore) ReleaseIdempotencyKey(key string) error {
+	_, err := p.pool.Exec(p.ctx, "DELETE FROM idempotency_keys WHERE key = $1", key)
+	return err
+}
+
+// TakeTokens takes count tokens from every bucket if all of them have enough,
+// like tokenBucketScript. The buckets are locked in key order so concurrent calls
+// sharing buckets cannot deadlock, and time is read from the database so
+// every instance shares the same clock.
+func (p *PostgresStore) TakeTokens(count int, buckets ...TokenBucket) (*RateLimitResult, error) {
+	count = max(count, 1)
+	tx, err := p.pool.Begin(p.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to take rate limit tokens: %w", err)
+	}
+	defer tx.Rollback(p.ctx)
+
+	var now time.Time
+	if err := tx.QueryRow(p.ctx, "SELECT clock_timestamp()").Scan(&now); err != nil {
+		return nil, fmt.Errorf("failed to take rate limit tokens: %w", err)
+	}
+	nowMs := now.UnixMilli()
+
+	order := make([]int, len(buckets))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return strings.Compare(buckets[a].Key, buckets[b].Key)
+	})
+
+	tokens := make([]float64, len(buckets))
+	allowed := true
+	for _, i := range order {
+		bucket := buckets[i]
+		current, updatedMs := float64(bucket.Burst), nowMs
+		err := tx.QueryRow(p.ctx, "SELECT tokens, updated_ms FROM rate_limits WHERE key = $1 FOR UPDATE", bucket.Key).
+			Scan(&current, &updatedMs)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("failed to take rate limit tokens: %w", err)
+		}
+
+		current = min(float64(bucket.Burst), current+float64(max(0, nowMs-updatedMs))*bucket.Rate/1000)
+		tokens[i] = current
+		if current < float64(min(count, bucket.Burst)) {
+			allowed = false
+		}
+	}
+
+	result := &RateLimitResult{Allowed: allowed, Remaining: -1}
+	var retry float64
+	batch := &pgx.Batch{}
+	for _, i := range order {
+		bucket := buckets[i]
+		cost := float64(min(count, bucket.Burst))
+		current := tokens[i]
+		if allowed {
+			current -= cost
+		} else if current < cost {
+			retry = max(retry, math.Ceil((cost-current)/bucket.Rate*1000))
+		}
+
+		ttl := time.Duration(math.Ceil(float64(bucket.Burst)/bucket.Rate*1000)+1000) * time.Millisecond
+		batch.Queue(`
+			INSERT INTO rate_limits (key, tokens, updated_ms, expires_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (key) DO UPDATE SET
+				tokens = EXCLUDED.tokens, updated_ms = EXCLUDED.updated_ms, expires_at = EXCLUDED.expires_at`,
+			bucket.Key, current, nowMs, now.Add(ttl))
+
+		left := int64(math.Floor(current))
+		if result.Remaining < 0 || left < result.Remaining {
+			result.Remaining = left
+			result.Limit = int64(bucket.Burst)
+			result.Reset = time.Duration(math.Ceil((float64(bucket.Burst)-current)/bucket.Rate*1000)) * time.Millisecond
+		}
+	}
+
+	if err := tx.SendBatch(p.ctx, batch).Close(); err != nil {
+		return nil, fmt.Errorf("failed to take rate limit tokens: %w", err)
+	}
+	if err := tx.Commit(p.ctx); err != nil {
+		return nil, fmt.Errorf("failed to take rate limit tokens: %w", err)
+	}
+
+	result.Remaining = max(result.Remaining, 0)
+	result.RetryAfter = time.Duration(retry) * time.Millisecond
+	return result, nil
+}
+
+func (p *PostgresStore) SaveAPIClient(client *models.APIClient) error {
+	data, err := json.Marshal(client)
+	if err != nil {
+		return fmt.Errorf("failed to marshal api client: %w", err)
+	}
+
+	_, err = p.pool.Exec(p.ctx, `
+		INSERT INTO api_clients (id, client) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET client = EXCLUDED.client`, client.ID, data)
+	return err
+}
+
+func (p *PostgresStore) GetAPIClient(id string) (*models.APIClient, error) {
+	return p.getAPIClient("SELECT client FROM api_clients WHERE id = $1", id)
+}
+
+func (p *PostgresStore) ListAPIClients() ([]*models.APIClient, error) {
+	rows, _ := p.pool.Query(p.ctx, "SELECT client FROM api_clients ORDER BY id")
+	data, err := pgx.CollectRows(rows, pgx.RowTo[[]byte])
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api clients: %w", err)
+	}
+
+	clients := make([]*models.APIClient, 0, len(data))
+	for _, item := range data {
+		var client models.APIClient
+		if err := json.Unmarshal(item, &client); err != nil {
+			return nil, fmt.Errorf("failed to decode api client: %w", err)
+		}
+		clients = append(clients, &client)
+	}
+	return clients, nil
+}
+
+// ReplaceAPIKey points keyHash at the client and drops oldKeyHash, if any, in
+// a single transaction so a rotated key stops working immediately.
+func (p *PostgresStore) ReplaceAPIKey(clientID, keyHash, oldKeyHash string) error {
+	batch := &pgx.Batch{}
+	if oldKeyHash != "" {
+		batch.Queue("DELETE FROM api_keys WHERE key_hash = $1", oldKeyHash)
+	}
+	if keyHash != "" {
+		batch.Queue(`
+			INSERT INTO api_keys (key_hash, client_id) VALUES ($1, $2)
+			ON CONFLICT (key_hash) DO UPDATE SET client_id = EXCLUDED.client_id`, keyHash, clientID)
+	}
+	return p.pool.SendBatch(p.ctx, batch).Close()
+}
+
+func (p *PostgresStore) GetAPIClientByKeyHash(keyHash string) (*models.APIClient, error) {
+	return p.getAPIClient(`
+		SELECT c.client FROM api_keys k JOIN api_clients c ON c.id = k.client_id
+		WHERE k.key_hash = $1`, keyHash)
+}
+
+func (p *PostgresStore) getAPIClient(query string, arg string) (*models.APIClient, error) {
+	var data []byte
+	err := p.pool.QueryRow(p.ctx, query, arg).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var client models.APIClient
+	err = json.Unmarshal(data, &client)
+	return &client, err
+}
+
+func (p *PostgresStore) SaveWebhookDelivery(delivery *models.WebhookDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook delivery: %w", err)
+	}
+
+	var claimableAt *time.Time
+	if delivery.Status == constants.WebhookPending {
+		claimableAt = &delivery.NextAttemptAt
+	}
+
+	_, err = p.pool.Exec(p.ctx, `
+		INSERT INTO webhook_deliveries (id, correlation_id, delivery, claimable_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET
+			correlation_id = EXCLUDED.correlation_id,
+			delivery = EXCLUDED.delivery,
+			claimable_at = EXCLUDED.claimable_at,
+			updated_at = EXCLUDED.updated_at`,
+		delivery.ID, delivery.CorrelationID, data, claimableAt, time.Now())
+	return err
+}
+
+func (p *PostgresStore) GetWebhookDelivery(id string) (*models.WebhookDelivery, error) {
+	var data []byte
+	err := p.pool.QueryRow(p.ctx, "SELECT delivery FROM webhook_deliveries WHERE id = $1", id).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var delivery models.WebhookDelivery
+	err = json.Unmarshal(data, &delivery)
+	return &delivery, err
+}
+
+func (p *PostgresStore) ListWebhookDeliveries(correlationID string) ([]*models.WebhookDelivery, error) {
+	rows, _ := p.pool.Query(p.ctx, "SELECT delivery FROM webhook_deliveries WHERE correlation_id = $1", correlationID)
+	data, err := pgx.CollectRows(rows, pgx.RowTo[[]byte])
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+
+	deliveries := make([]*models.WebhookDelivery, 0, len(data))
+	for _, item := range data {
+		var delivery models.WebhookDelivery
+		if err := json.Unmarshal(item, &delivery); err != nil {
+			return nil, fmt.Errorf("failed to decode webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, nil
+}
+
+// ClaimDueWebhooks returns up to limit deliveries due now, leasing them to the
+// caller for the given duration. Deliveries claimed by another instance at the
+// same time are skipped rather than waited for.
+func (p *PostgresStore) ClaimDueWebhooks(lease time.Duration, limit int) ([]string, error) {
+	now := time.Now()
+	rows, _ := p.pool.Query(p.ctx, `
+		UPDATE webhook_deliveries SET claimable_at = $2
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries WHERE claimable_at <= $1
+			ORDER BY claimable_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id`, now, now.Add(lease), limit)
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// AppendWebhookAttempt logs an attempt, keeping the last webhookMaxLogItems.
+func (p *PostgresStore) AppendWebhookAttempt(id string, attempt models.WebhookAttempt) error {
+	data, err := json.Marshal(attempt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook attempt: %w", err)
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue("INSERT INTO webhook_attempts (delivery_id, attempt) VALUES ($1, $2)", id, data)
+	batch.Queue(`
+		DELETE FROM webhook_attempts WHERE delivery_id = $1 AND id NOT IN (
+			SELECT id FROM webhook_attempts WHERE delivery_id = $1 ORDER BY id DESC LIMIT $2
+		)`, id, webhookMaxLogItems)
+	return p.pool.SendBatch(p.ctx, batch).Close()
+}
+
+// GetWebhookAttempts returns the logged attempts of a delivery, oldest first.
+func (p *PostgresStore) GetWebhookAttempts(id string) ([]models.WebhookAttempt, error) {
+	rows, _ := p.pool.Query(p.ctx, "SELECT attempt FROM webhook_attempts WHERE delivery_id = $1 ORDER BY id", id)
+	data, err := pgx.CollectRows(rows, pgx.RowTo[[]byte])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook attempts: %w", err)
+	}
+
+	attempts := make([]models.WebhookAttempt, 0, len(data))
+	for _, item := range data {
+		var attempt models.WebhookAttempt
+		if err := json.Unmarshal(item, &attempt); err != nil {
+			continue
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, nil
+}
+
+// DropWebhookDelivery removes a delivery from the retry schedule.
+func (p *PostgresStore) DropWebhookDelivery(id string) error {
+	_, err := p.pool.Exec(p.ctx, "UPDATE webhook_deliveries SET claimable_at = NULL WHERE id = $1", id)
+	return err
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mochaeng/payment-gateway/internal/models"
+)
+
+// appendEventSQL inserts an event and drops the events more than $2 behind
+// it.
+const appendEventSQL = `
+	WITH inserted AS (
+		INSERT INTO payment_events (event) VALUES ($1) RETURNING id
+	)
+	DELETE FROM payment_events WHERE id <= (SELECT id FROM inserted) - $2`
+
+// AppendEvents adds events to the stream in order, keeping the last maxLen.
+func (p *PostgresStore) AppendEvents(events []*models.PaymentEvent, maxLen int64) error {
+	batch := &pgx.Batch{}
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payment event: %w", err)
+		}
+		batch.Queue(appendEventSQL, data, maxLen)
+	}
+
+	return p.pool.SendBatch(p.ctx, batch).Close()
+}
+
+func (p *PostgresStore) ReadEvents(after string, count int, block time.Duration) ([]*models.PaymentEvent, error) {
+	id, err := strconv.ParseInt(after, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidEventID
+	}
+	deadline := time.Now().Add(block)
+
+	for {
+		// Taken before looking, as in BlockingDequeuePayments.
+		ready := p.eventsReady.wait()
+
+		rows, _ := p.pool.Query(p.ctx,
+			"SELECT id, event FROM payment_events WHERE id > $1 ORDER BY id LIMIT $2", id, count)
+		var eventID int64
+		var data []byte
+		var events []*models.PaymentEvent
+		_, err := pgx.ForEachRow(rows, []any{&eventID, &data}, func() error {
+			var event models.PaymentEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				return nil
+			}
+			event.ID = strconv.FormatInt(eventID, 10)
+			events = append(events, &event)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read payment events: %w", err)
+		}
+		if len(events) > 0 {
+			return events, nil
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, nil
+		}
+		timer := time.NewTimer(min(wait, queuePollInterval))
+		select {
+		case <-ready:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// LastEventID returns the ID of the last event, or 0 when there is none.
+func (p *PostgresStore) LastEventID() (string, error) {
+	var id int64
+	if err := p.pool.QueryRow(p.ctx, "SELECT coalesce(max(id), 0) FROM payment_events").Scan(&id); err != nil {
+		return "", fmt.Errorf("failed to get last payment event: %w", err)
+	}
+	return strconv.FormatInt(id, 10), nil
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+)
+
+// PeekQueue returns up to limit payments of a lane without dequeuing them, the
+// next to be dequeued first.
+func (p *PostgresStore) PeekQueue(priority constants.PaymentPriority, limit int) ([]*models.QueuedPayment, error) {
+	rows, _ := p.pool.Query(p.ctx, "SELECT payment FROM payment_queue WHERE priority = $1 ORDER BY id LIMIT $2",
+		queueLane(priority), limit)
+	data, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to peek queue: %w", err)
+	}
+	return decodePayments(data), nil
+}
+
+// PeekDeadLetters returns up to limit dead-lettered payments, the latest
+// first.
+func (p *PostgresStore) PeekDeadLetters(limit int) ([]*models.QueuedPayment, error) {
+	rows, _ := p.pool.Query(p.ctx, "SELECT payment FROM dead_letters ORDER BY id DESC LIMIT $1", limit)
+	data, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to peek dead letters: %w", err)
+	}
+	return decodePayments(data), nil
+}
+
+// RecomputeSummary rebuilds the totals of a summary from its records, scoped
+// like UpdateSummary. Records are locked against inserts meanwhile, so no
+// payment is left out of the totals.
+func (p *PostgresStore) RecomputeSummary(merchantID string, processor constants.PaymentMode, currency string) error {
+	tx, err := p.pool.Begin(p.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to recompute summary: %w", err)
+	}
+	defer tx.Rollback(p.ctx)
+
+	if _, err := tx.Exec(p.ctx, "LOCK TABLE summary_records IN SHARE MODE"); err != nil {
+		return fmt.Errorf("failed to recompute summary: %w", err)
+	}
+
+	_, err = tx.Exec(p.ctx, `
+		INSERT INTO summary_totals (scope, processor, currency, total_amount, total_count, refunded_amount)
+		SELECT $1::text, $2::text, $3::text,
+			COALESCE(sum(amount) FILTER (WHERE NOT refund), 0),
+			count(*) FILTER (WHERE NOT refund),
+			COALESCE(sum(amount) FILTER (WHERE refund), 0)
+		FROM summary_records
+		WHERE processor = $2 AND currency = $3 AND ($1 = '' OR merchant_id = $1)
+		ON CONFLICT (scope, processor, currency) DO UPDATE SET
+			total_amount = EXCLUDED.total_amount,
+			total_count = EXCLUDED.total_count,
+			refunded_amount = EXCLUDED.refunded_amount`,
+		merchantID, string(processor), currency)
+	if err != nil {
+		return fmt.Errorf("failed to recompute summary: %w", err)
+	}
+
+	if err := tx.Commit(p.ctx); err != nil {
+		return fmt.Errorf("failed to recompute summary: %w", err)
+	}
+	return nil
+}
+
+// PurgeQueue removes every queued payment, returning them.
+func (p *PostgresStore) PurgeQueue() ([]*models.QueuedPayment, error) {
+	rows, _ := p.pool.Query(p.ctx, "DELETE FROM payment_queue RETURNING payment")
+	data, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge queue: %w", err)
+	}
+	return decodePayments(data), nil
+}
+
+// PurgeScheduledPayments removes every pending scheduled payment, returning
+// them.
+func (p *PostgresStore) PurgeScheduledPayments() ([]*models.QueuedPayment, error) {
+	rows, _ := p.pool.Query(p.ctx, "DELETE FROM scheduled_payments RETURNING payment")
+	data, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge scheduled payments: %w", err)
+	}
+	return decodePayments(data), nil
+}
+
+// PurgeDeadLetters removes every dead-lettered payment, returning how many
+// there were.
+func (p *PostgresStore) PurgeDeadLetters() (int64, error) {
+	tag, err := p.pool.Exec(p.ctx, "DELETE FROM dead_letters")
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge dead letters: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// PurgeProcessorsHealth removes the health of every processor, returning how
+// many records were removed. The health monitor writes them again on its next
+// check.
+func (p *PostgresStore) PurgeProcessorsHealth() (int64, error) {
+	tag, err := p.pool.Exec(p.ctx, "DELETE FROM processor_health")
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge processors health: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// PurgeSummaries removes the records and totals of every summary, returning
+// how many rows were removed.
+func (p *PostgresStore) PurgeSummaries() (int64, error) {
+	batch := &pgx.Batch{}
+	records := batch.Queue("DELETE FROM summary_records")
+	totals := batch.Queue("DELETE FROM summary_totals")
+
+	var deleted int64
+	count := func(tag pgconn.CommandTag) error {
+		deleted += tag.RowsAffected()
+		return nil
+	}
+	records.Exec(count)
+	totals.Exec(count)
+
+	if err := p.pool.SendBatch(p.ctx, batch).Close(); err != nil {
+		return 0, fmt.Errorf("failed to purge summaries: %w", err)
+	}
+	return deleted, nil
+}
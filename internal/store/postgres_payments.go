@@ -0,0 +1,772 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+)
+
+const paymentColumns = `correlation_id, merchant_id, client_id, status, processor, amount, currency, refunded_amount, reason, updated_at`
+
+// upsertPaymentSQL stores the status record of a payment, taking the
+// arguments of paymentArgs.
+const upsertPaymentSQL = `
+	INSERT INTO payments (key, correlation_id, merchant_id, client_id, status, processor, amount, currency, refunded_amount, reason, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	ON CONFLICT (key) DO UPDATE SET
+		correlation_id = EXCLUDED.correlation_id,
+		merchant_id = EXCLUDED.merchant_id,
+		client_id = EXCLUDED.client_id,
+		status = EXCLUDED.status,
+		processor = EXCLUDED.processor,
+		amount = EXCLUDED.amount,
+		currency = EXCLUDED.currency,
+		refunded_amount = EXCLUDED.refunded_amount,
+		reason = EXCLUDED.reason,
+		updated_at = EXCLUDED.updated_at`
+
+// inactiveOnlySQL restricts upsertPaymentSQL to payments that are not active,
+// as defined by isActive, so it affects no row for a duplicate.
+const inactiveOnlySQL = `
+	WHERE payments.status NOT IN ('scheduled', 'queued', 'processing', 'succeeded', 'refunded')`
+
+// admitPaymentSQL records the queued status of a payment and pushes it into
+// lane $12 unless it is a duplicate, in which case it inserts nothing.
+const admitPaymentSQL = `
+	WITH admitted AS (` + upsertPaymentSQL + inactiveOnlySQL + ` RETURNING key)
+	INSERT INTO payment_queue (priority, payment) SELECT $12::text, $13::text FROM admitted`
+
+func paymentArgs(result *models.PaymentResult) []any {
+	return []any{
+		result.Key(), result.CorrelationID, result.MerchantID, result.ClientID, string(result.Status), result.Processor,
+		result.Amount, result.Currency, result.RefundedAmount, result.Reason, result.UpdatedAt,
+	}
+}
+
+func admitArgs(payment *models.QueuedPayment) []any {
+	return append(paymentArgs(paymentStatus(payment, constants.PaymentQueued)),
+		queueLane(payment.Priority), string(payment.AppendJSON(nil)))
+}
+
+// scanPayment reads the paymentColumns of a row, after the extra columns
+// selected before them, or returns ErrNotFound when there is no row.
+func scanPayment(row pgx.Row, extra ...any) (*models.PaymentResult, error) {
+	var result models.PaymentResult
+	var status string
+	dest := append(extra, &result.CorrelationID, &result.MerchantID, &result.ClientID, &status, &result.Processor,
+		&result.Amount, &result.Currency, &result.RefundedAmount, &result.Reason, &result.UpdatedAt)
+
+	if err := row.Scan(dest...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	result.Status = constants.PaymentStatus(status)
+	result.UpdatedAt = result.UpdatedAt.UTC()
+	return &result, nil
+}
+
+// queueLane returns the lane a payment is queued in, payments without a known
+// priority going to the normal lane as in queueKey.
+func queueLane(priority constants.PaymentPriority) string {
+	switch priority {
+	case constants.PriorityHigh, constants.PriorityLow:
+		return string(priority)
+	default:
+		return string(constants.PriorityNormal)
+	}
+}
+
+func (p *PostgresStore) GetPaymentStatus(key string) (*models.PaymentResult, error) {
+	return scanPayment(p.pool.QueryRow(p.ctx, `SELECT `+paymentColumns+` FROM payments WHERE key = $1`, key))
+}
+
+// CompletePayment stores the final status of a payment and notifies every
+// instance waiting on it.
+func (p *PostgresStore) CompletePayment(result *models.PaymentResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payment result: %w", err)
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue(upsertPaymentSQL, paymentArgs(result)...)
+	batch.Queue("SELECT pg_notify($1, $2)", resultsChannel, string(data))
+	return p.pool.SendBatch(p.ctx, batch).Close()
+}
+
+// SubscribePaymentResults streams the results notified by CompletePayment
+// until ctx is done.
+func (p *PostgresStore) SubscribePaymentResults(ctx context.Context) <-chan *models.PaymentResult {
+	subscriber := &resultSubscriber{
+		results: make(chan *models.PaymentResult, 64),
+		done:    ctx.Done(),
+	}
+
+	p.subscribersMu.Lock()
+	p.subscribers[subscriber] = struct{}{}
+	p.subscribersMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		p.subscribersMu.Lock()
+		delete(p.subscribers, subscriber)
+		p.subscribersMu.Unlock()
+		close(subscriber.results)
+	}()
+
+	return subscriber.results
+}
+
+// TransitionPayment moves the status of a payment to `to` if it currently is
+// one of from. It returns the resulting status record and whether it moved, or
+// ErrNotFound when the payment has no status record.
+func (p *PostgresStore) TransitionPayment(key string, to constants.PaymentStatus, from ...constants.PaymentStatus) (*models.PaymentResult, bool, error) {
+	statuses := make([]string, len(from))
+	for i, status := range from {
+		statuses[i] = string(status)
+	}
+
+	row := p.pool.QueryRow(p.ctx, `
+		WITH updated AS (
+			UPDATE payments SET status = $2, updated_at = $3
+			WHERE key = $1 AND status = ANY($4::text[])
+			RETURNING true AS moved, `+paymentColumns+`
+		)
+		SELECT * FROM updated
+		UNION ALL
+		SELECT false, `+paymentColumns+` FROM payments
+		WHERE key = $1 AND NOT EXISTS (SELECT 1 FROM updated)`,
+		key, string(to), time.Now().UTC(), statuses)
+
+	var moved bool
+	payment, err := scanPayment(row, &moved)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, false, fmt.Errorf("failed to transition payment: %w", err)
+	}
+	return payment, moved, err
+}
+
+// RefundPayment records a refund of amount, or of the whole remaining amount
+// when it is not positive, against a succeeded payment. Refunds are summarized
+// in the currency scope given by CurrencyScope.
+func (p *PostgresStore) RefundPayment(key, refundID string, amount float64, defaultCurrency string) (RefundOutcome, *models.Refund, *models.PaymentResult, error) {
+	tx, err := p.pool.Begin(p.ctx)
+	if err != nil {
+		return RefundNotFound, nil, nil, fmt.Errorf("failed to refund payment: %w", err)
+	}
+	defer tx.Rollback(p.ctx)
+
+	current, err := scanPayment(tx.QueryRow(p.ctx,
+		`SELECT `+paymentColumns+` FROM payments WHERE key = $1 FOR UPDATE`, key))
+	if errors.Is(err, ErrNotFound) {
+		return RefundNotFound, nil, nil, nil
+	}
+	if err != nil {
+		return RefundNotFound, nil, nil, fmt.Errorf("failed to refund payment: %w", err)
+	}
+
+	if current.Status != constants.PaymentSucceeded {
+		return RefundNotAllowed, nil, current, nil
+	}
+	remaining := current.Amount - current.RefundedAmount
+	if amount <= 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining+1e-9 {
+		return RefundExceedsAmount, nil, current, nil
+	}
+
+	now := time.Now().UTC()
+	updated := *current
+	updated.RefundedAmount += amount
+	if remaining-amount <= 1e-9 {
+		updated.Status = constants.PaymentRefunded
+	}
+	updated.UpdatedAt = now
+
+	refund := &models.Refund{
+		ID:            refundID,
+		CorrelationID: current.CorrelationID,
+		MerchantID:    current.MerchantID,
+		Processor:     current.Processor,
+		Amount:        amount,
+		Currency:      current.Currency,
+		CreatedAt:     now,
+	}
+	currency := CurrencyScope(cmp.Or(current.Currency, defaultCurrency), defaultCurrency)
+
+	batch := &pgx.Batch{}
+	batch.Queue(`UPDATE payments SET status = $2, refunded_amount = $3, updated_at = $4 WHERE key = $1`,
+		key, string(updated.Status), updated.RefundedAmount, now)
+	batch.Queue(`
+		INSERT INTO refunds (id, payment_key, correlation_id, merchant_id, processor, amount, currency, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		refund.ID, key, refund.CorrelationID, refund.MerchantID, refund.Processor, refund.Amount, refund.Currency, now)
+	batch.Queue(`
+		WITH record AS (
+			INSERT INTO summary_records (merchant_id, processor, currency, amount, refund, created_at)
+			VALUES ($1, $2, $3, $4, true, $5)
+		)
+		INSERT INTO summary_totals (scope, processor, currency, refunded_amount)
+		SELECT scope, $2::text, $3::text, $4::numeric FROM unnest($6::text[]) AS scope
+		ON CONFLICT (scope, processor, currency) DO UPDATE SET
+			refunded_amount = summary_totals.refunded_amount + EXCLUDED.refunded_amount`,
+		current.MerchantID, current.Processor, currency, amount, now, summaryScopes(current.MerchantID))
+
+	if err := tx.SendBatch(p.ctx, batch).Close(); err != nil {
+		return RefundNotFound, nil, nil, fmt.Errorf("failed to refund payment: %w", err)
+	}
+	if err := tx.Commit(p.ctx); err != nil {
+		return RefundNotFound, nil, nil, fmt.Errorf("failed to refund payment: %w", err)
+	}
+	return RefundApplied, refund, &updated, nil
+}
+
+// SetProcessedPayment claims a payment for a processor unless it is already
+// claimed and the claim has not expired.
+func (p *PostgresStore) SetProcessedPayment(key string, processor constants.PaymentMode, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	tag, err := p.pool.Exec(p.ctx, `
+		INSERT INTO processed_payments (key, processor, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET processor = EXCLUDED.processor, expires_at = EXCLUDED.expires_at
+		WHERE processed_payments.expires_at <= $4`,
+		key, string(processor), now.Add(ttl), now)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (p *PostgresStore) RemoveProcessedPayment(key string) (int64, error) {
+	tag, err := p.pool.Exec(p.ctx, "DELETE FROM processed_payments WHERE key = $1", key)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (p *PostgresStore) EnqueuePayment(payment *models.QueuedPayment) error {
+	_, err := p.pool.Exec(p.ctx, "INSERT INTO payment_queue (priority, payment) VALUES ($1, $2)",
+		queueLane(payment.Priority), string(payment.AppendJSON(nil)))
+	return err
+}
+
+// lockQueueDepth reads the queue depth from its counter row, locking the row
+// until tx ends so concurrent admissions check the limits one at a time.
+func (p *PostgresStore) lockQueueDepth(tx pgx.Tx) (int64, error) {
+	var depth int64
+	if err := tx.QueryRow(p.ctx, "SELECT depth FROM queue_depth FOR UPDATE").Scan(&depth); err != nil {
+		return 0, fmt.Errorf("failed to read queue depth: %w", err)
+	}
+	return depth, nil
+}
+
+// EnqueuePaymentWithLimit enqueues a payment unless the queue is above its
+// high-water marks, returning the admission decision and the queue depth, as
+// RedisStore does. The check and the insert share a transaction holding the
+// queue depth row, so they are atomic.
+func (p *PostgresStore) EnqueuePaymentWithLimit(payment *models.QueuedPayment, hardLimit, softLimit int, roll float64) (QueueAdmission, int64, error) {
+	tx, err := p.pool.Begin(p.ctx)
+	if err != nil {
+		return QueueFull, 0, fmt.Errorf("failed to enqueue payment: %w", err)
+	}
+	defer tx.Rollback(p.ctx)
+
+	var depth int64
+	var status *string
+	err = tx.QueryRow(p.ctx, `
+		SELECT q.depth, p.status FROM queue_depth q LEFT JOIN payments p ON p.key = $1
+		FOR UPDATE OF q`, payment.Key()).Scan(&depth, &status)
+	if err != nil {
+		return QueueFull, 0, fmt.Errorf("failed to read queue state: %w", err)
+	}
+
+	hard, soft := int64(hardLimit), int64(softLimit)
+	if status != nil && isActive(constants.PaymentStatus(*status)) {
+		return QueueDuplicate, depth, nil
+	}
+	if depth >= hard {
+		return QueueFull, depth, nil
+	}
+	if depth >= soft && hard > soft && roll < float64(depth-soft)/float64(hard-soft) {
+		return QueueShed, depth, nil
+	}
+
+	tag, err := tx.Exec(p.ctx, admitPaymentSQL, admitArgs(payment)...)
+	if err != nil {
+		return QueueFull, depth, fmt.Errorf("failed to enqueue payment: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return QueueDuplicate, depth, nil
+	}
+	if err := tx.Commit(p.ctx); err != nil {
+		return QueueFull, depth, fmt.Errorf("failed to enqueue payment: %w", err)
+	}
+	return QueueAccepted, depth + 1, nil
+}
+
+// EnqueuePayments enqueues a batch of payments like RedisStore does, in one
+// transaction holding the queue depth row: the status records are read first,
+// then the admitted payments are inserted in a single round trip.
+func (p *PostgresStore) EnqueuePayments(payments []*models.QueuedPayment, hardLimit, softLimit int, roll float64) ([]QueueAdmission, int64, error) {
+	admissions := make([]QueueAdmission, len(payments))
+	if len(payments) == 0 {
+		return admissions, 0, nil
+	}
+
+	tx, err := p.pool.Begin(p.ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to enqueue payments: %w", err)
+	}
+	defer tx.Rollback(p.ctx)
+
+	depth, err := p.lockQueueDepth(tx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keys := make([]string, len(payments))
+	for i, payment := range payments {
+		keys[i] = payment.Key()
+	}
+	statuses := make(map[string]constants.PaymentStatus, len(payments))
+	rows, _ := tx.Query(p.ctx, "SELECT key, status FROM payments WHERE key = ANY($1::text[])", keys)
+	var key, status string
+	_, err = pgx.ForEachRow(rows, []any{&key, &status}, func() error {
+		statuses[key] = constants.PaymentStatus(status)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read queue state: %w", err)
+	}
+
+	hard, soft := int64(hardLimit), int64(softLimit)
+	admit := &pgx.Batch{}
+	admitted := make([]int, 0, len(payments))
+	for i, payment := range payments {
+		if isActive(statuses[payment.Key()]) {
+			admissions[i] = QueueDuplicate
+			continue
+		}
+		if depth >= hard {
+			admissions[i] = QueueFull
+			continue
+		}
+		if depth >= soft && hard > soft && roll < float64(depth-soft)/float64(hard-soft) {
+			admissions[i] = QueueShed
+			continue
+		}
+
+		admit.Queue(admitPaymentSQL, admitArgs(payment)...)
+		admitted = append(admitted, i)
+		depth++
+	}
+	if len(admitted) == 0 {
+		return admissions, depth, nil
+	}
+
+	results := tx.SendBatch(p.ctx, admit)
+	for _, i := range admitted {
+		tag, err := results.Exec()
+		if err != nil {
+			results.Close()
+			return nil, 0, fmt.Errorf("failed to enqueue payments: %w", err)
+		}
+		admissions[i] = QueueAccepted
+		if tag.RowsAffected() == 0 {
+			admissions[i] = QueueDuplicate
+			depth--
+		}
+	}
+	if err := results.Close(); err != nil {
+		return nil, 0, fmt.Errorf("failed to enqueue payments: %w", err)
+	}
+	if err := tx.Commit(p.ctx); err != nil {
+		return nil, 0, fmt.Errorf("failed to enqueue payments: %w", err)
+	}
+	return admissions, depth, nil
+}
+
+// MarkPaymentsQueued records the queued status of payments held by an
+// in-process queue, reporting those still active as duplicates.
+func (p *PostgresStore) MarkPaymentsQueued(payments []*models.QueuedPayment) ([]QueueAdmission, error) {
+	batch := &pgx.Batch{}
+	for _, payment := range payments {
+		batch.Queue(upsertPaymentSQL+inactiveOnlySQL, paymentArgs(paymentStatus(payment, constants.PaymentQueued))...)
+	}
+
+	results := p.pool.SendBatch(p.ctx, batch)
+	defer results.Close()
+
+	admissions := make([]QueueAdmission, len(payments))
+	for i := range payments {
+		tag, err := results.Exec()
+		if err != nil {
+			return nil, fmt.Errorf("failed to mark payments queued: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			admissions[i] = QueueDuplicate
+		}
+	}
+	return admissions, nil
+}
+
+// BlockingDequeuePayments takes up to count payments, from the first lanes
+// first, waiting up to timeout for one to arrive. It returns ErrNotFound when
+// none did.
+func (p *PostgresStore) BlockingDequeuePayments(timeout time.Duration, lanes []constants.PaymentPriority, count int) ([]*models.QueuedPayment, error) {
+	names := make([]string, len(lanes))
+	for i, lane := range lanes {
+		names[i] = queueLane(lane)
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		// Taken before looking, so an insert right after the look still
+		// wakes this dequeue.
+		ready := p.queueReady.wait()
+
+		rows, _ := p.pool.Query(p.ctx, `
+			DELETE FROM payment_queue WHERE id IN (
+				SELECT id FROM payment_queue WHERE priority = ANY($1::text[])
+				ORDER BY array_position($1::text[], priority), id
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING payment`, names, count)
+		data, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return nil, fmt.Errorf("failed to dequeue payments: %w", err)
+		}
+		if len(data) > 0 {
+			return decodePayments(data), nil
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, ErrNotFound
+		}
+		timer := time.NewTimer(min(wait, queuePollInterval))
+		select {
+		case <-ready:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (p *PostgresStore) DeadLetterPayment(payment *models.QueuedPayment) error {
+	_, err := p.pool.Exec(p.ctx, "INSERT INTO dead_letters (payment) VALUES ($1)", string(payment.AppendJSON(nil)))
+	return err
+}
+
+func (p *PostgresStore) QueueDepths() (map[constants.PaymentPriority]int64, error) {
+	depths := make(map[constants.PaymentPriority]int64, len(constants.Priorities))
+	for _, priority := range constants.Priorities {
+		depths[priority] = 0
+	}
+
+	rows, _ := p.pool.Query(p.ctx, "SELECT priority, count(*) FROM payment_queue GROUP BY priority")
+	var priority string
+	var depth int64
+	_, err := pgx.ForEachRow(rows, []any{&priority, &depth}, func() error {
+		depths[constants.PaymentPriority(priority)] = depth
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return depths, nil
+}
+
+// RecordDequeued counts dequeued payments in per-second rows shared by all
+// instances, which DrainRate uses to estimate how fast the queue empties.
+func (p *PostgresStore) RecordDequeued(count int64) error {
+	_, err := p.pool.Exec(p.ctx, `
+		INSERT INTO queue_drained (second, count) VALUES ($1, $2)
+		ON CONFLICT (second) DO UPDATE SET count = queue_drained.count + EXCLUDED.count`,
+		time.Now().Unix(), count)
+	return err
+}
+
+// DrainRate returns the average number of payments dequeued per second over
+// the last complete seconds of window.
+func (p *PostgresStore) DrainRate(window time.Duration) (float64, error) {
+	seconds := max(int64(window/time.Second), 1)
+	now := time.Now().Unix()
+
+	var total int64
+	err := p.pool.QueryRow(p.ctx,
+		"SELECT COALESCE(sum(count), 0) FROM queue_drained WHERE second >= $1 AND second < $2",
+		now-seconds, now).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+	return float64(total) / float64(seconds), nil
+}
+
+// SchedulePayment holds a payment until its ScheduledAt. Like RedisStore, it
+// refuses payments still active.
+func (p *PostgresStore) SchedulePayment(payment *models.QueuedPayment) (QueueAdmission, error) {
+	args := append(paymentArgs(paymentStatus(payment, constants.PaymentScheduled)),
+		*payment.ScheduledAt, string(payment.AppendJSON(nil)))
+
+	tag, err := p.pool.Exec(p.ctx, `
+		WITH admitted AS (`+upsertPaymentSQL+inactiveOnlySQL+` RETURNING key)
+		INSERT INTO scheduled_payments (key, merchant_id, due_at, payment)
+		SELECT key, $3::text, $12::timestamptz, $13::text FROM admitted
+		ON CONFLICT (key) DO UPDATE SET due_at = EXCLUDED.due_at, payment = EXCLUDED.payment`, args...)
+	if err != nil {
+		return QueueFull, fmt.Errorf("failed to schedule payment: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return QueueDuplicate, nil
+	}
+	return QueueAccepted, nil
+}
+
+// PromoteDuePayments moves up to limit payments due by now into the queue, or
+// only takes them off the schedule when enqueue is false, leaving them to the
+// caller. Promotion bypasses the queue limits, the payments having been
+// admitted when they were scheduled.
+func (p *PostgresStore) PromoteDuePayments(now time.Time, limit int, enqueue bool) ([]*models.QueuedPayment, error) {
+	tx, err := p.pool.Begin(p.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to promote scheduled payments: %w", err)
+	}
+	defer tx.Rollback(p.ctx)
+
+	rows, _ := tx.Query(p.ctx, `
+		DELETE FROM scheduled_payments WHERE key IN (
+			SELECT key FROM scheduled_payments WHERE due_at <= $1
+			ORDER BY due_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING payment`, now, limit)
+	data, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to promote scheduled payments: %w", err)
+	}
+	payments := decodePayments(data)
+
+	if enqueue && len(payments) > 0 {
+		lanes := make([]string, len(payments))
+		for i, payment := range payments {
+			lanes[i] = queueLane(payment.Priority)
+		}
+		_, err := tx.Exec(p.ctx,
+			"INSERT INTO payment_queue (priority, payment) SELECT * FROM unnest($1::text[], $2::text[])",
+			lanes, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to promote scheduled payments: %w", err)
+		}
+	}
+
+	if err := tx.Commit(p.ctx); err != nil {
+		return nil, fmt.Errorf("failed to promote scheduled payments: %w", err)
+	}
+	return payments, nil
+}
+
+// UnschedulePayment removes a pending scheduled payment, returning it, or nil
+// when it was not scheduled.
+func (p *PostgresStore) UnschedulePayment(key string) (*models.QueuedPayment, error) {
+	var data string
+	err := p.pool.QueryRow(p.ctx, "DELETE FROM scheduled_payments WHERE key = $1 RETURNING payment", key).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unschedule payment: %w", err)
+	}
+
+	var payment models.QueuedPayment
+	err = payment.DecodeJSONString(data)
+	return &payment, err
+}
+
+// ListScheduledPayments returns up to limit pending payments of a merchant, or
+// of every merchant when merchantID is empty, the soonest due first. With a
+// clientID, only the payments of that API client and those submitted without
+// one are returned.
+func (p *PostgresStore) ListScheduledPayments(merchantID, clientID string, limit int) ([]*models.QueuedPayment, error) {
+	rows, _ := p.pool.Query(p.ctx, `
+		SELECT s.payment FROM scheduled_payments s LEFT JOIN payments p ON p.key = s.key
+		WHERE ($2 = '' OR s.merchant_id = $2) AND ($3 = '' OR coalesce(p.client_id, '') IN ('', $3))
+		ORDER BY s.due_at LIMIT $1`, limit, merchantID, clientID)
+	data, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scheduled payments: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return decodePayments(data), nil
+}
+
+// summaryScopes returns the summary_totals scopes a merchant's payment counts
+// in: all merchants, ”, and the merchant itself.
+func summaryScopes(merchantID string) []string {
+	if merchantID == "" {
+		return []string{""}
+	}
+	return []string{"", merchantID}
+}
+
+// UpdateSummary records a successful payment in the global summary and, when
+// the payment belongs to a merchant, in that merchant's summary as well.
+// currency is the scope given by CurrencyScope.
+func (p *PostgresStore) UpdateSummary(merchantID string, processor constants.PaymentMode, currency string, amount float64) error {
+	_, err := p.pool.Exec(p.ctx, `
+		WITH record AS (
+			INSERT INTO summary_records (merchant_id, processor, currency, amount, refund, created_at)
+			VALUES ($1, $2, $3, $4, false, $5)
+		)
+		INSERT INTO summary_totals (scope, processor, currency, total_amount, total_count)
+		SELECT scope, $2::text, $3::text, $4::numeric, 1 FROM unnest($6::text[]) AS scope
+		ON CONFLICT (scope, processor, currency) DO UPDATE SET
+			total_amount = summary_totals.total_amount + EXCLUDED.total_amount,
+			total_count = summary_totals.total_count + 1`,
+		merchantID, string(processor), currency, amount, time.Now().UTC(), summaryScopes(merchantID))
+	if err != nil {
+		return fmt.Errorf("failed to update summary: %w", err)
+	}
+	return nil
+}
+
+// GetSummary returns the summary of each processor in the currency scope given
+// by CurrencyScope, restricted to a merchant unless merchantID is empty. Like
+// RedisStore, time filters have a one second resolution and include both ends.
+func (p *PostgresStore) GetSummary(merchantID string, processors []constants.PaymentMode, currency string, from, to *time.Time) (*models.PaymentSummaryResponse, error) {
+	names := make([]string, len(processors))
+	for i, processor := range processors {
+		names[i] = string(processor)
+	}
+
+	var rows pgx.Rows
+	if from == nil && to == nil {
+		rows, _ = p.pool.Query(p.ctx, `
+			SELECT processor, total_count, total_amount, refunded_amount FROM summary_totals
+			WHERE scope = $1 AND currency = $2 AND processor = ANY($3::text[])`,
+			merchantID, currency, names)
+	} else {
+		var since, until *time.Time
+		if from != nil {
+			start := time.Unix(from.Unix(), 0)
+			since = &start
+		}
+		if to != nil {
+			end := time.Unix(to.Unix()+1, 0)
+			until = &end
+		}
+
+		query := `
+			SELECT processor,
+				count(*) FILTER (WHERE NOT refund),
+				COALESCE(sum(amount) FILTER (WHERE NOT refund), 0),
+				COALESCE(sum(amount) FILTER (WHERE refund), 0)
+			FROM summary_records
+			WHERE currency = $1 AND processor = ANY($2::text[])
+				AND created_at >= COALESCE($3::timestamptz, '-infinity')
+				AND created_at < COALESCE($4::timestamptz, 'infinity')`
+		args := []any{currency, names, since, until}
+		if merchantID != "" {
+			query += " AND merchant_id = $5"
+			args = append(args, merchantID)
+		}
+		rows, _ = p.pool.Query(p.ctx, query+" GROUP BY processor", args...)
+	}
+
+	response := make(models.PaymentSummaryResponse, len(processors))
+	for _, processor := range processors {
+		response[string(processor)] = models.ProcessorSummary{}
+	}
+
+	var processor string
+	var count int64
+	var amount, refunded float64
+	_, err := pgx.ForEachRow(rows, []any{&processor, &count, &amount, &refunded}, func() error {
+		response[processor] = models.ProcessorSummary{
+			SummaryTotals: models.SummaryTotals{
+				TotalRequest:   count,
+				TotalAmount:    amount,
+				RefundedAmount: refunded,
+				NetAmount:      amount - refunded,
+			},
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get summary: %w", err)
+	}
+	return &response, nil
+}
+
+// GetProcessorsHealth returns the health of the given processors. Processors
+// without a health record are left out of the map.
+func (p *PostgresStore) GetProcessorsHealth(processors []constants.PaymentMode) (map[constants.PaymentMode]*models.ProcessorHealth, error) {
+	if len(processors) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, len(processors))
+	for i, processor := range processors {
+		names[i] = string(processor)
+	}
+
+	rows, _ := p.pool.Query(p.ctx, `
+		SELECT processor, failing, min_response_time, last_checked, forced, forced_until FROM processor_health
+		WHERE processor = ANY($1::text[])`, names)
+
+	healths := make(map[constants.PaymentMode]*models.ProcessorHealth, len(processors))
+	var processor string
+	var health models.ProcessorHealth
+	var forcedUntil *time.Time
+	scans := []any{&processor, &health.Failing, &health.MinResponseTime, &health.LastChecked, &health.Forced, &forcedUntil}
+	_, err := pgx.ForEachRow(rows, scans, func() error {
+		health := health
+		if forcedUntil != nil {
+			health.ForcedUntil = *forcedUntil
+		}
+		healths[constants.PaymentMode(processor)] = &health
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get processors health: %w", err)
+	}
+	return healths, nil
+}
+
+func (p *PostgresStore) SetProcessorHealth(processor constants.PaymentMode, health models.ProcessorHealth) error {
+	_, err := p.pool.Exec(p.ctx, `
+		INSERT INTO processor_health (processor, failing, min_response_time, last_checked, forced, forced_until)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (processor) DO UPDATE SET
+			failing = EXCLUDED.failing,
+			min_response_time = EXCLUDED.min_response_time,
+			last_checked = EXCLUDED.last_checked,
+			forced = EXCLUDED.forced,
+			forced_until = EXCLUDED.forced_until`,
+		string(processor), health.Failing, health.MinResponseTime, health.LastChecked, health.Forced, forcedUntil(health))
+	return err
+}
+
+// forcedUntil returns the forced_until column of a health, null when the
+// health is not forced or forced until released.
+func forcedUntil(health models.ProcessorHealth) *time.Time {
+	if !health.Forced || health.ForcedUntil.IsZero() {
+		return nil
+	}
+	return &health.ForcedUntil
+}
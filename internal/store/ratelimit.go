@@ -0,0 +1,106 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const rateLimitPrefix = "ratelimit:"
+
+// tokenBucketScript checks every bucket in KEYS against its rate (tokens per
+// second) and burst given in ARGV as pairs after the number of tokens to take,
+// ARGV[1]. A bucket is never charged more than its burst, so requests weighing
+// more still get through once the bucket is full. Tokens are taken from all
+// buckets only when each of them has enough, so a denied request costs
+// nothing. Time is read from Redis so every instance shares the same clock.
+var tokenBucketScript = redis.NewScript(`
+	local now = redis.call('TIME')
+	local nowMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+
+	local count = tonumber(ARGV[1])
+	local tokens = {}
+	local allowed = 1
+	for i, key in ipairs(KEYS) do
+		local rate = tonumber(ARGV[2 * i])
+		local burst = tonumber(ARGV[2 * i + 1])
+		local state = redis.call('HMGET', key, 'tokens', 'ts')
+		local current = tonumber(state[1]) or burst
+		local ts = tonumber(state[2]) or nowMs
+		current = math.min(burst, current + math.max(0, nowMs - ts) * rate / 1000)
+		tokens[i] = current
+		if current < math.min(count, burst) then
+			allowed = 0
+		end
+	end
+
+	local limit, remaining, reset, retry = 0, -1, 0, 0
+	for i, key in ipairs(KEYS) do
+		local rate = tonumber(ARGV[2 * i])
+		local burst = tonumber(ARGV[2 * i + 1])
+		local cost = math.min(count, burst)
+		local current = tokens[i]
+		if allowed == 1 then
+			current = current - cost
+		elseif current < cost then
+			retry = math.max(retry, math.ceil((cost - current) / rate * 1000))
+		end
+		redis.call('HSET', key, 'tokens', tostring(current), 'ts', nowMs)
+		redis.call('PEXPIRE', key, math.ceil(burst / rate * 1000) + 1000)
+
+		local left = math.floor(current)
+		if remaining < 0 or left < remaining then
+			remaining = left
+			limit = burst
+			reset = math.ceil((burst - current) / rate * 1000)
+		end
+	end
+
+	return {allowed, limit, remaining, reset, retry}
+`)
+
+type TokenBucket struct {
+	Key   string
+	Rate  float64
+	Burst int
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// TakeTokens takes count tokens from every bucket if all of them have enough,
+// charging each bucket at most its burst. The reported limit and remaining
+// tokens are those of the most restrictive bucket.
+func (r *RedisStore) TakeTokens(count int, buckets ...TokenBucket) (*RateLimitResult, error) {
+	keys := make([]string, 0, len(buckets))
+	args := make([]any, 0, 1+2*len(buckets))
+	args = append(args, max(count, 1))
+	for _, bucket := range buckets {
+		keys = append(keys, r.key(rateLimitTag, rateLimitPrefix+bucket.Key))
+		args = append(args, strconv.FormatFloat(bucket.Rate, 'f', -1, 64), bucket.Burst)
+	}
+
+	result, err := tokenBucketScript.Run(r.ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take rate limit tokens: %w", err)
+	}
+
+	if len(result) < 5 {
+		return nil, fmt.Errorf("invalid rate limit result")
+	}
+
+	return &RateLimitResult{
+		Allowed:    result[0] == 1,
+		Limit:      result[1],
+		Remaining:  max(result[2], 0),
+		Reset:      time.Duration(result[3]) * time.Millisecond,
+		RetryAfter: time.Duration(result[4]) * time.Millisecond,
+	}, nil
+}
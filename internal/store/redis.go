@@ -4,12 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/mochaeng/payment-gateway/internal/config"
 	"github.com/mochaeng/payment-gateway/internal/constants"
 	"github.com/mochaeng/payment-gateway/internal/models"
 	"github.com/redis/go-redis/v9"
+	"github.com/valyala/bytebufferpool"
 )
 
 const (
@@ -18,35 +22,206 @@ const (
 	paymentPrefix     = "payments:"
 	totalAmountPrefix = "total_amount:"
 	totalCountPrefix  = "total_count:"
+	drainedPrefix     = "queue:drained:"
+	statusPrefix      = "payment:status:"
+	merchantPrefix    = "merchant:"
+	processedPrefix   = "processed:"
 
-	paymentQueueKey = "payment_queue"
+	paymentQueueKey         = "payment_queue"
+	deadLetterKey           = "payment_dead_letter"
+	paymentCompletedChannel = "payments:completed"
+
+	paymentStatusTTL = 24 * time.Hour
+)
+
+// Hash tags of the keys used together by a script or a transaction, which
+// must map to one slot in cluster mode. Status records are checked and
+// written along with the queue lanes and the schedule, so all three share
+// paymentsTag and are served by a single shard. Summaries are tagged per
+// merchant by summaryTag. Keys only ever used on their own, such as
+// processing claims, idempotency keys and the event stream, carry no tag and
+// spread over every slot.
+const (
+	paymentsTag   = "{payments}"
+	summariesTag  = "{summaries}"
+	healthTag     = "{health}"
+	drainedTag    = "{drained}"
+	rateLimitTag  = "{ratelimit}"
+	webhooksTag   = "{webhooks}"
+	apiClientsTag = "{apiclients}"
+)
+
+type QueueAdmission int
+
+const (
+	QueueAccepted QueueAdmission = iota
+	QueueShed
+	QueueFull
+	QueueDuplicate
 )
 
+// enqueueScript pushes a payment into its lane, KEYS[1], only while the queue
+// is below the hard limit. The depth is the total over every lane, given from
+// KEYS[3] on.
+// Between the soft and the hard limit the payment is shed with a probability
+// that grows linearly with the depth, ARGV[4] being a random number in [0, 1)
+// chosen by the caller so the script stays deterministic. Accepted payments get
+// their queued status record in the same round trip. A payment whose key is
+// still active, as defined by isActive, is reported as a duplicate and not
+// pushed again.
+var enqueueScript = redis.NewScript(`
+	local depth = 0
+	for i = 3, #KEYS do
+		depth = depth + redis.call('LLEN', KEYS[i])
+	end
+	local current = redis.call('GET', KEYS[2])
+	if current then
+		local status = cjson.decode(current).status
+		if status == 'scheduled' or status == 'queued' or status == 'processing' or status == 'succeeded' or status == 'refunded' then
+			return {3, depth}
+		end
+	end
+	local hard = tonumber(ARGV[2])
+	local soft = tonumber(ARGV[3])
+	if depth >= hard then
+		return {2, depth}
+	end
+	if depth >= soft and hard > soft and tonumber(ARGV[4]) < (depth - soft) / (hard - soft) then
+		return {1, depth}
+	end
+	redis.call('LPUSH', KEYS[1], ARGV[1])
+	redis.call('SET', KEYS[2], ARGV[5], 'EX', ARGV[6])
+	return {0, depth + 1}
+`)
+
+// summaryScript records a payment in a summary: the time-indexed records in
+// KEYS[1], the total amount in KEYS[2] and the count in KEYS[3].
+var summaryScript = redis.NewScript(`
+	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+	redis.call('INCRBYFLOAT', KEYS[2], ARGV[3])
+	redis.call('INCR', KEYS[3])
+	return 'OK'
+`)
+
 type RedisStore struct {
-	client *redis.Client
-	ctx    context.Context
+	client  redis.UniversalClient
+	ctx     context.Context
+	writes  *writeBatcher
+	cluster bool
 }
 
-func NewRedisStore(url string) (*RedisStore, error) {
-	opt, err := redis.ParseURL(url)
+// NewRedisStore connects to the standalone server, sentinel-managed master or
+// cluster described by config.
+func NewRedisStore(config config.RedisConfig) (*RedisStore, error) {
+	client, err := newRedisClient(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse redis url: %w", err)
+		return nil, err
 	}
 
-	client := redis.NewClient(opt)
+	ctx := context.Background()
+
+	// Preloading spares the first call of each script its EVAL fallback.
+	// Redis may not be up yet, in which case scripts are loaded on first use.
+	loadCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_ = loadScripts(loadCtx, client)
+
+	cluster := config.Mode == constants.RedisCluster
+	if cluster {
+		fmt.Printf("Redis cluster mode: payment statuses, queue lanes and scheduled payments share the %s slot and are served by a single shard; summaries, health, rate limits, API clients and webhooks spread over the cluster\n", paymentsTag)
+	}
 
 	return &RedisStore{
-		client: client,
-		ctx:    context.Background(),
+		client:  client,
+		ctx:     ctx,
+		writes:  newWriteBatcher(ctx, client),
+		cluster: cluster,
 	}, nil
 }
 
+var scripts = []*redis.Script{
+	enqueueScript, enqueueBatchScript, markQueuedScript, summaryScript, transitionScript, refundScript,
+	tokenBucketScript, scheduleScript, promoteScript, claimWebhooksScript,
+}
+
+// loadScripts loads every script into the Redis script cache. A cluster
+// client loads each script on every master, which a pipeline would not.
+func loadScripts(ctx context.Context, client redis.UniversalClient) error {
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		for _, script := range scripts {
+			if err := script.Load(ctx, cluster).Err(); err != nil {
+				return fmt.Errorf("failed to load scripts: %w", err)
+			}
+		}
+		return nil
+	}
+
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, script := range scripts {
+			script.Load(ctx, pipe)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load scripts: %w", err)
+	}
+	return nil
+}
+
+// key prefixes name with tag in cluster mode. Standalone and sentinel
+// deployments keep the untagged names, so existing data stays readable.
+func (r *RedisStore) key(tag, name string) string {
+	if !r.cluster {
+		return name
+	}
+	return tag + name
+}
+
+// notFound translates redis.Nil, the reply to reads of missing keys, into
+// ErrNotFound.
+func notFound(err error) error {
+	if err == redis.Nil {
+		return ErrNotFound
+	}
+	return err
+}
+
+// outOfMemory translates the OOM error Redis replies with to writes past
+// maxmemory, under the noeviction policy, into ErrOutOfMemory.
+func outOfMemory(err error) error {
+	if redis.HasErrorPrefix(err, "OOM") {
+		return ErrOutOfMemory
+	}
+	return err
+}
+
+// summaryTag returns the hash tag of the summaries of a merchant, or of the
+// global summaries when merchantID is empty, so that each merchant's
+// summaries get a slot of their own in cluster mode.
+func summaryTag(merchantID string) string {
+	if merchantID == "" {
+		return summariesTag
+	}
+	return "{summaries:" + merchantID + "}"
+}
+
+// processedKey returns the processing claim of the payment with the given
+// key. Claims are only used on their own and so left untagged.
+func processedKey(key string) string {
+	return processedPrefix + key
+}
+
+// statusKey returns the status record of the payment with the given key.
+func (r *RedisStore) statusKey(key string) string {
+	return r.key(paymentsTag, statusPrefix+key)
+}
+
 func (r *RedisStore) GetProcessorHealth(processor constants.PaymentMode) (*models.ProcessorHealth, error) {
-	healthKey := fmt.Sprintf("%s%s", healthPrefix, processor)
+	healthKey := r.key(healthTag, fmt.Sprintf("%s%s", healthPrefix, processor))
 
 	data, err := r.client.Get(r.ctx, healthKey).Bytes()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get health processor: %w", err)
+		return nil, fmt.Errorf("failed to get health processor: %w", notFound(err))
 	}
 
 	var health models.ProcessorHealth
@@ -54,65 +229,422 @@ func (r *RedisStore) GetProcessorHealth(processor constants.PaymentMode) (*model
 	return &health, err
 }
 
+// GetProcessorsHealth returns the health of the given processors in one round
+// trip. Processors without a health record are left out of the map.
+func (r *RedisStore) GetProcessorsHealth(processors []constants.PaymentMode) (map[constants.PaymentMode]*models.ProcessorHealth, error) {
+	if len(processors) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(processors))
+	for i, processor := range processors {
+		keys[i] = r.key(healthTag, healthPrefix+string(processor))
+	}
+
+	values, err := r.client.MGet(r.ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get processors health: %w", err)
+	}
+
+	healths := make(map[constants.PaymentMode]*models.ProcessorHealth, len(processors))
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var health models.ProcessorHealth
+		if err := json.Unmarshal([]byte(data), &health); err != nil {
+			return nil, fmt.Errorf("failed to decode %s processor health: %w", processors[i], err)
+		}
+		healths[processors[i]] = &health
+	}
+	return healths, nil
+}
+
 func (r *RedisStore) SetProcessorHealth(processor constants.PaymentMode, health models.ProcessorHealth) error {
 	data, err := json.Marshal(health)
 	if err != nil {
 		return fmt.Errorf("failed to marshal health processor: %w", err)
 	}
 
-	healthKey := fmt.Sprintf("%s%s", healthPrefix, processor)
+	healthKey := r.key(healthTag, fmt.Sprintf("%s%s", healthPrefix, processor))
 
 	return r.client.Set(r.ctx, healthKey, data, 0).Err()
 }
 
 func (r *RedisStore) EnqueuePayment(payment *models.QueuedPayment) error {
-	data, err := json.Marshal(payment)
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	buf.B = payment.AppendJSON(buf.B[:0])
+
+	return r.client.LPush(r.ctx, r.queueKey(payment.Priority), buf.B).Err()
+}
+
+// queueKey returns the list holding a priority lane. The normal lane keeps the
+// original queue key so payments queued before lanes existed are still served.
+func (r *RedisStore) queueKey(priority constants.PaymentPriority) string {
+	switch priority {
+	case constants.PriorityHigh, constants.PriorityLow:
+		return r.key(paymentsTag, paymentQueueKey+":"+string(priority))
+	default:
+		return r.key(paymentsTag, paymentQueueKey)
+	}
+}
+
+func (r *RedisStore) queueKeys(priorities []constants.PaymentPriority) []string {
+	keys := make([]string, 0, len(priorities))
+	for _, priority := range priorities {
+		keys = append(keys, r.queueKey(priority))
+	}
+	return keys
+}
+
+// EnqueuePaymentWithLimit enqueues a payment unless the queue is above its
+// high-water marks, returning the admission decision and the queue depth.
+func (r *RedisStore) EnqueuePaymentWithLimit(payment *models.QueuedPayment, hardLimit, softLimit int, roll float64) (QueueAdmission, int64, error) {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	buf.B = payment.AppendJSON(buf.B[:0])
+
+	status, err := queuedStatus(payment)
+	if err != nil {
+		return QueueFull, 0, err
+	}
+
+	keys := append([]string{r.queueKey(payment.Priority), r.statusKey(payment.Key())},
+		r.queueKeys(constants.Priorities)...)
+	result, err := enqueueScript.Run(r.ctx, r.client, keys,
+		buf.B, hardLimit, softLimit, roll, status, int(paymentStatusTTL.Seconds())).Int64Slice()
+	if err != nil {
+		return QueueFull, 0, fmt.Errorf("failed to enqueue payment: %w", outOfMemory(err))
+	}
+
+	if len(result) < 2 {
+		return QueueFull, 0, fmt.Errorf("invalid enqueue result")
+	}
+
+	return QueueAdmission(result[0]), result[1], nil
+}
+
+// markQueuedScript records the queued status KEYS[1] of a payment held
+// outside Redis, unless its status is still active as in enqueueScript.
+var markQueuedScript = redis.NewScript(`
+	local current = redis.call('GET', KEYS[1])
+	if current then
+		local status = cjson.decode(current).status
+		if status == 'scheduled' or status == 'queued' or status == 'processing' or status == 'succeeded' or status == 'refunded' then
+			return 1
+		end
+	end
+	redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+	return 0
+`)
+
+// MarkPaymentsQueued records the queued status of payments held by an
+// in-process queue, sharing a pipeline. Payments still active are reported as
+// duplicates and the others as accepted.
+func (r *RedisStore) MarkPaymentsQueued(payments []*models.QueuedPayment) ([]QueueAdmission, error) {
+	statuses := make([][]byte, len(payments))
+	for i, payment := range payments {
+		status, err := queuedStatus(payment)
+		if err != nil {
+			return nil, err
+		}
+		statuses[i] = status
+	}
+
+	cmds := make([]*redis.Cmd, len(payments))
+	err := r.writes.Do(func(pipe redis.Pipeliner) {
+		for i, payment := range payments {
+			cmds[i] = markQueuedScript.EvalSha(r.ctx, pipe, []string{r.statusKey(payment.Key())},
+				statuses[i], int(paymentStatusTTL.Seconds()))
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark payments queued: %w", outOfMemory(err))
+	}
+
+	admissions := make([]QueueAdmission, len(payments))
+	for i, cmd := range cmds {
+		if duplicate, _ := cmd.Int(); duplicate == 1 {
+			admissions[i] = QueueDuplicate
+		}
+	}
+	return admissions, nil
+}
+
+func queuedStatus(payment *models.QueuedPayment) ([]byte, error) {
+	status, err := json.Marshal(paymentStatus(payment, constants.PaymentQueued))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payment status: %w", err)
+	}
+	return status, nil
+}
+
+// GetPaymentStatus returns the status record of the payment with the given
+// key, as built by models.PaymentKey.
+func (r *RedisStore) GetPaymentStatus(key string) (*models.PaymentResult, error) {
+	data, err := r.client.Get(r.ctx, r.statusKey(key)).Bytes()
 	if err != nil {
-		return fmt.Errorf("failed to marshal payment: %w", err)
+		return nil, notFound(err)
 	}
 
-	return r.client.LPush(r.ctx, paymentQueueKey, data).Err()
+	var result models.PaymentResult
+	err = json.Unmarshal(data, &result)
+	return &result, err
+}
+
+// CompletePayment stores the final status of a payment and notifies every
+// instance waiting on it.
+func (r *RedisStore) CompletePayment(result *models.PaymentResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payment result: %w", err)
+	}
+
+	pipe := r.client.Pipeline()
+	pipe.Set(r.ctx, r.statusKey(result.Key()), data, paymentStatusTTL)
+	pipe.Publish(r.ctx, paymentCompletedChannel, data)
+	_, err = pipe.Exec(r.ctx)
+	return err
+}
+
+// SubscribePaymentResults streams the results published by CompletePayment
+// until ctx is done.
+func (r *RedisStore) SubscribePaymentResults(ctx context.Context) <-chan *models.PaymentResult {
+	pubsub := r.client.Subscribe(ctx, paymentCompletedChannel)
+	results := make(chan *models.PaymentResult, 64)
+
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+
+	go func() {
+		defer close(results)
+
+		for msg := range pubsub.Channel() {
+			var result models.PaymentResult
+			if err := json.Unmarshal([]byte(msg.Payload), &result); err != nil {
+				continue
+			}
+
+			select {
+			case results <- &result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return results
+}
+
+// DeadLetterPayment parks a payment that exhausted its retries so it can be
+// inspected or replayed later.
+func (r *RedisStore) DeadLetterPayment(payment *models.QueuedPayment) error {
+	return r.client.LPush(r.ctx, r.key(paymentsTag, deadLetterKey), payment.AppendJSON(nil)).Err()
 }
 
+// DequeuePayment pops the oldest payment of the most urgent non-empty lane.
 func (r *RedisStore) DequeuePayment() (*models.QueuedPayment, error) {
-	data, err := r.client.RPop(r.ctx, paymentQueueKey).Bytes()
+	for _, key := range r.queueKeys(constants.Priorities) {
+		data, err := r.client.RPop(r.ctx, key).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to dequeue payment: %w", err)
+		}
+
+		var payment models.QueuedPayment
+		err = payment.DecodeJSONString(data)
+		return &payment, err
+	}
+
+	return nil, fmt.Errorf("failed to dequeue payment: %w", ErrNotFound)
+}
+
+// BlockingDequeuePayments pops up to count of the oldest payments of the first
+// non-empty lane, trying the lanes in the given order, and waits up to timeout
+// for one to arrive when they are all empty.
+func (r *RedisStore) BlockingDequeuePayments(timeout time.Duration, lanes []constants.PaymentPriority, count int) ([]*models.QueuedPayment, error) {
+	_, values, err := r.client.BLMPop(r.ctx, timeout, "right", int64(count), r.queueKeys(lanes)...).Result()
 	if err != nil {
-		return nil, fmt.Errorf("failed to dequeue payment: %w", err)
+		return nil, notFound(err)
 	}
 
-	var payment models.QueuedPayment
-	err = json.Unmarshal(data, &payment)
-	return &payment, err
+	payments := make([]*models.QueuedPayment, 0, len(values))
+	for _, data := range values {
+		var payment models.QueuedPayment
+		if err := payment.DecodeJSONString(data); err != nil {
+			return payments, fmt.Errorf("failed to decode dequeued payment: %w", err)
+		}
+		payments = append(payments, &payment)
+	}
+	return payments, nil
 }
 
-func (r *RedisStore) BlockingDequeuePayment(timeout time.Duration) (*models.QueuedPayment, error) {
-	result, err := r.client.BRPop(r.ctx, timeout, paymentQueueKey).Result()
+// QueueSize returns the number of queued payments over every lane.
+func (r *RedisStore) QueueSize() (int64, error) {
+	depths, err := r.QueueDepths()
 	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, depth := range depths {
+		total += depth
+	}
+	return total, nil
+}
+
+// QueueDepths returns the number of queued payments in each lane.
+func (r *RedisStore) QueueDepths() (map[constants.PaymentPriority]int64, error) {
+	pipe := r.client.Pipeline()
+	cmds := make(map[constants.PaymentPriority]*redis.IntCmd, len(constants.Priorities))
+	for _, priority := range constants.Priorities {
+		cmds[priority] = pipe.LLen(r.ctx, r.queueKey(priority))
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil {
 		return nil, err
 	}
 
-	if len(result) < 2 {
-		return nil, fmt.Errorf("invalid BRPop result")
+	depths := make(map[constants.PaymentPriority]int64, len(cmds))
+	for priority, cmd := range cmds {
+		depths[priority] = cmd.Val()
 	}
+	return depths, nil
+}
+
+// RecordDequeued counts dequeued payments in per-second buckets shared by all
+// instances, which DrainRate uses to estimate how fast the queue empties.
+func (r *RedisStore) RecordDequeued(count int64) error {
+	bucketKey := r.key(drainedTag, fmt.Sprintf("%s%d", drainedPrefix, time.Now().Unix()))
 
-	data := result[1]
-	var payment models.QueuedPayment
-	err = json.Unmarshal([]byte(data), &payment)
-	return &payment, err
+	pipe := r.client.Pipeline()
+	pipe.IncrBy(r.ctx, bucketKey, count)
+	pipe.Expire(r.ctx, bucketKey, time.Minute)
+	_, err := pipe.Exec(r.ctx)
+	return err
 }
 
-func (r *RedisStore) QueueSize() (int64, error) {
-	return r.client.LLen(r.ctx, paymentQueueKey).Result()
+// DrainRate returns the average number of payments dequeued per second over
+// the last complete seconds of window.
+func (r *RedisStore) DrainRate(window time.Duration) (float64, error) {
+	seconds := int64(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	now := time.Now().Unix()
+	keys := make([]string, 0, seconds)
+	for i := int64(1); i <= seconds; i++ {
+		keys = append(keys, r.key(drainedTag, fmt.Sprintf("%s%d", drainedPrefix, now-i)))
+	}
+
+	values, err := r.client.MGet(r.ctx, keys...).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		count, err := strconv.ParseInt(str, 10, 64)
+		if err == nil {
+			total += count
+		}
+	}
+
+	return float64(total) / float64(seconds), nil
+}
+
+// MemoryUsage returns the fraction of maxmemory used by Redis, or 0 when no
+// memory limit is configured. In cluster mode it is that of the fullest
+// master.
+func (r *RedisStore) MemoryUsage() (float64, error) {
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return memoryUsage(r.ctx, r.client.(*redis.Client))
+	}
+
+	var mu sync.Mutex
+	var highest float64
+	err := cluster.ForEachMaster(r.ctx, func(ctx context.Context, client *redis.Client) error {
+		usage, err := memoryUsage(ctx, client)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		highest = max(highest, usage)
+		return nil
+	})
+	return highest, err
 }
 
-func (r *RedisStore) UpdateSummary(processor constants.PaymentMode, amount float64) error {
+func memoryUsage(ctx context.Context, client *redis.Client) (float64, error) {
+	info, err := client.InfoMap(ctx, "memory").Result()
+	if err != nil {
+		return 0, err
+	}
+
+	memory := info["Memory"]
+	used, _ := strconv.ParseFloat(memory["used_memory"], 64)
+	limit, _ := strconv.ParseFloat(memory["maxmemory"], 64)
+	if limit <= 0 {
+		return 0, nil
+	}
+
+	return used / limit, nil
+}
+
+// CurrencyScope returns the currency summary keys are scoped to. Payments in
+// the default currency keep the unscoped keys, which predate currencies.
+func CurrencyScope(currency, defaultCurrency string) string {
+	if currency == defaultCurrency {
+		return ""
+	}
+	return currency
+}
+
+// summaryKeys returns the records, total amount and total count keys of a
+// processor, scoped to a merchant unless merchantID is empty and to a currency
+// unless currency is empty.
+func (r *RedisStore) summaryKeys(merchantID string, processor constants.PaymentMode, currency string) (string, string, string) {
+	scope, suffix := "", ""
+	if merchantID != "" {
+		scope = merchantPrefix + merchantID + ":"
+	}
+	if currency != "" {
+		suffix = ":" + currency
+	}
+
+	tag := summaryTag(merchantID)
+	return r.key(tag, fmt.Sprintf("%s%s%s%s:records", scope, paymentPrefix, processor, suffix)),
+		r.key(tag, fmt.Sprintf("%s%s%s%s%s", scope, summaryPrefix, totalAmountPrefix, processor, suffix)),
+		r.key(tag, fmt.Sprintf("%s%s%s%s%s", scope, summaryPrefix, totalCountPrefix, processor, suffix))
+}
+
+// UpdateSummary records a successful payment in the global summary and, when
+// the payment belongs to a merchant, in that merchant's summary as well. Each
+// summary is updated atomically on its own. currency is the scope given by
+// CurrencyScope. Concurrent updates share a pipeline.
+func (r *RedisStore) UpdateSummary(merchantID string, processor constants.PaymentMode, currency string, amount float64) error {
 	now := time.Now().UTC()
 	timestamp := now.Unix()
 	timeStampNano := now.UnixNano()
 
-	recordsKey := fmt.Sprintf("%s%s:records", paymentPrefix, processor)
-	totalAmountKey := fmt.Sprintf("%s%s%s", summaryPrefix, totalAmountPrefix, processor)
-	totalCountKey := fmt.Sprintf("%s%s%s", summaryPrefix, totalCountPrefix, processor)
+	scopes := []string{""}
+	if merchantID != "" {
+		scopes = append(scopes, merchantID)
+	}
 
 	paymentRecord := map[string]any{
 		"amount":    amount,
@@ -124,75 +656,59 @@ func (r *RedisStore) UpdateSummary(processor constants.PaymentMode, amount float
 		return fmt.Errorf("failed to marshal payment record: %w", err)
 	}
 
-	luaScript := `
-		redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
-		redis.call('INCRBYFLOAT', KEYS[2], ARGV[3])
-		redis.call('INCR', KEYS[3])
-		return 'OK'
-	`
-
 	member := fmt.Sprintf("%d:%s", timeStampNano, string(recordData))
 
-	_, err = r.client.Eval(r.ctx, luaScript, []string{
-		recordsKey,
-		totalAmountKey,
-		totalCountKey,
-	}, float64(timestamp), member, amount).Result()
-
+	err = r.writes.Do(func(pipe redis.Pipeliner) {
+		for _, scope := range scopes {
+			recordsKey, totalAmountKey, totalCountKey := r.summaryKeys(scope, processor, currency)
+			summaryScript.EvalSha(r.ctx, pipe, []string{recordsKey, totalAmountKey, totalCountKey}, float64(timestamp), member, amount)
+		}
+	})
 	if err != nil {
 		return fmt.Errorf("failed to update summary atomically: %w", err)
 	}
 
-	// pipe.ZAdd(r.ctx, recordsKey, redis.Z{
-	// 	Score:  float64(timestamp),
-	// 	Member: fmt.Sprintf("%d:%s", timeStampNano, string(recordData)),
-	// })
-	// pipe.IncrByFloat(r.ctx, totalAmountKey, amount)
-	// pipe.Incr(r.ctx, totalCountKey)
-
 	return nil
 }
 
-func (r *RedisStore) GetSummary(from, to *time.Time) (*models.PaymentSummaryResponse, error) {
-	response := &models.PaymentSummaryResponse{}
-
-	defaultSummary, err := r.getProcecssorSummary(constants.DefaultProcessorKey, from, to)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get default processor summary: %w", err)
-	}
+// GetSummary returns the summary of each processor in the currency scope given
+// by CurrencyScope, restricted to a merchant unless merchantID is empty.
+func (r *RedisStore) GetSummary(merchantID string, processors []constants.PaymentMode, currency string, from, to *time.Time) (*models.PaymentSummaryResponse, error) {
+	response := make(models.PaymentSummaryResponse, len(processors))
 
-	fallbackSummary, err := r.getProcecssorSummary(constants.FallbackProcessorKey, from, to)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get fallback processor summary: %w", err)
+	for _, processor := range processors {
+		summary, err := r.getProcecssorSummary(merchantID, processor, currency, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s processor summary: %w", processor, err)
+		}
+		response[string(processor)] = *summary
 	}
 
-	response.Default = *defaultSummary
-	response.Fallback = *fallbackSummary
-
-	return response, nil
+	return &response, nil
 }
 
-func (r *RedisStore) getProcecssorSummary(processor constants.PaymentMode, from, to *time.Time) (*models.ProcessorSummary, error) {
+func (r *RedisStore) getProcecssorSummary(merchantID string, processor constants.PaymentMode, currency string, from, to *time.Time) (*models.ProcessorSummary, error) {
 	if from == nil && to == nil {
-		return r.getTotalSummary(processor)
+		return r.getTotalSummary(merchantID, processor, currency)
 	}
-	return r.getTimeFilteredSummary(processor, from, to)
+	return r.getTimeFilteredSummary(merchantID, processor, currency, from, to)
 }
 
-func (r *RedisStore) getTotalSummary(processor constants.PaymentMode) (*models.ProcessorSummary, error) {
-	totalAmountKey := fmt.Sprintf("%s%s%s", summaryPrefix, totalAmountPrefix, processor)
-	totalCountKey := fmt.Sprintf("%s%s%s", summaryPrefix, totalCountPrefix, processor)
+func (r *RedisStore) getTotalSummary(merchantID string, processor constants.PaymentMode, currency string) (*models.ProcessorSummary, error) {
+	_, totalAmountKey, totalCountKey := r.summaryKeys(merchantID, processor, currency)
+	_, refundedAmountKey := r.refundKeys(merchantID, processor, currency)
 
 	pipe := r.client.Pipeline()
 	amountCmd := pipe.Get(r.ctx, totalAmountKey)
 	countCmd := pipe.Get(r.ctx, totalCountKey)
+	refundedCmd := pipe.Get(r.ctx, refundedAmountKey)
 
 	_, err := pipe.Exec(r.ctx)
 	if err != nil && err != redis.Nil {
 		return nil, err
 	}
 
-	var totalAmount float64
+	var totalAmount, refundedAmount float64
 	var totalCount int64
 
 	if amountCmd.Err() == nil {
@@ -203,14 +719,23 @@ func (r *RedisStore) getTotalSummary(processor constants.PaymentMode) (*models.P
 		totalCount, _ = countCmd.Int64()
 	}
 
+	if refundedCmd.Err() == nil {
+		refundedAmount, _ = refundedCmd.Float64()
+	}
+
 	return &models.ProcessorSummary{
-		TotalRequest: totalCount,
-		TotalAmount:  totalAmount,
+		SummaryTotals: models.SummaryTotals{
+			TotalRequest:   totalCount,
+			TotalAmount:    totalAmount,
+			RefundedAmount: refundedAmount,
+			NetAmount:      totalAmount - refundedAmount,
+		},
 	}, nil
 }
 
-func (r *RedisStore) getTimeFilteredSummary(processor constants.PaymentMode, from, to *time.Time) (*models.ProcessorSummary, error) {
-	recordsKey := fmt.Sprintf("%s%s:records", paymentPrefix, processor)
+func (r *RedisStore) getTimeFilteredSummary(merchantID string, processor constants.PaymentMode, currency string, from, to *time.Time) (*models.ProcessorSummary, error) {
+	recordsKey, _, _ := r.summaryKeys(merchantID, processor, currency)
+	refundRecordsKey, _ := r.refundKeys(merchantID, processor, currency)
 
 	var minScore, maxScore string
 
@@ -226,13 +751,36 @@ func (r *RedisStore) getTimeFilteredSummary(processor constants.PaymentMode, fro
 		maxScore = "+inf"
 	}
 
-	records, err := r.client.ZRangeByScore(r.ctx, recordsKey, &redis.ZRangeBy{
+	totalCount, totalAmount, err := sumRecords(r.ctx, r.client, recordsKey, minScore, maxScore)
+	if err != nil {
+		return nil, err
+	}
+
+	_, refundedAmount, err := sumRecords(r.ctx, r.client, refundRecordsKey, minScore, maxScore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ProcessorSummary{
+		SummaryTotals: models.SummaryTotals{
+			TotalRequest:   totalCount,
+			TotalAmount:    totalAmount,
+			RefundedAmount: refundedAmount,
+			NetAmount:      totalAmount - refundedAmount,
+		},
+	}, nil
+}
+
+// sumRecords counts and sums the amounts of the summary records stored in a
+// sorted set between two timestamps.
+func sumRecords(ctx context.Context, client redis.Cmdable, recordsKey, minScore, maxScore string) (int64, float64, error) {
+	records, err := client.ZRangeByScore(ctx, recordsKey, &redis.ZRangeBy{
 		Min: minScore,
 		Max: maxScore,
 	}).Result()
 
 	if err != nil && err != redis.Nil {
-		return nil, err
+		return 0, 0, err
 	}
 
 	var totalAmount float64
@@ -254,25 +802,35 @@ func (r *RedisStore) getTimeFilteredSummary(processor constants.PaymentMode, fro
 		}
 	}
 
-	return &models.ProcessorSummary{
-		TotalRequest: totalCount,
-		TotalAmount:  totalAmount,
-	}, nil
+	return totalCount, totalAmount, nil
 }
 
-func (r *RedisStore) SetProcessedPayment(correlationID string, processor constants.PaymentMode, ttl time.Duration) (bool, error) {
-	processedKey := fmt.Sprintf("processed:%s", correlationID)
-	return r.client.SetNX(r.ctx, processedKey, processor, ttl).Result()
+// SetProcessedPayment claims a payment for a processor unless it is already
+// claimed. Like RemoveProcessedPayment, it goes through the shared pipeline.
+func (r *RedisStore) SetProcessedPayment(key string, processor constants.PaymentMode, ttl time.Duration) (bool, error) {
+	var cmd *redis.BoolCmd
+	err := r.writes.Do(func(pipe redis.Pipeliner) {
+		cmd = pipe.SetNX(r.ctx, processedKey(key), string(processor), ttl)
+	})
+	if err != nil {
+		return false, err
+	}
+	return cmd.Val(), nil
 }
 
-func (r *RedisStore) RemoveProcessedPayment(correlationID string) (int64, error) {
-	processedKey := fmt.Sprintf("processed:%s", correlationID)
-	return r.client.Del(r.ctx, processedKey).Result()
+func (r *RedisStore) RemoveProcessedPayment(key string) (int64, error) {
+	var cmd *redis.IntCmd
+	err := r.writes.Do(func(pipe redis.Pipeliner) {
+		cmd = pipe.Del(r.ctx, processedKey(key))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return cmd.Val(), nil
 }
 
-func (r *RedisStore) IsPaymentProcessed(correlationID string) (bool, string, error) {
-	processedKey := fmt.Sprintf("processed:%s", correlationID)
-	result, err := r.client.Get(r.ctx, processedKey).Result()
+func (r *RedisStore) IsPaymentProcessed(key string) (bool, string, error) {
+	result, err := r.client.Get(r.ctx, processedKey(key)).Result()
 	if err != nil {
 		return false, "", err
 	}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"cmp"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+)
+
+// The benchmarks below run against a local Redis, BENCH_REDIS_URL or database
+// 15 of localhost by default, which they flush. They are skipped when Redis is
+// not reachable:
+//
+//	go test ./internal/store -run '^$' -bench .
+
+// benchParallelism mimics the default 32 workers on a typical 4 core machine.
+const benchParallelism = 8
+
+func benchStore(b *testing.B) *RedisStore {
+	b.Helper()
+
+	url := cmp.Or(os.Getenv("BENCH_REDIS_URL"), "redis://localhost:6379/15")
+	store, err := NewRedisStore(config.RedisConfig{URL: url})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := store.client.Ping(store.ctx).Err(); err != nil {
+		b.Skipf("redis is not reachable at %s: %s", url, err)
+	}
+
+	flush := func() {
+		if err := store.client.FlushDB(store.ctx).Err(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	flush()
+	b.Cleanup(flush)
+
+	return store
+}
+
+func fillQueue(b *testing.B, store *RedisStore, count int) {
+	b.Helper()
+
+	payment := models.QueuedPayment{
+		CorrelationID: "4a7901b8-7d26-4d2d-8c5e-6d0a2ba5a9f1",
+		Amount:        19.9,
+		CreatedAt:     time.Now().UTC(),
+		Priority:      constants.PriorityNormal,
+	}
+	data := payment.AppendJSON(nil)
+
+	values := make([]any, 0, 1000)
+	for count > 0 {
+		values = values[:0]
+		for range min(count, cap(values)) {
+			values = append(values, data)
+		}
+		if err := store.client.LPush(store.ctx, store.queueKey(constants.PriorityNormal), values...).Err(); err != nil {
+			b.Fatal(err)
+		}
+		count -= len(values)
+	}
+}
+
+func BenchmarkBlockingDequeuePayments(b *testing.B) {
+	for _, bc := range []struct {
+		name  string
+		count int
+	}{{"single", 1}, {"batch16", 16}, {"batch64", 64}} {
+		b.Run(bc.name, func(b *testing.B) {
+			store := benchStore(b)
+			fillQueue(b, store, b.N)
+			b.ResetTimer()
+
+			for dequeued := 0; dequeued < b.N; {
+				payments, err := store.BlockingDequeuePayments(time.Second, constants.Priorities, bc.count)
+				if err != nil {
+					b.Fatal(err)
+				}
+				dequeued += len(payments)
+			}
+		})
+	}
+}
+
+func BenchmarkUpdateSummary(b *testing.B) {
+	// eval is the path before pipelining: one script call, and round trip,
+	// per payment.
+	b.Run("eval", func(b *testing.B) {
+		store := benchStore(b)
+		keys := []string{"payments:default:records", "summary:total_amount:default", "summary:total_count:default"}
+		b.SetParallelism(benchParallelism)
+		b.ResetTimer()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				member := time.Now().UnixNano() + int64(i)
+				if err := summaryScript.Run(store.ctx, store.client, keys, time.Now().Unix(), member, 19.9).Err(); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+
+	b.Run("pipelined", func(b *testing.B) {
+		store := benchStore(b)
+		b.SetParallelism(benchParallelism)
+		b.ResetTimer()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := store.UpdateSummary("", constants.DefaultProcessorKey, "", 19.9); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
+
+func BenchmarkSetProcessedPayment(b *testing.B) {
+	b.Run("direct", func(b *testing.B) {
+		store := benchStore(b)
+		b.SetParallelism(benchParallelism)
+		b.ResetTimer()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := store.client.SetNX(store.ctx, "processed:bench", "default", time.Minute).Err(); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+
+	b.Run("pipelined", func(b *testing.B) {
+		store := benchStore(b)
+		b.SetParallelism(benchParallelism)
+		b.ResetTimer()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := store.SetProcessedPayment("bench", constants.DefaultProcessorKey, time.Minute); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedisClient connects to the deployment described by config. A URL with a
+// scheme goes through the go-redis parser of its mode; anything else is taken
+// as addresses, or as a Unix socket when it is a path.
+func newRedisClient(config config.RedisConfig) (redis.UniversalClient, error) {
+	switch config.Mode {
+	case constants.RedisStandalone, "":
+		return newStandaloneClient(config)
+	case constants.RedisSentinel:
+		return newSentinelClient(config)
+	case constants.RedisCluster:
+		return newClusterClient(config)
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", config.Mode)
+	}
+}
+
+func newStandaloneClient(config config.RedisConfig) (redis.UniversalClient, error) {
+	var opt *redis.Options
+	switch {
+	case hasScheme(config.URL):
+		parsed, err := redis.ParseURL(config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse redis url: %w", err)
+		}
+		opt = parsed
+	case strings.HasPrefix(config.URL, "/"):
+		opt = &redis.Options{Network: "unix", Addr: config.URL}
+	default:
+		opt = &redis.Options{Addr: config.URL}
+	}
+
+	opt.Username = cmp.Or(config.Username, opt.Username)
+	opt.Password = cmp.Or(config.Password, opt.Password)
+	opt.DB = cmp.Or(config.DB, opt.DB)
+	opt.PoolSize = cmp.Or(config.PoolSize, opt.PoolSize)
+	opt.MinIdleConns = cmp.Or(config.MinIdleConns, opt.MinIdleConns)
+
+	tlsConfig, err := redisTLSConfig(config, opt.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	opt.TLSConfig = tlsConfig
+
+	return redis.NewClient(opt), nil
+}
+
+// newSentinelClient connects to the master named by config through its
+// sentinels. Credentials in the URL are those of the sentinels, as with
+// redis.ParseFailoverURL.
+func newSentinelClient(config config.RedisConfig) (redis.UniversalClient, error) {
+	opt := &redis.FailoverOptions{}
+	if hasScheme(config.URL) {
+		parsed, err := redis.ParseFailoverURL(config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse redis sentinel url: %w", err)
+		}
+		opt = parsed
+	} else {
+		opt.SentinelAddrs = splitAddrs(config.URL)
+	}
+
+	opt.MasterName = cmp.Or(config.MasterName, opt.MasterName)
+	if opt.MasterName == "" {
+		return nil, fmt.Errorf("redis sentinel mode needs a master name")
+	}
+
+	opt.Username = cmp.Or(config.Username, opt.Username)
+	opt.Password = cmp.Or(config.Password, opt.Password)
+	opt.SentinelUsername = cmp.Or(config.SentinelUsername, opt.SentinelUsername)
+	opt.SentinelPassword = cmp.Or(config.SentinelPassword, opt.SentinelPassword)
+	opt.DB = cmp.Or(config.DB, opt.DB)
+	opt.PoolSize = cmp.Or(config.PoolSize, opt.PoolSize)
+	opt.MinIdleConns = cmp.Or(config.MinIdleConns, opt.MinIdleConns)
+
+	tlsConfig, err := redisTLSConfig(config, opt.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	opt.TLSConfig = tlsConfig
+
+	return redis.NewFailoverClient(opt), nil
+}
+
+// newClusterClient connects to a Redis Cluster from its seed nodes, the
+// remaining nodes being discovered from them.
+func newClusterClient(config config.RedisConfig) (redis.UniversalClient, error) {
+	if config.DB > 0 {
+		return nil, fmt.Errorf("redis cluster mode only has database 0")
+	}
+
+	opt := &redis.ClusterOptions{}
+	if hasScheme(config.URL) {
+		parsed, err := redis.ParseClusterURL(config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse redis cluster url: %w", err)
+		}
+		opt = parsed
+	} else {
+		opt.Addrs = splitAddrs(config.URL)
+	}
+
+	opt.Username = cmp.Or(config.Username, opt.Username)
+	opt.Password = cmp.Or(config.Password, opt.Password)
+	opt.PoolSize = cmp.Or(config.PoolSize, opt.PoolSize)
+	opt.MinIdleConns = cmp.Or(config.MinIdleConns, opt.MinIdleConns)
+
+	tlsConfig, err := redisTLSConfig(config, opt.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	opt.TLSConfig = tlsConfig
+
+	return redis.NewClusterClient(opt), nil
+}
+
+// redisTLSConfig returns the TLS settings of config, built on those parsed
+// from a rediss:// URL. Without REDIS_TLS the parsed settings are kept as is.
+func redisTLSConfig(config config.RedisConfig, parsed *tls.Config) (*tls.Config, error) {
+	if !config.TLS {
+		return parsed, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if parsed != nil {
+		tlsConfig = parsed.Clone()
+		tlsConfig.MinVersion = max(tlsConfig.MinVersion, tls.VersionTLS12)
+	}
+
+	tlsConfig.ServerName = cmp.Or(config.TLSServerName, tlsConfig.ServerName)
+	tlsConfig.InsecureSkipVerify = config.TLSInsecureSkipVerify
+
+	if config.TLSCAFile != "" {
+		pem, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in redis ca file %s", config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+func hasScheme(url string) bool {
+	return strings.Contains(url, "://")
+}
+
+func splitAddrs(addrs string) []string {
+	var result []string
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
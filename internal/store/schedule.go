@@ -0,0 +1,214 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	scheduleKey     = "payment_schedule"
+	scheduleDataKey = "payment_schedule:data"
+)
+
+// merchantScheduleKey returns the sorted set indexing the scheduled payments
+// of a merchant, scored like the schedule, so listing them does not go through
+// every merchant's. The scripts derive it from the schedule key, which keeps
+// it in the same slot.
+func (r *RedisStore) merchantScheduleKey(merchantID string) string {
+	return r.key(paymentsTag, scheduleKey) + ":merchant:" + merchantID
+}
+
+// scheduleScript holds a payment until it is due: its key goes into the
+// schedule sorted set KEYS[1], scored by due time, as well as the merchant
+// schedule KEYS[4] when there is one, and the payment itself into the hash
+// KEYS[2]. Like enqueueScript, it refuses payments whose status record KEYS[3]
+// is still active.
+var scheduleScript = redis.NewScript(`
+	local current = redis.call('GET', KEYS[3])
+	if current then
+		local status = cjson.decode(current).status
+		if status == 'scheduled' or status == 'queued' or status == 'processing' or status == 'succeeded' or status == 'refunded' then
+			return 1
+		end
+	end
+	redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+	redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+	redis.call('SET', KEYS[3], ARGV[4], 'EX', ARGV[5])
+	if KEYS[4] then
+		redis.call('ZADD', KEYS[4], ARGV[2], ARGV[1])
+	end
+	return 0
+`)
+
+// promoteScript moves up to ARGV[2] payments due at ARGV[1] from the schedule
+// into their queue lane, KEYS[3], KEYS[4] and KEYS[5] being the high, normal
+// and low lanes, or only removes them from the schedule when ARGV[3] is 0. The
+// payments leave their merchant schedule as well. It returns the promoted
+// payments.
+var promoteScript = redis.NewScript(`
+	local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+	local promoted = {}
+	for _, key in ipairs(due) do
+		local data = redis.call('HGET', KEYS[2], key)
+		redis.call('ZREM', KEYS[1], key)
+		redis.call('HDEL', KEYS[2], key)
+		if data then
+			local payment = cjson.decode(data)
+			if payment.MerchantID then
+				redis.call('ZREM', KEYS[1] .. ':merchant:' .. payment.MerchantID, key)
+			end
+			local priority = payment.Priority
+			local lane = KEYS[4]
+			if priority == 'high' then
+				lane = KEYS[3]
+			elseif priority == 'low' then
+				lane = KEYS[5]
+			end
+			if ARGV[3] == '1' then
+				redis.call('LPUSH', lane, data)
+			end
+			table.insert(promoted, data)
+		end
+	end
+	return promoted
+`)
+
+// unscheduleScript removes the payment ARGV[1] from the schedule KEYS[1], the
+// hash KEYS[2] and its merchant schedule, returning it, or nil when it was not
+// scheduled.
+var unscheduleScript = redis.NewScript(`
+	local data = redis.call('HGET', KEYS[2], ARGV[1])
+	redis.call('ZREM', KEYS[1], ARGV[1])
+	redis.call('HDEL', KEYS[2], ARGV[1])
+	if not data then
+		return false
+	end
+	local merchant = cjson.decode(data).MerchantID
+	if merchant then
+		redis.call('ZREM', KEYS[1] .. ':merchant:' .. merchant, ARGV[1])
+	end
+	return data
+`)
+
+// SchedulePayment holds a payment until its ScheduledAt. Its status record is
+// kept until paymentStatusTTL after that time.
+func (r *RedisStore) SchedulePayment(payment *models.QueuedPayment) (QueueAdmission, error) {
+	data := payment.AppendJSON(nil)
+	status, err := json.Marshal(paymentStatus(payment, constants.PaymentScheduled))
+	if err != nil {
+		return QueueFull, fmt.Errorf("failed to marshal payment status: %w", err)
+	}
+
+	ttl := time.Until(*payment.ScheduledAt) + paymentStatusTTL
+	keys := []string{r.key(paymentsTag, scheduleKey), r.key(paymentsTag, scheduleDataKey), r.statusKey(payment.Key())}
+	if payment.MerchantID != "" {
+		keys = append(keys, r.merchantScheduleKey(payment.MerchantID))
+	}
+	duplicate, err := scheduleScript.Run(r.ctx, r.client, keys,
+		payment.Key(), payment.ScheduledAt.UnixMilli(), data, status, int(ttl.Seconds())).Int()
+	if err != nil {
+		return QueueFull, fmt.Errorf("failed to schedule payment: %w", err)
+	}
+
+	if duplicate == 1 {
+		return QueueDuplicate, nil
+	}
+	return QueueAccepted, nil
+}
+
+// PromoteDuePayments moves up to limit payments due by now into the queue, or
+// only takes them off the schedule when enqueue is false, leaving them to the
+// caller. Promotion bypasses the queue limits, the payments having been
+// admitted when they were scheduled.
+func (r *RedisStore) PromoteDuePayments(now time.Time, limit int, enqueue bool) ([]*models.QueuedPayment, error) {
+	push := 0
+	if enqueue {
+		push = 1
+	}
+
+	keys := append([]string{r.key(paymentsTag, scheduleKey), r.key(paymentsTag, scheduleDataKey)},
+		r.queueKeys(constants.Priorities)...)
+	promoted, err := promoteScript.Run(r.ctx, r.client, keys, now.UnixMilli(), limit, push).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to promote scheduled payments: %w", err)
+	}
+
+	return decodePayments(promoted), nil
+}
+
+// UnschedulePayment removes a pending scheduled payment, returning it, or nil
+// when it was not scheduled.
+func (r *RedisStore) UnschedulePayment(key string) (*models.QueuedPayment, error) {
+	data, err := unscheduleScript.Run(r.ctx, r.client,
+		[]string{r.key(paymentsTag, scheduleKey), r.key(paymentsTag, scheduleDataKey)}, key).Text()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unschedule payment: %w", err)
+	}
+
+	var payment models.QueuedPayment
+	err = payment.DecodeJSONString(data)
+	return &payment, err
+}
+
+// ListScheduledPayments returns up to limit pending payments of a merchant, or
+// of every merchant when merchantID is empty, the soonest due first. With a
+// clientID, only the payments of that API client and those submitted without
+// one are returned, which may take going through the schedule page by page.
+func (r *RedisStore) ListScheduledPayments(merchantID, clientID string, limit int) ([]*models.QueuedPayment, error) {
+	schedule := r.key(paymentsTag, scheduleKey)
+	if merchantID != "" {
+		schedule = r.merchantScheduleKey(merchantID)
+	}
+
+	var payments []*models.QueuedPayment
+	for start := int64(0); len(payments) < limit; start += int64(limit) {
+		keys, err := r.client.ZRange(r.ctx, schedule, start, start+int64(limit)-1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list scheduled payments: %w", err)
+		}
+		if len(keys) == 0 {
+			break
+		}
+
+		values, err := r.client.HMGet(r.ctx, r.key(paymentsTag, scheduleDataKey), keys...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get scheduled payments: %w", err)
+		}
+
+		data := make([]string, 0, len(values))
+		for _, value := range values {
+			if str, ok := value.(string); ok {
+				data = append(data, str)
+			}
+		}
+		for _, payment := range decodePayments(data) {
+			if clientID == "" || payment.ClientID == "" || payment.ClientID == clientID {
+				payments = append(payments, payment)
+			}
+		}
+		if clientID == "" || len(keys) < limit {
+			break
+		}
+	}
+	return payments[:min(len(payments), limit)], nil
+}
+
+func decodePayments(data []string) []*models.QueuedPayment {
+	payments := make([]*models.QueuedPayment, 0, len(data))
+	for _, item := range data {
+		var payment models.QueuedPayment
+		if err := payment.DecodeJSONString(item); err != nil {
+			continue
+		}
+		payments = append(payments, &payment)
+	}
+	return payments
+}
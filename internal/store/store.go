@@ -1,4 +1,139 @@
 package store
 
-type Store struct {
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/config"
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+)
+
+// ErrNotFound is returned by lookups of records that do not exist, whatever
+// the backend. Callers compare against it with errors.Is.
+var ErrNotFound = errors.New("not found")
+
+// ErrOutOfMemory is returned by writes refused because the store reached its
+// memory limit, which the gateway reports as a full queue.
+var ErrOutOfMemory = errors.New("store is out of memory")
+
+// ErrInvalidEventID is returned when reading events after an ID the event
+// stream of the store could not have assigned.
+var ErrInvalidEventID = errors.New("invalid event id")
+
+// Store holds the state of the gateway: payment statuses and their queue,
+// summaries, processor health, idempotency keys, rate limits, API clients,
+// webhook deliveries and the payment event stream. RedisStore is the default;
+// PostgresStore keeps the payment history durable.
+type Store interface {
+	GetPaymentStatus(key string) (*models.PaymentResult, error)
+	CompletePayment(result *models.PaymentResult) error
+	SubscribePaymentResults(ctx context.Context) <-chan *models.PaymentResult
+	TransitionPayment(key string, to constants.PaymentStatus, from ...constants.PaymentStatus) (*models.PaymentResult, bool, error)
+	RefundPayment(key, refundID string, amount float64, defaultCurrency string) (RefundOutcome, *models.Refund, *models.PaymentResult, error)
+	SetProcessedPayment(key string, processor constants.PaymentMode, ttl time.Duration) (bool, error)
+	RemoveProcessedPayment(key string) (int64, error)
+
+	EnqueuePayment(payment *models.QueuedPayment) error
+	EnqueuePaymentWithLimit(payment *models.QueuedPayment, hardLimit, softLimit int, roll float64) (QueueAdmission, int64, error)
+	EnqueuePayments(payments []*models.QueuedPayment, hardLimit, softLimit int, roll float64) ([]QueueAdmission, int64, error)
+	MarkPaymentsQueued(payments []*models.QueuedPayment) ([]QueueAdmission, error)
+	BlockingDequeuePayments(timeout time.Duration, lanes []constants.PaymentPriority, count int) ([]*models.QueuedPayment, error)
+	DeadLetterPayment(payment *models.QueuedPayment) error
+	QueueDepths() (map[constants.PaymentPriority]int64, error)
+
+	SchedulePayment(payment *models.QueuedPayment) (QueueAdmission, error)
+	PromoteDuePayments(now time.Time, limit int, enqueue bool) ([]*models.QueuedPayment, error)
+	UnschedulePayment(key string) (*models.QueuedPayment, error)
+	ListScheduledPayments(merchantID, clientID string, limit int) ([]*models.QueuedPayment, error)
+
+	UpdateSummary(merchantID string, processor constants.PaymentMode, currency string, amount float64) error
+	GetSummary(merchantID string, processors []constants.PaymentMode, currency string, from, to *time.Time) (*models.PaymentSummaryResponse, error)
+
+	GetProcessorsHealth(processors []constants.PaymentMode) (map[constants.PaymentMode]*models.ProcessorHealth, error)
+	SetProcessorHealth(processor constants.PaymentMode, health models.ProcessorHealth) error
+
+	ClaimIdempotencyKey(key, requestHash string, ttl time.Duration) (*IdempotentResponse, error)
+	SaveIdempotentResponse(key string, response *IdempotentResponse, ttl time.Duration) error
+	ReleaseIdempotencyKey(key string) error
+
+	TakeTokens(count int, buckets ...TokenBucket) (*RateLimitResult, error)
+
+	SaveAPIClient(client *models.APIClient) error
+	GetAPIClient(id string) (*models.APIClient, error)
+	ListAPIClients() ([]*models.APIClient, error)
+	ReplaceAPIKey(clientID, keyHash, oldKeyHash string) error
+	GetAPIClientByKeyHash(keyHash string) (*models.APIClient, error)
+
+	SaveWebhookDelivery(delivery *models.WebhookDelivery) error
+	GetWebhookDelivery(id string) (*models.WebhookDelivery, error)
+	ListWebhookDeliveries(correlationID string) ([]*models.WebhookDelivery, error)
+	ClaimDueWebhooks(lease time.Duration, limit int) ([]string, error)
+	AppendWebhookAttempt(id string, attempt models.WebhookAttempt) error
+	GetWebhookAttempts(id string) ([]models.WebhookAttempt, error)
+	DropWebhookDelivery(id string) error
+
+	AppendEvents(events []*models.PaymentEvent, maxLen int64) error
+	ReadEvents(after string, count int, block time.Duration) ([]*models.PaymentEvent, error)
+	LastEventID() (string, error)
+
+	PeekQueue(priority constants.PaymentPriority, limit int) ([]*models.QueuedPayment, error)
+	PeekDeadLetters(limit int) ([]*models.QueuedPayment, error)
+	RecomputeSummary(merchantID string, processor constants.PaymentMode, currency string) error
+	PurgeQueue() ([]*models.QueuedPayment, error)
+	PurgeScheduledPayments() ([]*models.QueuedPayment, error)
+	PurgeDeadLetters() (int64, error)
+	PurgeProcessorsHealth() (int64, error)
+	PurgeSummaries() (int64, error)
+}
+
+// DrainMeter is implemented by stores that measure how fast the queue drains
+// across instances. Backpressure type-asserts for it; without one, Retry-After
+// is always MaxRetryAfter.
+type DrainMeter interface {
+	RecordDequeued(count int64) error
+	DrainRate(window time.Duration) (float64, error)
+}
+
+// MemoryMeter is implemented by stores that evict or refuse writes past a
+// memory limit, so payments can be refused before they get there.
+type MemoryMeter interface {
+	MemoryUsage() (float64, error)
+}
+
+// New opens the storage backend selected by the config.
+func New(config *config.Config) (Store, error) {
+	switch config.StorageBackend {
+	case constants.StorageRedis, "":
+		return NewRedisStore(config.Redis)
+	case constants.StoragePostgres:
+		return NewPostgresStore(config.DatabaseURL)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", config.StorageBackend)
+	}
+}
+
+var (
+	_ Store = (*RedisStore)(nil)
+	_ Store = (*PostgresStore)(nil)
+
+	_ DrainMeter  = (*RedisStore)(nil)
+	_ DrainMeter  = (*PostgresStore)(nil)
+	_ MemoryMeter = (*RedisStore)(nil)
+)
+
+// paymentStatus returns the status record of a payment entering the given
+// status.
+func paymentStatus(payment *models.QueuedPayment, status constants.PaymentStatus) *models.PaymentResult {
+	return &models.PaymentResult{
+		CorrelationID: payment.CorrelationID,
+		MerchantID:    payment.MerchantID,
+		ClientID:      payment.ClientID,
+		Status:        status,
+		Amount:        payment.Amount,
+		Currency:      payment.Currency,
+		UpdatedAt:     time.Now().UTC(),
+	}
 }
@@ -0,0 +1,297 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/mochaeng/payment-gateway/internal/models"
+)
+
+// paymentLogCompactSize is the log size past which completed payments are
+// dropped from it.
+const paymentLogCompactSize = 64 << 20
+
+// PaymentLog is the append-only write-ahead log of an in-process queue. Each
+// line records either a queued payment, "+" followed by the payment JSON, or a
+// completed one, "-" followed by its quoted key. The payments queued and not
+// completed are the ones to replay after a crash.
+//
+// Append syncs the log before returning, and concurrent appends share a sync.
+// Completions are not synced: a payment completed just before a crash is
+// replayed, and then skipped by the worker as its status is final.
+type PaymentLog struct {
+	path string
+
+	// syncMu serializes syncs and compactions, and is taken before mu.
+	syncMu sync.Mutex
+	synced uint64
+
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	size    int64
+	written uint64
+	pending map[string]loggedPayment
+	next    uint64
+}
+
+// loggedPayment is a pending payment of the log, seq keeping the order in
+// which payments were first queued.
+type loggedPayment struct {
+	seq  uint64
+	data []byte
+}
+
+// OpenPaymentLog opens the log at path, creating it if needed, and returns
+// the payments it holds that were not completed, in the order they were
+// queued. The log is compacted to those payments.
+func OpenPaymentLog(path string) (*PaymentLog, []*models.QueuedPayment, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create payment log directory: %w", err)
+	}
+
+	l := &PaymentLog{path: path, pending: make(map[string]loggedPayment)}
+	if err := l.replay(); err != nil {
+		return nil, nil, err
+	}
+
+	records := l.pendingRecords()
+	payments := make([]*models.QueuedPayment, 0, len(records))
+	for _, data := range records {
+		var payment models.QueuedPayment
+		if err := payment.DecodeJSON(data); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode logged payment: %w", err)
+		}
+		payments = append(payments, &payment)
+	}
+
+	if err := l.rewrite(records); err != nil {
+		return nil, nil, err
+	}
+	return l, payments, nil
+}
+
+// replay loads the pending payments of the log. A torn last line, left by a
+// crash during a write, is ignored.
+func (l *PaymentLog) replay() error {
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read payment log: %w", err)
+	}
+
+	for len(data) > 0 {
+		line, rest, complete := bytes.Cut(data, []byte{'\n'})
+		if !complete {
+			break
+		}
+		data = rest
+
+		if len(line) == 0 {
+			continue
+		}
+		switch line[0] {
+		case '+':
+			var payment models.QueuedPayment
+			if err := payment.DecodeJSON(line[1:]); err != nil {
+				return fmt.Errorf("failed to decode payment log entry: %w", err)
+			}
+			l.track(payment.Key(), line[1:])
+		case '-':
+			key, err := strconv.Unquote(string(line[1:]))
+			if err != nil {
+				return fmt.Errorf("failed to decode payment log entry: %w", err)
+			}
+			delete(l.pending, key)
+		default:
+			return fmt.Errorf("invalid payment log entry %q", line)
+		}
+	}
+	return nil
+}
+
+// track records a pending payment, keeping its place in the queue order when
+// it was already pending.
+func (l *PaymentLog) track(key string, data []byte) {
+	entry, ok := l.pending[key]
+	if !ok {
+		entry.seq = l.next
+		l.next++
+	}
+	entry.data = data
+	l.pending[key] = entry
+}
+
+// pendingRecords returns the pending payments in the order they were queued.
+func (l *PaymentLog) pendingRecords() [][]byte {
+	entries := make([]loggedPayment, 0, len(l.pending))
+	for _, entry := range l.pending {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b loggedPayment) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	records := make([][]byte, len(entries))
+	for i, entry := range entries {
+		records[i] = entry.data
+	}
+	return records
+}
+
+// Append logs queued payments and syncs them to disk. Appending a payment
+// already in the log replaces it, as retries do to record their count.
+func (l *PaymentLog) Append(payments ...*models.QueuedPayment) error {
+	l.mu.Lock()
+	for _, payment := range payments {
+		data := payment.AppendJSON(nil)
+		l.writeRecord('+', data)
+		l.track(payment.Key(), data)
+	}
+	err := l.writer.Flush()
+	l.written++
+	seq := l.written
+	l.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to write payment log: %w", err)
+	}
+	return l.sync(seq)
+}
+
+// Complete logs that a payment left the queue for good.
+func (l *PaymentLog) Complete(key string) error {
+	l.mu.Lock()
+	delete(l.pending, key)
+	l.writeRecord('-', strconv.AppendQuote(nil, key))
+	err := l.writer.Flush()
+	compact := l.size > paymentLogCompactSize
+	l.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to write payment log: %w", err)
+	}
+	if compact {
+		return l.compact()
+	}
+	return nil
+}
+
+func (l *PaymentLog) writeRecord(kind byte, data []byte) {
+	l.writer.WriteByte(kind)
+	l.writer.Write(data)
+	l.writer.WriteByte('\n')
+	l.size += int64(len(data)) + 2
+}
+
+// sync makes the appends up to seq durable. A single fsync covers every
+// append written before it started.
+func (l *PaymentLog) sync(seq uint64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+
+	if l.synced >= seq {
+		return nil
+	}
+
+	l.mu.Lock()
+	file, written := l.file, l.written
+	l.mu.Unlock()
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync payment log: %w", err)
+	}
+	l.synced = written
+	return nil
+}
+
+// compact rewrites the log with only its pending payments.
+func (l *PaymentLog) compact() error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size <= paymentLogCompactSize {
+		return nil
+	}
+
+	if err := l.rewrite(l.pendingRecords()); err != nil {
+		return err
+	}
+	l.synced = l.written
+	return nil
+}
+
+// rewrite atomically replaces the log with one holding only the given payment
+// records, and reopens it for appending.
+func (l *PaymentLog) rewrite(records [][]byte) error {
+	tmpPath := l.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to compact payment log: %w", err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	var size int64
+	for _, data := range records {
+		writer.WriteByte('+')
+		writer.Write(data)
+		writer.WriteByte('\n')
+		size += int64(len(data)) + 2
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact payment log: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact payment log: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to compact payment log: %w", err)
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		return fmt.Errorf("failed to compact payment log: %w", err)
+	}
+	if dir, err := os.Open(filepath.Dir(l.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open payment log: %w", err)
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	l.size = size
+	return nil
+}
+
+// Close closes the log. Pending payments stay in it for the next start.
+func (l *PaymentLog) Close() error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.writer.Flush(); err != nil {
+		l.file.Close()
+		return fmt.Errorf("failed to write payment log: %w", err)
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mochaeng/payment-gateway/internal/models"
+)
+
+func walPayment(id string, retries int) *models.QueuedPayment {
+	return &models.QueuedPayment{CorrelationID: id, Amount: 10, RetryCount: retries}
+}
+
+func correlationIDs(payments []*models.QueuedPayment) []string {
+	ids := make([]string, len(payments))
+	for i, payment := range payments {
+		ids[i] = payment.CorrelationID
+	}
+	return ids
+}
+
+func TestPaymentLog_ReplaysPendingPaymentsInOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+
+	log, pending, err := OpenPaymentLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("new log replayed %d payments", len(pending))
+	}
+
+	if err := log.Append(walPayment("a", 0), walPayment("b", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Append(walPayment("c", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Complete(walPayment("b", 0).Key()); err != nil {
+		t.Fatal(err)
+	}
+	// a retry replaces the payment without moving it
+	if err := log.Append(walPayment("a", 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	log, pending, err = OpenPaymentLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	if got := correlationIDs(pending); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatalf("replayed %v, want [a c]", got)
+	}
+	if pending[0].RetryCount != 2 {
+		t.Errorf("replayed retry count %d, want 2", pending[0].RetryCount)
+	}
+}
+
+func TestPaymentLog_IgnoresTornLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+
+	log, _, err := OpenPaymentLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Append(walPayment("a", 0)); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`+{"CorrelationID":"b","Amo`)
+	file.Close()
+
+	log, pending, err := OpenPaymentLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	if got := correlationIDs(pending); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("replayed %v, want [a]", got)
+	}
+}
+
+func TestPaymentLog_CompactsOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+
+	log, _, err := OpenPaymentLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := log.Append(walPayment(id, 0)); err != nil {
+			t.Fatal(err)
+		}
+		if err := log.Complete(walPayment(id, 0).Key()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+
+	log, pending, err := OpenPaymentLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	if len(pending) != 0 {
+		t.Fatalf("replayed %d completed payments", len(pending))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("compacted log holds %d bytes, want 0", info.Size())
+	}
+}
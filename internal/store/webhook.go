@@ -0,0 +1,141 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mochaeng/payment-gateway/internal/constants"
+	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	webhookDeliveryPrefix = "webhook:delivery:"
+	webhookLogPrefix      = "webhook:log:"
+	webhookPaymentPrefix  = "webhook:payment:"
+
+	webhookScheduleKey = "webhook:schedule"
+
+	webhookRetention   = 7 * 24 * time.Hour
+	webhookMaxLogItems = 50
+)
+
+// claimWebhooksScript returns the deliveries due at ARGV[1] and pushes them
+// ARGV[2] milliseconds into the future, so an instance that dies mid-delivery
+// only delays the webhook instead of losing it.
+var claimWebhooksScript = redis.NewScript(`
+	local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+	for _, id in ipairs(ids) do
+		redis.call('ZADD', KEYS[1], ARGV[1] + ARGV[2], id)
+	end
+	return ids
+`)
+
+func (r *RedisStore) SaveWebhookDelivery(delivery *models.WebhookDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook delivery: %w", err)
+	}
+
+	paymentKey := r.key(webhooksTag, webhookPaymentPrefix+delivery.CorrelationID)
+	scheduleKey := r.key(webhooksTag, webhookScheduleKey)
+
+	pipe := r.client.TxPipeline()
+	pipe.Set(r.ctx, r.key(webhooksTag, webhookDeliveryPrefix+delivery.ID), data, webhookRetention)
+	pipe.SAdd(r.ctx, paymentKey, delivery.ID)
+	pipe.Expire(r.ctx, paymentKey, webhookRetention)
+	if delivery.Status == constants.WebhookPending {
+		pipe.ZAdd(r.ctx, scheduleKey, redis.Z{
+			Score:  float64(delivery.NextAttemptAt.UnixMilli()),
+			Member: delivery.ID,
+		})
+	} else {
+		pipe.ZRem(r.ctx, scheduleKey, delivery.ID)
+	}
+
+	_, err = pipe.Exec(r.ctx)
+	return err
+}
+
+func (r *RedisStore) GetWebhookDelivery(id string) (*models.WebhookDelivery, error) {
+	data, err := r.client.Get(r.ctx, r.key(webhooksTag, webhookDeliveryPrefix+id)).Bytes()
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	var delivery models.WebhookDelivery
+	err = json.Unmarshal(data, &delivery)
+	return &delivery, err
+}
+
+func (r *RedisStore) ListWebhookDeliveries(correlationID string) ([]*models.WebhookDelivery, error) {
+	ids, err := r.client.SMembers(r.ctx, r.key(webhooksTag, webhookPaymentPrefix+correlationID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+
+	deliveries := make([]*models.WebhookDelivery, 0, len(ids))
+	for _, id := range ids {
+		delivery, err := r.GetWebhookDelivery(id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// ClaimDueWebhooks returns up to limit deliveries due now, leasing them to the
+// caller for the given duration.
+func (r *RedisStore) ClaimDueWebhooks(lease time.Duration, limit int) ([]string, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	return claimWebhooksScript.Run(r.ctx, r.client, []string{r.key(webhooksTag, webhookScheduleKey)},
+		now, lease.Milliseconds(), limit).StringSlice()
+}
+
+func (r *RedisStore) AppendWebhookAttempt(id string, attempt models.WebhookAttempt) error {
+	data, err := json.Marshal(attempt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook attempt: %w", err)
+	}
+
+	logKey := r.key(webhooksTag, webhookLogPrefix+id)
+
+	pipe := r.client.Pipeline()
+	pipe.LPush(r.ctx, logKey, data)
+	pipe.LTrim(r.ctx, logKey, 0, webhookMaxLogItems-1)
+	pipe.Expire(r.ctx, logKey, webhookRetention)
+	_, err = pipe.Exec(r.ctx)
+	return err
+}
+
+// GetWebhookAttempts returns the logged attempts of a delivery, oldest first.
+func (r *RedisStore) GetWebhookAttempts(id string) ([]models.WebhookAttempt, error) {
+	items, err := r.client.LRange(r.ctx, r.key(webhooksTag, webhookLogPrefix+id), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook attempts: %w", err)
+	}
+
+	attempts := make([]models.WebhookAttempt, 0, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		var attempt models.WebhookAttempt
+		if err := json.Unmarshal([]byte(items[i]), &attempt); err != nil {
+			continue
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, nil
+}
+
+// DropWebhookDelivery removes a delivery from the retry schedule.
+func (r *RedisStore) DropWebhookDelivery(id string) error {
+	return r.client.ZRem(r.ctx, r.key(webhooksTag, webhookScheduleKey), id).Err()
+}
@@ -1,11 +1,15 @@
 package integration
 
 import (
+	"bufio"
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,6 +17,7 @@ import (
 	"github.com/mochaeng/payment-gateway/internal/config"
 	"github.com/mochaeng/payment-gateway/internal/constants"
 	"github.com/mochaeng/payment-gateway/internal/models"
+	"github.com/mochaeng/payment-gateway/internal/services"
 	"github.com/stretchr/testify/suite"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -21,11 +26,16 @@ import (
 
 type IntegrationTestSuite struct {
 	suite.Suite
-	redisURL       string
-	redisContainer testcontainers.Container
-	ctx            context.Context
-	app            *app.Application
-	mockProcessors *MockProcessors
+	// backend is the storage backend under test, Redis when empty.
+	backend           constants.StorageBackend
+	redisURL          string
+	redisContainer    testcontainers.Container
+	databaseURL       string
+	postgresContainer testcontainers.Container
+	ctx               context.Context
+	app               *app.Application
+	config            *config.Config
+	mockProcessors    *MockProcessors
 }
 
 type MockProcessors struct {
@@ -35,6 +45,8 @@ type MockProcessors struct {
 	fallbackHealth   models.HealthResponse
 	defaultPayments  []models.PaymentProcessorRequest
 	fallbackPayments []models.PaymentProcessorRequest
+	defaultDelay     time.Duration
+	defaultStatus    int
 }
 
 func (suite *IntegrationTestSuite) SetupSuite() {
@@ -61,33 +73,75 @@ func (suite *IntegrationTestSuite) SetupSuite() {
 
 	suite.redisURL = fmt.Sprintf("redis://%s:%s", host, port.Port())
 
+	if suite.backend == constants.StoragePostgres {
+		suite.setupPostgres()
+	}
+
 	suite.setupMockProcessors()
 
 	testConfig := &config.Config{
 		Port:                "8080",
-		RedisURL:            suite.redisURL,
+		Redis:               config.RedisConfig{URL: suite.redisURL},
+		StorageBackend:      cmp.Or(suite.backend, constants.StorageRedis),
+		DatabaseURL:         suite.databaseURL,
 		HealthCheckInterval: 1 * time.Second,
+		SchedulerInterval:   500 * time.Millisecond,
 		RequestTimeout:      2 * time.Second,
+		ConnectTimeout:      500 * time.Millisecond,
+		HealthCheckTimeout:  1 * time.Second,
+		PaymentDeadline:     30 * time.Second,
 		MaxQueueSize:        100,
-		ProcessorThreshold:  300,
-		Urls:                make(map[constants.PaymentMode]*config.ProcessorsConfig),
-	}
-
-	testConfig.Urls[constants.DefaultProcessorKey] = &config.ProcessorsConfig{
-		BaseURL:    suite.mockProcessors.defaultServer.URL,
-		PaymentURL: suite.mockProcessors.defaultServer.URL + "/payments",
-		HealthURL:  suite.mockProcessors.defaultServer.URL + "/payments/service-health",
-	}
-
-	testConfig.Urls[constants.FallbackProcessorKey] = &config.ProcessorsConfig{
-		BaseURL:    suite.mockProcessors.fallbackServer.URL,
-		PaymentURL: suite.mockProcessors.fallbackServer.URL + "/payments",
-		HealthURL:  suite.mockProcessors.fallbackServer.URL + "/payments/service-health",
+		QueueSoftLimit:      80,
+		MaxRetryAfter:       5 * time.Second,
+		MaxSyncWait:         5 * time.Second,
+		MaxBatchSize:        10,
+		MaxBodySize:         1024,
+		MaxBatchBodySize:    16 * 1024,
+		MaxAmount:           1_000_000,
+		IdempotencyTTL:      time.Minute,
+		Currencies:          map[string]int{"BRL": 2, "USD": 2, "JPY": 0},
+		DefaultCurrency:     "BRL",
+		Webhook: config.WebhookConfig{
+			Enabled:      true,
+			Secret:       "test-secret",
+			AllowedHosts: []string{"127.0.0.1"},
+			Timeout:      1 * time.Second,
+			MaxAttempts:  3,
+			Backoff:      100 * time.Millisecond,
+			MaxBackoff:   1 * time.Second,
+		},
+		Events: config.EventsConfig{
+			Enabled:   true,
+			MaxLen:    1000,
+			Heartbeat: time.Second,
+		},
+		RateLimit: config.RateLimitConfig{
+			Enabled:   true,
+			Client:    config.RateLimitRule{Rate: 1000, Burst: 1000},
+			Overrides: map[string]config.RateLimitRule{},
+		},
+		ProcessorThreshold: 300,
+		Workers:            4,
+		Limiter: config.LimiterConfig{
+			Initial:   10,
+			Min:       1,
+			Max:       50,
+			Tolerance: 2,
+			Backoff:   0.9,
+			RTTWindow: 10 * time.Second,
+		},
+		Processors: []*config.ProcessorConfig{
+			config.NewProcessorConfig(constants.DefaultProcessorKey, 0, suite.mockProcessors.defaultServer.URL),
+			config.NewProcessorConfig(constants.FallbackProcessorKey, 1, suite.mockProcessors.fallbackServer.URL),
+		},
 	}
+	// Only the default processor is told the currency, and so takes USD.
+	testConfig.Processors[0].Currencies = []string{"BRL", "USD", "JPY"}
 
 	app, err := app.NewApp(testConfig)
 	suite.Require().NoError(err)
 	suite.app = app
+	suite.config = testConfig
 
 	// wait for health monitoring
 	time.Sleep(2 * time.Second)
@@ -96,6 +150,8 @@ func (suite *IntegrationTestSuite) SetupSuite() {
 func (suite *IntegrationTestSuite) SetupTest() {
 	suite.mockProcessors.defaultPayments = []models.PaymentProcessorRequest{}
 	suite.mockProcessors.fallbackPayments = []models.PaymentProcessorRequest{}
+	suite.mockProcessors.defaultDelay = 0
+	suite.mockProcessors.defaultStatus = 0
 	suite.mockProcessors.defaultHealth = models.HealthResponse{
 		Failing:         false,
 		MinResponseTime: 100,
@@ -115,6 +171,41 @@ func (suite *IntegrationTestSuite) TearDownSuite() {
 	if suite.redisContainer != nil {
 		suite.redisContainer.Terminate(suite.ctx)
 	}
+
+	if suite.postgresContainer != nil {
+		suite.postgresContainer.Terminate(suite.ctx)
+	}
+}
+
+// setupPostgres starts a Postgres like the one of the payment processor
+// compose file.
+func (suite *IntegrationTestSuite) setupPostgres() {
+	req := testcontainers.ContainerRequest{
+		Image:        "postgres:17-alpine",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": "postgres",
+			"POSTGRES_USER":     "postgres",
+			"POSTGRES_DB":       "rinha",
+		},
+		// the server restarts once the database is initialized
+		WaitingFor: wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+	}
+
+	postgresContainer, err := testcontainers.GenericContainer(suite.ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	suite.Require().NoError(err)
+	suite.postgresContainer = postgresContainer
+
+	host, err := postgresContainer.Host(suite.ctx)
+	suite.Require().NoError(err)
+
+	port, err := postgresContainer.MappedPort(suite.ctx, "5432")
+	suite.Require().NoError(err)
+
+	suite.databaseURL = fmt.Sprintf("postgres://postgres:postgres@%s:%s/rinha?sslmode=disable", host, port.Port())
 }
 
 func (suite *IntegrationTestSuite) setupMockProcessors() {
@@ -144,13 +235,29 @@ func (suite *IntegrationTestSuite) setupMockProcessors() {
 					return
 				}
 
+				if suite.mockProcessors.defaultStatus != 0 {
+					w.WriteHeader(suite.mockProcessors.defaultStatus)
+					return
+				}
+
 				suite.mockProcessors.defaultPayments = append(suite.mockProcessors.defaultPayments, req)
+				time.Sleep(suite.mockProcessors.defaultDelay)
 				w.Header().Set("Content-Type", "application/json")
 				json.NewEncoder(w).Encode(models.PaymentProcessorResponse{
 					Message: "payment processed successfully",
 				})
 			}
 		default:
+			correlationID, ok := strings.CutPrefix(r.URL.Path, "/payments/")
+			if ok && r.Method == "GET" {
+				for _, payment := range suite.mockProcessors.defaultPayments {
+					if payment.CorrelationID == correlationID {
+						w.Header().Set("Content-Type", "application/json")
+						json.NewEncoder(w).Encode(payment)
+						return
+					}
+				}
+			}
 			w.WriteHeader(http.StatusNotFound)
 		}
 	}))
@@ -182,7 +289,7 @@ func (suite *IntegrationTestSuite) setupMockProcessors() {
 
 func (suite *IntegrationTestSuite) TestPaymentProcessing_SinglePayment() {
 	paymentReq := models.PaymentRequest{
-		CorrelationID: "test-0001",
+		CorrelationID: "00000000-0000-4000-8000-000000000001",
 		Amount:        25.50,
 	}
 
@@ -210,10 +317,664 @@ func (suite *IntegrationTestSuite) TestPaymentProcessing_SinglePayment() {
 	suite.Len(suite.mockProcessors.defaultPayments, 1)
 	suite.Len(suite.mockProcessors.fallbackPayments, 0)
 	suite.Len(suite.mockProcessors.defaultPayments, 1)
-	suite.Equal("test-0001", suite.mockProcessors.defaultPayments[0].CorrelationID)
+	suite.Equal("00000000-0000-4000-8000-000000000001", suite.mockProcessors.defaultPayments[0].CorrelationID)
 	suite.Equal(25.50, suite.mockProcessors.defaultPayments[0].Amount)
 }
 
+func (suite *IntegrationTestSuite) TestPaymentProcessing_WaitForResult() {
+	reqBody, err := json.Marshal(models.PaymentRequest{
+		CorrelationID: "00000000-0000-4000-8000-000000000002",
+		Amount:        10.00,
+	})
+	suite.Require().NoError(err)
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/payments")
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.Header.SetContentType("application/json")
+	ctx.Request.Header.Set("Prefer", "wait=3")
+	ctx.Request.SetBody(reqBody)
+
+	server := suite.app.Mount()
+	server.Handler(&ctx)
+
+	suite.Equal(http.StatusOK, ctx.Response.StatusCode())
+	suite.Equal("wait=3", string(ctx.Response.Header.Peek("Preference-Applied")))
+
+	var result models.PaymentResult
+	err = json.Unmarshal(ctx.Response.Body(), &result)
+	suite.Require().NoError(err)
+	suite.Equal(constants.PaymentSucceeded, result.Status)
+	suite.Equal(string(constants.DefaultProcessorKey), result.Processor)
+
+	var statusCtx fasthttp.RequestCtx
+	statusCtx.Request.SetRequestURI("/payments/00000000-0000-4000-8000-000000000002")
+	statusCtx.Request.Header.SetMethod("GET")
+	server.Handler(&statusCtx)
+
+	suite.Equal(http.StatusOK, statusCtx.Response.StatusCode())
+}
+
+func (suite *IntegrationTestSuite) TestPaymentProcessing_SignedWebhook() {
+	type delivery struct {
+		signature string
+		body      []byte
+	}
+	received := make(chan delivery, 1)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- delivery{signature: r.Header.Get("X-Webhook-Signature"), body: body}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	reqBody, err := json.Marshal(models.PaymentRequest{
+		CorrelationID: "00000000-0000-4000-8000-000000000003",
+		Amount:        12.34,
+		CallbackURL:   receiver.URL,
+	})
+	suite.Require().NoError(err)
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/payments")
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.Header.SetContentType("application/json")
+	ctx.Request.SetBody(reqBody)
+
+	server := suite.app.Mount()
+	server.Handler(&ctx)
+	suite.Equal(http.StatusOK, ctx.Response.StatusCode())
+
+	select {
+	case got := <-received:
+		var timestamp int64
+		_, err := fmt.Sscanf(got.signature, "t=%d,", &timestamp)
+		suite.Require().NoError(err)
+		suite.Equal(services.SignWebhook("test-secret", timestamp, got.body), got.signature)
+
+		var event models.WebhookEvent
+		suite.Require().NoError(json.Unmarshal(got.body, &event))
+		suite.Equal("payment.succeeded", event.Type)
+		suite.Equal("00000000-0000-4000-8000-000000000003", event.Data.CorrelationID)
+	case <-time.After(5 * time.Second):
+		suite.Fail("webhook was not delivered")
+	}
+}
+
+func (suite *IntegrationTestSuite) TestPaymentProcessing_TimeoutResolvedByLookup() {
+	suite.mockProcessors.defaultDelay = 3 * time.Second
+
+	reqBody, err := json.Marshal(models.PaymentRequest{
+		CorrelationID: "00000000-0000-4000-8000-000000000004",
+		Amount:        30.00,
+	})
+	suite.Require().NoError(err)
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/payments")
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.Header.SetContentType("application/json")
+	ctx.Request.Header.Set("Prefer", "wait=5")
+	ctx.Request.SetBody(reqBody)
+
+	server := suite.app.Mount()
+	server.Handler(&ctx)
+
+	suite.Equal(http.StatusOK, ctx.Response.StatusCode())
+
+	var result models.PaymentResult
+	suite.Require().NoError(json.Unmarshal(ctx.Response.Body(), &result))
+	suite.Equal(constants.PaymentSucceeded, result.Status)
+	suite.Equal(string(constants.DefaultProcessorKey), result.Processor)
+
+	suite.Len(suite.mockProcessors.defaultPayments, 1)
+	suite.Len(suite.mockProcessors.fallbackPayments, 0)
+}
+
+func (suite *IntegrationTestSuite) TestPaymentProcessing_PermanentErrorNotRetried() {
+	suite.mockProcessors.defaultStatus = http.StatusBadRequest
+
+	reqBody, err := json.Marshal(models.PaymentRequest{
+		CorrelationID: "00000000-0000-4000-8000-000000000005",
+		Amount:        5.00,
+	})
+	suite.Require().NoError(err)
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/payments")
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.Header.SetContentType("application/json")
+	ctx.Request.Header.Set("Prefer", "wait=3")
+	ctx.Request.SetBody(reqBody)
+
+	server := suite.app.Mount()
+	server.Handler(&ctx)
+
+	suite.Equal(http.StatusBadGateway, ctx.Response.StatusCode())
+
+	var result models.PaymentResult
+	suite.Require().NoError(json.Unmarshal(ctx.Response.Body(), &result))
+	suite.Equal(constants.PaymentFailed, result.Status)
+	suite.Len(suite.mockProcessors.fallbackPayments, 0)
+}
+
+func (suite *IntegrationTestSuite) TestPaymentProcessing_RateLimited() {
+	suite.config.RateLimit.Overrides["rate-limited-client"] = config.RateLimitRule{Rate: 0.1, Burst: 1}
+	defer delete(suite.config.RateLimit.Overrides, "rate-limited-client")
+
+	server := suite.app.Mount()
+	send := func(correlationID string) *fasthttp.RequestCtx {
+		reqBody, err := json.Marshal(models.PaymentRequest{
+			CorrelationID: correlationID,
+			Amount:        1.00,
+		})
+		suite.Require().NoError(err)
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/payments")
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.Header.SetContentType("application/json")
+		ctx.Request.Header.Set("X-Real-IP", "rate-limited-client")
+		ctx.Request.SetBody(reqBody)
+		server.Handler(ctx)
+		return ctx
+	}
+
+	suite.config.RateLimit.TrustProxy = true
+	defer func() { suite.config.RateLimit.TrustProxy = false }()
+
+	first := send("00000000-0000-4000-8000-000000000006")
+	suite.Equal(http.StatusOK, first.Response.StatusCode())
+	suite.Equal("1", string(first.Response.Header.Peek("RateLimit-Limit")))
+	suite.Equal("0", string(first.Response.Header.Peek("RateLimit-Remaining")))
+
+	second := send("00000000-0000-4000-8000-000000000007")
+	suite.Equal(http.StatusTooManyRequests, second.Response.StatusCode())
+	suite.NotEmpty(second.Response.Header.Peek("Retry-After"))
+}
+
+func (suite *IntegrationTestSuite) TestAPIKeys_RequiredWhenAuthEnabled() {
+	suite.config.AuthEnabled = true
+	suite.config.AdminToken = "admin-token"
+	defer func() {
+		suite.config.AuthEnabled = false
+		suite.config.AdminToken = ""
+	}()
+
+	server := suite.app.Mount()
+
+	var createCtx fasthttp.RequestCtx
+	createCtx.Request.SetRequestURI("/admin/api-clients")
+	createCtx.Request.Header.SetMethod("POST")
+	createCtx.Request.Header.Set("Authorization", "Bearer admin-token")
+	createCtx.Request.Header.SetContentType("application/json")
+	createCtx.Request.SetBodyString(`{"name":"orders","scopes":["payments:write"]}`)
+	server.Handler(&createCtx)
+	suite.Require().Equal(http.StatusCreated, createCtx.Response.StatusCode())
+
+	var created models.APIKeyResponse
+	suite.Require().NoError(json.Unmarshal(createCtx.Response.Body(), &created))
+	suite.NotEmpty(created.APIKey)
+	suite.Empty(created.Client.KeyHash)
+
+	pay := func(correlationID, apiKey string) int {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI("/payments")
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.Header.SetContentType("application/json")
+		if apiKey != "" {
+			ctx.Request.Header.Set("X-API-Key", apiKey)
+		}
+		ctx.Request.SetBodyString(fmt.Sprintf(`{"correlationId":%q,"amount":1.5}`, correlationID))
+		server.Handler(&ctx)
+		return ctx.Response.StatusCode()
+	}
+
+	suite.Equal(http.StatusUnauthorized, pay("00000000-0000-4000-8000-000000000008", ""))
+	suite.Equal(http.StatusOK, pay("00000000-0000-4000-8000-000000000009", created.APIKey))
+
+	var summaryCtx fasthttp.RequestCtx
+	summaryCtx.Request.SetRequestURI("/payments-summary")
+	summaryCtx.Request.Header.SetMethod("GET")
+	summaryCtx.Request.Header.Set("Authorization", "Bearer "+created.APIKey)
+	server.Handler(&summaryCtx)
+	suite.Equal(http.StatusForbidden, summaryCtx.Response.StatusCode())
+
+	var revokeCtx fasthttp.RequestCtx
+	revokeCtx.Request.SetRequestURI("/admin/api-clients/" + created.Client.ID + "/revoke")
+	revokeCtx.Request.Header.SetMethod("POST")
+	revokeCtx.Request.Header.Set("Authorization", "Bearer admin-token")
+	server.Handler(&revokeCtx)
+	suite.Equal(http.StatusOK, revokeCtx.Response.StatusCode())
+
+	suite.Equal(http.StatusUnauthorized, pay("00000000-0000-4000-8000-000000000010", created.APIKey))
+}
+
+func (suite *IntegrationTestSuite) TestAPIKeys_PaymentsOnlyVisibleToTheirClient() {
+	suite.config.AuthEnabled = true
+	suite.config.AdminToken = "admin-token"
+	defer func() {
+		suite.config.AuthEnabled = false
+		suite.config.AdminToken = ""
+	}()
+
+	server := suite.app.Mount()
+
+	createClient := func(name, scope string) string {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI("/admin/api-clients")
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.Header.Set("Authorization", "Bearer admin-token")
+		ctx.Request.Header.SetContentType("application/json")
+		ctx.Request.SetBodyString(fmt.Sprintf(`{"name":%q,"scopes":[%q]}`, name, scope))
+		server.Handler(&ctx)
+		suite.Require().Equal(http.StatusCreated, ctx.Response.StatusCode())
+
+		var created models.APIKeyResponse
+		suite.Require().NoError(json.Unmarshal(ctx.Response.Body(), &created))
+		return created.APIKey
+	}
+	owner := createClient("orders", constants.ScopePaymentsWrite)
+	other := createClient("reports", constants.ScopePaymentsRead)
+
+	correlationID := "00000000-0000-4000-8000-000000000020"
+	var payCtx fasthttp.RequestCtx
+	payCtx.Request.SetRequestURI("/payments")
+	payCtx.Request.Header.SetMethod("POST")
+	payCtx.Request.Header.SetContentType("application/json")
+	payCtx.Request.Header.Set("X-API-Key", owner)
+	payCtx.Request.SetBodyString(fmt.Sprintf(`{"correlationId":%q,"amount":1.5}`, correlationID))
+	server.Handler(&payCtx)
+	suite.Require().Equal(http.StatusOK, payCtx.Response.StatusCode())
+
+	request := func(method, path, apiKey string) int {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI(path)
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.Header.Set("X-API-Key", apiKey)
+		server.Handler(&ctx)
+		return ctx.Response.StatusCode()
+	}
+
+	suite.Equal(http.StatusOK, request("GET", "/payments/"+correlationID, owner))
+	suite.Equal(http.StatusNotFound, request("GET", "/payments/"+correlationID, other))
+	suite.Equal(http.StatusForbidden, request("POST", "/payments/"+correlationID+"/cancel", other))
+}
+
+func (suite *IntegrationTestSuite) TestPaymentsSummary_KeyPerProcessor() {
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/payments-summary")
+	ctx.Request.Header.SetMethod("GET")
+
+	server := suite.app.Mount()
+	server.Handler(&ctx)
+
+	suite.Equal(http.StatusOK, ctx.Response.StatusCode())
+
+	var response models.PaymentSummaryResponse
+	err := json.Unmarshal(ctx.Response.Body(), &response)
+	suite.Require().NoError(err)
+	suite.Len(response, 2)
+	suite.Contains(response, string(constants.DefaultProcessorKey))
+	suite.Contains(response, string(constants.FallbackProcessorKey))
+}
+
+func (suite *IntegrationTestSuite) TestValidation_ProblemDetails() {
+	server := suite.app.Mount()
+	post := func(contentType, body string) (int, models.Problem) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/payments")
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.Header.SetContentType(contentType)
+		ctx.Request.SetBodyString(body)
+		server.Handler(ctx)
+
+		suite.Equal("application/problem+json", string(ctx.Response.Header.ContentType()))
+		var problem models.Problem
+		suite.Require().NoError(json.Unmarshal(ctx.Response.Body(), &problem))
+		suite.Equal(ctx.Response.StatusCode(), problem.Status)
+		return ctx.Response.StatusCode(), problem
+	}
+
+	status, problem := post("application/json", `{"correlationId":"test-0019","amount":10.005}`)
+	suite.Equal(http.StatusBadRequest, status)
+	suite.Equal(constants.ProblemValidationFailed, problem.Code)
+	suite.Equal("/problems/validation-failed", problem.Type)
+	suite.Equal([]models.InvalidParam{
+		{Name: "correlationId", Reason: "expected a UUID"},
+		{Name: "amount", Reason: "BRL allows 2 decimal places"},
+	}, problem.InvalidParams)
+
+	status, problem = post("application/json", `{"correlationId":"00000000-0000-4000-8000-000000000019","amount":2000000}`)
+	suite.Equal(http.StatusBadRequest, status)
+	suite.Equal("amount", problem.InvalidParams[0].Name)
+
+	status, problem = post("application/json", `{"correlationId":"00000000-0000-4000-8000-000000000019","amount":10,"amout":10}`)
+	suite.Equal(http.StatusBadRequest, status)
+	suite.Equal(constants.ProblemUnknownField, problem.Code)
+
+	status, problem = post("application/json", `{"correlationId":"00000000-0000-4000-8000-000000000019","amount":"10"}`)
+	suite.Equal(http.StatusBadRequest, status)
+	suite.Equal(constants.ProblemValidationFailed, problem.Code)
+
+	status, problem = post("text/plain", `{"correlationId":"00000000-0000-4000-8000-000000000019","amount":10}`)
+	suite.Equal(http.StatusUnsupportedMediaType, status)
+	suite.Equal(constants.ProblemUnsupportedMediaType, problem.Code)
+
+	status, problem = post("application/json", `{"correlationId":"00000000-0000-4000-8000-000000000019","amount":10,"callbackUrl":"https://example.com/`+strings.Repeat("a", 1024)+`"}`)
+	suite.Equal(http.StatusRequestEntityTooLarge, status)
+	suite.Equal(constants.ProblemBodyTooLarge, problem.Code)
+
+	suite.Empty(suite.mockProcessors.defaultPayments)
+}
+
+func (suite *IntegrationTestSuite) TestMerchants_IsolatedSummaries() {
+	suite.config.MerchantProcessors = map[string][]constants.PaymentMode{
+		"globex": {constants.FallbackProcessorKey},
+	}
+	defer func() { suite.config.MerchantProcessors = nil }()
+
+	server := suite.app.Mount()
+	pay := func(merchantID string, amount float64) *fasthttp.RequestCtx {
+		reqBody, err := json.Marshal(models.PaymentRequest{
+			CorrelationID: "00000000-0000-4000-8000-000000000011",
+			Amount:        amount,
+			MerchantID:    merchantID,
+		})
+		suite.Require().NoError(err)
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/payments")
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.Header.SetContentType("application/json")
+		ctx.Request.Header.Set("Prefer", "wait=3")
+		ctx.Request.SetBody(reqBody)
+		server.Handler(ctx)
+		return ctx
+	}
+
+	suite.Equal(http.StatusOK, pay("acme", 10).Response.StatusCode())
+	suite.Equal(http.StatusOK, pay("globex", 20).Response.StatusCode())
+	suite.Equal(http.StatusConflict, pay("acme", 10).Response.StatusCode())
+
+	summary := func(merchantID string) models.PaymentSummaryResponse {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI("/payments-summary?merchant=" + merchantID)
+		ctx.Request.Header.SetMethod("GET")
+		server.Handler(&ctx)
+		suite.Require().Equal(http.StatusOK, ctx.Response.StatusCode())
+
+		var response models.PaymentSummaryResponse
+		suite.Require().NoError(json.Unmarshal(ctx.Response.Body(), &response))
+		return response
+	}
+
+	acme := summary("acme")
+	suite.Equal(int64(1), acme[string(constants.DefaultProcessorKey)].TotalRequest)
+	suite.Equal(10.0, acme[string(constants.DefaultProcessorKey)].TotalAmount)
+	suite.Equal(int64(0), acme[string(constants.FallbackProcessorKey)].TotalRequest)
+
+	globex := summary("globex")
+	suite.Len(globex, 1)
+	suite.Equal(int64(1), globex[string(constants.FallbackProcessorKey)].TotalRequest)
+	suite.Equal(20.0, globex[string(constants.FallbackProcessorKey)].TotalAmount)
+}
+
+func (suite *IntegrationTestSuite) TestBatchPayments_NDJSONWithIdempotencyKey() {
+	server := suite.app.Mount()
+	send := func() *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/payments/batch")
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.Header.SetContentType("application/x-ndjson")
+		ctx.Request.Header.Set("Idempotency-Key", "batch-0001")
+		ctx.Request.SetBodyString(`{"correlationId":"00000000-0000-4000-8000-000000000012","amount":5}
+{"correlationId":"00000000-0000-4000-8000-000000000013","amount":-1}
+not json
+{"correlationId":"00000000-0000-4000-8000-000000000012","amount":5}
+`)
+		server.Handler(ctx)
+		return ctx
+	}
+
+	first := send()
+	suite.Require().Equal(http.StatusOK, first.Response.StatusCode())
+
+	var response models.BatchPaymentResponse
+	suite.Require().NoError(json.Unmarshal(first.Response.Body(), &response))
+	suite.Equal(1, response.Accepted)
+	suite.Equal(3, response.Rejected)
+	suite.Require().Len(response.Items, 4)
+	suite.Equal("accepted", response.Items[0].Status)
+	suite.Equal("rejected", response.Items[1].Status)
+	suite.Equal(constants.ProblemValidationFailed, response.Items[1].Code)
+	suite.Equal("rejected", response.Items[2].Status)
+	suite.Equal(constants.ProblemInvalidJSON, response.Items[2].Code)
+	suite.Equal(constants.ProblemDuplicateInBatch, response.Items[3].Code)
+
+	replayed := send()
+	suite.Equal(http.StatusOK, replayed.Response.StatusCode())
+	suite.Equal("true", string(replayed.Response.Header.Peek("Idempotent-Replayed")))
+	suite.JSONEq(string(first.Response.Body()), string(replayed.Response.Body()))
+
+	time.Sleep(500 * time.Millisecond)
+	suite.Len(suite.mockProcessors.defaultPayments, 1)
+}
+
+func (suite *IntegrationTestSuite) TestRefunds_ReportedInSummary() {
+	server := suite.app.Mount()
+	post := func(uri, body string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.Header.SetContentType("application/json")
+		ctx.Request.SetBodyString(body)
+		server.Handler(ctx)
+		return ctx
+	}
+
+	paid := post("/payments?wait=3", `{"correlationId":"00000000-0000-4000-8000-000000000014","amount":10,"merchantId":"initech"}`)
+	suite.Require().Equal(http.StatusOK, paid.Response.StatusCode())
+
+	suite.Equal(http.StatusConflict, post("/payments/00000000-0000-4000-8000-000000000014/cancel?merchant=initech", "").Response.StatusCode())
+
+	partial := post("/payments/00000000-0000-4000-8000-000000000014/refund?merchant=initech", `{"amount":4}`)
+	suite.Require().Equal(http.StatusCreated, partial.Response.StatusCode())
+
+	var refund models.Refund
+	suite.Require().NoError(json.Unmarshal(partial.Response.Body(), &refund))
+	suite.Equal(4.0, refund.Amount)
+	suite.Equal(string(constants.DefaultProcessorKey), refund.Processor)
+
+	suite.Equal(http.StatusUnprocessableEntity, post("/payments/00000000-0000-4000-8000-000000000014/refund?merchant=initech", `{"amount":7}`).Response.StatusCode())
+	suite.Equal(http.StatusCreated, post("/payments/00000000-0000-4000-8000-000000000014/refund?merchant=initech", "").Response.StatusCode())
+	suite.Equal(http.StatusConflict, post("/payments/00000000-0000-4000-8000-000000000014/refund?merchant=initech", "").Response.StatusCode())
+
+	var summaryCtx fasthttp.RequestCtx
+	summaryCtx.Request.SetRequestURI("/payments-summary?merchant=initech")
+	summaryCtx.Request.Header.SetMethod("GET")
+	server.Handler(&summaryCtx)
+
+	var summary models.PaymentSummaryResponse
+	suite.Require().NoError(json.Unmarshal(summaryCtx.Response.Body(), &summary))
+	processor := summary[string(constants.DefaultProcessorKey)]
+	suite.Equal(10.0, processor.TotalAmount)
+	suite.Equal(10.0, processor.RefundedAmount)
+	suite.Equal(0.0, processor.NetAmount)
+}
+
+func (suite *IntegrationTestSuite) TestCurrencies_SummarizedSeparately() {
+	server := suite.app.Mount()
+	post := func(body string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/payments?wait=3")
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.Header.SetContentType("application/json")
+		ctx.Request.SetBodyString(body)
+		server.Handler(ctx)
+		return ctx
+	}
+
+	suite.Equal(http.StatusBadRequest, post(`{"correlationId":"00000000-0000-4000-8000-000000000017","amount":5,"currency":"EUR","merchantId":"umbrella"}`).Response.StatusCode())
+	suite.Equal(http.StatusBadRequest, post(`{"correlationId":"00000000-0000-4000-8000-000000000017","amount":1.5,"currency":"JPY","merchantId":"umbrella"}`).Response.StatusCode())
+
+	suite.Require().Equal(http.StatusOK, post(`{"correlationId":"00000000-0000-4000-8000-000000000017","amount":12.5,"merchantId":"umbrella"}`).Response.StatusCode())
+	suite.Require().Equal(http.StatusOK, post(`{"correlationId":"00000000-0000-4000-8000-000000000018","amount":7.25,"currency":"USD","merchantId":"umbrella"}`).Response.StatusCode())
+
+	suite.Require().Len(suite.mockProcessors.defaultPayments, 2)
+	suite.Equal("BRL", suite.mockProcessors.defaultPayments[0].Currency)
+	suite.Equal("USD", suite.mockProcessors.defaultPayments[1].Currency)
+
+	var summaryCtx fasthttp.RequestCtx
+	summaryCtx.Request.SetRequestURI("/payments-summary?merchant=umbrella")
+	summaryCtx.Request.Header.SetMethod("GET")
+	server.Handler(&summaryCtx)
+
+	var summary models.PaymentSummaryResponse
+	suite.Require().NoError(json.Unmarshal(summaryCtx.Response.Body(), &summary))
+	processor := summary[string(constants.DefaultProcessorKey)]
+	suite.Equal(int64(1), processor.TotalRequest)
+	suite.Equal(12.5, processor.TotalAmount)
+	suite.Equal(12.5, processor.Currencies["BRL"].TotalAmount)
+	suite.Equal(7.25, processor.Currencies["USD"].TotalAmount)
+	suite.NotContains(processor.Currencies, "JPY")
+}
+
+func (suite *IntegrationTestSuite) TestScheduledPayments_PromotedAndCancelled() {
+	server := suite.app.Mount()
+	schedule := func(correlationID string, at time.Time, wait string) *fasthttp.RequestCtx {
+		reqBody, err := json.Marshal(models.PaymentRequest{
+			CorrelationID: correlationID,
+			Amount:        3.00,
+			MerchantID:    "hooli",
+			ScheduledAt:   &at,
+		})
+		suite.Require().NoError(err)
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/payments")
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.Header.SetContentType("application/json")
+		if wait != "" {
+			ctx.Request.Header.Set("Prefer", "wait="+wait)
+		}
+		ctx.Request.SetBody(reqBody)
+		server.Handler(ctx)
+		return ctx
+	}
+
+	suite.Equal(http.StatusOK, schedule("00000000-0000-4000-8000-000000000015", time.Now().Add(time.Hour), "").Response.StatusCode())
+
+	var listCtx fasthttp.RequestCtx
+	listCtx.Request.SetRequestURI("/payments/scheduled?merchant=hooli")
+	listCtx.Request.Header.SetMethod("GET")
+	server.Handler(&listCtx)
+	suite.Require().Equal(http.StatusOK, listCtx.Response.StatusCode())
+
+	var scheduled []models.PaymentRequest
+	suite.Require().NoError(json.Unmarshal(listCtx.Response.Body(), &scheduled))
+	suite.Require().Len(scheduled, 1)
+	suite.Equal("00000000-0000-4000-8000-000000000015", scheduled[0].CorrelationID)
+
+	var cancelCtx fasthttp.RequestCtx
+	cancelCtx.Request.SetRequestURI("/payments/00000000-0000-4000-8000-000000000015/cancel?merchant=hooli")
+	cancelCtx.Request.Header.SetMethod("POST")
+	server.Handler(&cancelCtx)
+	suite.Require().Equal(http.StatusOK, cancelCtx.Response.StatusCode())
+
+	var cancelled models.PaymentResult
+	suite.Require().NoError(json.Unmarshal(cancelCtx.Response.Body(), &cancelled))
+	suite.Equal(constants.PaymentCancelled, cancelled.Status)
+
+	listCtx.Response.Reset()
+	server.Handler(&listCtx)
+	suite.Require().Equal(http.StatusOK, listCtx.Response.StatusCode())
+	scheduled = nil
+	suite.Require().NoError(json.Unmarshal(listCtx.Response.Body(), &scheduled))
+	suite.Empty(scheduled)
+
+	due := schedule("00000000-0000-4000-8000-000000000016", time.Now().Add(1500*time.Millisecond), "5")
+	suite.Require().Equal(http.StatusOK, due.Response.StatusCode())
+
+	var result models.PaymentResult
+	suite.Require().NoError(json.Unmarshal(due.Response.Body(), &result))
+	suite.Equal(constants.PaymentSucceeded, result.Status)
+	suite.Len(suite.mockProcessors.defaultPayments, 1)
+}
+
+func (suite *IntegrationTestSuite) TestEvents_ReplayedFromLastEventID() {
+	correlationID := "00000000-0000-4000-8000-000000000017"
+	reqBody, err := json.Marshal(models.PaymentRequest{CorrelationID: correlationID, Amount: 7.00})
+	suite.Require().NoError(err)
+
+	server := suite.app.Mount()
+
+	var paymentCtx fasthttp.RequestCtx
+	paymentCtx.Request.SetRequestURI("/payments")
+	paymentCtx.Request.Header.SetMethod("POST")
+	paymentCtx.Request.Header.SetContentType("application/json")
+	paymentCtx.Request.Header.Set("Prefer", "wait=3")
+	paymentCtx.Request.SetBody(reqBody)
+	server.Handler(&paymentCtx)
+	suite.Require().Equal(http.StatusOK, paymentCtx.Response.StatusCode())
+
+	var invalidCtx fasthttp.RequestCtx
+	invalidCtx.Request.SetRequestURI("/events?type=payment.unknown")
+	invalidCtx.Request.Header.SetMethod("GET")
+	server.Handler(&invalidCtx)
+	suite.Equal(http.StatusBadRequest, invalidCtx.Response.StatusCode())
+
+	var eventsCtx fasthttp.RequestCtx
+	eventsCtx.Request.SetRequestURI("/events?correlationId=" + correlationID)
+	eventsCtx.Request.Header.SetMethod("GET")
+	eventsCtx.Request.Header.Set("Last-Event-ID", "0")
+	server.Handler(&eventsCtx)
+	suite.Require().Equal(http.StatusOK, eventsCtx.Response.StatusCode())
+	suite.Equal("text/event-stream", string(eventsCtx.Response.Header.ContentType()))
+
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		eventsCtx.Response.BodyWriteTo(writer)
+		writer.Close()
+	}()
+
+	var types []string
+	lines := make(chan string, 64)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for len(types) < 3 {
+		select {
+		case line, ok := <-lines:
+			suite.Require().True(ok, "event stream ended early")
+			if eventType, found := strings.CutPrefix(line, "event: "); found {
+				types = append(types, eventType)
+			}
+		case <-timeout:
+			suite.FailNow("timed out waiting for events", "got %v", types)
+		}
+	}
+
+	suite.Equal([]string{
+		string(constants.EventPaymentAccepted),
+		string(constants.EventPaymentDispatched),
+		string(constants.EventPaymentSucceeded),
+	}, types)
+}
+
 func TestIntegrationSuite(t *testing.T) {
 	suite.Run(t, new(IntegrationTestSuite))
 }
+
+// TestPostgresIntegrationSuite runs the same tests with the Postgres backend.
+func TestPostgresIntegrationSuite(t *testing.T) {
+	suite.Run(t, &IntegrationTestSuite{backend: constants.StoragePostgres})
+}